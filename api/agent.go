@@ -23,8 +23,11 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/clustercontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/gencontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/noopcontroller"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/receivercontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simplecontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simulcontroller"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/delivery"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/errorlog"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/userentity"
 	"github.com/mattermost/mattermost-load-test-ng/performance"
@@ -112,7 +115,22 @@ func (a *api) createLoadAgentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newC, err := NewControllerWrapper(&ltConfig, ucConfig, 0, agentId, a.metrics)
+	var tracker *delivery.Tracker
+	if size := ltConfig.UsersConfiguration.DeliveryCheckSampleSize; size > 0 {
+		tracker = delivery.NewTracker(size)
+	}
+
+	errLog, err := NewErrorLog(&ltConfig)
+	if err != nil {
+		writeAgentResponse(w, http.StatusBadRequest, &client.AgentResponse{
+			Id:      agentId,
+			Message: "load-test agent creation failed",
+			Error:   fmt.Sprintf("could not create error log: %s", err),
+		})
+		return
+	}
+
+	newC, err := NewControllerWrapper(&ltConfig, ucConfig, 0, agentId, a.metrics, tracker, errLog)
 	if err != nil {
 		writeAgentResponse(w, http.StatusBadRequest, &client.AgentResponse{
 			Id:      agentId,
@@ -135,6 +153,12 @@ func (a *api) createLoadAgentHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if tracker != nil {
+		a.setDeliveryTracker(agentId, tracker)
+	}
+	if errLog != nil {
+		a.setErrorLog(agentId, errLog)
+	}
 
 	writeAgentResponse(w, http.StatusCreated, &client.AgentResponse{
 		Id:      agentId,
@@ -196,9 +220,18 @@ func (a *api) stopLoadAgentHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+
+	var res *delivery.Result
+	if tracker := a.getDeliveryTracker(mux.Vars(r)["id"]); tracker != nil {
+		checkRes := tracker.Check()
+		res = &checkRes
+		logDeliveryCheck(a.agentLog, checkRes)
+	}
+
 	writeAgentResponse(w, http.StatusOK, &client.AgentResponse{
-		Message: "load-test agent stopped",
-		Status:  lt.Status(),
+		Message:       "load-test agent stopped",
+		Status:        lt.Status(),
+		DeliveryCheck: res,
 	})
 }
 
@@ -211,6 +244,12 @@ func (a *api) destroyLoadAgentHandler(w http.ResponseWriter, r *http.Request) {
 	_ = lt.Stop() // we are ignoring the error here in case the load test was previously stopped
 
 	id := mux.Vars(r)["id"]
+	a.deleteDeliveryTracker(id)
+	if errLog := a.deleteErrorLog(id); errLog != nil {
+		if err := errLog.Close(); err != nil {
+			a.agentLog.Warn("failed to close the error log", mlog.Err(err))
+		}
+	}
 	if ok := a.deleteResource(id); !ok {
 		writeAgentResponse(w, http.StatusNotFound, &client.AgentResponse{
 			Error: fmt.Sprintf("load-test agent with id %s not found", id),
@@ -282,7 +321,8 @@ func (a *api) removeUsersHandler(w http.ResponseWriter, r *http.Request) {
 	writeAgentResponse(w, http.StatusOK, &resp)
 }
 
-func getServerVersion(serverURL string) (string, error) {
+// GetServerVersion returns the version of the server at the given URL.
+func GetServerVersion(serverURL string) (string, error) {
 	var version string
 	resp, err := http.Get(serverURL)
 	if err != nil {
@@ -303,10 +343,33 @@ func getServerVersion(serverURL string) (string, error) {
 	return version, nil
 }
 
+// NewErrorLog returns the logger writing the errors reported by the users to
+// the configured directory, or nil if none is set.
+func NewErrorLog(config *loadtest.Config) (*errorlog.Logger, error) {
+	if config.UsersConfiguration.ErrorLogDir == "" {
+		return nil, nil
+	}
+	return errorlog.New(errorlog.Config{
+		Dir:         config.UsersConfiguration.ErrorLogDir,
+		MaxFileSize: int64(config.UsersConfiguration.ErrorLogMaxFileSizeBytes),
+		MaxBackups:  config.UsersConfiguration.ErrorLogMaxBackups,
+	})
+}
+
 // NewControllerWrapper returns a constructor function used to create
 // a new UserController.
-func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{}, userOffset int, namePrefix string, metrics *performance.Metrics) (loadtest.NewController, error) {
+// An optional delivery tracker can be passed to check the delivery of posted
+// events once the load-test is over.
+func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{}, userOffset int, namePrefix string, metrics *performance.Metrics, tracker *delivery.Tracker, errLog *errorlog.Logger) (loadtest.NewController, error) {
 	maxHTTPconns := loadtest.MaxHTTPConns(config.UsersConfiguration.MaxActiveUsers)
+	maxIdleConns := config.ConnectionConfiguration.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = maxHTTPconns
+	}
+	maxIdleConnsPerHost := config.ConnectionConfiguration.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = maxHTTPconns
+	}
 
 	// http.Transport to be shared amongst all clients.
 	transport := &http.Transport{
@@ -317,10 +380,10 @@ func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{},
 			DualStack: true,
 		}).DialContext,
 		MaxConnsPerHost:       maxHTTPconns,
-		MaxIdleConns:          maxHTTPconns,
-		MaxIdleConnsPerHost:   maxHTTPconns,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
 		ResponseHeaderTimeout: 5 * time.Second,
-		IdleConnTimeout:       90 * time.Second,
+		IdleConnTimeout:       time.Duration(config.ConnectionConfiguration.IdleConnTimeoutMs) * time.Millisecond,
 		TLSHandshakeTimeout:   1 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
@@ -328,7 +391,7 @@ func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{},
 	var err error
 	serverVersion := config.UserControllerConfiguration.ServerVersion
 	if serverVersion == "" {
-		serverVersion, err = getServerVersion(config.ConnectionConfiguration.ServerURL)
+		serverVersion, err = GetServerVersion(config.ConnectionConfiguration.ServerURL)
 		if err != nil {
 			mlog.Error("Failed to get server version", mlog.Err(err))
 		}
@@ -355,19 +418,72 @@ func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{},
 			password = "testPass123$"
 		}
 
+		persona, err := loadtest.PickPersona(config.UsersConfiguration)
+		if err != nil {
+			return nil, err
+		}
+
+		// Receivers are not supported by the cluster controller which only
+		// tests system console APIs.
+		isReceiver := config.UserControllerConfiguration.Type != loadtest.UserControllerCluster &&
+			loadtest.PickReceiver(config.UsersConfiguration)
+		if isReceiver {
+			persona = loadtest.ReceiverPersona
+		}
+
+		locale, err := loadtest.PickLocale(config.UsersConfiguration)
+		if err != nil {
+			return nil, err
+		}
+
 		ueConfig := userentity.Config{
-			ServerURL:    config.ConnectionConfiguration.ServerURL,
-			WebSocketURL: config.ConnectionConfiguration.WebSocketURL,
-			Username:     username,
-			Email:        email,
-			Password:     password,
+			ServerURL:                        config.ConnectionConfiguration.ServerURL,
+			WebSocketURL:                     config.ConnectionConfiguration.WebSocketURL,
+			Username:                         username,
+			Email:                            email,
+			Password:                         password,
+			Persona:                          persona,
+			ExemplarMinLatency:               time.Duration(config.MetricsConfiguration.ExemplarMinLatencyMs) * time.Millisecond,
+			WebSocketDegradedThreshold:       config.ConnectionConfiguration.WebSocketDegradedThreshold,
+			WebSocketMaxReconnectAttempts:    config.ConnectionConfiguration.WebSocketMaxReconnectAttempts,
+			WebSocketReconnectJitter:         config.ConnectionConfiguration.WebSocketReconnectJitter,
+			EventsBufferSize:                 config.ConnectionConfiguration.WebSocketEventsBufferSize,
+			DropEventsWhenFull:               config.ConnectionConfiguration.WebSocketDropEventsWhenFull,
+			SlowConsumerThreshold:            time.Duration(config.ConnectionConfiguration.WebSocketSlowConsumerThresholdMs) * time.Millisecond,
+			WebSocketConnections:             config.ConnectionConfiguration.WebSocketConnectionsPerUser,
+			WebSocketMinReconnectDuration:    time.Duration(config.ConnectionConfiguration.WebSocketMinReconnectDurationMs) * time.Millisecond,
+			WebSocketMaxReconnectDuration:    time.Duration(config.ConnectionConfiguration.WebSocketMaxReconnectDurationMs) * time.Millisecond,
+			WebSocketFailThreshold:           config.ConnectionConfiguration.WebSocketFailThreshold,
+			WebSocketHealthyDuration:         time.Duration(config.ConnectionConfiguration.WebSocketHealthyDurationMs) * time.Millisecond,
+			WebSocketReadTimeout:             time.Duration(config.ConnectionConfiguration.WebSocketReadTimeoutMs) * time.Millisecond,
+			WebSocketEnableCompression:       config.ConnectionConfiguration.WebSocketEnableCompression,
+			WebSocketMaxMessageSize:          int64(config.ConnectionConfiguration.WebSocketMaxMessageSizeBytes),
+			TypingCoalesceWindow:             time.Duration(config.ConnectionConfiguration.TypingCoalesceWindowMs) * time.Millisecond,
+			TypingEventRate:                  config.ConnectionConfiguration.TypingEventRate,
+			TypingEventBurst:                 config.ConnectionConfiguration.TypingEventBurst,
+			TrackAllLoadedChannels:           config.UsersConfiguration.TrackAllLoadedChannels,
+			CurrentChannelDwell:              time.Duration(config.UsersConfiguration.CurrentChannelDwellMs) * time.Millisecond,
+			CacheUpdatedUsers:                config.UsersConfiguration.CacheUpdatedUsers,
+			RateLimitBackoff:                 config.ConnectionConfiguration.RateLimitBackoff,
+			StoreUnhealthyThreshold:          config.ConnectionConfiguration.StoreUnhealthyThreshold,
+			StoreUnhealthyWindow:             time.Duration(config.ConnectionConfiguration.StoreUnhealthyWindowMs) * time.Millisecond,
+			StoreRecoveryEvents:              config.ConnectionConfiguration.StoreRecoveryEvents,
+			WebSocketSeqGapRecoveryThreshold: config.ConnectionConfiguration.WebSocketSeqGapRecoveryThreshold,
+			PostWriteBatchSize:               config.ConnectionConfiguration.PostWriteBatchSize,
+			PostWriteFlushInterval:           time.Duration(config.ConnectionConfiguration.PostWriteFlushIntervalMs) * time.Millisecond,
+			SetOnlineOnReconnect:             config.ConnectionConfiguration.SetOnlineOnReconnect,
+			ClockSkew:                        loadtest.PickClockSkew(config.UsersConfiguration),
+			DeviceId:                         loadtest.PickDeviceId(config.UsersConfiguration),
+			Locale:                           locale,
 		}
 		store, err := memstore.New(&memstore.Config{
-			MaxStoredPosts:          500,
+			MaxStoredPosts:          config.UsersConfiguration.MaxStoredPosts,
 			MaxStoredUsers:          1000,
 			MaxStoredChannelMembers: 1000,
 			MaxStoredStatuses:       1000,
 			MaxStoredThreads:        500,
+			PostsSpillDir:           config.UsersConfiguration.PostsSpillDir,
+			PostRateWindow:          time.Duration(config.UsersConfiguration.ChannelPostRateWindowMs) * time.Millisecond,
 		})
 		if err != nil {
 			return nil, err
@@ -378,14 +494,20 @@ func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{},
 		}
 
 		ueSetup := userentity.Setup{
-			Store:     store,
-			Transport: transport,
+			Store:           store,
+			Transport:       transport,
+			DeliveryTracker: tracker,
+			ErrorLog:        errLog,
 		}
 		if metrics != nil {
 			ueSetup.Metrics = metrics.UserEntityMetrics()
 		}
 		ue := userentity.New(ueSetup, ueConfig)
 
+		if isReceiver {
+			return receivercontroller.New(id, ue, config.UsersConfiguration.ReceiverChannels, status)
+		}
+
 		switch config.UserControllerConfiguration.Type {
 		case loadtest.UserControllerSimple:
 			return simplecontroller.New(id, ue, controllerConfig.(*simplecontroller.Config), status)
@@ -410,6 +532,24 @@ func NewControllerWrapper(config *loadtest.Config, controllerConfig interface{},
 	}, nil
 }
 
+// logDeliveryCheck logs the outcome of a delivery check.
+func logDeliveryCheck(log *mlog.Logger, res delivery.Result) {
+	fields := []mlog.Field{
+		mlog.Int("sampled_posts", res.SampledPosts),
+		mlog.Int("expected_deliveries", res.ExpectedDeliveries),
+		mlog.Int("missing_deliveries", res.MissingDeliveries),
+	}
+	if res.MissingDeliveries > 0 {
+		log.Warn("delivery check: some posted events were not delivered", fields...)
+		for _, m := range res.Missing {
+			log.Warn("delivery check: missing deliveries", mlog.String("pending_post_id", m.PendingPostId),
+				mlog.String("channel_id", m.ChannelId), mlog.Array("user_ids", m.UserIds))
+		}
+		return
+	}
+	log.Info("delivery check: all posted events were delivered", fields...)
+}
+
 type user struct {
 	email    string
 	username string
@@ -456,6 +596,9 @@ func getUserCredentials(usersFilePath string, config *loadtest.Config) ([]user,
 	if len(users) < config.UsersConfiguration.MaxActiveUsers+1 {
 		return nil, fmt.Errorf("number of lines in %q is %d, which is less than MaxActiveUsers+1(%d)", usersFilePath, len(users), config.UsersConfiguration.MaxActiveUsers+1)
 	}
+	if uc := config.UsersConfiguration; uc.ArrivalsPerMinute > 0 && len(users) < uc.MaxActiveUsers+uc.MaxArrivals+1 {
+		return nil, fmt.Errorf("number of lines in %q is %d, which is less than MaxActiveUsers+MaxArrivals+1(%d)", usersFilePath, len(users), uc.MaxActiveUsers+uc.MaxArrivals+1)
+	}
 
 	return users, nil
 }
@@ -14,6 +14,7 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/loadtest"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simplecontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simulcontroller"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/delivery"
 )
 
 var (
@@ -34,6 +35,9 @@ type AgentResponse struct {
 	Message string           `json:"message,omitempty"` // Message contains information about the response.
 	Status  *loadtest.Status `json:"status,omitempty"`  // Status contains the current status of the load test.
 	Error   string           `json:"error,omitempty"`   // Error is set if there was an error during the operation.
+	// DeliveryCheck holds the outcome of the check of the delivery of posted
+	// events. It's only set when stopping an agent with the check enabled.
+	DeliveryCheck *delivery.Result `json:"delivery_check,omitempty"`
 }
 
 func (a *Agent) apiRequest(req *http.Request) (AgentResponse, error) {
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/mattermost/mattermost-load-test-ng/coordinator"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest"
@@ -157,6 +158,26 @@ func (c *Coordinator) Stop() (coordinator.Status, error) {
 	return status, nil
 }
 
+// Partition makes the coordinator unable to communicate with the agents with
+// the given ids for the given duration.
+// Returns the coordinator status or an error in case of failure.
+func (c *Coordinator) Partition(agentIds []string, duration time.Duration) (coordinator.Status, error) {
+	var status coordinator.Status
+	data, err := json.Marshal(coordinator.Partition{
+		AgentIds:    agentIds,
+		DurationSec: int(duration.Seconds()),
+	})
+	if err != nil {
+		return status, err
+	}
+	resp, err := c.apiPost(c.apiURL+c.id+"/partition", data)
+	if err != nil {
+		return status, err
+	}
+	status = *resp.Status
+	return status, nil
+}
+
 // Destroy stops (if running) and destroys the coordinator resource.
 // Returns the coordinator status or an error in case of failure.
 func (c *Coordinator) Destroy() (coordinator.Status, error) {
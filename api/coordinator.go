@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	client "github.com/mattermost/mattermost-load-test-ng/api/client/coordinator"
 	"github.com/mattermost/mattermost-load-test-ng/coordinator"
@@ -82,7 +83,7 @@ func (a *api) createCoordinatorHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := coordinator.New(&config, ltConfig, a.coordLog)
+	c, err := coordinator.New(&config, ltConfig, a.coordLog, a.metrics.CoordinatorMetrics())
 	if err != nil {
 		writeCoordinatorResponse(w, http.StatusBadRequest, &client.CoordinatorResponse{
 			Id:      id,
@@ -197,6 +198,41 @@ func (a *api) stopCoordinatorHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (a *api) partitionCoordinatorHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := a.getCoordinatorById(w, r)
+	if err != nil {
+		return
+	}
+
+	var partition coordinator.Partition
+	if err := json.NewDecoder(r.Body).Decode(&partition); err != nil {
+		writeCoordinatorResponse(w, http.StatusBadRequest, &client.CoordinatorResponse{
+			Error: fmt.Sprintf("could not read request: %s", err),
+		})
+		return
+	}
+
+	if err := c.Partition(partition.AgentIds, time.Duration(partition.DurationSec)*time.Second); err != nil {
+		writeCoordinatorResponse(w, http.StatusBadRequest, &client.CoordinatorResponse{
+			Message: "partitioning agents failed",
+			Error:   fmt.Sprintf("could not partition agents: %s", err),
+		})
+		return
+	}
+
+	status, err := c.Status()
+	if err != nil {
+		writeCoordinatorResponse(w, http.StatusInternalServerError, &client.CoordinatorResponse{
+			Error: fmt.Sprintf("could not get status for coordinator: %s", err),
+		})
+		return
+	}
+	writeCoordinatorResponse(w, http.StatusOK, &client.CoordinatorResponse{
+		Message: fmt.Sprintf("%d agents partitioned for %ds", len(partition.AgentIds), partition.DurationSec),
+		Status:  &status,
+	})
+}
+
 func (a *api) getCoordinatorStatusHandler(w http.ResponseWriter, r *http.Request) {
 	c, err := a.getCoordinatorById(w, r)
 	if err != nil {
@@ -8,6 +8,8 @@ import (
 	"net/http/pprof"
 	"sync"
 
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/delivery"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/errorlog"
 	"github.com/mattermost/mattermost-load-test-ng/performance"
 
 	"github.com/gorilla/mux"
@@ -21,6 +23,10 @@ type api struct {
 	metrics   *performance.Metrics
 	coordLog  *mlog.Logger
 	agentLog  *mlog.Logger
+	// delivery trackers of the load-test agents, keyed by agent id.
+	deliveryTrackers map[string]*delivery.Tracker
+	// error logs of the load-test agents, keyed by agent id.
+	errorLogs map[string]*errorlog.Logger
 }
 
 func (a *api) getResource(id string) (interface{}, bool) {
@@ -40,6 +46,40 @@ func (a *api) setResource(id string, res interface{}) bool {
 	return false
 }
 
+func (a *api) getDeliveryTracker(id string) *delivery.Tracker {
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+	return a.deliveryTrackers[id]
+}
+
+func (a *api) setDeliveryTracker(id string, tracker *delivery.Tracker) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.deliveryTrackers[id] = tracker
+}
+
+func (a *api) deleteDeliveryTracker(id string) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	delete(a.deliveryTrackers, id)
+}
+
+func (a *api) setErrorLog(id string, errLog *errorlog.Logger) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.errorLogs[id] = errLog
+}
+
+// deleteErrorLog removes the error log of the given agent, returning it so
+// that it can be closed.
+func (a *api) deleteErrorLog(id string) *errorlog.Logger {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	errLog := a.errorLogs[id]
+	delete(a.errorLogs, id)
+	return errLog
+}
+
 func (a *api) deleteResource(id string) bool {
 	a.mut.Lock()
 	defer a.mut.Unlock()
@@ -68,10 +108,12 @@ func (a *api) pprofIndexHandler(w http.ResponseWriter, r *http.Request) {
 // Custom loggers for coordinator and agent are given.
 func SetupAPIRouter(coordLog, agentLog *mlog.Logger) *mux.Router {
 	a := api{
-		resources: make(map[string]interface{}),
-		metrics:   performance.NewMetrics(),
-		coordLog:  coordLog,
-		agentLog:  agentLog,
+		resources:        make(map[string]interface{}),
+		deliveryTrackers: make(map[string]*delivery.Tracker),
+		errorLogs:        make(map[string]*errorlog.Logger),
+		metrics:          performance.NewMetrics(),
+		coordLog:         coordLog,
+		agentLog:         agentLog,
 	}
 
 	router := mux.NewRouter()
@@ -95,6 +137,7 @@ func SetupAPIRouter(coordLog, agentLog *mlog.Logger) *mux.Router {
 	c.HandleFunc("/{id}/status", a.getCoordinatorStatusHandler).Methods("GET")
 	c.HandleFunc("/{id}/run", a.runCoordinatorHandler).Methods("POST")
 	c.HandleFunc("/{id}/stop", a.stopCoordinatorHandler).Methods("POST")
+	c.HandleFunc("/{id}/partition", a.partitionCoordinatorHandler).Methods("POST")
 
 	// Debug endpoint.
 	p := router.PathPrefix("/debug/pprof").Subrouter()
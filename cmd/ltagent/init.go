@@ -119,7 +119,7 @@ func RunInitCmdF(cmd *cobra.Command, args []string) error {
 		},
 	}
 
-	newC, err := api.NewControllerWrapper(config, &genConfig, 0, userPrefix, nil)
+	newC, err := api.NewControllerWrapper(config, &genConfig, 0, userPrefix, nil, nil, nil)
 	if err != nil {
 		return fmt.Errorf("error while creating new controller: %w", err)
 	}
@@ -128,7 +128,7 @@ func RunInitCmdF(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("error while initializing loadtest: %w", err)
 	}
 
-	err = genAdmins(config, userPrefix)
+	_, err = genAdmins(config, userPrefix)
 	if err != nil {
 		return fmt.Errorf("error while generating admin users: %w", err)
 	}
@@ -137,12 +137,15 @@ func RunInitCmdF(cmd *cobra.Command, args []string) error {
 	return genData(lt, 50)
 }
 
-func genAdmins(config *loadtest.Config, userPrefix string) error {
+// genAdmins makes sure the configured number of admins exist, creating the
+// missing ones and promoting the existing ones if needed.
+func genAdmins(config *loadtest.Config, userPrefix string) (provisionResult, error) {
+	var res provisionResult
 	mlog.Info(fmt.Sprintf("generating %d admins", config.InstanceConfiguration.NumAdmins))
 
 	adminStore, err := memstore.New(nil)
 	if err != nil {
-		return err
+		return res, err
 	}
 	adminUeSetup := userentity.Setup{
 		Store: adminStore,
@@ -156,13 +159,13 @@ func genAdmins(config *loadtest.Config, userPrefix string) error {
 	}
 	sysadmin := userentity.New(adminUeSetup, adminUeConfig)
 	if err := sysadmin.Login(); err != nil {
-		return err
+		return res, err
 	}
 
 	for i := 0; i < int(config.InstanceConfiguration.NumAdmins); i++ {
 		userStore, err := memstore.New(nil)
 		if err != nil {
-			return err
+			return res, err
 		}
 		user := &model.User{
 			Password: "testPass123$",
@@ -177,15 +180,30 @@ func genAdmins(config *loadtest.Config, userPrefix string) error {
 			Password:     user.Password,
 		}
 
-		userId, err := sysadmin.CreateUser(user)
+		userIds, err := sysadmin.GetUsersByUsernames([]string{user.Username})
 		if err != nil {
-			return err
+			return res, err
+		}
+		if len(userIds) > 0 {
+			existing, err := sysadmin.Store().GetUser(userIds[0])
+			if err != nil {
+				return res, err
+			}
+			user.Id = existing.Id
+			user.Roles = existing.Roles
+			res.existing++
+		} else {
+			userId, err := sysadmin.CreateUser(user)
+			if err != nil {
+				return res, err
+			}
+			user.Id = userId
+			res.created++
 		}
 
-		user.Id = userId
 		err = userStore.SetUser(user)
 		if err != nil {
-			return err
+			return res, err
 		}
 		userSetup := userentity.Setup{
 			Store: userStore,
@@ -193,15 +211,15 @@ func genAdmins(config *loadtest.Config, userPrefix string) error {
 
 		err = loadtest.PromoteToAdmin(sysadmin, userentity.New(userSetup, ueConfig))
 		if err != nil {
-			return err
+			return res, err
 		}
 	}
 
 	if err := sysadmin.Logout(); err != nil {
-		return err
+		return res, err
 	}
 
-	return nil
+	return res, nil
 }
 
 func MakeInitCommand() *cobra.Command {
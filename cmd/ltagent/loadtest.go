@@ -13,6 +13,7 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/gencontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simplecontroller"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simulcontroller"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/delivery"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
 	"github.com/mattermost/mattermost-load-test-ng/logger"
 
@@ -108,7 +109,24 @@ func RunLoadTestCmdF(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	newC, err := api.NewControllerWrapper(config, ucConfig, userOffset, userPrefix, nil)
+	var tracker *delivery.Tracker
+	if size := config.UsersConfiguration.DeliveryCheckSampleSize; size > 0 {
+		tracker = delivery.NewTracker(size)
+	}
+
+	errLog, err := api.NewErrorLog(config)
+	if err != nil {
+		return fmt.Errorf("error while creating error log: %w", err)
+	}
+	if errLog != nil {
+		defer func() {
+			if err := errLog.Close(); err != nil {
+				mlog.Warn("failed to close the error log", mlog.Err(err))
+			}
+		}()
+	}
+
+	newC, err := api.NewControllerWrapper(config, ucConfig, userOffset, userPrefix, nil, tracker, errLog)
 	if err != nil {
 		return fmt.Errorf("error while creating new controller: %w", err)
 	}
@@ -137,6 +155,16 @@ func RunLoadTestCmdF(cmd *cobra.Command, args []string) error {
 	err = lt.Stop()
 	mlog.Info("loadtest done", mlog.String("elapsed", time.Since(start).String()))
 
+	if tracker != nil {
+		res := tracker.Check()
+		mlog.Info("delivery check done", mlog.Int("sampled_posts", res.SampledPosts),
+			mlog.Int("expected_deliveries", res.ExpectedDeliveries), mlog.Int("missing_deliveries", res.MissingDeliveries))
+		for _, m := range res.Missing {
+			mlog.Warn("delivery check: missing deliveries", mlog.String("pending_post_id", m.PendingPostId),
+				mlog.String("channel_id", m.ChannelId), mlog.Array("user_ids", m.UserIds))
+		}
+	}
+
 	return err
 }
 
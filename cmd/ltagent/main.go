@@ -13,6 +13,8 @@ func main() {
 	rootCmd := MakeLoadTestCommand()
 	commands := []*cobra.Command{
 		MakeInitCommand(),
+		MakeProvisionCommand(),
+		MakeSmokeTestCommand(),
 	}
 	rootCmd.AddCommand(commands...)
 	if err := rootCmd.Execute(); err != nil {
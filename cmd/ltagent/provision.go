@@ -0,0 +1,306 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-load-test-ng/defaults"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+	"github.com/spf13/cobra"
+)
+
+// provisionResult keeps track of how many resources of a given kind were
+// found already existing and how many had to be created.
+type provisionResult struct {
+	existing int
+	created  int
+}
+
+func isNotFound(resp *model.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	var appErr *model.AppError
+	if errors.As(err, &appErr) && appErr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	return resp != nil && resp.StatusCode == http.StatusNotFound
+}
+
+// membersPerPage is the number of members fetched per request when checking
+// for existing memberships.
+const membersPerPage = 200
+
+// provisionUsers makes sure the users the load-test agent logs in as exist,
+// named the same way as in init.
+func provisionUsers(client *model.Client4, userPrefix string, numUsers int) ([]string, provisionResult, error) {
+	var res provisionResult
+	userIds := make([]string, 0, numUsers)
+	for i := 1; i <= numUsers; i++ {
+		username := fmt.Sprintf("%s-%d", userPrefix, i)
+		user, resp, err := client.GetUserByUsername(username, "")
+		if err == nil {
+			userIds = append(userIds, user.Id)
+			res.existing++
+			continue
+		} else if !isNotFound(resp, err) {
+			return nil, res, fmt.Errorf("failed to get user %q: %w", username, err)
+		}
+
+		user, _, err = client.CreateUser(&model.User{
+			Username: username,
+			Email:    fmt.Sprintf("%s-%d@example.com", userPrefix, i),
+			Password: "testPass123$",
+		})
+		if err != nil {
+			return nil, res, fmt.Errorf("failed to create user %q: %w", username, err)
+		}
+		userIds = append(userIds, user.Id)
+		res.created++
+	}
+	return userIds, res, nil
+}
+
+func provisionTeams(client *model.Client4, numTeams int) ([]string, provisionResult, error) {
+	var res provisionResult
+	teamIds := make([]string, 0, numTeams)
+	for i := 1; i <= numTeams; i++ {
+		name := fmt.Sprintf("team-%d", i)
+		team, resp, err := client.GetTeamByName(name, "")
+		if err == nil {
+			teamIds = append(teamIds, team.Id)
+			res.existing++
+			continue
+		} else if !isNotFound(resp, err) {
+			return nil, res, fmt.Errorf("failed to get team %q: %w", name, err)
+		}
+
+		team, _, err = client.CreateTeam(&model.Team{
+			Name:        name,
+			DisplayName: name,
+			Type:        model.TeamOpen,
+		})
+		if err != nil {
+			return nil, res, fmt.Errorf("failed to create team %q: %w", name, err)
+		}
+		teamIds = append(teamIds, team.Id)
+		res.created++
+	}
+	return teamIds, res, nil
+}
+
+func provisionChannels(client *model.Client4, teamIds []string, numPublic, numPrivate int) ([]string, provisionResult, error) {
+	var res provisionResult
+	if len(teamIds) == 0 {
+		return nil, res, nil
+	}
+	channelIds := make([]string, 0, numPublic+numPrivate)
+	for i := 0; i < numPublic+numPrivate; i++ {
+		name := fmt.Sprintf("ch-%d", i+1)
+		chType := model.ChannelTypeOpen
+		if i >= numPublic {
+			chType = model.ChannelTypePrivate
+		}
+		teamId := teamIds[i%len(teamIds)]
+
+		channel, resp, err := client.GetChannelByName(name, teamId, "")
+		if err == nil {
+			channelIds = append(channelIds, channel.Id)
+			res.existing++
+			continue
+		} else if !isNotFound(resp, err) {
+			return nil, res, fmt.Errorf("failed to get channel %q: %w", name, err)
+		}
+
+		channel, _, err = client.CreateChannel(&model.Channel{
+			TeamId:      teamId,
+			Name:        name,
+			DisplayName: name,
+			Type:        chType,
+		})
+		if err != nil {
+			return nil, res, fmt.Errorf("failed to create channel %q: %w", name, err)
+		}
+		channelIds = append(channelIds, channel.Id)
+		res.created++
+	}
+	return channelIds, res, nil
+}
+
+// missingMembers returns the given users that are not part of members.
+func missingMembers(userIds []string, members map[string]bool) []string {
+	var missing []string
+	for _, userId := range userIds {
+		if !members[userId] {
+			missing = append(missing, userId)
+		}
+	}
+	return missing
+}
+
+// provisionTeamMembers makes sure all the given users are members of all the
+// given teams.
+func provisionTeamMembers(client *model.Client4, teamIds, userIds []string) (provisionResult, error) {
+	var res provisionResult
+	for _, teamId := range teamIds {
+		members := map[string]bool{}
+		for page := 0; ; page++ {
+			tms, _, err := client.GetTeamMembers(teamId, page, membersPerPage, "")
+			if err != nil {
+				return res, fmt.Errorf("failed to get members of team %q: %w", teamId, err)
+			}
+			for _, tm := range tms {
+				members[tm.UserId] = true
+			}
+			if len(tms) < membersPerPage {
+				break
+			}
+		}
+
+		missing := missingMembers(userIds, members)
+		res.existing += len(userIds) - len(missing)
+		for len(missing) > 0 {
+			n := membersPerPage
+			if n > len(missing) {
+				n = len(missing)
+			}
+			if _, _, err := client.AddTeamMembers(teamId, missing[:n]); err != nil {
+				return res, fmt.Errorf("failed to add members to team %q: %w", teamId, err)
+			}
+			res.created += n
+			missing = missing[n:]
+		}
+	}
+	return res, nil
+}
+
+// provisionChannelMembers makes sure all the given users are members of all
+// the given channels.
+func provisionChannelMembers(client *model.Client4, channelIds, userIds []string) (provisionResult, error) {
+	var res provisionResult
+	for _, channelId := range channelIds {
+		members := map[string]bool{}
+		for page := 0; ; page++ {
+			cms, _, err := client.GetChannelMembers(channelId, page, membersPerPage, "")
+			if err != nil {
+				return res, fmt.Errorf("failed to get members of channel %q: %w", channelId, err)
+			}
+			for _, cm := range cms {
+				members[cm.UserId] = true
+			}
+			if len(cms) < membersPerPage {
+				break
+			}
+		}
+
+		missing := missingMembers(userIds, members)
+		res.existing += len(userIds) - len(missing)
+		for _, userId := range missing {
+			if _, _, err := client.AddChannelMember(channelId, userId); err != nil {
+				return res, fmt.Errorf("failed to add user %q to channel %q: %w", userId, channelId, err)
+			}
+			res.created++
+		}
+	}
+	return res, nil
+}
+
+func RunProvisionCmdF(cmd *cobra.Command, args []string) error {
+	configFilePath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+
+	config, err := loadtest.ReadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := defaults.Validate(*config); err != nil {
+		return fmt.Errorf("could not validate configuration: %w", err)
+	}
+
+	userPrefix, err := cmd.Flags().GetString("user-prefix")
+	if err != nil {
+		return err
+	}
+
+	client := model.NewAPIv4Client(config.ConnectionConfiguration.ServerURL)
+	if _, _, err := client.Login(config.ConnectionConfiguration.AdminEmail, config.ConnectionConfiguration.AdminPassword); err != nil {
+		return fmt.Errorf("failed to login as admin: %w", err)
+	}
+	defer func() {
+		if _, err := client.Logout(); err != nil {
+			mlog.Warn("failed to logout admin", mlog.Err(err))
+		}
+	}()
+
+	mlog.Info("provisioning started")
+
+	userIds, usersRes, err := provisionUsers(client, userPrefix, config.UsersConfiguration.MaxActiveUsers)
+	if err != nil {
+		return err
+	}
+	mlog.Info("users provisioned", mlog.Int("existing", usersRes.existing), mlog.Int("created", usersRes.created))
+
+	adminsRes, err := genAdmins(config, userPrefix)
+	if err != nil {
+		return fmt.Errorf("error while generating admin users: %w", err)
+	}
+	mlog.Info("admins provisioned", mlog.Int("existing", adminsRes.existing), mlog.Int("created", adminsRes.created))
+
+	teamIds, teamsRes, err := provisionTeams(client, int(config.InstanceConfiguration.NumTeams))
+	if err != nil {
+		return err
+	}
+	mlog.Info("teams provisioned", mlog.Int("existing", teamsRes.existing), mlog.Int("created", teamsRes.created))
+
+	numChannels := float64(config.InstanceConfiguration.NumChannels)
+	numPublic := int(numChannels * config.InstanceConfiguration.PercentPublicChannels)
+	numPrivate := int(numChannels * config.InstanceConfiguration.PercentPrivateChannels)
+	channelIds, channelsRes, err := provisionChannels(client, teamIds, numPublic, numPrivate)
+	if err != nil {
+		return err
+	}
+	mlog.Info("channels provisioned", mlog.Int("existing", channelsRes.existing), mlog.Int("created", channelsRes.created))
+
+	teamMembersRes, err := provisionTeamMembers(client, teamIds, userIds)
+	if err != nil {
+		return err
+	}
+	mlog.Info("team members provisioned", mlog.Int("existing", teamMembersRes.existing), mlog.Int("created", teamMembersRes.created))
+
+	channelMembersRes, err := provisionChannelMembers(client, channelIds, userIds)
+	if err != nil {
+		return err
+	}
+	mlog.Info("channel members provisioned", mlog.Int("existing", channelMembersRes.existing), mlog.Int("created", channelMembersRes.created))
+
+	fmt.Printf("Users: %d existing, %d created\n", usersRes.existing, usersRes.created)
+	fmt.Printf("Admins: %d existing, %d created\n", adminsRes.existing, adminsRes.created)
+	fmt.Printf("Teams: %d existing, %d created\n", teamsRes.existing, teamsRes.created)
+	fmt.Printf("Channels: %d existing, %d created\n", channelsRes.existing, channelsRes.created)
+	fmt.Printf("Team memberships: %d existing, %d created\n", teamMembersRes.existing, teamMembersRes.created)
+	fmt.Printf("Channel memberships: %d existing, %d created\n", channelMembersRes.existing, channelMembersRes.created)
+
+	return nil
+}
+
+func MakeProvisionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "provision",
+		Short:        "Idempotently provision users, teams and channels",
+		SilenceUsage: true,
+		RunE:         RunProvisionCmdF,
+		PreRun:       SetupLoadTest,
+	}
+	cmd.PersistentFlags().StringP("user-prefix", "", "testuser", "prefix used when generating usernames and emails")
+	return cmd
+}
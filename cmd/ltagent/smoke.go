@@ -0,0 +1,130 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mattermost/mattermost-load-test-ng/api"
+	"github.com/mattermost/mattermost-load-test-ng/defaults"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simulcontroller"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/userentity"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+	"github.com/spf13/cobra"
+)
+
+func RunSmokeTestCmdF(cmd *cobra.Command, args []string) error {
+	configFilePath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+
+	config, err := loadtest.ReadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := defaults.Validate(*config); err != nil {
+		return fmt.Errorf("could not validate configuration: %w", err)
+	}
+
+	ucConfigPath, err := cmd.Flags().GetString("controller-config")
+	if err != nil {
+		return err
+	}
+	ucConfig, err := simulcontroller.ReadConfig(ucConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read controller configuration: %w", err)
+	}
+
+	userPrefix, err := cmd.Flags().GetString("user-prefix")
+	if err != nil {
+		return err
+	}
+
+	store, err := memstore.New(nil)
+	if err != nil {
+		return err
+	}
+	version := config.UserControllerConfiguration.ServerVersion
+	if version == "" {
+		version, err = api.GetServerVersion(config.ConnectionConfiguration.ServerURL)
+	}
+	if err != nil {
+		mlog.Warn("failed to get server version", mlog.Err(err))
+	} else if err := store.SetServerVersion(version); err != nil {
+		return err
+	}
+
+	ue := userentity.New(userentity.Setup{
+		Store:     store,
+		Transport: http.DefaultTransport,
+	}, userentity.Config{
+		ServerURL:    config.ConnectionConfiguration.ServerURL,
+		WebSocketURL: config.ConnectionConfiguration.WebSocketURL,
+		Username:     userPrefix + "-smoke",
+		Email:        userPrefix + "-smoke@example.com",
+		Password:     "testPass123$",
+	})
+
+	status := make(chan control.UserStatus, 10)
+	defer close(status)
+	go func() {
+		for st := range status {
+			if st.Err != nil {
+				mlog.Debug("smoke test error", mlog.Err(st.Err))
+			} else {
+				mlog.Debug(st.Info)
+			}
+		}
+	}()
+
+	c, err := simulcontroller.New(1, ue, ucConfig, status)
+	if err != nil {
+		return fmt.Errorf("failed to create controller: %w", err)
+	}
+
+	mlog.Info("smoke test started")
+
+	results, err := c.SmokeTest()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", res.Action, res.Err.Error())
+		case res.Skipped:
+			fmt.Printf("SKIP  %s: %s\n", res.Action, res.Info)
+		default:
+			fmt.Printf("PASS  %s: %s\n", res.Action, res.Info)
+		}
+	}
+	fmt.Printf("\n%d actions run, %d failed\n", len(results), failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d actions failed", failed)
+	}
+	return nil
+}
+
+func MakeSmokeTestCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "smoke",
+		Short:        "Run every enabled action of the simulative controller once with a single user",
+		SilenceUsage: true,
+		RunE:         RunSmokeTestCmdF,
+		PreRun:       SetupLoadTest,
+	}
+	cmd.Flags().StringP("controller-config", "", "", "path to the controller configuration file to use")
+	return cmd
+}
@@ -37,7 +37,7 @@ func RunCoordinatorCmdF(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	c, err := coordinator.New(cfg, *ltConfig, log)
+	c, err := coordinator.New(cfg, *ltConfig, log, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create coordinator: %w", err)
 	}
@@ -75,16 +75,12 @@ func RunGenerateReportCmdF(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if promURL == "" {
-		config, err := getConfig(cmd)
-		if err != nil {
-			return err
-		}
+	t, err := terraform.New("", config)
+	if err != nil {
+		return fmt.Errorf("failed to create terraform engine: %w", err)
+	}
 
-		t, err := terraform.New("", config)
-		if err != nil {
-			return fmt.Errorf("failed to create terraform engine: %w", err)
-		}
+	if promURL == "" {
 		output, err := t.Output()
 		if err != nil {
 			return fmt.Errorf("could not parse output: %w", err)
@@ -103,6 +99,12 @@ func RunGenerateReportCmdF(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("error while generating report: %w", err)
 	}
 
+	if runCfg, err := t.GetRunConfig(); err != nil {
+		fmt.Printf("Could not include the load-test configuration in the report: %s\n", err)
+	} else if err := data.SetConfig(runCfg); err != nil {
+		return err
+	}
+
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
 	err = enc.Encode(data)
@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/mattermost/mattermost-load-test-ng/coordinator"
+	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance"
 	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance/prometheus"
 	"github.com/mattermost/mattermost-load-test-ng/deployment"
 	"github.com/mattermost/mattermost-load-test-ng/deployment/terraform"
@@ -79,6 +80,19 @@ func getDeploymentInfo(config *deployment.Config) DeploymentInfo {
 	}
 }
 
+// serverMetricsValues returns the values of the given server metrics keyed by
+// name.
+func serverMetricsValues(metrics []performance.ServerMetric) map[string]float64 {
+	if len(metrics) == 0 {
+		return nil
+	}
+	values := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+	}
+	return values
+}
+
 func (c *Comparison) getResults(resultsCh <-chan Result) []Result {
 	var wg sync.WaitGroup
 	var results []Result
@@ -120,6 +134,17 @@ func (c *Comparison) getResults(resultsCh <-chan Result) []Result {
 				return
 			}
 
+			if err := baseReport.SetConfig(res.LoadTests[0].Config); err != nil {
+				mlog.Error("Failed to set report config", mlog.Err(err))
+				return
+			}
+			if err := newReport.SetConfig(res.LoadTests[1].Config); err != nil {
+				mlog.Error("Failed to set report config", mlog.Err(err))
+				return
+			}
+			baseReport.ServerMetrics = serverMetricsValues(res.LoadTests[0].Status.ServerMetrics)
+			newReport.ServerMetrics = serverMetricsValues(res.LoadTests[1].Status.ServerMetrics)
+
 			if c.config.Output.GenerateReport {
 				var buf bytes.Buffer
 				graphsPrefix := fmt.Sprintf("%s_%s_%d_", res.LoadTests[0].Config.DBEngine,
@@ -6,6 +6,7 @@ package cluster
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"sync"
 	"sync/atomic"
 
@@ -24,8 +25,10 @@ type LoadAgentCluster struct {
 	config   LoadAgentClusterConfig
 	ltConfig loadtest.Config
 	agents   []*client.Agent
-	errMap   map[*client.Agent]*errorTrack
-	log      *mlog.Logger
+	// transports holds the transport used to reach each agent, by index.
+	transports []*partitionTransport
+	errMap     map[*client.Agent]*errorTrack
+	log        *mlog.Logger
 }
 
 type errorTrack struct {
@@ -65,9 +68,11 @@ func New(config LoadAgentClusterConfig, ltConfig loadtest.Config, log *mlog.Logg
 		return nil, fmt.Errorf("could not validate configuration: %w", err)
 	}
 	agents := make([]*client.Agent, len(config.Agents))
+	transports := make([]*partitionTransport, len(config.Agents))
 	errMap := make(map[*client.Agent]*errorTrack)
 	for i := 0; i < len(agents); i++ {
-		agent, err := client.New(config.Agents[i].Id, config.Agents[i].ApiURL, nil)
+		transports[i] = &partitionTransport{transport: http.DefaultTransport}
+		agent, err := client.New(config.Agents[i].Id, config.Agents[i].ApiURL, &http.Client{Transport: transports[i]})
 		if err != nil {
 			return nil, fmt.Errorf("cluster: failed to create api client: %w", err)
 		}
@@ -85,11 +90,12 @@ func New(config LoadAgentClusterConfig, ltConfig loadtest.Config, log *mlog.Logg
 	}
 
 	return &LoadAgentCluster{
-		agents:   agents,
-		config:   config,
-		ltConfig: ltConfig,
-		errMap:   errMap,
-		log:      log,
+		agents:     agents,
+		transports: transports,
+		config:     config,
+		ltConfig:   ltConfig,
+		errMap:     errMap,
+		log:        log,
 	}, nil
 }
 
@@ -116,6 +122,9 @@ func (c *LoadAgentCluster) Stop() error {
 // Shutdown stops and destroys all the load-test agents available in the cluster.
 // It makes sure agent.Destroy() is called once for every agent in the cluster.
 func (c *LoadAgentCluster) Shutdown() {
+	// Agents need to be reachable to be destroyed.
+	c.Heal()
+
 	var wg sync.WaitGroup
 	wg.Add(len(c.agents))
 	for _, ag := range c.agents {
@@ -198,6 +207,10 @@ func (c *LoadAgentCluster) Status() (Status, error) {
 			}
 		} else if err != nil {
 			c.log.Error("cluster: failed to get status for agent:", mlog.Err(err))
+			// The agent is unreachable but most likely still running, so
+			// its error count is left untouched rather than mistaken for
+			// a crash.
+			continue
 		}
 
 		status.ActiveUsers += int(st.NumUsers)
@@ -0,0 +1,88 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// ErrPartitioned is returned for any request made to an agent while it's
+// partitioned from the coordinator.
+var ErrPartitioned = errors.New("cluster: agent is partitioned")
+
+// partitionTransport is used to simulate a network partition between the
+// coordinator and an agent by failing any request made while partitioned.
+type partitionTransport struct {
+	transport http.RoundTripper
+	// partitionedUntil holds the time, in nanoseconds since the epoch, at
+	// which the partition heals.
+	partitionedUntil int64
+}
+
+// RoundTrip implements the RoundTripper interface for partitionTransport.
+func (t *partitionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.isPartitioned(time.Now()) {
+		return nil, ErrPartitioned
+	}
+	return t.transport.RoundTrip(req)
+}
+
+func (t *partitionTransport) isPartitioned(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&t.partitionedUntil)
+}
+
+// partitionUntil extends the partition up to the given time. An ongoing
+// partition lasting longer is left untouched.
+func (t *partitionTransport) partitionUntil(until time.Time) {
+	for {
+		cur := atomic.LoadInt64(&t.partitionedUntil)
+		if cur >= until.UnixNano() || atomic.CompareAndSwapInt64(&t.partitionedUntil, cur, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+// Partition cuts the communication between the coordinator and the agents
+// with the given ids for the given duration, after which it's restored.
+func (c *LoadAgentCluster) Partition(agentIds []string, duration time.Duration) error {
+	if duration <= 0 {
+		return errors.New("cluster: partition duration should be > 0")
+	}
+
+	transports := make([]*partitionTransport, 0, len(agentIds))
+	for _, id := range agentIds {
+		var found bool
+		for i, agent := range c.agents {
+			if agent.Id() == id {
+				transports = append(transports, c.transports[i])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cluster: agent %q not found", id)
+		}
+	}
+
+	c.log.Info("cluster: partitioning agents", mlog.Any("agent_ids", agentIds), mlog.String("duration", duration.String()))
+	until := time.Now().Add(duration)
+	for _, t := range transports {
+		t.partitionUntil(until)
+	}
+
+	return nil
+}
+
+// Heal restores the communication with all the partitioned agents.
+func (c *LoadAgentCluster) Heal() {
+	for _, t := range c.transports {
+		atomic.StoreInt64(&t.partitionedUntil, 0)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	client "github.com/mattermost/mattermost-load-test-ng/api/client/agent"
+	"github.com/mattermost/mattermost-load-test-ng/logger"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartition(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	c := &LoadAgentCluster{log: logger.New(&logger.Settings{})}
+	for _, id := range []string{"lt0", "lt1"} {
+		transport := &partitionTransport{transport: http.DefaultTransport}
+		agent, err := client.New(id, ts.URL, &http.Client{Transport: transport})
+		require.NoError(t, err)
+		c.agents = append(c.agents, agent)
+		c.transports = append(c.transports, transport)
+	}
+
+	get := func(i int) error {
+		resp, err := (&http.Client{Transport: c.transports[i]}).Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	require.Error(t, c.Partition([]string{"lt0"}, 0))
+	require.Error(t, c.Partition([]string{"lt2"}, time.Minute))
+	require.False(t, c.transports[0].isPartitioned(time.Now()))
+
+	require.NoError(t, c.Partition([]string{"lt0"}, 100*time.Millisecond))
+	require.ErrorIs(t, get(0), ErrPartitioned)
+	require.NoError(t, get(1))
+
+	// A shorter partition doesn't cut an ongoing one short.
+	require.NoError(t, c.Partition([]string{"lt0"}, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	require.ErrorIs(t, get(0), ErrPartitioned)
+
+	require.Eventually(t, func() bool {
+		return get(0) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, c.Partition([]string{"lt0", "lt1"}, time.Hour))
+	require.ErrorIs(t, get(1), ErrPartitioned)
+	c.Heal()
+	require.NoError(t, get(0))
+	require.NoError(t, get(1))
+}
@@ -4,6 +4,9 @@
 package coordinator
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/mattermost/mattermost-load-test-ng/coordinator/cluster"
 	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance"
 	"github.com/mattermost/mattermost-load-test-ng/defaults"
@@ -17,6 +20,9 @@ type Config struct {
 	ClusterConfig cluster.LoadAgentClusterConfig
 	// MonitorConfig holds the performance monitor configuration.
 	MonitorConfig performance.MonitorConfig
+	// ServerMetricsConfig holds the configuration of the scraper recording
+	// the target instance's metrics during the load-test.
+	ServerMetricsConfig performance.ScraperConfig
 	// The number of active users to increment at each iteration of the feedback loop.
 	// It should be proportional to the maximum number of users expected to test.
 	NumUsersInc int `default:"8" validate:"range:(0,]"`
@@ -26,9 +32,102 @@ type Config struct {
 	// The number of seconds to wait after a performance degradation alert before
 	// incrementing or decrementing users again.
 	RestTimeSec int `default:"2" validate:"range:(0,]"`
+	// An optional list of stages to drive the number of active users through.
+	// If set, the coordinator performs a step-load test going through each
+	// stage in order instead of running the feedback loop.
+	RampProfile []RampStage
+	// An optional daily pattern to drive the number of active users through.
+	// If enabled, the coordinator makes the number of active users follow the
+	// pattern over each simulated day until stopped, instead of running the
+	// feedback loop.
+	DailyProfile DailyProfile
+	// An optional list of network partitions to simulate between the
+	// coordinator and some of the agents during the load-test.
+	Partitions  []Partition
 	LogSettings logger.Settings
 }
 
+// RampStage defines a single stage of a ramp profile.
+type RampStage struct {
+	// The number of active users to reach during the stage.
+	TargetUsers int `default:"0" validate:"range:[0,]"`
+	// The number of seconds to hold the target number of active users
+	// before moving on to the next stage.
+	DurationSec int `default:"60" validate:"range:(0,]"`
+}
+
+// DailyProfile defines a pattern followed by the number of active users over
+// a simulated day.
+type DailyProfile struct {
+	// The duration (in seconds) of a simulated day. Zero disables the daily
+	// profile.
+	DayDurationSec int `default:"0" validate:"range:[0,]"`
+	// The number of active users at the lowest point of the day.
+	MinUsers int `default:"0" validate:"range:[0,]"`
+	// The number of active users at the highest point of the day.
+	MaxUsers int `default:"0" validate:"range:[$MinUsers,]"`
+	// An optional list of points, between 0 (MinUsers) and 1 (MaxUsers),
+	// evenly spaced over the day. The number of active users is linearly
+	// interpolated between them. If empty, it follows a sinusoid with its
+	// lowest point at the start of the day.
+	Curve []float64
+}
+
+// Partition defines a window of time during which the coordinator can't
+// communicate with some of the agents.
+type Partition struct {
+	// The ids of the agents to partition from the coordinator.
+	AgentIds []string
+	// The number of seconds after the start of the load-test at which the
+	// partition begins.
+	StartSec int `default:"0" validate:"range:[0,]"`
+	// The number of seconds the partition lasts before it heals.
+	DurationSec int `default:"60" validate:"range:(0,]"`
+}
+
+// IsValid reports whether a given Config is valid or not.
+// Returns an error if the validation fails.
+func (c *Config) IsValid() error {
+	for i, stage := range c.RampProfile {
+		if stage.TargetUsers > c.ClusterConfig.MaxActiveUsers {
+			return fmt.Errorf("RampProfile stage %d: TargetUsers (%d) should not be greater than MaxActiveUsers (%d)",
+				i, stage.TargetUsers, c.ClusterConfig.MaxActiveUsers)
+		}
+	}
+	if c.DailyProfile.DayDurationSec > 0 {
+		if len(c.RampProfile) > 0 {
+			return errors.New("RampProfile and DailyProfile should not be both set")
+		}
+		if c.DailyProfile.MaxUsers > c.ClusterConfig.MaxActiveUsers {
+			return fmt.Errorf("DailyProfile: MaxUsers (%d) should not be greater than MaxActiveUsers (%d)",
+				c.DailyProfile.MaxUsers, c.ClusterConfig.MaxActiveUsers)
+		}
+		for i, v := range c.DailyProfile.Curve {
+			if v < 0 || v > 1 {
+				return fmt.Errorf("DailyProfile: Curve point %d (%f) should be between 0 and 1", i, v)
+			}
+		}
+	}
+	for i, p := range c.Partitions {
+		if len(p.AgentIds) == 0 {
+			return fmt.Errorf("Partitions %d: AgentIds should not be empty", i)
+		}
+		for _, id := range p.AgentIds {
+			var found bool
+			for _, agent := range c.ClusterConfig.Agents {
+				if agent.Id == id {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("Partitions %d: agent %q is not part of the cluster", i, id)
+			}
+		}
+	}
+	return nil
+}
+
 // ReadConfig reads the configuration file from the given string. If the string
 // is empty, it will return a config with default values.
 func ReadConfig(configFilePath string) (*Config, error) {
@@ -14,6 +14,7 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance"
 	"github.com/mattermost/mattermost-load-test-ng/defaults"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest"
+	ltperformance "github.com/mattermost/mattermost-load-test-ng/performance"
 
 	"github.com/mattermost/mattermost-server/v6/shared/mlog"
 )
@@ -28,6 +29,8 @@ type Coordinator struct {
 	config   *Config
 	cluster  *cluster.LoadAgentCluster
 	monitor  *performance.Monitor
+	scraper  *performance.Scraper
+	metrics  *ltperformance.CoordinatorMetrics
 	log      *mlog.Logger
 }
 
@@ -52,6 +55,10 @@ func (c *Coordinator) Run() (<-chan struct{}, error) {
 	}
 
 	monitorChan := c.monitor.Run()
+	if c.scraper != nil {
+		c.scraper.Run()
+	}
+	partitionTimers := c.schedulePartitions()
 
 	var lastActionTime, lastAlertTime time.Time
 
@@ -86,7 +93,15 @@ func (c *Coordinator) Run() (<-chan struct{}, error) {
 		var supported int
 
 		defer func() {
+			for _, t := range partitionTimers {
+				t.Stop()
+			}
 			c.monitor.Stop()
+			var serverMetrics []performance.ServerMetric
+			if c.scraper != nil {
+				c.scraper.Stop()
+				serverMetrics = c.scraper.Metrics()
+			}
 			clusterStatus, err := c.cluster.Status()
 			if err != nil {
 				c.log.Error("coordinator: cluster status error:", mlog.Err(err))
@@ -97,12 +112,23 @@ func (c *Coordinator) Run() (<-chan struct{}, error) {
 			c.status.State = Done
 			c.status.SupportedUsers = supported
 			c.status.StopTime = time.Now()
+			c.status.ServerMetrics = serverMetrics
 			if clusterStatus.NumErrors > 0 {
 				c.status.NumErrors = clusterStatus.NumErrors
 			}
 			c.mut.Unlock()
 		}()
 
+		if len(c.config.RampProfile) > 0 {
+			supported = c.runRampProfile(monitorChan)
+			return
+		}
+
+		if c.config.DailyProfile.DayDurationSec > 0 {
+			c.runDailyProfile(monitorChan)
+			return
+		}
+
 		var samples []point
 
 		for {
@@ -192,6 +218,34 @@ func (c *Coordinator) Run() (<-chan struct{}, error) {
 	return c.doneChan, nil
 }
 
+// schedulePartitions starts the configured network partitions at their
+// scheduled time. It returns the timers used so that pending partitions can
+// be canceled.
+func (c *Coordinator) schedulePartitions() []*time.Timer {
+	timers := make([]*time.Timer, 0, len(c.config.Partitions))
+	for _, p := range c.config.Partitions {
+		p := p
+		timers = append(timers, time.AfterFunc(time.Duration(p.StartSec)*time.Second, func() {
+			if err := c.cluster.Partition(p.AgentIds, time.Duration(p.DurationSec)*time.Second); err != nil {
+				c.log.Error("coordinator: failed to partition agents", mlog.Err(err))
+			}
+		}))
+	}
+	return timers
+}
+
+// Partition cuts the communication between the coordinator and the agents
+// with the given ids for the given duration, after which it's restored.
+// It returns an error if the coordinator is not running.
+func (c *Coordinator) Partition(agentIds []string, duration time.Duration) error {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	if c.status.State != Running {
+		return ErrNotRunning
+	}
+	return c.cluster.Partition(agentIds, duration)
+}
+
 // Stop stops the coordinator.
 // It returns an error in case of failure.
 func (c *Coordinator) Stop() error {
@@ -213,6 +267,7 @@ func (c *Coordinator) Stop() error {
 		ActiveUsers:    clusterStatus.ActiveUsers,
 		NumErrors:      clusterStatus.NumErrors,
 		SupportedUsers: c.status.SupportedUsers,
+		ServerMetrics:  c.status.ServerMetrics,
 	}
 	return nil
 }
@@ -228,6 +283,10 @@ func (c *Coordinator) Status() (Status, error) {
 	if err != nil {
 		return Status{}, fmt.Errorf("coordinator: failed to get cluster status: %w", err)
 	}
+	var serverMetrics []performance.ServerMetric
+	if c.scraper != nil {
+		serverMetrics = c.scraper.Metrics()
+	}
 	return Status{
 		State:          c.status.State,
 		StartTime:      c.status.StartTime,
@@ -235,13 +294,15 @@ func (c *Coordinator) Status() (Status, error) {
 		ActiveUsers:    clusterStatus.ActiveUsers,
 		NumErrors:      clusterStatus.NumErrors,
 		SupportedUsers: c.status.SupportedUsers,
+		ServerMetrics:  serverMetrics,
 	}, nil
 }
 
 // New creates and initializes a new Coordinator for the given config.
 // The ltConfig parameter is used to create and configure load-test agents.
+// The optional metrics parameter is used to expose the coordinator's progress.
 // An error is returned if the initialization fails.
-func New(config *Config, ltConfig loadtest.Config, log *mlog.Logger) (*Coordinator, error) {
+func New(config *Config, ltConfig loadtest.Config, log *mlog.Logger, metrics *ltperformance.CoordinatorMetrics) (*Coordinator, error) {
 	if config == nil {
 		return nil, errors.New("coordinator: config should not be nil")
 	}
@@ -268,12 +329,22 @@ func New(config *Config, ltConfig loadtest.Config, log *mlog.Logger) (*Coordinat
 		return nil, fmt.Errorf("coordinator: failed to create performance monitor: %w", err)
 	}
 
+	var scraper *performance.Scraper
+	if config.ServerMetricsConfig.URL != "" {
+		scraper, err = performance.NewScraper(config.ServerMetricsConfig, log)
+		if err != nil {
+			return nil, fmt.Errorf("coordinator: failed to create metrics scraper: %w", err)
+		}
+	}
+
 	return &Coordinator{
 		stopChan: make(chan struct{}),
 		doneChan: make(chan struct{}),
 		config:   config,
 		cluster:  cluster,
 		monitor:  monitor,
+		scraper:  scraper,
+		metrics:  metrics,
 		log:      log,
 	}, nil
 }
@@ -14,6 +14,9 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/defaults"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest"
 	"github.com/mattermost/mattermost-load-test-ng/logger"
+	"github.com/mattermost/mattermost-load-test-ng/performance"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/stretchr/testify/require"
 )
@@ -53,15 +56,15 @@ func setupAPIServer(t *testing.T) *httptest.Server {
 }
 
 func TestNew(t *testing.T) {
-	c, err := New(nil, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err := New(nil, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.Error(t, err)
 	require.Nil(t, c)
 
-	c, err = New(newConfig(t), loadtest.Config{}, logger.New(&logger.Settings{}))
+	c, err = New(newConfig(t), loadtest.Config{}, logger.New(&logger.Settings{}), nil)
 	require.Error(t, err)
 	require.Nil(t, c)
 
-	c, err = New(newConfig(t), newLoadTestConfig(t), nil)
+	c, err = New(newConfig(t), newLoadTestConfig(t), nil, nil)
 	require.Error(t, err)
 	require.Nil(t, c)
 
@@ -70,7 +73,7 @@ func TestNew(t *testing.T) {
 
 	cfg := newConfig(t)
 	cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
-	c, err = New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err = New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
@@ -78,7 +81,7 @@ func TestNew(t *testing.T) {
 	cfg = newConfig(t)
 	cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
 	cfg.MonitorConfig.Queries = nil
-	c, err = New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err = New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.NoError(t, err)
 	require.NotNil(t, c)
 }
@@ -90,7 +93,7 @@ func TestRun(t *testing.T) {
 	cfg := newConfig(t)
 	cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
 
-	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
@@ -123,6 +126,56 @@ func TestRun(t *testing.T) {
 	c.mut.RUnlock()
 }
 
+func TestRunRampProfile(t *testing.T) {
+	srv := setupAPIServer(t)
+	defer srv.Close()
+
+	t.Run("invalid target", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
+		cfg.RampProfile = []RampStage{
+			{TargetUsers: cfg.ClusterConfig.MaxActiveUsers + 1, DurationSec: 1},
+		}
+
+		c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
+		require.Error(t, err)
+		require.Nil(t, c)
+	})
+
+	t.Run("completes stages", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
+		cfg.MonitorConfig.UpdateIntervalMs = 1000
+		cfg.MonitorConfig.Queries = nil
+		cfg.RampProfile = []RampStage{
+			{TargetUsers: 0, DurationSec: 1},
+		}
+
+		metrics := performance.NewMetrics().CoordinatorMetrics()
+		c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), metrics)
+		require.NoError(t, err)
+		require.NotNil(t, c)
+
+		done, err := c.Run()
+		require.NoError(t, err)
+		require.NotNil(t, done)
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "timed out waiting for the ramp profile to complete")
+		}
+
+		require.Equal(t, float64(1), testutil.ToFloat64(metrics.RampStage))
+		require.Equal(t, float64(0), testutil.ToFloat64(metrics.RampStageTargetUsers))
+
+		require.Eventually(t, func() bool {
+			status, err := c.Status()
+			return err == nil && status.State == Done
+		}, 5*time.Second, 100*time.Millisecond)
+	})
+}
+
 func TestStop(t *testing.T) {
 	srv := setupAPIServer(t)
 	defer srv.Close()
@@ -130,7 +183,7 @@ func TestStop(t *testing.T) {
 	cfg := newConfig(t)
 	cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
 
-	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.NoError(t, err)
 	require.NotNil(t, c)
 	c.mut.RLock()
@@ -169,7 +222,7 @@ func TestStatus(t *testing.T) {
 	cfg := newConfig(t)
 	cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
 
-	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
@@ -202,7 +255,7 @@ func TestConcurrency(t *testing.T) {
 	cfg := newConfig(t)
 	cfg.ClusterConfig.Agents[0].ApiURL = srv.URL
 
-	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}))
+	c, err := New(cfg, newLoadTestConfig(t), logger.New(&logger.Settings{}), nil)
 	require.NoError(t, err)
 	require.NotNil(t, c)
 
@@ -229,3 +282,47 @@ func TestConcurrency(t *testing.T) {
 		c.mut.RUnlock()
 	})
 }
+
+func TestDailyProfile(t *testing.T) {
+	t.Run("invalid max users", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.DailyProfile = DailyProfile{
+			DayDurationSec: 60,
+			MaxUsers:       cfg.ClusterConfig.MaxActiveUsers + 1,
+		}
+		require.Error(t, cfg.IsValid())
+	})
+
+	t.Run("invalid curve", func(t *testing.T) {
+		cfg := newConfig(t)
+		cfg.DailyProfile = DailyProfile{
+			DayDurationSec: 60,
+			MaxUsers:       10,
+			Curve:          []float64{0, 1.5},
+		}
+		require.Error(t, cfg.IsValid())
+	})
+
+	t.Run("phase", func(t *testing.T) {
+		require.Equal(t, 0.0, dailyPhase(0, time.Minute))
+		require.Equal(t, 0.5, dailyPhase(30*time.Second, time.Minute))
+		require.Equal(t, 0.25, dailyPhase(75*time.Second, time.Minute))
+	})
+
+	t.Run("sinusoid", func(t *testing.T) {
+		profile := DailyProfile{DayDurationSec: 60, MinUsers: 10, MaxUsers: 110}
+		require.Equal(t, 10, dailyTarget(profile, 0))
+		require.Equal(t, 60, dailyTarget(profile, 0.25))
+		require.Equal(t, 110, dailyTarget(profile, 0.5))
+		require.Equal(t, 60, dailyTarget(profile, 0.75))
+	})
+
+	t.Run("curve", func(t *testing.T) {
+		profile := DailyProfile{DayDurationSec: 60, MinUsers: 0, MaxUsers: 100, Curve: []float64{0, 1, 0.5, 0.5}}
+		require.Equal(t, 0, dailyTarget(profile, 0))
+		require.Equal(t, 50, dailyTarget(profile, 0.125))
+		require.Equal(t, 100, dailyTarget(profile, 0.25))
+		require.Equal(t, 50, dailyTarget(profile, 0.5))
+		require.Equal(t, 25, dailyTarget(profile, 0.875))
+	})
+}
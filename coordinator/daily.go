@@ -0,0 +1,92 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package coordinator
+
+import (
+	"math"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// runDailyProfile drives the number of active users through the configured
+// daily profile until the coordinator is stopped. At each update from the
+// performance monitor users are incremented or decremented towards the
+// target of the current point of the simulated day.
+func (c *Coordinator) runDailyProfile(monitorChan <-chan performance.Status) {
+	profile := c.config.DailyProfile
+	dayDuration := time.Duration(profile.DayDurationSec) * time.Second
+	start := time.Now()
+
+	c.log.Info("coordinator: starting daily profile", mlog.Int("day_duration_sec", profile.DayDurationSec),
+		mlog.Int("min_users", profile.MinUsers), mlog.Int("max_users", profile.MaxUsers))
+
+	for {
+		var perfStatus performance.Status
+
+		select {
+		case <-c.stopChan:
+			c.log.Info("coordinator: shutting down")
+			return
+		case perfStatus = <-monitorChan:
+		}
+
+		phase := dailyPhase(time.Since(start), dayDuration)
+		target := dailyTarget(profile, phase)
+		if c.metrics != nil {
+			c.metrics.DailyPhase.Set(phase)
+			c.metrics.DailyTargetUsers.Set(float64(target))
+		}
+
+		if perfStatus.Alert {
+			c.log.Warn("coordinator: performance degradation alert", mlog.Float64("phase", phase), mlog.Int("target_users", target))
+		}
+
+		status, err := c.cluster.Status()
+		if err != nil {
+			c.log.Error("coordinator: cluster status error:", mlog.Err(err))
+			continue
+		}
+		c.log.Info("coordinator: cluster status:", mlog.Int("active_users", status.ActiveUsers), mlog.Int64("errors", status.NumErrors),
+			mlog.Int("target_users", target))
+
+		switch {
+		case status.ActiveUsers < target:
+			inc := min(c.config.NumUsersInc, target-status.ActiveUsers)
+			c.log.Info("coordinator: incrementing active users", mlog.Int("num_users", inc))
+			if err := c.cluster.IncrementUsers(inc); err != nil {
+				c.log.Error("coordinator: failed to increment users", mlog.Err(err))
+			}
+		case status.ActiveUsers > target:
+			dec := min(c.config.NumUsersDec, status.ActiveUsers-target)
+			c.log.Info("coordinator: decrementing active users", mlog.Int("num_users", dec))
+			if err := c.cluster.DecrementUsers(dec); err != nil {
+				c.log.Error("coordinator: failed to decrement users", mlog.Err(err))
+			}
+		}
+	}
+}
+
+// dailyPhase returns the elapsed fraction, in [0, 1), of the simulated day
+// the given elapsed time falls in.
+func dailyPhase(elapsed, dayDuration time.Duration) float64 {
+	return float64(elapsed%dayDuration) / float64(dayDuration)
+}
+
+// dailyTarget returns the number of active users the given daily profile
+// targets at the given phase of the day.
+func dailyTarget(profile DailyProfile, phase float64) int {
+	var level float64
+	if n := len(profile.Curve); n > 0 {
+		pos := phase * float64(n)
+		idx := int(pos) % n
+		next := profile.Curve[(idx+1)%n]
+		level = profile.Curve[idx] + (next-profile.Curve[idx])*(pos-math.Floor(pos))
+	} else {
+		level = (1 - math.Cos(2*math.Pi*phase)) / 2
+	}
+	return profile.MinUsers + int(math.Round(level*float64(profile.MaxUsers-profile.MinUsers)))
+}
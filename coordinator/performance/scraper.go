@@ -0,0 +1,202 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package performance
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+const scrapeTimeout = 10 * time.Second
+
+// ScraperConfig holds the necessary information to create a Scraper.
+type ScraperConfig struct {
+	// The URL of the metrics endpoint of the target instance
+	// (e.g. http://localhost:8067/metrics). If empty, no metrics are scraped.
+	URL string
+	// The time interval in milliseconds to wait before scraping again.
+	ScrapeIntervalMs int `default:"10000" validate:"range:[1000,]"`
+	// The names of the metrics to record.
+	Metrics []string
+}
+
+// ServerMetric holds the value of a metric recorded from the target instance
+// during a load-test. For counters the value is the increase over the
+// load-test, for every other type of metric it's the average of the scraped
+// values. Histograms and summaries are averaged as sum over count.
+type ServerMetric struct {
+	Name    string
+	Value   float64
+	Samples int // The number of successful scrapes the value is based on.
+}
+
+type metricSamples struct {
+	isCounter bool
+	first     float64
+	last      float64
+	sum       float64
+	count     int
+}
+
+// Scraper periodically scrapes the metrics endpoint of the target instance
+// recording the values of the selected metrics.
+type Scraper struct {
+	config   ScraperConfig
+	client   *http.Client
+	log      *mlog.Logger
+	stopChan chan struct{}
+	doneChan chan struct{}
+
+	mut     sync.RWMutex
+	samples map[string]*metricSamples
+}
+
+// NewScraper creates and initializes a new Scraper.
+func NewScraper(config ScraperConfig, log *mlog.Logger) (*Scraper, error) {
+	if log == nil {
+		return nil, errors.New("logger should not be nil")
+	}
+	if config.URL == "" {
+		return nil, errors.New("URL cannot be empty")
+	}
+	if config.ScrapeIntervalMs < 1000 {
+		return nil, errors.New("ScrapeIntervalMs cannot be less than 1000")
+	}
+
+	return &Scraper{
+		config:   config,
+		client:   &http.Client{Timeout: scrapeTimeout},
+		log:      log,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+		samples:  make(map[string]*metricSamples),
+	}, nil
+}
+
+// Run starts the scraping process.
+func (s *Scraper) Run() {
+	go func() {
+		defer close(s.doneChan)
+		s.log.Info("scraper: started")
+		for {
+			if err := s.scrape(); err != nil {
+				s.log.Warn("scraper: failed to scrape metrics", mlog.Err(err))
+			}
+			select {
+			case <-s.stopChan:
+				s.log.Info("scraper: shutting down")
+				return
+			case <-time.After(time.Duration(s.config.ScrapeIntervalMs) * time.Millisecond):
+			}
+		}
+	}()
+}
+
+// Stop stops the scraping process and waits for it to be done.
+func (s *Scraper) Stop() {
+	s.log.Info("scraper: stop")
+	close(s.stopChan)
+	<-s.doneChan
+}
+
+// Metrics returns the values recorded so far for the selected metrics,
+// sorted by name.
+func (s *Scraper) Metrics() []ServerMetric {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	metrics := make([]ServerMetric, 0, len(s.samples))
+	for name, samples := range s.samples {
+		m := ServerMetric{
+			Name:    name,
+			Samples: samples.count,
+		}
+		if samples.isCounter {
+			m.Value = samples.last - samples.first
+		} else {
+			m.Value = samples.sum / float64(samples.count)
+		}
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics
+}
+
+func (s *Scraper) scrape() error {
+	resp, err := s.client.Get(s.config.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, name := range s.config.Metrics {
+		family, ok := families[name]
+		if !ok {
+			continue
+		}
+		value := familyValue(family)
+		samples, ok := s.samples[name]
+		if !ok {
+			samples = &metricSamples{
+				isCounter: family.GetType() == dto.MetricType_COUNTER,
+				first:     value,
+			}
+			s.samples[name] = samples
+		}
+		samples.last = value
+		samples.sum += value
+		samples.count++
+	}
+
+	return nil
+}
+
+// familyValue aggregates the value of all the series of a metric family.
+func familyValue(family *dto.MetricFamily) float64 {
+	var value, sum float64
+	var count uint64
+	for _, m := range family.GetMetric() {
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			value += m.GetCounter().GetValue()
+		case dto.MetricType_GAUGE:
+			value += m.GetGauge().GetValue()
+		case dto.MetricType_HISTOGRAM:
+			sum += m.GetHistogram().GetSampleSum()
+			count += m.GetHistogram().GetSampleCount()
+		case dto.MetricType_SUMMARY:
+			sum += m.GetSummary().GetSampleSum()
+			count += m.GetSummary().GetSampleCount()
+		default:
+			value += m.GetUntyped().GetValue()
+		}
+	}
+	if count > 0 {
+		return sum / float64(count)
+	}
+	return value
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package performance
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScraper(t *testing.T) {
+	var numScrapes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numScrapes++
+		fmt.Fprintf(w, `# TYPE requests_total counter
+requests_total{path="a"} %d
+requests_total{path="b"} %d
+# TYPE connections gauge
+connections %d
+# TYPE db_time histogram
+db_time_bucket{le="+Inf"} 4
+db_time_sum 2
+db_time_count 4
+# TYPE ignored gauge
+ignored 1
+`, 10*numScrapes, 5*numScrapes, 2*numScrapes)
+	}))
+	defer server.Close()
+
+	s, err := NewScraper(ScraperConfig{
+		URL:              server.URL,
+		ScrapeIntervalMs: 1000,
+		Metrics:          []string{"requests_total", "connections", "db_time", "missing"},
+	}, mlog.CreateConsoleTestLogger(true, mlog.LvlError))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.scrape())
+	}
+
+	require.Equal(t, []ServerMetric{
+		{Name: "connections", Value: 4, Samples: 3},
+		{Name: "db_time", Value: 0.5, Samples: 3},
+		{Name: "requests_total", Value: 30, Samples: 3},
+	}, s.Metrics())
+}
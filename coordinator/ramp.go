@@ -0,0 +1,104 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package coordinator
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// runRampProfile drives the number of active users through the stages of the
+// configured ramp profile. At each update from the performance monitor users
+// are incremented or decremented towards the target of the current stage.
+// Once the target is reached it is held for the duration of the stage
+// before moving on to the next one.
+// It returns the target of the last stage that completed without any
+// performance degradation alert.
+func (c *Coordinator) runRampProfile(monitorChan <-chan performance.Status) int {
+	var supported int
+	var reachedAt time.Time
+	var alerted bool
+
+	stages := c.config.RampProfile
+	idx := 0
+	c.setRampStage(idx)
+
+	for {
+		var perfStatus performance.Status
+
+		select {
+		case <-c.stopChan:
+			c.log.Info("coordinator: shutting down")
+			return supported
+		case perfStatus = <-monitorChan:
+		}
+
+		stage := stages[idx]
+
+		if perfStatus.Alert {
+			alerted = true
+			c.log.Warn("coordinator: performance degradation alert", mlog.Int("stage", idx+1), mlog.Int("target_users", stage.TargetUsers))
+		}
+
+		status, err := c.cluster.Status()
+		if err != nil {
+			c.log.Error("coordinator: cluster status error:", mlog.Err(err))
+			continue
+		}
+		c.log.Info("coordinator: cluster status:", mlog.Int("active_users", status.ActiveUsers), mlog.Int64("errors", status.NumErrors))
+
+		switch {
+		case status.ActiveUsers < stage.TargetUsers:
+			inc := min(c.config.NumUsersInc, stage.TargetUsers-status.ActiveUsers)
+			c.log.Info("coordinator: incrementing active users", mlog.Int("num_users", inc))
+			if err := c.cluster.IncrementUsers(inc); err != nil {
+				c.log.Error("coordinator: failed to increment users", mlog.Err(err))
+			}
+		case status.ActiveUsers > stage.TargetUsers:
+			dec := min(c.config.NumUsersDec, status.ActiveUsers-stage.TargetUsers)
+			c.log.Info("coordinator: decrementing active users", mlog.Int("num_users", dec))
+			if err := c.cluster.DecrementUsers(dec); err != nil {
+				c.log.Error("coordinator: failed to decrement users", mlog.Err(err))
+			}
+		default:
+			if reachedAt.IsZero() {
+				c.log.Info("coordinator: ramp stage target reached", mlog.Int("stage", idx+1), mlog.Int("target_users", stage.TargetUsers))
+				reachedAt = time.Now()
+			}
+			if !hasPassed(reachedAt, time.Duration(stage.DurationSec)*time.Second) {
+				continue
+			}
+
+			if !alerted {
+				supported = stage.TargetUsers
+			}
+
+			idx++
+			if idx == len(stages) {
+				c.log.Info("coordinator done!")
+				return supported
+			}
+
+			reachedAt = time.Time{}
+			alerted = false
+			c.setRampStage(idx)
+		}
+	}
+}
+
+// setRampStage logs and records the transition to the given stage of the
+// ramp profile.
+func (c *Coordinator) setRampStage(idx int) {
+	stage := c.config.RampProfile[idx]
+	c.log.Info("coordinator: starting ramp stage", mlog.Int("stage", idx+1), mlog.Int("target_users", stage.TargetUsers), mlog.Int("duration_sec", stage.DurationSec))
+
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.RampStage.Set(float64(idx + 1))
+	c.metrics.RampStageTargetUsers.Set(float64(stage.TargetUsers))
+}
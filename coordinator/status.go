@@ -8,6 +8,8 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/coordinator/performance"
 )
 
 // State determines which state a Coordinator is in.
@@ -88,4 +90,6 @@ type Status struct {
 	ActiveUsers    int       // Total number of currently active users across the load-test agents cluster.
 	NumErrors      int64     // Total number of errors received from the load-test agents cluster.
 	SupportedUsers int       // Number of supported users.
+	// The metrics recorded from the target instance, if enabled.
+	ServerMetrics []performance.ServerMetric `json:",omitempty"`
 }
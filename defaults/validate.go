@@ -48,6 +48,13 @@ func Validate(value interface{}) error {
 					return err
 				}
 			}
+			// Only slices of structs have fields to be validated.
+			if dv.Kind() != reflect.Slice {
+				continue
+			}
+			if elem := dv.Type().Elem(); elem.Kind() != reflect.Struct && !(elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct) {
+				continue
+			}
 			for j := 0; j < dv.Len(); j++ {
 				if err := Validate(dv.Index(j).Interface()); err != nil {
 					return err
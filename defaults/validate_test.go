@@ -73,6 +73,14 @@ func TestValidate(t *testing.T) {
 		require.Contains(t, err.Error(), fmt.Sprintf("% q", valids))
 	})
 
+	t.Run("slice of strings", func(t *testing.T) {
+		type stringsConfig struct {
+			Values []string
+		}
+		err := Validate(stringsConfig{Values: []string{"a", "b"}})
+		require.NoError(t, err)
+	})
+
 	t.Run("invalid field reference", func(t *testing.T) {
 		type invalidField struct {
 			InitialUsers int `default:"0" validate:"range:[0,$MaxActiveUsers]"`
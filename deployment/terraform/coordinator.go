@@ -59,6 +59,9 @@ func (t *Terraform) StartCoordinator(config *coordinator.Config) error {
 	}
 	config.ClusterConfig.Agents = loadAgentConfigs
 	config.MonitorConfig.PrometheusURL = "http://" + t.output.MetricsServer.PrivateIP + ":9090"
+	if len(config.ServerMetricsConfig.Metrics) > 0 && len(t.output.Instances) > 0 {
+		config.ServerMetricsConfig.URL = "http://" + t.output.Instances[0].PrivateIP + ":8067/metrics"
+	}
 
 	// TODO: consider removing this. Config is passed dynamically when creating
 	// a coordinator resource through the API.
@@ -144,6 +147,15 @@ func (t *Terraform) StartCoordinator(config *coordinator.Config) error {
 		return fmt.Errorf("failed to start coordinator: %w", err)
 	}
 
+	runConfig := RunConfig{
+		AgentConfig:           agentConfig,
+		CoordinatorConfig:     config,
+		SimulControllerConfig: simulConfig,
+	}
+	if err := t.saveRunConfig(runConfig); err != nil {
+		mlog.Warn("Could not save the run config", mlog.Err(err))
+	}
+
 	mlog.Info("Done")
 	return nil
 }
@@ -0,0 +1,66 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-load-test-ng/coordinator"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control/simulcontroller"
+)
+
+// RunConfig holds the configuration that was in effect for a load-test run.
+type RunConfig struct {
+	AgentConfig           *loadtest.Config
+	CoordinatorConfig     *coordinator.Config
+	SimulControllerConfig *simulcontroller.Config
+}
+
+// redact clears all the credentials from the configuration.
+func (c RunConfig) redact() RunConfig {
+	if c.AgentConfig != nil {
+		agentConfig := *c.AgentConfig
+		agentConfig.ConnectionConfiguration.AdminEmail = ""
+		agentConfig.ConnectionConfiguration.AdminPassword = ""
+		c.AgentConfig = &agentConfig
+	}
+	return c
+}
+
+func (t *Terraform) getRunConfigPath() string {
+	name := strings.TrimSuffix(t.getStatePath(), ".tfstate") + ".runconfig.json"
+	return filepath.Join(t.config.TerraformStateDir, name)
+}
+
+// saveRunConfig persists the given configuration, without credentials, so
+// that it can later be included in the report of the run.
+func (t *Terraform) saveRunConfig(config RunConfig) error {
+	data, err := json.MarshalIndent(config.redact(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run config: %w", err)
+	}
+	if err := os.WriteFile(t.getRunConfigPath(), data, 0600); err != nil {
+		return fmt.Errorf("failed to write run config: %w", err)
+	}
+	return nil
+}
+
+// GetRunConfig returns the configuration that was in effect when the
+// coordinator was last started in the current load-test deployment.
+func (t *Terraform) GetRunConfig() (RunConfig, error) {
+	var config RunConfig
+	data, err := os.ReadFile(t.getRunConfigPath())
+	if err != nil {
+		return config, fmt.Errorf("failed to read run config: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to unmarshal run config: %w", err)
+	}
+	return config, nil
+}
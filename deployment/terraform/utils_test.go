@@ -6,6 +6,10 @@ package terraform
 import (
 	"testing"
 
+	"github.com/mattermost/mattermost-load-test-ng/coordinator"
+	"github.com/mattermost/mattermost-load-test-ng/deployment"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest"
+
 	"github.com/stretchr/testify/require"
 )
 
@@ -32,3 +36,29 @@ func TestFillConfigTemplate(t *testing.T) {
 		require.Equal(t, "this is a template", output)
 	})
 }
+
+func TestRunConfig(t *testing.T) {
+	agentConfig, err := loadtest.ReadConfig("../../config/config.sample.json")
+	require.NoError(t, err)
+	coordConfig, err := coordinator.ReadConfig("../../config/coordinator.sample.json")
+	require.NoError(t, err)
+
+	tf := &Terraform{config: &deployment.Config{TerraformStateDir: t.TempDir()}}
+	_, err = tf.GetRunConfig()
+	require.Error(t, err)
+
+	require.NoError(t, tf.saveRunConfig(RunConfig{
+		AgentConfig:       agentConfig,
+		CoordinatorConfig: coordConfig,
+	}))
+
+	config, err := tf.GetRunConfig()
+	require.NoError(t, err)
+	require.Empty(t, config.AgentConfig.ConnectionConfiguration.AdminEmail)
+	require.Empty(t, config.AgentConfig.ConnectionConfiguration.AdminPassword)
+	require.Equal(t, agentConfig.ConnectionConfiguration.ServerURL, config.AgentConfig.ConnectionConfiguration.ServerURL)
+	require.Equal(t, coordConfig, config.CoordinatorConfig)
+	require.Nil(t, config.SimulControllerConfig)
+	// The original config is left untouched.
+	require.NotEmpty(t, agentConfig.ConnectionConfiguration.AdminPassword)
+}
@@ -21,7 +21,10 @@ require (
 	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
 )
 
-require github.com/vmihailenco/msgpack/v5 v5.3.5
+require (
+	github.com/prometheus/client_model v0.2.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+)
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.0 // indirect
@@ -75,7 +78,6 @@ require (
 	github.com/philhofer/fwd v1.1.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/prometheus/client_model v0.2.0 // indirect
 	github.com/prometheus/procfs v0.7.3 // indirect
 	github.com/rs/xid v1.4.0 // indirect
 	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca // indirect
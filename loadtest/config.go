@@ -21,6 +21,98 @@ type ConnectionConfiguration struct {
 	AdminEmail string `default:"sysadmin@sample.mattermost.com" validate:"email"`
 	// Password of the system admin.
 	AdminPassword string `default:"Sys@dmin-sample1" validate:"notempty"`
+	// The number of consecutive WebSocket reconnect attempts after which a
+	// user is reported as degraded while it keeps retrying.
+	// Zero disables the degraded state.
+	WebSocketDegradedThreshold int `default:"5" validate:"range:[0,]"`
+	// The number of consecutive WebSocket reconnect attempts after which a
+	// user gives up reconnecting and reports a failure.
+	// Zero means the user will retry indefinitely.
+	WebSocketMaxReconnectAttempts int `default:"0" validate:"range:[0,]"`
+	// The fraction by which the WebSocket reconnect wait time is randomly
+	// increased or decreased, to avoid users reconnecting in lockstep.
+	WebSocketReconnectJitter float64 `default:"0.1" validate:"range:[0,1]"`
+	// The minimum time (in milliseconds) a user waits before reconnecting
+	// the WebSocket.
+	WebSocketMinReconnectDurationMs int `default:"3000" validate:"range:[0,]"`
+	// The maximum time (in milliseconds) a user waits before reconnecting
+	// the WebSocket.
+	WebSocketMaxReconnectDurationMs int `default:"300000" validate:"range:[$WebSocketMinReconnectDurationMs,]"`
+	// The number of consecutive failed connections after which the wait
+	// before reconnecting starts growing.
+	WebSocketFailThreshold int `default:"7" validate:"range:[0,]"`
+	// The time (in milliseconds) after which a WebSocket connection is
+	// considered healthy, resetting the reconnect backoff once it drops.
+	// Zero means the backoff is never reset.
+	WebSocketHealthyDurationMs int `default:"60000" validate:"range:[0,]"`
+	// The maximum time (in milliseconds) a user waits for any message from
+	// the server before considering its WebSocket connection dead and
+	// reconnecting. Zero means the default of one minute.
+	WebSocketReadTimeoutMs int `default:"60000" validate:"range:[0,]"`
+	// The maximum size (in bytes) of a WebSocket message read from the
+	// server. Larger messages make the connection close and reconnect. Zero
+	// means a default fitting the server's default post size limit.
+	WebSocketMaxMessageSizeBytes int `default:"0" validate:"range:[0,]"`
+	// If true, users request WebSocket messages to be compressed.
+	WebSocketEnableCompression bool `default:"false"`
+	// The number of WebSocket events buffered per user while waiting to be
+	// handled by its controller. Zero means events are handed over directly.
+	WebSocketEventsBufferSize int `default:"0" validate:"range:[0,]"`
+	// If true, the WebSocket events a controller can't keep up with are
+	// dropped, once the buffer is full, instead of blocking the connection.
+	WebSocketDropEventsWhenFull bool `default:"false"`
+	// The time (in milliseconds) after which a WebSocket event still waiting
+	// to be received by a user's controller is reported as such, with a
+	// warning and a metric. Zero disables the reporting.
+	WebSocketSlowConsumerThresholdMs int `default:"0" validate:"range:[0,]"`
+	// The number of concurrent WebSocket connections opened by each user, to
+	// model clients connected from several devices at once.
+	WebSocketConnectionsPerUser int `default:"1" validate:"range:[0,]"`
+	// The time window (in milliseconds) within which the typing events sent
+	// by a user for a same channel are coalesced into one. Zero disables
+	// coalescing.
+	TypingCoalesceWindowMs int `default:"0" validate:"range:[0,]"`
+	// The maximum number of typing events per second a user sends for a
+	// same channel, in excess of TypingEventBurst. Events exceeding the rate
+	// are dropped. Zero disables the limit.
+	TypingEventRate float64 `default:"0" validate:"range:[0,)"`
+	// The number of typing events a user can send in a burst for a same
+	// channel before TypingEventRate applies.
+	TypingEventBurst int `default:"1" validate:"range:[0,]"`
+	// If true, users back off when rate limited by the server, honoring the
+	// Retry-After header, before retrying the request.
+	RateLimitBackoff bool `default:"false"`
+	// The number of WebSocket sequence mismatches within
+	// StoreUnhealthyWindowMs after which a user's store is considered out of
+	// sync and its actions are paused. Zero disables the check.
+	StoreUnhealthyThreshold int `default:"0" validate:"range:[0,]"`
+	// The time window (in milliseconds) over which sequence mismatches are
+	// counted.
+	StoreUnhealthyWindowMs int `default:"60000" validate:"range:[0,]"`
+	// The number of consecutive WebSocket events received in sequence after
+	// which an out of sync user resumes its actions.
+	StoreRecoveryEvents int `default:"10" validate:"range:[0,]"`
+	// The maximum number of missed WebSocket events that a user recovers
+	// from by fetching the missed posts of its current channel, instead of
+	// reconnecting. Zero means always reconnecting.
+	WebSocketSeqGapRecoveryThreshold int `default:"0" validate:"range:[0,]"`
+	// The maximum number of post writes made while handling WebSocket events
+	// that a user applies to its store at once. Zero disables batching.
+	PostWriteBatchSize int `default:"0" validate:"range:[0,]"`
+	// The maximum time (in milliseconds) a batched post write waits before
+	// being applied to the store.
+	PostWriteFlushIntervalMs int `default:"100" validate:"range:[0,]"`
+	// The maximum number of idle connections kept by the HTTP transport
+	// shared by all users. Zero means it's derived from MaxActiveUsers.
+	MaxIdleConns int `default:"0" validate:"range:[0,]"`
+	// The maximum number of idle connections kept per host by the shared
+	// HTTP transport. Zero means it's derived from MaxActiveUsers.
+	MaxIdleConnsPerHost int `default:"0" validate:"range:[0,]"`
+	// The time (in milliseconds) after which an idle connection is closed.
+	IdleConnTimeoutMs int `default:"90000" validate:"range:[0,]"`
+	// If true, users set their status to online after every WebSocket
+	// reconnect, as the webapp does.
+	SetOnlineOnReconnect bool `default:"false"`
 }
 
 // userControllerType describes the type of a UserController.
@@ -42,6 +134,28 @@ type RatesDistribution struct {
 	Percentage float64 `default:"1.0" validate:"range:[0,1]"`
 }
 
+// DefaultPersona is the persona assigned to users when no
+// PersonasDistribution is configured.
+const DefaultPersona = "default"
+
+// ReceiverPersona is the persona assigned to users that only receive
+// WebSocket events without performing any action.
+const ReceiverPersona = "receiver"
+
+// PersonaDistribution maps a persona to a percentage of users that should be
+// tagged with it.
+type PersonaDistribution struct {
+	Persona    string  `default:"default" validate:"notempty"`
+	Percentage float64 `default:"1.0" validate:"range:[0,1]"`
+}
+
+// LocaleDistribution maps a locale to a percentage of users that should be
+// assigned it.
+type LocaleDistribution struct {
+	Locale     string  `default:"en" validate:"notempty"`
+	Percentage float64 `default:"1.0" validate:"range:[0,1]"`
+}
+
 // UserControllerConfiguration holds information about the UserController to
 // run during a load-test.
 type UserControllerConfiguration struct {
@@ -133,6 +247,97 @@ type UsersConfiguration struct {
 	MaxActiveUsers int `default:"2000" validate:"range:(0,]"`
 	// The average number of sessions per user.
 	AvgSessionsPerUser int `default:"1" validate:"range:[1,]"`
+	// A distribution of personas (e.g. "lurker", "poster", "admin") used to tag
+	// the metrics emitted by each user so that they can be isolated by persona.
+	PersonasDistribution []PersonaDistribution `default_len:"1"`
+	// The maximum clock skew (in milliseconds) applied to the timestamps sent
+	// by each user. Every user gets a fixed offset picked uniformly in the
+	// [-ClockSkewMaxMs, ClockSkewMaxMs] interval. Zero disables clock skew.
+	ClockSkewMaxMs int `default:"0" validate:"range:[0,]"`
+	// The percentage of users that log in with a simulated mobile device, making
+	// the server send them push notifications.
+	PercentMobileSessions float64 `default:"0" validate:"range:[0,1]"`
+	// The percentage of users that only receive WebSocket events without ever
+	// performing actions or sending messages. These users get the "receiver"
+	// persona.
+	PercentReceivers float64 `default:"0" validate:"range:[0,1]"`
+	// The names of the public channels receivers join in each of their teams.
+	// If empty, receivers join all the public channels.
+	ReceiverChannels []string
+	// The number of created posts sampled to check, once the load-test is
+	// stopped, that their posted event was received by all the connected
+	// members of the channel. Zero disables the check.
+	DeliveryCheckSampleSize int `default:"0" validate:"range:[0,]"`
+	// A distribution of locales (e.g. "en", "ja", "zh-CN") assigned to users.
+	// Each user sets its locale preference and sends it in the Accept-Language
+	// header to exercise locale dependent server behaviour. If empty, users
+	// keep their current locale.
+	LocalesDistribution []LocaleDistribution
+	// The rate (in users per minute) at which brand-new users arrive during
+	// the load-test. Each arrival signs up a new account and goes through
+	// onboarding before becoming an active user. Zero disables arrivals.
+	ArrivalsPerMinute float64 `default:"0" validate:"range:[0,]"`
+	// The maximum number of new users arriving during the load-test.
+	MaxArrivals int `default:"100" validate:"range:[0,]"`
+	// The maximum number of posts each user keeps in memory.
+	MaxStoredPosts int `default:"500" validate:"range:[1,]"`
+	// The directory where each user spills the posts evicted from memory.
+	// If empty, evicted posts are discarded.
+	PostsSpillDir string `default:""`
+	// The time window (in milliseconds) over which each user computes the
+	// post rate of the channels it receives posts for.
+	ChannelPostRateWindowMs int `default:"60000" validate:"range:[0,]"`
+	// If true, users apply reactions received for posts in any loaded channel
+	// rather than only for posts in the channel they are viewing.
+	TrackAllLoadedChannels bool `default:"false"`
+	// The minimum time (in milliseconds) a channel must have been the one
+	// viewed by a user for the reactions to its posts to be applied.
+	CurrentChannelDwellMs int `default:"0" validate:"range:[0,]"`
+	// If true, users store the profiles of the users they are notified about
+	// through user_updated events even if they didn't know about them yet.
+	CacheUpdatedUsers bool `default:"false"`
+	// The directory where the errors reported by the users are written, in
+	// a gzip compressed file per persona. If empty, errors are only logged.
+	ErrorLogDir string `default:""`
+	// The size (in uncompressed bytes) after which an error log file is
+	// rotated. It must be greater than zero if ErrorLogDir is set.
+	ErrorLogMaxFileSizeBytes int `default:"104857600" validate:"range:[0,]"`
+	// The number of rotated error log files kept for each persona.
+	ErrorLogMaxBackups int `default:"5" validate:"range:[0,]"`
+}
+
+// IsValid reports whether a given UsersConfiguration is valid or not.
+// Returns an error if the validation fails.
+func (uc *UsersConfiguration) IsValid() error {
+	var sum float64
+	for _, el := range uc.PersonasDistribution {
+		sum += el.Percentage
+	}
+	if len(uc.PersonasDistribution) > 0 && (math.Round(sum*100)/100) != 1 {
+		return errors.New("Percentages in PersonasDistribution should sum to 1")
+	}
+
+	sum = 0
+	for _, el := range uc.LocalesDistribution {
+		sum += el.Percentage
+	}
+	if len(uc.LocalesDistribution) > 0 && (math.Round(sum*100)/100) != 1 {
+		return errors.New("Percentages in LocalesDistribution should sum to 1")
+	}
+
+	if uc.ErrorLogDir != "" && uc.ErrorLogMaxFileSizeBytes == 0 {
+		return errors.New("ErrorLogMaxFileSizeBytes should be greater than zero if ErrorLogDir is set")
+	}
+	return nil
+}
+
+// MetricsConfiguration holds information about the metrics emitted by the
+// load-test agent.
+type MetricsConfiguration struct {
+	// The minimum duration (in milliseconds) of a request for its latency
+	// sample to carry an exemplar holding the server's request id.
+	// Zero disables exemplars.
+	ExemplarMinLatencyMs int `default:"0" validate:"range:[0,]"`
 }
 
 // Config holds information needed to create and initialize a new load-test
@@ -142,6 +347,7 @@ type Config struct {
 	UserControllerConfiguration UserControllerConfiguration
 	InstanceConfiguration       InstanceConfiguration
 	UsersConfiguration          UsersConfiguration
+	MetricsConfiguration        MetricsConfiguration
 	LogSettings                 logger.Settings
 }
 
@@ -154,6 +360,9 @@ func (c *Config) IsValid() error {
 	if err := c.InstanceConfiguration.IsValid(); err != nil {
 		return err
 	}
+	if err := c.UsersConfiguration.IsValid(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,161 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package receivercontroller
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+func (c *ReceiverController) connect() error {
+	if !atomic.CompareAndSwapInt32(&c.connectedFlag, 0, 1) {
+		return errors.New("already connected")
+	}
+	errChan, err := c.user.Connect()
+	if err != nil {
+		atomic.StoreInt32(&c.connectedFlag, 0)
+		return fmt.Errorf("connect failed %w", err)
+	}
+	c.wg.Add(2)
+	go func() {
+		defer c.wg.Done()
+		for err := range errChan {
+			c.status <- c.newErrorStatus(err)
+		}
+	}()
+	go func() {
+		defer c.wg.Done()
+		c.wsEventHandler()
+	}()
+	return nil
+}
+
+func (c *ReceiverController) disconnect() error {
+	if !atomic.CompareAndSwapInt32(&c.connectedFlag, 1, 0) {
+		return errors.New("not connected")
+	}
+
+	// The user is disconnected even if an error is returned, so the
+	// goroutines still need to be waited for.
+	err := c.user.Disconnect()
+	c.wg.Wait()
+	if err != nil {
+		return fmt.Errorf("disconnect failed %w", err)
+	}
+
+	return nil
+}
+
+func (c *ReceiverController) login(u user.User) control.UserActionResponse {
+	for {
+		resp := control.Login(u)
+		if resp.Err == nil {
+			err := c.connect()
+			if err == nil {
+				return resp
+			}
+			c.status <- c.newErrorStatus(err)
+		}
+
+		c.status <- c.newErrorStatus(resp.Err)
+
+		select {
+		case <-c.stopChan:
+			return control.UserActionResponse{Info: "login canceled"}
+		case <-time.After(control.PickIdleTimeMs(1000, 20000, 1.0)):
+		}
+	}
+}
+
+// joinTeams makes the user a member of all the open teams.
+func (c *ReceiverController) joinTeams(u user.User) control.UserActionResponse {
+	if _, err := u.GetAllTeams(0, 100); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	memberOf, err := u.GetTeamsForUser(u.Store().Id())
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	isMember := make(map[string]bool, len(memberOf))
+	for _, teamId := range memberOf {
+		isMember[teamId] = true
+	}
+
+	teams, err := u.Store().Teams()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	var joined int
+	for _, team := range teams {
+		if isMember[team.Id] || team.Type != model.TeamOpen {
+			continue
+		}
+		if err := u.AddTeamMember(team.Id, u.Store().Id()); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		joined++
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("joined %d teams", joined)}
+}
+
+// joinChannels makes the user a member of the configured public channels in
+// each of its teams, or of all of them if none is configured.
+func (c *ReceiverController) joinChannels(u user.User) control.UserActionResponse {
+	names := make(map[string]bool, len(c.channelNames))
+	for _, name := range c.channelNames {
+		names[name] = true
+	}
+
+	teamIds, err := u.GetTeamsForUser(u.Store().Id())
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	var joined int
+	for _, teamId := range teamIds {
+		if err := u.GetPublicChannelsForTeam(teamId, 0, 100); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+
+		if err := u.GetChannelMembersForUser(u.Store().Id(), teamId); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+
+		channels, err := u.Store().Channels(teamId)
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+
+		for _, channel := range channels {
+			if channel.Type != model.ChannelTypeOpen || (len(names) > 0 && !names[channel.Name]) {
+				continue
+			}
+
+			member, err := u.Store().ChannelMember(channel.Id, u.Store().Id())
+			if err != nil {
+				return control.UserActionResponse{Err: control.NewUserError(err)}
+			}
+			if member.UserId != "" {
+				continue
+			}
+
+			if err := u.AddChannelMember(channel.Id, u.Store().Id()); err != nil {
+				return control.UserActionResponse{Err: control.NewUserError(err)}
+			}
+			joined++
+		}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("joined %d channels", joined)}
+}
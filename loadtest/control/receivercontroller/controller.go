@@ -0,0 +1,120 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package receivercontroller
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user"
+)
+
+// ReceiverController is a controller that only receives WebSocket events.
+// After joining its teams and channels it never performs any further action
+// nor sends any outbound WebSocket message, so that its resource profile
+// isolates the cost of the server pushing events to clients.
+type ReceiverController struct {
+	id            int
+	user          user.User
+	status        chan<- control.UserStatus
+	channelNames  []string        // the names of the channels to join in every team
+	stopChan      chan struct{}   // this channel coordinates the stop sequence of the controller
+	stoppedChan   chan struct{}   // blocks until controller cleans up everything
+	connectedFlag int32           // indicates that the controller is connected
+	wg            *sync.WaitGroup // to keep the track of every goroutine created by the controller
+}
+
+// New creates and initializes a new ReceiverController with given parameters.
+// An id is provided to identify the controller, a User is passed as the entity to be controlled and
+// a UserStatus channel is passed to communicate errors and information about the user's status.
+// channelNames holds the names of the public channels the user joins in each of
+// its teams. If empty, the user joins all the public channels.
+func New(id int, user user.User, channelNames []string, status chan<- control.UserStatus) (*ReceiverController, error) {
+	if user == nil {
+		return nil, errors.New("nil params passed")
+	}
+
+	return &ReceiverController{
+		id:           id,
+		user:         user,
+		status:       status,
+		channelNames: channelNames,
+		stopChan:     make(chan struct{}),
+		stoppedChan:  make(chan struct{}),
+		wg:           &sync.WaitGroup{},
+	}, nil
+}
+
+// Run performs the initial set of actions needed to receive events and then
+// waits until Stop() is invoked.
+// This is also a blocking function, so it is recommended to invoke it
+// inside a goroutine.
+func (c *ReceiverController) Run() {
+	if c.user == nil {
+		c.sendFailStatus("controller was not initialized")
+		return
+	}
+
+	c.status <- control.UserStatus{ControllerId: c.id, User: c.user, Info: "user started", Code: control.USER_STATUS_STARTED}
+
+	defer func() {
+		if err := c.disconnect(); err != nil {
+			c.status <- c.newErrorStatus(control.NewUserError(err))
+		}
+		c.user.ClearUserData()
+		c.sendStopStatus()
+		close(c.stoppedChan)
+	}()
+
+	initActions := []control.UserAction{
+		control.SignUp,
+		c.login,
+		c.joinTeams,
+		c.joinChannels,
+	}
+
+	for i := 0; i < len(initActions); i++ {
+		select {
+		case <-c.stopChan:
+			return
+		case <-time.After(time.Second):
+		}
+
+		if resp := initActions[i](c.user); resp.Err != nil {
+			c.status <- c.newErrorStatus(resp.Err)
+			i--
+		} else {
+			c.status <- c.newInfoStatus(resp.Info)
+		}
+	}
+
+	<-c.stopChan
+}
+
+// SetRate is a no-op since the controller doesn't perform any action.
+func (c *ReceiverController) SetRate(rate float64) error {
+	if rate < 0 {
+		return errors.New("rate should be a positive value")
+	}
+	return nil
+}
+
+// Stop stops the controller.
+func (c *ReceiverController) Stop() {
+	close(c.stopChan)
+	<-c.stoppedChan
+	// re-initialize for the next use
+	c.stopChan = make(chan struct{})
+	c.stoppedChan = make(chan struct{})
+}
+
+func (c *ReceiverController) sendFailStatus(reason string) {
+	c.status <- control.UserStatus{ControllerId: c.id, User: c.user, Code: control.USER_STATUS_FAILED, Err: errors.New(reason)}
+}
+
+func (c *ReceiverController) sendStopStatus() {
+	c.status <- control.UserStatus{ControllerId: c.id, User: c.user, Info: "user stopped", Code: control.USER_STATUS_STOPPED}
+}
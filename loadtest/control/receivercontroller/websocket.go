@@ -0,0 +1,12 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package receivercontroller
+
+// wsEventHandler consumes the WebSocket events received by the user without
+// ever responding to them.
+func (c *ReceiverController) wsEventHandler() {
+	for range c.user.Events() {
+		// do nothing
+	}
+}
@@ -177,10 +177,12 @@ func (c *SimpleController) disconnect() error {
 		return errors.New("not connected")
 	}
 
+	// The user is disconnected even if an error is returned, so the
+	// goroutines still need to be waited for.
 	err := c.user.Disconnect()
+	c.wg.Wait()
 	if err != nil {
 		return fmt.Errorf("disconnect failed %w", err)
 	}
-	c.wg.Wait()
 	return nil
 }
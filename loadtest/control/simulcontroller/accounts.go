@@ -0,0 +1,77 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package simulcontroller
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// accountRegistry keeps track of the non-admin users run by the controllers
+// of this process and of whether their account got deactivated. This lets
+// admins only deactivate users whose controller can pause accordingly,
+// instead of leaving them erroring while acting on a deactivated account.
+type accountRegistry struct {
+	mut sync.RWMutex
+	// maps the id of each registered user to whether it's deactivated.
+	users map[string]bool
+}
+
+var accounts = newAccountRegistry()
+
+func newAccountRegistry() *accountRegistry {
+	return &accountRegistry{
+		users: make(map[string]bool),
+	}
+}
+
+func (r *accountRegistry) register(userId string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if _, ok := r.users[userId]; !ok {
+		r.users[userId] = false
+	}
+}
+
+func (r *accountRegistry) unregister(userId string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.users, userId)
+}
+
+func (r *accountRegistry) isDeactivated(userId string) bool {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return r.users[userId]
+}
+
+func (r *accountRegistry) setDeactivated(userId string, deactivated bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if _, ok := r.users[userId]; ok {
+		r.users[userId] = deactivated
+	}
+}
+
+// pickToDeactivate randomly selects an active registered user, other than
+// the specified one, and marks it as deactivated. It returns false if no
+// such user exists.
+func (r *accountRegistry) pickToDeactivate(excludeId string) (string, bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var candidates []string
+	for userId, deactivated := range r.users {
+		if !deactivated && userId != excludeId {
+			candidates = append(candidates, userId)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	userId := candidates[rand.Intn(len(candidates))]
+	r.users[userId] = true
+	return userId, true
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package simulcontroller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountRegistry(t *testing.T) {
+	r := newAccountRegistry()
+
+	_, ok := r.pickToDeactivate("")
+	require.False(t, ok)
+
+	r.register("user1")
+	r.register("user2")
+
+	_, ok = r.pickToDeactivate("user1")
+	require.True(t, ok)
+	require.True(t, r.isDeactivated("user2"))
+	require.False(t, r.isDeactivated("user1"))
+
+	// Only active users can be picked.
+	_, ok = r.pickToDeactivate("user1")
+	require.False(t, ok)
+
+	// Registering again doesn't reset the state.
+	r.register("user2")
+	require.True(t, r.isDeactivated("user2"))
+
+	r.setDeactivated("user2", false)
+	require.False(t, r.isDeactivated("user2"))
+
+	r.unregister("user2")
+	r.setDeactivated("user2", true)
+	require.False(t, r.isDeactivated("user2"))
+}
@@ -4,9 +4,11 @@
 package simulcontroller
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,6 +19,7 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/userentity"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 )
@@ -54,14 +57,15 @@ func (c *SimulController) disconnect() error {
 		return errors.New("not connected")
 	}
 
+	// The user is disconnected even if an error is returned, so the
+	// goroutines still need to be stopped.
 	err := c.user.Disconnect()
+	c.disconnectChan <- struct{}{}
+	c.wg.Wait()
 	if err != nil {
 		return fmt.Errorf("disconnect failed %w", err)
 	}
 
-	c.disconnectChan <- struct{}{}
-	c.wg.Wait()
-
 	return nil
 }
 
@@ -116,12 +120,71 @@ func (c *SimulController) loginOrSignUp(u user.User) control.UserActionResponse
 		if resp = control.SignUp(u); resp.Err != nil {
 			return resp
 		}
+		// The user is only stored when the account was actually created,
+		// as opposed to already existing.
+		c.signedUp = u.Store().Id() != ""
 		c.status <- c.newInfoStatus(resp.Info)
 		return c.login(u)
 	}
 	return resp
 }
 
+// Onboarding preferences, as written by the webapp.
+const (
+	prefCategoryOnboardingTaskList = "onboarding_task_list"
+	prefNameOnboardingTaskListShow = "onboarding_task_list_show"
+	prefNameOnboardingTaskListOpen = "onboarding_task_list_open"
+	prefNameCompleteProfile        = "complete_your_profile"
+	tutorialStepFinished           = "999"
+	numTutorialSteps               = 4
+)
+
+// onboard simulates a newly signed up user going through the onboarding
+// tutorial and task list, once per account created by the controller.
+func (c *SimulController) onboard(u user.User) control.UserActionResponse {
+	if !c.signedUp {
+		return control.UserActionResponse{Info: "onboarding skipped for existing user"}
+	}
+
+	userId := u.Store().Id()
+	// Preferences are fetched first to figure out the tutorial step to show.
+	if err := u.GetPreferences(); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	updatePref := func(category, name, value string) error {
+		return u.UpdatePreferences(model.Preferences{
+			{UserId: userId, Category: category, Name: name, Value: value},
+		})
+	}
+
+	// Each tutorial step is saved as the user moves through the tour.
+	for step := 0; step < numTutorialSteps; step++ {
+		if err := updatePref(model.PreferenceCategoryTutorialSteps, userId, strconv.Itoa(step)); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+	if err := updatePref(model.PreferenceCategoryTutorialSteps, userId, tutorialStepFinished); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	for _, pref := range []struct{ name, value string }{
+		{prefNameOnboardingTaskListShow, "true"},
+		{prefNameOnboardingTaskListOpen, "true"},
+		{prefNameCompleteProfile, "true"},
+		{prefNameOnboardingTaskListOpen, "false"},
+		{prefNameOnboardingTaskListShow, "false"},
+	} {
+		if err := updatePref(prefCategoryOnboardingTaskList, pref.name, pref.value); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+
+	c.signedUp = false
+
+	return control.UserActionResponse{Info: "onboarding completed"}
+}
+
 func (c *SimulController) login(u user.User) control.UserActionResponse {
 	for {
 		resp := control.Login(u)
@@ -160,6 +223,13 @@ func (c *SimulController) logout() control.UserActionResponse {
 	return control.UserActionResponse{Info: "logged out"}
 }
 
+func (c *SimulController) refreshSession(u user.User) control.UserActionResponse {
+	if err := u.RefreshSession(); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	return control.UserActionResponse{Info: "session refreshed"}
+}
+
 func (c *SimulController) logoutLogin(u user.User) control.UserActionResponse {
 	// logout
 	if resp := c.logout(); resp.Err != nil {
@@ -181,6 +251,46 @@ func (c *SimulController) logoutLogin(u user.User) control.UserActionResponse {
 	return c.reload(false)
 }
 
+// reactivationCheckInterval is how often a deactivated user checks whether
+// its account got reactivated.
+const reactivationCheckInterval = 5 * time.Second
+
+// waitForReactivation pauses the user while its account is deactivated. Once
+// reactivated, the user logs in again and reloads as its previous session was
+// revoked. It returns false if the controller got stopped in the meantime.
+func (c *SimulController) waitForReactivation() bool {
+	userId := c.user.Store().Id()
+
+	if err := c.disconnect(); err != nil {
+		c.status <- c.newErrorStatus(control.NewUserError(err))
+	}
+	c.status <- c.newInfoStatus("account deactivated, waiting for reactivation")
+
+	for accounts.isDeactivated(userId) {
+		select {
+		case <-c.stopChan:
+			return false
+		case <-time.After(reactivationCheckInterval):
+		}
+	}
+
+	c.user.ClearUserData()
+
+	if resp := c.login(c.user); resp.Err != nil {
+		c.status <- c.newErrorStatus(resp.Err)
+	} else {
+		c.status <- c.newInfoStatus(resp.Info)
+	}
+
+	if resp := c.reload(false); resp.Err != nil {
+		c.status <- c.newErrorStatus(resp.Err)
+	} else {
+		c.status <- c.newInfoStatus(resp.Info)
+	}
+
+	return true
+}
+
 func (c *SimulController) joinTeam(u user.User) control.UserActionResponse {
 	userStore := u.Store()
 	userId := userStore.Id()
@@ -294,6 +404,195 @@ func (c *SimulController) joinChannel(u user.User) control.UserActionResponse {
 	return control.UserActionResponse{Info: fmt.Sprintf("joined channel %s", channel.Id)}
 }
 
+// archiveChannels simulates an admin archiving several stale channels of the
+// current team in quick succession.
+func (c *SimulController) archiveChannels(u user.User) control.UserActionResponse {
+	if ok, err := u.IsSysAdmin(); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if !ok {
+		return control.UserActionResponse{Info: "user is not a system admin"}
+	}
+
+	team, err := u.Store().CurrentTeam()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if team == nil {
+		return control.UserActionResponse{Err: control.NewUserError(errors.New("current team should be set"))}
+	}
+
+	channels, err := u.Store().Channels(team.Id)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	staleBefore := model.GetMillis() - int64(c.config.ArchiveChannelsStaleAfterDays)*24*time.Hour.Milliseconds()
+	var candidates []string
+	for _, channel := range channels {
+		if channel.Type != model.ChannelTypeOpen && channel.Type != model.ChannelTypePrivate {
+			continue
+		}
+		// Default channels cannot be archived.
+		if channel.Name == model.DefaultChannelName || channel.Name == "off-topic" || channel.DeleteAt > 0 {
+			continue
+		}
+		if !isStaleChannel(&channel, staleBefore) {
+			continue
+		}
+		candidates = append(candidates, channel.Id)
+	}
+
+	if len(candidates) == 0 {
+		return control.UserActionResponse{Info: "no stale channels to archive"}
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	numChannels := 1 + rand.Intn(c.config.ArchiveChannelsMaxCount)
+	if numChannels > len(candidates) {
+		numChannels = len(candidates)
+	}
+
+	for _, channelId := range candidates[:numChannels] {
+		if err := u.DeleteChannel(channelId); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("archived %d channels in team %s", numChannels, team.Id)}
+}
+
+// toggleUserActive deactivates the account of a user run by this process or,
+// if this admin already deactivated one, reactivates it.
+func (c *SimulController) toggleUserActive(u user.User) control.UserActionResponse {
+	if ok, err := u.IsSysAdmin(); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if !ok {
+		return control.UserActionResponse{Info: "user is not a system admin"}
+	}
+
+	if c.deactivatedUserId != "" {
+		return c.reactivateUser(u)
+	}
+
+	// The user is marked as deactivated before the actual request so that
+	// its controller stops acting as soon as possible.
+	userId, ok := accounts.pickToDeactivate(u.Store().Id())
+	if !ok {
+		return control.UserActionResponse{Info: "no user to deactivate"}
+	}
+
+	if err := u.UpdateUserActive(userId, false); err != nil {
+		accounts.setDeactivated(userId, false)
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	c.deactivatedUserId = userId
+
+	return control.UserActionResponse{Info: fmt.Sprintf("deactivated user %s", userId)}
+}
+
+func (c *SimulController) reactivateUser(u user.User) control.UserActionResponse {
+	userId := c.deactivatedUserId
+	if err := u.UpdateUserActive(userId, true); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	accounts.setDeactivated(userId, false)
+	c.deactivatedUserId = ""
+
+	return control.UserActionResponse{Info: fmt.Sprintf("reactivated user %s", userId)}
+}
+
+// maxPostsOnScreen is the number of most recent posts of a channel considered
+// to be read when viewing it.
+const maxPostsOnScreen = 30
+
+// viewProfilePopovers simulates the user hovering on the usernames of the
+// authors of the posts it has read in the current channel, which fetches their
+// profile and status.
+func (c *SimulController) viewProfilePopovers(u user.User) control.UserActionResponse {
+	channel, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	posts, err := u.Store().ChannelPostsSorted(channel.Id, false)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	if len(posts) > maxPostsOnScreen {
+		posts = posts[:maxPostsOnScreen]
+	}
+
+	ttl := time.Duration(c.config.ProfilePopoverCacheTTLSec) * time.Second
+	var fetched int
+	for _, post := range posts {
+		if post.UserId == u.Store().Id() || rand.Float64() >= c.config.ProfilePopoverRate {
+			continue
+		}
+		if fetchedAt, ok := c.profilesFetchedAt[post.UserId]; ok && time.Since(fetchedAt) < ttl {
+			continue
+		}
+
+		if _, err := u.GetUsersByIds([]string{post.UserId}); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		if err := u.GetUsersStatusesByIds([]string{post.UserId}); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		c.profilesFetchedAt[post.UserId] = time.Now()
+		fetched++
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("viewed %d profile popovers", fetched)}
+}
+
+// maxMembershipGrowthAttempts is the maximum number of channels checked when
+// looking for one to grow.
+const maxMembershipGrowthAttempts = 5
+
+// growChannelMembership makes the user join a public channel in the current
+// team which has not yet reached the configured maximum number of members.
+func (c *SimulController) growChannelMembership(u user.User) control.UserActionResponse {
+	team, err := u.Store().CurrentTeam()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if team == nil {
+		return control.UserActionResponse{Info: "current team is not set"}
+	}
+
+	if err := u.GetPublicChannelsForTeam(team.Id, 0, 100); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	for i := 0; i < maxMembershipGrowthAttempts; i++ {
+		channel, err := u.Store().RandomChannel(team.Id, store.SelectNotMemberOf|store.SelectNotPrivate|store.SelectNotDirect|store.SelectNotGroup)
+		if errors.Is(err, memstore.ErrChannelStoreEmpty) {
+			return control.UserActionResponse{Info: "no channel to grow"}
+		} else if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+
+		if err := u.GetChannelStats(channel.Id); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		stats, err := u.Store().ChannelStats(channel.Id)
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		} else if stats != nil && stats.MemberCount >= int64(c.config.MembershipGrowthMaxMembers) {
+			continue
+		}
+
+		if err := u.AddChannelMember(channel.Id, u.Store().Id()); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		return control.UserActionResponse{Info: fmt.Sprintf("membership growth: joined channel %s", channel.Id)}
+	}
+
+	return control.UserActionResponse{Info: "no channel below the maximum number of members found"}
+}
+
 // fetchPostsInfo fetches additional information for the given posts ids like
 // statuses and profile pictures of the posters and thumbnails for file
 // attachments.
@@ -432,6 +731,10 @@ func viewChannel(u user.User, channel *model.Channel) control.UserActionResponse
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
 
+	if err := fetchChannelBookmarks(u, channel.Id); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
 	if channel.Type == model.ChannelTypeDirect || channel.Type == model.ChannelTypeGroup {
 		category := map[model.ChannelType]string{
 			model.ChannelTypeDirect: model.PreferenceCategoryDirectChannelShow,
@@ -451,7 +754,7 @@ func viewChannel(u user.User, channel *model.Channel) control.UserActionResponse
 				UserId:   u.Store().Id(),
 				Category: "channel_open_time", // This is a client defined constant.
 				Name:     channel.Id,
-				Value:    time.Now().Format(time.RFC3339),
+				Value:    u.Now().Format(time.RFC3339),
 			},
 		}
 
@@ -491,6 +794,55 @@ func switchChannel(u user.User) control.UserActionResponse {
 	return control.UserActionResponse{Info: fmt.Sprintf("switched to channel %s", channel.Id)}
 }
 
+// quickSwitchChannel simulates the user opening the channel switcher, typing
+// part of the name of a channel while the matching channels and users get
+// autocompleted, and then switching to it.
+func quickSwitchChannel(u user.User) control.UserActionResponse {
+	team, err := u.Store().CurrentTeam()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if team == nil {
+		return control.UserActionResponse{Err: control.NewUserError(errors.New("current team should be set"))}
+	}
+
+	channel, err := u.Store().RandomChannel(team.Id, store.SelectMemberOf|store.SelectNotCurrent|store.SelectNotDirect|store.SelectNotGroup)
+	if errors.Is(err, memstore.ErrChannelStoreEmpty) {
+		return control.UserActionResponse{Info: "no channel to switch to"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	// Users usually type just enough characters for the channel to show up
+	// among the first results.
+	numChars := 6
+	if numChars > len(channel.Name) {
+		numChars = len(channel.Name)
+	}
+
+	resp := control.EmulateUserTyping(channel.Name[:1+rand.Intn(numChars)], func(term string) control.UserActionResponse {
+		if _, err := u.AutocompleteChannelsForTeamForSearch(team.Id, term); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		if _, err := u.AutocompleteUsersInTeam(team.Id, term, 25); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		return control.UserActionResponse{}
+	})
+	if resp.Err != nil {
+		return resp
+	}
+
+	if resp := viewChannel(u, &channel); resp.Err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(resp.Err)}
+	}
+
+	if err := u.SetCurrentChannel(&channel); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("quick switched to channel %s", channel.Id)}
+}
+
 func (c *SimulController) getUsersStatuses() control.UserActionResponse {
 	channel, err := c.user.Store().CurrentChannel()
 	if errors.Is(err, memstore.ErrChannelNotFound) {
@@ -560,20 +912,37 @@ func deletePost(u user.User) control.UserActionResponse {
 }
 
 func (c *SimulController) updateCustomStatus(u user.User) control.UserActionResponse {
+	text := control.GenerateRandomSentences(1)
+	if len(c.config.CustomStatusTexts) > 0 {
+		text = c.config.CustomStatusTexts[rand.Intn(len(c.config.CustomStatusTexts))]
+	}
+
+	// The expiry is picked between the configured bounds so that the server
+	// clears some of the statuses while the load-test is running.
+	expiry := c.config.CustomStatusMinExpiryMinutes
+	if diff := c.config.CustomStatusMaxExpiryMinutes - c.config.CustomStatusMinExpiryMinutes; diff > 0 {
+		expiry += rand.Intn(diff + 1)
+	}
+
 	status := &model.CustomStatus{
 		Emoji:     control.RandomEmoji(),
-		Text:      control.GenerateRandomSentences(1),
-		Duration:  "thirty_minutes",
-		ExpiresAt: time.Now().UTC().Add(30 * time.Minute),
+		Text:      text,
+		Duration:  "date_and_time",
+		ExpiresAt: u.Now().UTC().Add(time.Duration(expiry) * time.Minute),
 	}
 	err := u.UpdateCustomStatus(u.Store().Id(), status)
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
-	return control.UserActionResponse{Info: fmt.Sprintf("updated custom status: %s", status.Emoji)}
+	return control.UserActionResponse{Info: fmt.Sprintf("updated custom status: %s, expires in %d minutes", status.Emoji, expiry)}
 }
 
 func (c *SimulController) removeCustomStatus(u user.User) control.UserActionResponse {
+	// Users don't always clear their status before it expires.
+	if rand.Float64() >= c.config.CustomStatusEarlyClearRate {
+		return control.UserActionResponse{Info: "custom status left to expire"}
+	}
+
 	err := u.RemoveCustomStatus(u.Store().Id())
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
@@ -653,7 +1022,7 @@ func (c *SimulController) updateSidebarCategory(u user.User) control.UserActionR
 	return control.UserActionResponse{Info: fmt.Sprintf("updated sidebar categories, ids [%s, %s]", cat1.Id, cat2.Id)}
 }
 
-func editPost(u user.User) control.UserActionResponse {
+func (c *SimulController) editPost(u user.User) control.UserActionResponse {
 	channel, err := u.Store().CurrentChannel()
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
@@ -666,10 +1035,31 @@ func editPost(u user.User) control.UserActionResponse {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
 
-	isReply := post.RootId != ""
-	message, err := createMessage(u, channel, isReply)
-	if err != nil {
-		return control.UserActionResponse{Err: control.NewUserError(err)}
+	var message string
+	var mentionInfo string
+	if rand.Float64() < c.config.EditMentionChangeRate {
+		// Adding a channel wide mention on edit makes the server send
+		// notifications for it.
+		mention := "@channel"
+		if rand.Intn(2) == 0 {
+			mention = "@here"
+		}
+		var added bool
+		message, added = toggleChannelMention(post.Message, mention)
+		if added {
+			mentionInfo = ", added " + mention
+		} else {
+			mentionInfo = ", removed channel mentions"
+		}
+		if message == "" {
+			message = genMessage(post.RootId != "")
+		}
+	} else {
+		isReply := post.RootId != ""
+		message, err = createMessage(u, channel, isReply)
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
 	}
 
 	postId, err := u.PatchPost(post.Id, &model.PostPatch{
@@ -679,7 +1069,7 @@ func editPost(u user.User) control.UserActionResponse {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
 
-	return control.UserActionResponse{Info: fmt.Sprintf("post edited, id %v", postId)}
+	return control.UserActionResponse{Info: fmt.Sprintf("post edited, id %v%s", postId, mentionInfo)}
 }
 
 func (c *SimulController) createPostReply(u user.User) control.UserActionResponse {
@@ -702,6 +1092,32 @@ func (c *SimulController) createPostReply(u user.User) control.UserActionRespons
 		rootId = post.Id
 	}
 
+	collapsedThreads, resp := control.CollapsedThreadsEnabled(u)
+	if resp.Err != nil {
+		return resp
+	}
+
+	// Replying from the channel view is only possible without collapsed
+	// threads, to a root post that is already loaded in the channel.
+	fromChannel := !collapsedThreads && rand.Float64() < c.config.ChannelViewReplyRate
+	if fromChannel {
+		if _, err := u.Store().Post(rootId); errors.Is(err, memstore.ErrPostNotFound) {
+			fromChannel = false
+		} else if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+	if !fromChannel {
+		// Replying from the RHS requires the thread to be opened first.
+		if _, _, err := u.GetPostThreadWithOpts(rootId, "", model.GetPostsOptions{
+			CollapsedThreads: collapsedThreads,
+			Direction:        "down",
+			PerPage:          25,
+		}); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+
 	if err := sendTypingEventIfEnabled(u, channel.Id); err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
@@ -710,14 +1126,16 @@ func (c *SimulController) createPostReply(u user.User) control.UserActionRespons
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
+	if message, err = c.resizeMessage(message); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
 
 	reply := &model.Post{
 		Message:   message,
 		ChannelId: channel.Id,
-		CreateAt:  time.Now().Unix() * 1000,
+		CreateAt:  u.Now().Unix() * 1000,
 		RootId:    rootId,
 	}
-
 	// 2% of the times post will have files attached.
 	if rand.Float64() < 0.02 {
 		if err := c.attachFilesToPost(u, reply); err != nil {
@@ -730,7 +1148,10 @@ func (c *SimulController) createPostReply(u user.User) control.UserActionRespons
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
 
-	return control.UserActionResponse{Info: fmt.Sprintf("post reply created, id %v", replyId)}
+	if fromChannel {
+		return control.UserActionResponse{Info: fmt.Sprintf("post reply created from channel view, id %v", replyId)}
+	}
+	return control.UserActionResponse{Info: fmt.Sprintf("post reply created from RHS, id %v", replyId)}
 }
 
 func (c *SimulController) createPost(u user.User) control.UserActionResponse {
@@ -747,11 +1168,14 @@ func (c *SimulController) createPost(u user.User) control.UserActionResponse {
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
+	if message, err = c.resizeMessage(message); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
 
 	post := &model.Post{
 		Message:   message,
 		ChannelId: channel.Id,
-		CreateAt:  time.Now().Unix() * 1000,
+		CreateAt:  u.Now().Unix() * 1000,
 	}
 
 	// 2% of the times post will have files attached.
@@ -769,24 +1193,60 @@ func (c *SimulController) createPost(u user.User) control.UserActionResponse {
 	return control.UserActionResponse{Info: fmt.Sprintf("post created, id %v", postId)}
 }
 
-func (c *SimulController) attachFilesToPost(u user.User, post *model.Post) error {
-	type file struct {
-		data   []byte
-		upload bool
+// draftPost simulates the user typing a message in the current channel.
+// With a probability given by DraftAbandonmentRate the draft gets abandoned,
+// producing typing events that are never followed by a post.
+func (c *SimulController) draftPost(u user.User) control.UserActionResponse {
+	channel, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
-	filenames := []string{"test_upload.png", "test_upload.jpg", "test_upload.mp4"}
-	files := make(map[string]*file, len(filenames))
 
-	for _, filename := range filenames {
-		files[filename] = &file{
-			data:   control.MustAsset(filename),
-			upload: rand.Intn(2) == 0,
-		}
+	if ok, err := shouldSendTypingEvent(u, channel.Id); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if !ok {
+		return control.UserActionResponse{Info: "typing events are disabled"}
 	}
 
-	// We make sure at least one file gets uploaded.
-	files[filenames[rand.Intn(len(filenames))]].upload = true
-
+	// The user may stop and resume typing a few times.
+	numTypingEvents := 1 + rand.Intn(3)
+	for i := 0; i < numTypingEvents; i++ {
+		// Throttled typing events are dropped, as real clients do. A saturated
+		// queue means the connection is busy, so the rest are dropped too.
+		if err := u.SendTypingEvent(channel.Id, ""); errors.Is(err, userentity.ErrTypingRateLimited) || errors.Is(err, userentity.ErrTypingQueueFull) {
+			break
+		} else if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+
+	if rand.Float64() < c.config.DraftAbandonmentRate {
+		return control.UserActionResponse{Info: fmt.Sprintf("draft abandoned in channel %v", channel.Id)}
+	}
+
+	return c.createPost(u)
+}
+
+func (c *SimulController) attachFilesToPost(u user.User, post *model.Post) error {
+	type file struct {
+		data   []byte
+		upload bool
+	}
+	filenames := []string{"test_upload.png", "test_upload.jpg", "test_upload.mp4"}
+	files := make(map[string]*file, len(filenames))
+
+	for _, filename := range filenames {
+		files[filename] = &file{
+			data:   control.MustAsset(filename),
+			upload: rand.Intn(2) == 0,
+		}
+	}
+
+	// We make sure at least one file gets uploaded.
+	files[filenames[rand.Intn(len(filenames))]].upload = true
+
 	var wg sync.WaitGroup
 	fileIds := make(chan string, len(files))
 	for filename, file := range files {
@@ -987,6 +1447,19 @@ func getProfileImageForUsers(u user.User, userIds []string) error {
 	return nil
 }
 
+// resizeMessage fits the given message to a length picked from the configured
+// message size distribution, if any.
+func (c *SimulController) resizeMessage(message string) (string, error) {
+	length, err := pickMessageLength(c.config.MessageSizeDistribution, c.config.MaxMessageLength)
+	if err != nil {
+		return "", err
+	}
+	if length == 0 {
+		return message, nil
+	}
+	return fitMessage(message, length), nil
+}
+
 func createMessage(u user.User, channel *model.Channel, isReply bool) (string, error) {
 	var message string
 	// 10% of messages will contain a mention.
@@ -1181,7 +1654,7 @@ func searchPosts(u user.User) control.UserActionResponse {
 
 	if rand.Float64() < 0.2 {
 		// We limit the search to 7 days.
-		t := time.Now().Add(-time.Duration(rand.Intn(7)) * time.Hour * 24)
+		t := u.Now().Add(-time.Duration(rand.Intn(7)) * time.Hour * 24)
 		switch rand.Intn(3) {
 		case 0:
 			opts.On = t
@@ -1386,7 +1859,9 @@ func sendTypingEventIfEnabled(u user.User, channelId string) error {
 	if ok, err := shouldSendTypingEvent(u, channelId); ok && err == nil {
 		// TODO: possibly add some additional idle time here to simulate the
 		// user actually taking time to type a post message.
-		return u.SendTypingEvent(channelId, "")
+		if err := u.SendTypingEvent(channelId, ""); !errors.Is(err, userentity.ErrTypingRateLimited) {
+			return err
+		}
 	} else if err != nil {
 		return err
 	}
@@ -1405,13 +1880,20 @@ func (c *SimulController) viewGlobalThreads(u user.User) control.UserActionRespo
 		return control.UserActionResponse{Err: control.NewUserError(errors.New("viewGlobalThreads: current team should be set"))}
 	}
 
+	// Fetch the unread counts for the followed threads
+	if _, err := u.GetUserThreads(team.Id, &model.GetUserThreadsOpts{
+		TotalsOnly: true,
+	}); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
 	// View "All your threads" in the Global Threads Screen
 	threads, err := u.Store().ThreadsSorted(false, false)
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	} else if len(threads) == 0 {
 		threads, err = u.GetUserThreads(team.Id, &model.GetUserThreadsOpts{
-			PageSize:    25,
+			PageSize:    uint64(c.config.GlobalThreadsPageSize),
 			Extended:    false,
 			Deleted:     false,
 			Unread:      false,
@@ -1428,11 +1910,11 @@ func (c *SimulController) viewGlobalThreads(u user.User) control.UserActionRespo
 	}
 
 	oldestThreadId := threads[len(threads)-1].PostId
-	// scrolling between 1 and 3 times
-	numScrolls := rand.Intn(3) + 1
+	// scrolling between 1 and GlobalThreadsMaxScrolls times
+	numScrolls := rand.Intn(c.config.GlobalThreadsMaxScrolls) + 1
 	for i := 0; i < numScrolls; i++ {
 		threads, err = u.GetUserThreads(team.Id, &model.GetUserThreadsOpts{
-			PageSize:    25,
+			PageSize:    uint64(c.config.GlobalThreadsPageSize),
 			Extended:    false,
 			Deleted:     false,
 			Unread:      false,
@@ -1463,7 +1945,7 @@ func (c *SimulController) viewGlobalThreads(u user.User) control.UserActionRespo
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	} else if len(unreadThreads) == 0 {
 		unreadThreads, err = u.GetUserThreads(team.Id, &model.GetUserThreadsOpts{
-			PageSize:    25,
+			PageSize:    uint64(c.config.GlobalThreadsPageSize),
 			Extended:    false,
 			Deleted:     false,
 			Unread:      true,
@@ -1480,11 +1962,11 @@ func (c *SimulController) viewGlobalThreads(u user.User) control.UserActionRespo
 	}
 
 	oldestUnreadThreadId := unreadThreads[len(unreadThreads)-1].PostId
-	// scrolling between 1 and 3 times
-	numScrolls = rand.Intn(3) + 1
+	// scrolling between 1 and GlobalThreadsMaxScrolls times
+	numScrolls = rand.Intn(c.config.GlobalThreadsMaxScrolls) + 1
 	for i := 0; i < numScrolls; i++ {
 		unreadThreads, err = u.GetUserThreads(team.Id, &model.GetUserThreadsOpts{
-			PageSize:    25,
+			PageSize:    uint64(c.config.GlobalThreadsPageSize),
 			Extended:    false,
 			Deleted:     false,
 			Unread:      true,
@@ -1762,6 +2244,12 @@ func (c *SimulController) updateThreadRead(u user.User) control.UserActionRespon
 }
 
 func (c *SimulController) getInsights(u user.User) control.UserActionResponse {
+	// Insights require a license and the feature flag to be enabled. They
+	// were also removed from later server versions altogether.
+	if u.Store().ClientConfig()["InsightsEnabled"] != "true" {
+		return control.UserActionResponse{Info: "insights not enabled"}
+	}
+
 	team, err := u.Store().CurrentTeam()
 	if errors.Is(err, memstore.ErrTeamStoreEmpty) {
 		return control.UserActionResponse{Info: "no team set"}
@@ -1795,7 +2283,8 @@ func (c *SimulController) getInsights(u user.User) control.UserActionResponse {
 		limit = 5
 	}
 	// my insights is the default option, so team insights will be viewed less.
-	if rand.Float64() < 0.30 {
+	viewTeam := c.config.InsightsScope == "team" || (c.config.InsightsScope == "mixed" && rand.Float64() < 0.30)
+	if viewTeam {
 		// view team insights
 		if _, err := u.GetTopThreadsForTeamSince(userID, team.Id, timeRange, 0, limit); err != nil {
 			return control.UserActionResponse{Err: control.NewUserError(err)}
@@ -1851,10 +2340,569 @@ func (c *SimulController) createPostReminder(u user.User) control.UserActionResp
 
 	// Going with a hardcoded 10 minute addition for now.
 	// Probably there's no need to randomize this yet.
-	err = u.CreatePostReminder(u.Store().Id(), post.Id, time.Now().Add(10*time.Minute).Unix())
+	err = u.CreatePostReminder(u.Store().Id(), post.Id, u.Now().Add(10*time.Minute).Unix())
 	if err != nil {
 		return control.UserActionResponse{Err: control.NewUserError(err)}
 	}
 
 	return control.UserActionResponse{Info: fmt.Sprintf("created post reminder, id %s", post.Id)}
 }
+
+func (c *SimulController) createRemindCommand(u user.User) control.UserActionResponse {
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	// The command is provided by a plugin which may not be installed.
+	team, err := u.Store().CurrentTeam()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if team == nil {
+		return control.UserActionResponse{Info: "current team is not set"}
+	}
+	commands, err := u.ListAutocompleteCommands(team.Id)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	if !hasCommand(commands, "remind") {
+		return control.UserActionResponse{Info: "/remind command is not available"}
+	}
+
+	delay := c.config.RemindMinDelayMinutes
+	if diff := c.config.RemindMaxDelayMinutes - c.config.RemindMinDelayMinutes; diff > 0 {
+		delay += rand.Intn(diff + 1)
+	}
+
+	command := fmt.Sprintf("/remind me \"%s\" in %d minutes", genMessage(false), delay)
+	if _, err := u.ExecuteCommand(ch.Id, command); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("reminder scheduled in %d minutes in channel %s", delay, ch.Id)}
+}
+
+// triggerIntegration posts a message or executes a command picked from the
+// configured triggers in the current channel, causing the integrations
+// listening for it to be dispatched by the server.
+func (c *SimulController) triggerIntegration(u user.User) control.UserActionResponse {
+	if len(c.config.IntegrationTriggers) == 0 {
+		return control.UserActionResponse{Info: "no integration triggers configured"}
+	}
+
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	trigger := c.config.IntegrationTriggers[rand.Intn(len(c.config.IntegrationTriggers))]
+	if !strings.HasPrefix(trigger, "/") {
+		postId, err := u.CreatePost(&model.Post{
+			Message:   trigger + " " + genMessage(false),
+			ChannelId: ch.Id,
+			CreateAt:  u.Now().Unix() * 1000,
+		})
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		return control.UserActionResponse{Info: fmt.Sprintf("triggered %q in channel %s, post id %s", trigger, ch.Id, postId)}
+	}
+
+	resp, err := u.ExecuteCommand(ch.Id, trigger)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	// Nothing else is needed to handle the response: ephemeral responses are
+	// only displayed locally while in-channel ones are posted by the server
+	// and received through the WebSocket like any other post.
+	if resp.GotoLocation != "" {
+		return control.UserActionResponse{Info: fmt.Sprintf("executed %q in channel %s, redirected to %s", trigger, ch.Id, resp.GotoLocation)}
+	}
+	return control.UserActionResponse{Info: fmt.Sprintf("executed %q in channel %s, %s response", trigger, ch.Id, resp.ResponseType)}
+}
+
+// annotationEmojis are the emojis integrations react to their own posts with.
+var annotationEmojis = []string{"white_check_mark", "warning", "x", "eyes", "rocket", "hourglass", "memo", "bell"}
+
+// createAnnotatedPost simulates an integration creating a post and annotating
+// it right away with several reactions, e.g. to flag its status.
+func (c *SimulController) createAnnotatedPost(u user.User) control.UserActionResponse {
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	postId, err := u.CreatePost(&model.Post{
+		Message:   genMessage(false),
+		ChannelId: ch.Id,
+		CreateAt:  u.Now().Unix() * 1000,
+	})
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	numReactions := c.config.AnnotatedPostReactions
+	if numReactions > len(annotationEmojis) {
+		numReactions = len(annotationEmojis)
+	}
+	for _, idx := range rand.Perm(len(annotationEmojis))[:numReactions] {
+		if err := u.SaveReaction(&model.Reaction{
+			UserId:    u.Store().Id(),
+			PostId:    postId,
+			EmojiName: annotationEmojis[idx],
+		}); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("created post %s in channel %s with %d reactions", postId, ch.Id, numReactions)}
+}
+
+// announcementFrequency returns the frequency at which the user posts
+// announcements, which is zero unless the user is an announcer.
+func (c *SimulController) announcementFrequency() float64 {
+	if findIndex(c.config.Announcers, c.user.Store().Username()) == -1 {
+		return 0
+	}
+	return c.config.AnnouncementFrequency
+}
+
+// postAnnouncement simulates an announcer posting a channel wide mention to
+// one of the announcement channels, which notifies all of its members.
+func (c *SimulController) postAnnouncement(u user.User) control.UserActionResponse {
+	team, err := u.Store().CurrentTeam()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if team == nil {
+		return control.UserActionResponse{Info: "current team is not set"}
+	}
+
+	name := model.DefaultChannelName
+	if len(c.config.AnnouncementChannels) > 0 {
+		name = c.config.AnnouncementChannels[rand.Intn(len(c.config.AnnouncementChannels))]
+	}
+
+	channels, err := u.Store().Channels(team.Id)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	var channelId string
+	for _, ch := range channels {
+		if ch.Name == name {
+			channelId = ch.Id
+			break
+		}
+	}
+	if channelId == "" {
+		return control.UserActionResponse{Info: fmt.Sprintf("announcement channel %q not found in team %s", name, team.Id)}
+	}
+
+	postId, err := u.CreatePost(&model.Post{
+		Message:   "@channel " + genMessage(false),
+		ChannelId: channelId,
+		CreateAt:  u.Now().Unix() * 1000,
+	})
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("posted announcement in channel %s, post id %s", channelId, postId)}
+}
+
+// postGif simulates a user searching for a GIF through the GIF picker and
+// posting the picked one in the current channel.
+func (c *SimulController) postGif(u user.User) control.UserActionResponse {
+	if len(c.config.GifSearchTerms) == 0 {
+		return control.UserActionResponse{Info: "no GIF search terms configured"}
+	}
+	if c.config.GifSearchURL == "" {
+		return control.UserActionResponse{Info: "no GIF search URL configured"}
+	}
+
+	clientConfig := u.Store().ClientConfig()
+	if clientConfig["EnableGifPicker"] != "true" {
+		return control.UserActionResponse{Info: "GIF picker is not enabled"}
+	}
+
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	term := c.config.GifSearchTerms[rand.Intn(len(c.config.GifSearchTerms))]
+	gifURLs, err := u.SearchGifs(c.config.GifSearchURL, term)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	if len(gifURLs) == 0 {
+		return control.UserActionResponse{Info: fmt.Sprintf("no GIF found for %q", term)}
+	}
+	gifURL := gifURLs[rand.Intn(len(gifURLs))]
+
+	// The picker only loads results through the server when the image proxy
+	// is enabled, otherwise they are fetched directly from the provider.
+	if clientConfig["HasImageProxy"] == "true" {
+		if err := u.GetProxiedImage(gifURL); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+
+	postId, err := u.CreatePost(&model.Post{
+		Message:   fmt.Sprintf("![%s](%s)", term, gifURL),
+		ChannelId: ch.Id,
+		CreateAt:  u.Now().Unix() * 1000,
+	})
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("posted GIF for %q in channel %s, post id %s", term, ch.Id, postId)}
+}
+
+// postPermalink posts a message containing the permalink to a random post
+// from the store in the current channel, triggering the permalink preview
+// expansion on the server.
+func (c *SimulController) postPermalink(u user.User) control.UserActionResponse {
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	post, err := u.Store().RandomPost()
+	if errors.Is(err, memstore.ErrPostNotFound) {
+		return control.UserActionResponse{Info: "no posts found"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	// Permalinks are relative to the team of the referenced post, falling
+	// back to the current team for DMs and GMs.
+	team, err := u.Store().CurrentTeam()
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	if postCh, err := u.Store().Channel(post.ChannelId); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if postCh != nil && postCh.TeamId != "" {
+		teams, err := u.Store().Teams()
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		for i := range teams {
+			if teams[i].Id == postCh.TeamId {
+				team = &teams[i]
+				break
+			}
+		}
+	}
+	if team == nil {
+		return control.UserActionResponse{Info: "current team is not set"}
+	}
+
+	permalink := u.Store().ClientConfig()["SiteURL"] + "/" + team.Name + "/pl/" + post.Id
+	postId, err := u.CreatePost(&model.Post{
+		Message:   genMessage(false) + " " + permalink,
+		ChannelId: ch.Id,
+		CreateAt:  u.Now().Unix() * 1000,
+	})
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("posted permalink to post %s in channel %s, post id %s", post.Id, ch.Id, postId)}
+}
+
+// flaggedPostsPerPage is the number of flagged posts fetched when opening the
+// flagged posts panel.
+const flaggedPostsPerPage = 20
+
+// openPostListPanel simulates the user opening either the pinned posts panel
+// of the current channel or the flagged posts panel in the RHS.
+func (c *SimulController) openPostListPanel(u user.User) control.UserActionResponse {
+	if rand.Intn(2) == 0 {
+		list, err := u.GetFlaggedPosts(0, flaggedPostsPerPage)
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		return control.UserActionResponse{Info: fmt.Sprintf("opened flagged posts, found %d posts", len(list.Order))}
+	}
+
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	list, err := u.GetPinnedPosts(ch.Id)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	return control.UserActionResponse{Info: fmt.Sprintf("opened pinned posts in channel %s, found %d posts", ch.Id, len(list.Order))}
+}
+
+// openChannelFiles simulates the user opening the files view of the current
+// channel, which lists the files shared in it along with their thumbnails.
+func (c *SimulController) openChannelFiles(u user.User) control.UserActionResponse {
+	ch, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	// DM and GM channels have no team, the current one is used instead.
+	teamId := ch.TeamId
+	if teamId == "" {
+		team, err := u.Store().CurrentTeam()
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		} else if team == nil {
+			return control.UserActionResponse{Info: "current team is not set"}
+		}
+		teamId = team.Id
+	}
+
+	// The request is made even if no files are known to be in the channel,
+	// as the webapp does.
+	list, err := u.SearchFilesInChannel(teamId, ch.Name, 0, c.config.ChannelFilesPerPage)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	for _, id := range list.Order {
+		if info := list.FileInfos[id]; info != nil && info.HasPreviewImage {
+			if err := u.GetFileThumbnail(id); err != nil {
+				return control.UserActionResponse{Err: control.NewUserError(err)}
+			}
+		}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("opened files of channel %s, found %d files", ch.Id, len(list.Order))}
+}
+
+// exportMessages simulates an admin triggering a compliance message export.
+// The job is not waited upon: its progress is checked the next time the
+// action runs.
+func (c *SimulController) exportMessages(u user.User) control.UserActionResponse {
+	if ok, err := u.IsSysAdmin(); err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	} else if !ok {
+		return control.UserActionResponse{Info: "user is not a system admin"}
+	}
+
+	// The license is kept up to date through license_changed events once
+	// fetched.
+	if len(u.Store().ClientLicense()) == 0 {
+		if err := u.GetClientLicense(); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+	}
+	if !u.Store().IsFeatureLicensed("MessageExport") {
+		return control.UserActionResponse{Info: "message export is not licensed"}
+	}
+
+	if c.exportJobId != "" {
+		job, err := u.GetJob(c.exportJobId)
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		if job.Status == model.JobStatusPending || job.Status == model.JobStatusInProgress {
+			return control.UserActionResponse{Info: fmt.Sprintf("message export job %s in progress (%d%%)", job.Id, job.Progress)}
+		}
+		c.exportJobId = ""
+		return control.UserActionResponse{Info: fmt.Sprintf("message export job %s finished with status %s", job.Id, job.Status)}
+	}
+
+	job, err := u.CreateJob(&model.Job{Type: model.JobTypeMessageExport})
+	if isNotSupportedErr(err) {
+		return control.UserActionResponse{Info: "message export is not available"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	c.exportJobId = job.Id
+
+	return control.UserActionResponse{Info: fmt.Sprintf("message export job created, id %s", job.Id)}
+}
+
+// uploadChunkSize is the size of the chunks (in bytes) in which large files are
+// uploaded.
+const uploadChunkSize = 1024 * 1024
+
+// zeroChunk is the content of the uploaded chunks. The content doesn't matter
+// so we simply upload zeroed data.
+var zeroChunk = make([]byte, uploadChunkSize)
+
+// uploadLargeFile simulates a user uploading a large file in chunks and
+// posting it in the current channel. An upload can get interrupted midway,
+// like when a client goes offline, in which case it's resumed from the
+// offset the server reports the next time the action runs.
+func (c *SimulController) uploadLargeFile(u user.User) control.UserActionResponse {
+	if us := c.interruptedUpload; us != nil {
+		c.interruptedUpload = nil
+		us, err := u.GetUpload(us.Id)
+		if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		return c.completeUpload(u, us)
+	}
+
+	channel, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	fileSize := int64(c.config.ChunkedUploadFileSizeKB) * 1024
+	us, err := u.CreateUpload(&model.UploadSession{
+		Type:      model.UploadTypeAttachment,
+		ChannelId: channel.Id,
+		Filename:  fmt.Sprintf("large_upload_%s.bin", model.NewId()),
+		FileSize:  fileSize,
+	})
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	numChunks := (fileSize + uploadChunkSize - 1) / uploadChunkSize
+	if numChunks > 1 && rand.Float64() < c.config.ChunkedUploadInterruptionRate {
+		interruptAt := rand.Int63n(numChunks-1) + 1
+		if _, err := uploadChunks(u, us, interruptAt*uploadChunkSize); err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		c.interruptedUpload = us
+		return control.UserActionResponse{Info: fmt.Sprintf("upload %s interrupted after %d chunks", us.Id, interruptAt)}
+	}
+
+	return c.completeUpload(u, us)
+}
+
+// completeUpload uploads the remaining data of the given upload session and
+// posts the resulting file in the channel of the session.
+func (c *SimulController) completeUpload(u user.User, us *model.UploadSession) control.UserActionResponse {
+	info, err := uploadChunks(u, us, us.FileSize)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+	if info == nil {
+		return control.UserActionResponse{Err: control.NewUserError(fmt.Errorf("upload %s did not complete", us.Id))}
+	}
+
+	postId, err := u.CreatePost(&model.Post{
+		Message:   "large file upload",
+		ChannelId: us.ChannelId,
+		CreateAt:  u.Now().Unix() * 1000,
+		FileIds:   []string{info.Id},
+	})
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("large file uploaded, id %s, post id %s", info.Id, postId)}
+}
+
+// uploadChunks uploads the data of the given upload session from its current
+// offset up to the given one. It returns the uploaded file's info once the
+// upload completes.
+func uploadChunks(u user.User, us *model.UploadSession, until int64) (*model.FileInfo, error) {
+	var info *model.FileInfo
+	for offset := us.FileOffset; offset < until; {
+		size := until - offset
+		if size > uploadChunkSize {
+			size = uploadChunkSize
+		}
+		var err error
+		info, err = u.UploadData(us.Id, bytes.NewReader(zeroChunk[:size]))
+		if err != nil {
+			return nil, err
+		}
+		offset += size
+	}
+	return info, nil
+}
+
+// minChannelBookmarksVersion is the first server version supporting channel
+// bookmarks.
+const minChannelBookmarksVersion = "9.11.0"
+
+// isNotSupportedErr reports whether the given error is the result of
+// calling an API endpoint that the server doesn't support or has disabled.
+func isNotSupportedErr(err error) bool {
+	var appErr *model.AppError
+	if !errors.As(err, &appErr) {
+		return false
+	}
+	return appErr.StatusCode == http.StatusNotFound || appErr.StatusCode == http.StatusNotImplemented
+}
+
+// fetchChannelBookmarks fetches the bookmarks for the given channel, if
+// supported by the server.
+func fetchChannelBookmarks(u user.User, channelId string) error {
+	serverVersion, err := u.Store().ServerVersion()
+	if err != nil {
+		return err
+	}
+
+	if ok, err := control.IsVersionSupported(minChannelBookmarksVersion, serverVersion); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+
+	if err := u.GetChannelBookmarks(channelId); err != nil && !isNotSupportedErr(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *SimulController) updateChannelBookmarks(u user.User) control.UserActionResponse {
+	channel, err := u.Store().CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return control.UserActionResponse{Info: "current channel is not set"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	bookmarks, err := u.Store().ChannelBookmarks(channel.Id)
+	if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	// Half of the times we remove an existing bookmark, if any.
+	if len(bookmarks) > 0 && rand.Intn(2) == 0 {
+		bookmark := bookmarks[rand.Intn(len(bookmarks))]
+		if err := u.DeleteChannelBookmark(channel.Id, bookmark.Id); isNotSupportedErr(err) {
+			return control.UserActionResponse{Info: "channel bookmarks are not supported"}
+		} else if err != nil {
+			return control.UserActionResponse{Err: control.NewUserError(err)}
+		}
+		return control.UserActionResponse{Info: fmt.Sprintf("channel bookmark deleted, id %s", bookmark.Id)}
+	}
+
+	bookmark := &store.ChannelBookmark{
+		ChannelId:   channel.Id,
+		DisplayName: "Bookmark " + model.NewId()[:8],
+		LinkUrl:     "https://mattermost.com",
+		Type:        store.ChannelBookmarkLink,
+	}
+	if err := u.CreateChannelBookmark(bookmark); isNotSupportedErr(err) {
+		return control.UserActionResponse{Info: "channel bookmarks are not supported"}
+	} else if err != nil {
+		return control.UserActionResponse{Err: control.NewUserError(err)}
+	}
+
+	return control.UserActionResponse{Info: fmt.Sprintf("channel bookmark created in channel %s", channel.Id)}
+}
@@ -4,6 +4,11 @@
 package simulcontroller
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+
 	"github.com/mattermost/mattermost-load-test-ng/defaults"
 )
 
@@ -15,6 +20,170 @@ type Config struct {
 	// The average amount of time (in milliseconds) the controlled users
 	// will wait between actions.
 	AvgIdleTimeMs int `default:"20000" validate:"range:($MinIdleTimeMs,]"`
+	// The relative frequency at which the controlled users upload a large
+	// file through a chunked (resumable) upload. Zero, the default, disables
+	// the action.
+	ChunkedUploadFrequency float64 `default:"0" validate:"range:[0,]"`
+	// The size (in kilobytes) of the file generated when performing a
+	// chunked (resumable) upload.
+	ChunkedUploadFileSizeKB int `default:"10240" validate:"range:(0,]"`
+	// The probability of a chunked upload being interrupted, to be resumed
+	// the next time a large file is uploaded.
+	ChunkedUploadInterruptionRate float64 `default:"0.1" validate:"range:[0,1]"`
+	// The relative frequency at which the controlled users open the global
+	// threads view. Zero disables the action.
+	GlobalThreadsFrequency float64 `default:"5.4" validate:"range:[0,]"`
+	// The number of threads fetched for each page of the global threads view.
+	GlobalThreadsPageSize int `default:"25" validate:"range:(0,200]"`
+	// The maximum number of pages scrolled through when viewing the global
+	// threads view.
+	GlobalThreadsMaxScrolls int `default:"3" validate:"range:(0,]"`
+	// The probability of a message draft being abandoned after typing,
+	// meaning no post is created.
+	DraftAbandonmentRate float64 `default:"0.3" validate:"range:[0,1]"`
+	// The minimum delay (in minutes) of the reminders scheduled through the
+	// /remind command.
+	RemindMinDelayMinutes int `default:"1" validate:"range:[1,]"`
+	// The maximum delay (in minutes) of the reminders scheduled through the
+	// /remind command.
+	RemindMaxDelayMinutes int `default:"60" validate:"range:[$RemindMinDelayMinutes,]"`
+	// The relative frequency at which controlled system admins archive
+	// stale channels in bulk. Archiving is permanent so the action is
+	// disabled by default. Zero disables the action.
+	ArchiveChannelsFrequency float64 `default:"0" validate:"range:[0,]"`
+	// The maximum number of channels archived at once.
+	ArchiveChannelsMaxCount int `default:"5" validate:"range:(0,]"`
+	// The number of days without any post after which a channel is
+	// considered stale and can be archived.
+	ArchiveChannelsStaleAfterDays int `default:"30" validate:"range:(0,]"`
+	// The texts used when setting a custom status. If empty, random
+	// sentences are used.
+	CustomStatusTexts []string
+	// The minimum time (in minutes) after which a custom status expires.
+	CustomStatusMinExpiryMinutes int `default:"5" validate:"range:[1,]"`
+	// The maximum time (in minutes) after which a custom status expires.
+	CustomStatusMaxExpiryMinutes int `default:"60" validate:"range:[$CustomStatusMinExpiryMinutes,]"`
+	// The probability of a custom status being cleared by the user before
+	// it expires.
+	CustomStatusEarlyClearRate float64 `default:"0.5" validate:"range:[0,1]"`
+	// The relative frequency at which controlled system admins trigger a
+	// compliance message export. Zero, the default, disables the action.
+	MessageExportFrequency float64 `default:"0" validate:"range:[0,]"`
+	// The relative frequency at which controlled system admins deactivate a
+	// user account, or reactivate the one they previously deactivated.
+	// The action is disabled by default and needs to be explicitly enabled.
+	UserDeactivationFrequency float64 `default:"0" validate:"range:[0,]"`
+	// The interval (in milliseconds) at which each controlled user joins a
+	// new channel in the background, making channel membership grow over
+	// the duration of the test. Zero disables membership growth.
+	MembershipGrowthIntervalMs int `default:"0" validate:"range:[0,]"`
+	// The member count past which channels stop growing.
+	MembershipGrowthMaxMembers int `default:"1000" validate:"range:(0,]"`
+	// The probability of the user opening the profile popover of the author
+	// of a post it has read.
+	ProfilePopoverRate float64 `default:"0.05" validate:"range:[0,1]"`
+	// The time (in seconds) during which a fetched profile is not fetched
+	// again when opening its popover.
+	ProfilePopoverCacheTTLSec int `default:"300" validate:"range:[0,]"`
+	// The probability of a reply being posted from the channel view rather
+	// than from the RHS.
+	ChannelViewReplyRate float64 `default:"0.2" validate:"range:[0,1]"`
+	// The interval (in milliseconds) at which users refresh their session
+	// token. Zero disables session refreshes.
+	SessionRefreshIntervalMs int `default:"0" validate:"range:[0,]"`
+	// The keywords and slash commands used to trigger the integrations
+	// configured on the target instance. Entries starting with a slash are
+	// executed as commands, the others are posted as the first word of a
+	// message. If empty, integrations are not triggered.
+	IntegrationTriggers []string
+	// The relative frequency at which users trigger integrations.
+	IntegrationTriggerFrequency float64 `default:"0.05" validate:"range:[0,]"`
+	// The terms searched for in the GIF picker. If empty, GIFs are not posted.
+	GifSearchTerms []string
+	// The URL of the Gfycat-compatible search API the GIF picker searches
+	// through, typically a mock. If empty, GIFs are not posted.
+	GifSearchURL string
+	// The fraction of the created posts that are GIFs picked from the GIF
+	// picker.
+	GifPostRate float64 `default:"0.05" validate:"range:[0,1]"`
+	// The relative frequency at which users post a permalink to a known
+	// post, making the server expand it into a preview.
+	PermalinkPostFrequency float64 `default:"0.05" validate:"range:[0,]"`
+	// The probability of a post edit adding a channel wide mention (@channel
+	// or @here) to the post, or removing the ones it already has.
+	EditMentionChangeRate float64 `default:"0.1" validate:"range:[0,1]"`
+	// The relative frequency at which users open the pinned posts panel of
+	// the current channel or the flagged posts panel.
+	PostListPanelsFrequency float64 `default:"0.1" validate:"range:[0,]"`
+	// An optional distribution of the lengths (in characters) of created
+	// posts and replies. If empty, lengths follow the average word counts
+	// observed on community servers.
+	MessageSizeDistribution []MessageSizeDistribution
+	// The maximum length (in characters) of a created message. It should
+	// not exceed the server's maximum post size.
+	MaxMessageLength int `default:"16383" validate:"range:(0,]"`
+	// The probability of a user acknowledging a post mentioning them by
+	// reacting to it.
+	MentionAckRate float64 `default:"0.3" validate:"range:[0,1]"`
+	// The minimum amount of time (in milliseconds) a user waits before
+	// acknowledging a mention.
+	MentionAckMinDelayMs int `default:"1000" validate:"range:[0,]"`
+	// The maximum amount of time (in milliseconds) a user waits before
+	// acknowledging a mention.
+	MentionAckMaxDelayMs int `default:"10000" validate:"range:[$MentionAckMinDelayMs,]"`
+	// The relative frequency at which users open the insights views.
+	InsightsFrequency float64 `default:"0.011" validate:"range:[0,]"`
+	// The insights views users open: "my" for the user's own insights, "team"
+	// for the current team's insights or "mixed" for both, with "my" being
+	// the most common.
+	InsightsScope string `default:"mixed" validate:"oneof:{my,team,mixed}"`
+	// The relative frequency at which users create a post and immediately
+	// react to it, as integrations annotating their own posts do. It's
+	// meant to be set for agents simulating integrations.
+	AnnotatedPostFrequency float64 `default:"0" validate:"range:[0,]"`
+	// The number of reactions added to an annotated post.
+	AnnotatedPostReactions int `default:"3" validate:"range:[1,8]"`
+	// The relative frequency at which users open the files view of the
+	// current channel.
+	ChannelFilesFrequency float64 `default:"0.05" validate:"range:[0,]"`
+	// The number of files fetched when opening the channel files view.
+	ChannelFilesPerPage int `default:"50" validate:"range:(0,]"`
+	// The usernames of the users posting channel wide announcements.
+	Announcers []string
+	// The names of the channels announcements are posted to. If empty, they
+	// are posted to the town square of the announcer's current team.
+	AnnouncementChannels []string
+	// The relative frequency at which announcers post an announcement.
+	AnnouncementFrequency float64 `default:"0.5" validate:"range:[0,]"`
+}
+
+// MessageSizeDistribution defines the share of messages whose length falls
+// within a given range.
+type MessageSizeDistribution struct {
+	// The minimum length (in characters) of the messages.
+	MinLength int `default:"1" validate:"range:(0,]"`
+	// The maximum length (in characters) of the messages.
+	MaxLength int `default:"300" validate:"range:[$MinLength,]"`
+	// The share, between 0 and 1, of messages within the range.
+	Percentage float64 `default:"1.0" validate:"range:[0,1]"`
+}
+
+// IsValid reports whether a given simulcontroller.Config is valid or not.
+// Returns an error if the validation fails.
+func (c *Config) IsValid() error {
+	var sum float64
+	for _, el := range c.MessageSizeDistribution {
+		sum += el.Percentage
+	}
+	if len(c.MessageSizeDistribution) > 0 && (math.Round(sum*100)/100) != 1 {
+		return errors.New("Percentages in MessageSizeDistribution should sum to 1")
+	}
+	if c.GifSearchURL != "" {
+		if _, err := url.ParseRequestURI(c.GifSearchURL); err != nil {
+			return fmt.Errorf("invalid GifSearchURL: %w", err)
+		}
+	}
+	return nil
 }
 
 // ReadConfig reads the configuration file from the given string. If the string
@@ -7,11 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattermost/mattermost-load-test-ng/defaults"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user"
+
+	"github.com/mattermost/mattermost-server/v6/model"
 )
 
 // SimulController is a simulative implementation of a UserController.
@@ -27,6 +30,18 @@ type SimulController struct {
 	connectedFlag  int32           // indicates that the controller is connected
 	wg             *sync.WaitGroup // to keep the track of every goroutine created by the controller
 	serverVersion  string          // stores the current server version
+	exportJobId    string          // the id of the last message export job triggered
+	// the upload session interrupted by this controller, resumed the next
+	// time a large file is uploaded.
+	interruptedUpload *model.UploadSession
+	// the id of the user whose account was deactivated by this controller.
+	deactivatedUserId string
+	// profilesFetchedAt tracks when the profile of a user was last fetched
+	// through a profile popover.
+	profilesFetchedAt map[string]time.Time
+	// signedUp indicates that the account was created by this controller
+	// and has yet to go through onboarding.
+	signedUp bool
 }
 
 // New creates and initializes a new SimulController with given parameters.
@@ -51,6 +66,8 @@ func New(id int, user user.User, config *Config, status chan<- control.UserStatu
 		stopChan:       make(chan struct{}),
 		stoppedChan:    make(chan struct{}),
 		wg:             &sync.WaitGroup{},
+
+		profilesFetchedAt: make(map[string]time.Time),
 	}, nil
 }
 
@@ -67,9 +84,19 @@ func (c *SimulController) Run() {
 	c.status <- control.UserStatus{ControllerId: c.id, User: c.user, Info: "user started", Code: control.USER_STATUS_STARTED}
 
 	defer func() {
-		if err := c.disconnect(); err != nil {
-			c.status <- c.newErrorStatus(control.NewUserError(err))
+		if c.deactivatedUserId != "" {
+			// Not leaving the account deactivated after the admin is gone.
+			if resp := c.reactivateUser(c.user); resp.Err != nil {
+				c.status <- c.newErrorStatus(resp.Err)
+			}
+		}
+		// The user is already disconnected if stopped while deactivated.
+		if atomic.LoadInt32(&c.connectedFlag) == 1 {
+			if err := c.disconnect(); err != nil {
+				c.status <- c.newErrorStatus(control.NewUserError(err))
+			}
 		}
+		accounts.unregister(c.user.Store().Id())
 		c.user.ClearUserData()
 		c.sendStopStatus()
 		close(c.stoppedChan)
@@ -84,6 +111,9 @@ func (c *SimulController) Run() {
 		{
 			run: c.initialJoinTeam,
 		},
+		{
+			run: c.onboard,
+		},
 	}
 
 	for i := 0; i < len(initActions); i++ {
@@ -101,11 +131,88 @@ func (c *SimulController) Run() {
 		}
 	}
 
-	actions := []userAction{
+	if isAdmin, err := c.user.IsSysAdmin(); err != nil {
+		c.status <- c.newErrorStatus(err)
+	} else if !isAdmin {
+		accounts.register(c.user.Store().Id())
+	}
+
+	actions := c.getActionList()
+
+	lastSessionRefresh := time.Now()
+	sessionRefreshInterval := time.Duration(c.config.SessionRefreshIntervalMs) * time.Millisecond
+
+	for {
+		if accounts.isDeactivated(c.user.Store().Id()) {
+			if !c.waitForReactivation() {
+				return
+			}
+			lastSessionRefresh = time.Now()
+		}
+
+		// An out of sync store would only produce meaningless load, so
+		// actions are paused until the user recovers.
+		if !c.user.StoreHealthy() {
+			select {
+			case <-c.stopChan:
+				return
+			case <-time.After(control.PickIdleTimeMs(c.config.MinIdleTimeMs, c.config.AvgIdleTimeMs, c.rate)):
+			}
+			continue
+		}
+
+		// Refreshing the session from the actions loop ensures the token is
+		// never replaced while another action is running.
+		if sessionRefreshInterval > 0 && time.Since(lastSessionRefresh) >= sessionRefreshInterval {
+			if resp := c.refreshSession(c.user); resp.Err != nil {
+				c.status <- c.newErrorStatus(resp.Err)
+			} else {
+				c.status <- c.newInfoStatus(resp.Info)
+			}
+			lastSessionRefresh = time.Now()
+		}
+
+		action, err := pickAction(actions)
+		if err != nil {
+			panic(fmt.Sprintf("simulcontroller: failed to pick action %s", err.Error()))
+		}
+
+		if action.minServerVersion != "" {
+			supported, err := control.IsVersionSupported(action.minServerVersion, c.serverVersion)
+			if err != nil {
+				c.status <- c.newErrorStatus(err)
+			} else if !supported {
+				continue
+			}
+		}
+
+		if resp := action.run(c.user); resp.Err != nil {
+			c.status <- c.newErrorStatus(resp.Err)
+		} else {
+			c.status <- c.newInfoStatus(resp.Info)
+		}
+
+		select {
+		case <-c.stopChan:
+			return
+		case <-time.After(control.PickIdleTimeMs(c.config.MinIdleTimeMs, c.config.AvgIdleTimeMs, c.rate)):
+		}
+	}
+
+}
+
+// getActionList returns the actions performed by the controlled user,
+// along with their relative frequencies.
+func (c *SimulController) getActionList() []userAction {
+	return []userAction{
 		{
 			run:       switchChannel,
 			frequency: 4,
 		},
+		{
+			run:       quickSwitchChannel,
+			frequency: 1.5,
+		},
 		{
 			run:       c.switchTeam,
 			frequency: 3,
@@ -126,10 +233,22 @@ func (c *SimulController) Run() {
 			run:       c.createPost,
 			frequency: 1.5,
 		},
+		{
+			run:       c.postGif,
+			frequency: 1.5 * c.config.GifPostRate,
+		},
+		{
+			run:       c.postPermalink,
+			frequency: c.config.PermalinkPostFrequency,
+		},
 		{
 			run:       c.createPostReply,
 			frequency: 0.5,
 		},
+		{
+			run:       c.draftPost,
+			frequency: 0.3,
+		},
 		{
 			run:       c.joinChannel,
 			frequency: 0.8,
@@ -154,6 +273,18 @@ func (c *SimulController) Run() {
 			run:       c.logoutLogin,
 			frequency: 0.1,
 		},
+		{
+			run:       c.openPostListPanel,
+			frequency: c.config.PostListPanelsFrequency,
+		},
+		{
+			run:       c.openChannelFiles,
+			frequency: c.config.ChannelFilesFrequency,
+		},
+		{
+			run:       c.viewProfilePopovers,
+			frequency: 1,
+		},
 		{
 			run:       searchUsers,
 			frequency: 0.1,
@@ -167,7 +298,11 @@ func (c *SimulController) Run() {
 			frequency: 0.1,
 		},
 		{
-			run:       editPost,
+			run:       c.createRemindCommand,
+			frequency: 0.02,
+		},
+		{
+			run:       c.editPost,
 			frequency: 0.1,
 		},
 		{
@@ -198,6 +333,30 @@ func (c *SimulController) Run() {
 			run:       c.createGroupChannel,
 			frequency: 0.05,
 		},
+		{
+			run:       c.archiveChannels,
+			frequency: c.config.ArchiveChannelsFrequency,
+		},
+		{
+			run:       c.exportMessages,
+			frequency: c.config.MessageExportFrequency,
+		},
+		{
+			run:       c.toggleUserActive,
+			frequency: c.config.UserDeactivationFrequency,
+		},
+		{
+			run:       c.triggerIntegration,
+			frequency: c.config.IntegrationTriggerFrequency,
+		},
+		{
+			run:       c.createAnnotatedPost,
+			frequency: c.config.AnnotatedPostFrequency,
+		},
+		{
+			run:       c.postAnnouncement,
+			frequency: c.announcementFrequency(),
+		},
 		{
 			run:       createPrivateChannel,
 			frequency: 0.022,
@@ -208,7 +367,7 @@ func (c *SimulController) Run() {
 		},
 		{
 			run:       c.viewGlobalThreads,
-			frequency: 5.4,
+			frequency: c.config.GlobalThreadsFrequency,
 		},
 		{
 			run:       c.followThread,
@@ -232,38 +391,18 @@ func (c *SimulController) Run() {
 		},
 		{
 			run:       c.getInsights,
-			frequency: 0.011,
+			frequency: c.config.InsightsFrequency,
+		},
+		{
+			run:       c.uploadLargeFile,
+			frequency: c.config.ChunkedUploadFrequency,
+		},
+		{
+			run:              c.updateChannelBookmarks,
+			frequency:        0.01,
+			minServerVersion: minChannelBookmarksVersion,
 		},
 	}
-
-	for {
-		action, err := pickAction(actions)
-		if err != nil {
-			panic(fmt.Sprintf("simulcontroller: failed to pick action %s", err.Error()))
-		}
-
-		if action.minServerVersion != "" {
-			supported, err := control.IsVersionSupported(action.minServerVersion, c.serverVersion)
-			if err != nil {
-				c.status <- c.newErrorStatus(err)
-			} else if !supported {
-				continue
-			}
-		}
-
-		if resp := action.run(c.user); resp.Err != nil {
-			c.status <- c.newErrorStatus(resp.Err)
-		} else {
-			c.status <- c.newInfoStatus(resp.Info)
-		}
-
-		select {
-		case <-c.stopChan:
-			return
-		case <-time.After(control.PickIdleTimeMs(c.config.MinIdleTimeMs, c.config.AvgIdleTimeMs, c.rate)):
-		}
-	}
-
 }
 
 // SetRate sets the relative speed of execution of actions by the user.
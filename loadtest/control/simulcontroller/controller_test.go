@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/faultstore"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/userentity"
 	"github.com/stretchr/testify/require"
@@ -81,3 +82,66 @@ func TestRunStop(t *testing.T) {
 	close(statusChan)
 	<-doneHandlingStatus
 }
+
+func TestRunStopFaultyStore(t *testing.T) {
+	ms, err := memstore.New(nil)
+	require.NoError(t, err)
+	store, err := faultstore.New(ms, faultstore.Config{})
+	require.NoError(t, err)
+
+	user := userentity.New(userentity.Setup{Store: store}, userentity.Config{
+		ServerURL:    "http://localhost:8065",
+		WebSocketURL: "ws://localhost:8065",
+	})
+	require.NotNil(t, user)
+
+	err = store.SetErrorRate(1)
+	require.NoError(t, err)
+	statusChan := make(chan control.UserStatus)
+
+	config, err := ReadConfig("../../../config/simulcontroller.sample.json")
+	require.NoError(t, err)
+	require.NotNil(t, config)
+	config.MinIdleTimeMs = 0
+	config.AvgIdleTimeMs = 10
+
+	c, err := New(1, user, config, statusChan)
+	require.Nil(t, err)
+
+	doneRunning := make(chan struct{})
+	go func() {
+		c.Run()
+		close(doneRunning)
+	}()
+
+	status := <-statusChan
+	require.NoError(t, status.Err)
+	require.Equal(t, "user started", status.Info)
+
+	// The controller should keep going despite the failing store.
+	for numErrors := 0; numErrors < 3; {
+		status := <-statusChan
+		if status.Err != nil {
+			numErrors++
+		}
+	}
+
+	doneHandlingStatus := make(chan struct{})
+	go func() {
+		var last control.UserStatus
+		for {
+			status, ok := <-statusChan
+			if !ok {
+				require.Equal(t, "user stopped", last.Info)
+				break
+			}
+			last = status
+		}
+		close(doneHandlingStatus)
+	}()
+
+	c.Stop()
+	<-doneRunning
+	close(statusChan)
+	<-doneHandlingStatus
+}
@@ -14,14 +14,32 @@ const (
 
 func (c *SimulController) periodicActions(wg *sync.WaitGroup) {
 	defer wg.Done()
+
+	statusesTicker := time.NewTicker(getUsersStatusByIdsInterval)
+	defer statusesTicker.Stop()
+
+	// A nil channel never fires, which keeps membership growth disabled.
+	var growthChan <-chan time.Time
+	if c.config.MembershipGrowthIntervalMs > 0 {
+		growthTicker := time.NewTicker(time.Duration(c.config.MembershipGrowthIntervalMs) * time.Millisecond)
+		defer growthTicker.Stop()
+		growthChan = growthTicker.C
+	}
+
 	for {
 		select {
-		case <-time.After(getUsersStatusByIdsInterval):
+		case <-statusesTicker.C:
 			if resp := c.getUsersStatuses(); resp.Err != nil {
 				c.status <- c.newErrorStatus(resp.Err)
 			} else {
 				c.status <- c.newInfoStatus(resp.Info)
 			}
+		case <-growthChan:
+			if resp := c.growChannelMembership(c.user); resp.Err != nil {
+				c.status <- c.newErrorStatus(resp.Err)
+			} else {
+				c.status <- c.newInfoStatus(resp.Info)
+			}
 		// We can add more periodic actions here.
 		case <-c.disconnectChan:
 			return
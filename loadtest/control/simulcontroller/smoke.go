@@ -0,0 +1,95 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package simulcontroller
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
+)
+
+// SmokeResult holds the outcome of an action run during a smoke test.
+type SmokeResult struct {
+	// The name of the action.
+	Action string
+	// Whether the action was skipped because the target server version
+	// doesn't support it.
+	Skipped bool
+	// The information returned by the action, if it succeeded.
+	Info string
+	// The error returned by the action, if it failed.
+	Err error
+}
+
+// SmokeTest logs the controlled user in and runs each of the enabled actions
+// exactly once, in order and without idling in between, to check that they
+// work against the target instance before a full load-test. It returns the
+// outcome of each action, or an error if the user could not be initialized.
+func (c *SimulController) SmokeTest() ([]SmokeResult, error) {
+	if c.user == nil {
+		return nil, errors.New("controller was not initialized")
+	}
+
+	defer func() {
+		if atomic.LoadInt32(&c.connectedFlag) == 1 {
+			if err := c.disconnect(); err != nil {
+				c.status <- c.newErrorStatus(control.NewUserError(err))
+			}
+		}
+		c.user.ClearUserData()
+	}()
+
+	c.serverVersion, _ = c.user.Store().ServerVersion()
+
+	if resp := c.loginOrSignUp(c.user); resp.Err != nil {
+		return nil, fmt.Errorf("failed to log in: %w", resp.Err)
+	}
+	if resp := c.initialJoinTeam(c.user); resp.Err != nil {
+		return nil, fmt.Errorf("failed to join the initial team: %w", resp.Err)
+	}
+
+	var results []SmokeResult
+	for _, action := range c.getActionList() {
+		if action.frequency <= 0 {
+			continue
+		}
+
+		res := SmokeResult{Action: actionName(action.run)}
+		if action.minServerVersion != "" {
+			supported, err := control.IsVersionSupported(action.minServerVersion, c.serverVersion)
+			if err != nil {
+				res.Err = err
+				results = append(results, res)
+				continue
+			} else if !supported {
+				res.Skipped = true
+				res.Info = fmt.Sprintf("requires server version %s", action.minServerVersion)
+				results = append(results, res)
+				continue
+			}
+		}
+
+		resp := action.run(c.user)
+		res.Info = resp.Info
+		if resp.Err != nil {
+			res.Err = resp.Err
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// actionName returns the name of the function implementing the given action.
+func actionName(run control.UserAction) string {
+	name := runtime.FuncForPC(reflect.ValueOf(run).Pointer()).Name()
+	// Method values are suffixed by the compiler.
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -4,14 +4,19 @@
 package simulcontroller
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user"
+
+	"github.com/mattermost/mattermost-server/v6/model"
 )
 
 var errNoMatch = errors.New("could not match username")
@@ -71,6 +76,44 @@ func genMessage(isReply bool) string {
 	return message
 }
 
+// pickMessageLength returns a message length picked from the configured
+// distribution, capped to maxLength. It returns 0 if no distribution is
+// configured.
+func pickMessageLength(dist []MessageSizeDistribution, maxLength int) (int, error) {
+	if len(dist) == 0 {
+		return 0, nil
+	}
+
+	weights := make([]int, len(dist))
+	for i := range dist {
+		weights[i] = int(dist[i].Percentage * 100)
+	}
+
+	idx, err := control.SelectWeighted(weights)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select weight: %w", err)
+	}
+
+	length := dist[idx].MinLength + rand.Intn(dist[idx].MaxLength-dist[idx].MinLength+1)
+	if length > maxLength {
+		length = maxLength
+	}
+	return length, nil
+}
+
+// fitMessage pads the given message with random words, or truncates it, so
+// that it's exactly length characters long.
+func fitMessage(message string, length int) string {
+	var sb strings.Builder
+	sb.WriteString(message)
+	for utf8.RuneCountInString(sb.String()) < length {
+		sb.WriteString(" ")
+		sb.WriteString(control.GenerateRandomSentences(50))
+	}
+
+	return string([]rune(sb.String())[:length])
+}
+
 func splitName(name string) (string, string) {
 	typed := user.TestUserSuffixRegexp.FindString(name)
 	var prefix string
@@ -155,6 +198,56 @@ func extractMentionFromMessage(msg string) string {
 	return mention[1:]
 }
 
+// mentioningPost returns the post sent along the given posted event if it
+// mentions the specified user, nil otherwise. Posts created by the user
+// itself are ignored.
+func mentioningPost(ev *model.WebSocketEvent, userId string) (*model.Post, error) {
+	mentionsData, ok := ev.GetData()["mentions"].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	var mentions []string
+	if err := json.Unmarshal([]byte(mentionsData), &mentions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mentions: %w", err)
+	}
+	if findIndex(mentions, userId) == -1 {
+		return nil, nil
+	}
+
+	postData, ok := ev.GetData()["post"].(string)
+	if !ok {
+		return nil, errors.New("post data is missing")
+	}
+
+	var post *model.Post
+	if err := json.Unmarshal([]byte(postData), &post); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal post: %w", err)
+	}
+	if post.UserId == userId {
+		return nil, nil
+	}
+
+	return post, nil
+}
+
+// toggleChannelMention removes any @channel, @here or @all mention from the
+// given message. If there are none, it prepends the given mention instead.
+// It returns the resulting message and whether the mention was added.
+func toggleChannelMention(msg, mention string) (string, bool) {
+	words := strings.Fields(msg)
+	kept := words[:0]
+	for _, w := range words {
+		if w != "@channel" && w != "@here" && w != "@all" {
+			kept = append(kept, w)
+		}
+	}
+	if len(kept) < len(words) {
+		return strings.Join(kept, " "), false
+	}
+	return mention + " " + msg, true
+}
+
 // findIndex returns the index of needle in a haystack.
 func findIndex(haystack []string, needle string) int {
 	for i := range haystack {
@@ -164,3 +257,24 @@ func findIndex(haystack []string, needle string) int {
 	}
 	return -1
 }
+
+// isStaleChannel reports whether the given channel got neither created nor
+// posted in since the given time, in milliseconds.
+func isStaleChannel(channel *model.Channel, staleBefore int64) bool {
+	lastActivity := channel.LastPostAt
+	if channel.CreateAt > lastActivity {
+		lastActivity = channel.CreateAt
+	}
+	return lastActivity < staleBefore
+}
+
+// hasCommand reports whether a command with the given trigger is part of the
+// given ones.
+func hasCommand(commands []*model.Command, trigger string) bool {
+	for _, cmd := range commands {
+		if cmd != nil && cmd.Trigger == trigger {
+			return true
+		}
+	}
+	return false
+}
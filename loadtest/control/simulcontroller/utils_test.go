@@ -4,12 +4,18 @@
 package simulcontroller
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/stretchr/testify/require"
 )
 
@@ -281,3 +287,107 @@ func TestExtractMentionFromMessage(t *testing.T) {
 		require.Equal(t, tc.expected, extractMentionFromMessage(tc.input))
 	}
 }
+
+func TestToggleChannelMention(t *testing.T) {
+	msg, added := toggleChannelMention("hello world", "@here")
+	require.True(t, added)
+	require.Equal(t, "@here hello world", msg)
+
+	msg, added = toggleChannelMention("@channel hello @here world", "@here")
+	require.False(t, added)
+	require.Equal(t, "hello world", msg)
+
+	msg, added = toggleChannelMention("ping @channels", "@channel")
+	require.True(t, added)
+	require.Equal(t, "@channel ping @channels", msg)
+}
+
+func TestMentioningPost(t *testing.T) {
+	userId := model.NewId()
+	newEvent := func(post *model.Post, mentions []string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", post.ChannelId, "", nil)
+		postData, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev.Add("post", string(postData))
+		if mentions != nil {
+			mentionsData, err := json.Marshal(mentions)
+			require.NoError(t, err)
+			ev.Add("mentions", string(mentionsData))
+		}
+		return ev
+	}
+
+	post := &model.Post{Id: model.NewId(), UserId: model.NewId(), ChannelId: model.NewId()}
+
+	t.Run("mentioned", func(t *testing.T) {
+		p, err := mentioningPost(newEvent(post, []string{model.NewId(), userId}), userId)
+		require.NoError(t, err)
+		require.NotNil(t, p)
+		require.Equal(t, post.Id, p.Id)
+	})
+
+	t.Run("not mentioned", func(t *testing.T) {
+		p, err := mentioningPost(newEvent(post, nil), userId)
+		require.NoError(t, err)
+		require.Nil(t, p)
+
+		p, err = mentioningPost(newEvent(post, []string{model.NewId()}), userId)
+		require.NoError(t, err)
+		require.Nil(t, p)
+	})
+
+	t.Run("own post", func(t *testing.T) {
+		own := &model.Post{Id: model.NewId(), UserId: userId, ChannelId: model.NewId()}
+		p, err := mentioningPost(newEvent(own, []string{userId}), userId)
+		require.NoError(t, err)
+		require.Nil(t, p)
+	})
+}
+
+func TestActionName(t *testing.T) {
+	c := &SimulController{}
+	require.Equal(t, "switchChannel", actionName(switchChannel))
+	require.Equal(t, "createPost", actionName(c.createPost))
+	require.Equal(t, "CreatePublicChannel", actionName(control.CreatePublicChannel))
+}
+
+func TestPickMessageLength(t *testing.T) {
+	length, err := pickMessageLength(nil, 100)
+	require.NoError(t, err)
+	require.Zero(t, length)
+
+	dist := []MessageSizeDistribution{
+		{MinLength: 10, MaxLength: 20, Percentage: 0.5},
+		{MinLength: 1000, MaxLength: 2000, Percentage: 0.5},
+	}
+	for i := 0; i < 100; i++ {
+		length, err := pickMessageLength(dist, 1500)
+		require.NoError(t, err)
+		require.True(t, (length >= 10 && length <= 20) || (length >= 1000 && length <= 1500), length)
+	}
+}
+
+func TestFitMessage(t *testing.T) {
+	require.Equal(t, "hello", fitMessage("hello world", 5))
+	require.Equal(t, "héllo", fitMessage("héllo world", 5))
+
+	msg := fitMessage("hello", 5000)
+	require.Equal(t, 5000, utf8.RuneCountInString(msg))
+	require.True(t, strings.HasPrefix(msg, "hello "))
+}
+
+func TestIsStaleChannel(t *testing.T) {
+	const staleBefore = 1000
+	require.True(t, isStaleChannel(&model.Channel{CreateAt: 10, LastPostAt: 999}, staleBefore))
+	require.False(t, isStaleChannel(&model.Channel{CreateAt: 10, LastPostAt: 1000}, staleBefore))
+	// Channels recently created without any post aren't stale.
+	require.False(t, isStaleChannel(&model.Channel{CreateAt: 2000}, staleBefore))
+	require.True(t, isStaleChannel(&model.Channel{CreateAt: 10}, staleBefore))
+}
+
+func TestHasCommand(t *testing.T) {
+	commands := []*model.Command{{Trigger: "away"}, nil, {Trigger: "remind"}}
+	require.True(t, hasCommand(commands, "remind"))
+	require.False(t, hasCommand(commands, "giphy"))
+	require.False(t, hasCommand(nil, "remind"))
+}
@@ -6,12 +6,20 @@ package simulcontroller
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"runtime"
 	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/control"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 )
 
+// maxCatchUpChannels is the maximum number of recently viewed channels to
+// refresh when catching up after a WebSocket reconnection.
+const maxCatchUpChannels = 10
+
 // wsEventHandler listens for WebSocket events to be handled.
 // This is used to model user behaviour by responding to certain events with
 // the appropriate actions. It differs from userentity.wsEventHandler which is
@@ -27,8 +35,45 @@ func (c *SimulController) wsEventHandler(wg *sync.WaitGroup) {
 		wg.Done()
 	}()
 
+	// The first hello event is received on the initial connection. Any
+	// following one means the WebSocket connection was re-established.
+	var helloReceived bool
+
 	for ev := range c.user.Events() {
 		switch ev.EventType() {
+		case model.WebsocketEventHello:
+			if !helloReceived {
+				helloReceived = true
+				break
+			}
+
+			// As the webapp does, we fetch any post we may have missed while
+			// disconnected.
+			select {
+			case semaphore <- struct{}{}:
+				go fetchMissedPosts(c, semaphore)
+			default:
+				c.status <- c.newErrorStatus(errors.New("simulcontroller: dropping call"))
+			}
+		case model.WebsocketEventPosted:
+			if c.config.MentionAckRate == 0 || rand.Float64() >= c.config.MentionAckRate {
+				break
+			}
+			post, err := mentioningPost(ev, c.user.Store().Id())
+			if err != nil {
+				c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: invalid posted event: %w", err))
+				break
+			}
+			if post == nil {
+				break
+			}
+
+			select {
+			case semaphore <- struct{}{}:
+				go acknowledgeMention(c, semaphore, post)
+			default:
+				c.status <- c.newErrorStatus(errors.New("simulcontroller: dropping call"))
+			}
 		case model.WebsocketEventTyping:
 			userId, ok := ev.GetData()["user_id"].(string)
 			if !ok || userId == "" {
@@ -73,6 +118,35 @@ func (c *SimulController) wsEventHandler(wg *sync.WaitGroup) {
 	}
 }
 
+// acknowledgeMention reacts to a post mentioning the user after a short
+// delay, as users often do to let the sender know they've seen it.
+func acknowledgeMention(c *SimulController, sem chan struct{}, post *model.Post) {
+	defer func() { <-sem }()
+
+	delay := c.config.MentionAckMinDelayMs
+	if diff := c.config.MentionAckMaxDelayMs - c.config.MentionAckMinDelayMs; diff > 0 {
+		delay += rand.Intn(diff + 1)
+	}
+
+	select {
+	case <-c.stopChan:
+		return
+	case <-time.After(time.Duration(delay) * time.Millisecond):
+	}
+
+	reaction := &model.Reaction{
+		UserId:    c.user.Store().Id(),
+		PostId:    post.Id,
+		EmojiName: "+1",
+	}
+	if err := c.user.SaveReaction(reaction); err != nil {
+		c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: SaveReaction failed %w", err))
+		return
+	}
+
+	c.status <- c.newInfoStatus(fmt.Sprintf("acknowledged mention in post %s", post.Id))
+}
+
 func fetchStatus(c *SimulController, sem chan struct{}, id string) {
 	defer func() { <-sem }()
 
@@ -93,3 +167,45 @@ func fetchUserAndStatus(c *SimulController, sem chan struct{}, id string) {
 		c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: GetUsersStatusesByIds failed %w", err))
 	}
 }
+
+func fetchMissedPosts(c *SimulController, sem chan struct{}) {
+	defer func() { <-sem }()
+
+	collapsedThreads, resp := control.CollapsedThreadsEnabled(c.user)
+	if resp.Err != nil {
+		c.status <- c.newErrorStatus(resp.Err)
+		return
+	}
+
+	channelIds, err := c.user.Store().RecentlyViewedChannels(maxCatchUpChannels)
+	if err != nil {
+		c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: RecentlyViewedChannels failed %w", err))
+		return
+	}
+
+	for _, channelId := range channelIds {
+		since, err := c.user.Store().ChannelLastPostAt(channelId)
+		if err != nil {
+			c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: ChannelLastPostAt failed %w", err))
+			continue
+		}
+
+		// If we have no posts for the channel we fall back to the last time
+		// it was viewed.
+		if since == 0 {
+			since, err = c.user.Store().ChannelView(channelId)
+			if err != nil {
+				c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: ChannelView failed %w", err))
+				continue
+			}
+		}
+
+		if since == 0 {
+			continue
+		}
+
+		if _, err := c.user.GetPostsSince(channelId, since, collapsedThreads); err != nil {
+			c.status <- c.newErrorStatus(fmt.Errorf("simulcontroller: GetPostsSince failed %w", err))
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package delivery keeps track of the posts created by the users of a
+// load-test and of the posted events received by them, so that it can be
+// verified that the server delivered each event to all the expected users.
+package delivery
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// MemberFunc reports whether a user is a member of the given channel.
+type MemberFunc func(channelId string) bool
+
+// Tracker samples the posts created by the users of a load-test and records
+// which of the users that should have received the related posted event
+// actually did. Posts are identified by their pending post id, since the event
+// can be received before the creation request returns.
+// It is safe for concurrent use.
+type Tracker struct {
+	mut        sync.Mutex
+	sampleSize int
+	numSent    int
+	// the users with an open WebSocket connection, paired with a function
+	// telling which channels they are members of.
+	connected map[string]*connection
+	samples   []*sample
+	byId      map[string]*sample
+}
+
+// connection is the WebSocket connection of a user. A new one is created
+// each time the user connects.
+type connection struct {
+	isMember MemberFunc
+}
+
+type sample struct {
+	pendingId string
+	channelId string
+	// maps each expected recipient to whether the event was received.
+	recipients map[string]bool
+}
+
+// MissingDelivery holds the users that didn't receive the posted event for
+// a sampled post.
+type MissingDelivery struct {
+	PendingPostId string
+	ChannelId     string
+	UserIds       []string
+}
+
+// Result holds the outcome of a delivery check.
+type Result struct {
+	SampledPosts       int               // The number of posts checked.
+	ExpectedDeliveries int               // The number of events that should have been received.
+	MissingDeliveries  int               // The number of events that were not received.
+	Missing            []MissingDelivery `json:",omitempty"` // The details of the posts with missing deliveries.
+}
+
+// NewTracker returns a new Tracker keeping at most sampleSize posts.
+func NewTracker(sampleSize int) *Tracker {
+	return &Tracker{
+		sampleSize: sampleSize,
+		connected:  make(map[string]*connection),
+		byId:       make(map[string]*sample),
+	}
+}
+
+// SetConnected records that the specified user opened its WebSocket
+// connection. A nil isMember records that the connection was closed, in
+// which case the user is no longer expected to receive the events it didn't
+// receive yet, since they can't be delivered anymore.
+func (t *Tracker) SetConnected(userId string, isMember MemberFunc) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if isMember == nil {
+		delete(t.connected, userId)
+		for _, s := range t.samples {
+			if received, ok := s.recipients[userId]; ok && !received {
+				delete(s.recipients, userId)
+			}
+		}
+		return
+	}
+	t.connected[userId] = &connection{isMember: isMember}
+}
+
+// TrackSent records that a post with the given pending post id is about to be
+// created in the given channel. The post is kept with a probability that
+// makes the tracked posts a uniform sample of all the created ones. Its
+// expected recipients are the users currently connected that are members of
+// the channel.
+func (t *Tracker) TrackSent(pendingId, channelId string) {
+	t.mut.Lock()
+	t.numSent++
+	idx := len(t.samples)
+	if idx >= t.sampleSize {
+		// Reservoir sampling.
+		idx = rand.Intn(t.numSent)
+		if idx >= t.sampleSize {
+			t.mut.Unlock()
+			return
+		}
+	}
+	connected := make(map[string]*connection, len(t.connected))
+	for userId, conn := range t.connected {
+		connected[userId] = conn
+	}
+	t.mut.Unlock()
+
+	// The membership functions access the users' stores so they are called
+	// without holding the lock.
+	recipients := make(map[string]bool)
+	for userId, conn := range connected {
+		if conn.isMember(channelId) {
+			recipients[userId] = false
+		}
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	s := &sample{
+		pendingId:  pendingId,
+		channelId:  channelId,
+		recipients: recipients,
+	}
+	// Users that disconnected in the meantime are not expected to receive the
+	// event anymore.
+	for userId := range recipients {
+		if t.connected[userId] != connected[userId] {
+			delete(recipients, userId)
+		}
+	}
+
+	// Other posts may have been sampled in the meantime.
+	if len(t.samples) < t.sampleSize {
+		t.samples = append(t.samples, s)
+	} else {
+		if idx >= len(t.samples) {
+			idx = rand.Intn(len(t.samples))
+		}
+		delete(t.byId, t.samples[idx].pendingId)
+		t.samples[idx] = s
+	}
+	t.byId[pendingId] = s
+}
+
+// Untrack stops tracking the post with the given pending post id. It's meant
+// to be called when the creation of the post failed.
+func (t *Tracker) Untrack(pendingId string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if _, ok := t.byId[pendingId]; !ok {
+		return
+	}
+	delete(t.byId, pendingId)
+	for i, s := range t.samples {
+		if s.pendingId == pendingId {
+			t.samples = append(t.samples[:i], t.samples[i+1:]...)
+			break
+		}
+	}
+}
+
+// TrackReceived records that the specified user received the posted event
+// for the post with the given pending post id.
+func (t *Tracker) TrackReceived(userId, pendingId string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	s, ok := t.byId[pendingId]
+	if !ok {
+		return
+	}
+	if _, ok := s.recipients[userId]; ok {
+		s.recipients[userId] = true
+	}
+}
+
+// Check compares the expected recipients of the sampled posts with the users
+// that actually received the related events.
+func (t *Tracker) Check() Result {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	res := Result{
+		SampledPosts: len(t.samples),
+	}
+	for _, s := range t.samples {
+		var missing []string
+		for userId, received := range s.recipients {
+			res.ExpectedDeliveries++
+			if !received {
+				missing = append(missing, userId)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		sort.Strings(missing)
+		res.MissingDeliveries += len(missing)
+		res.Missing = append(res.Missing, MissingDelivery{
+			PendingPostId: s.pendingId,
+			ChannelId:     s.channelId,
+			UserIds:       missing,
+		})
+	}
+	return res
+}
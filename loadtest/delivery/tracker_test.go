@@ -0,0 +1,122 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package delivery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func memberOf(channelIds ...string) MemberFunc {
+	return func(channelId string) bool {
+		for _, id := range channelIds {
+			if id == channelId {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestTracker(t *testing.T) {
+	t.Run("all delivered", func(t *testing.T) {
+		tr := NewTracker(10)
+		tr.SetConnected("user1", memberOf("ch1"))
+		tr.SetConnected("user2", memberOf("ch1", "ch2"))
+
+		tr.TrackSent("p1", "ch1")
+		tr.TrackSent("p2", "ch2")
+		tr.TrackReceived("user1", "p1")
+		tr.TrackReceived("user2", "p1")
+		tr.TrackReceived("user2", "p2")
+		// Events for untracked posts or from unexpected users are ignored.
+		tr.TrackReceived("user1", "p2")
+		tr.TrackReceived("user1", "p3")
+
+		require.Equal(t, Result{
+			SampledPosts:       2,
+			ExpectedDeliveries: 3,
+		}, tr.Check())
+	})
+
+	t.Run("missing deliveries", func(t *testing.T) {
+		tr := NewTracker(10)
+		tr.SetConnected("user1", memberOf("ch1"))
+		tr.SetConnected("user2", memberOf("ch1"))
+		tr.SetConnected("user3", memberOf("ch1"))
+		tr.SetConnected("user3", nil)
+
+		tr.TrackSent("p1", "ch1")
+		tr.TrackReceived("user2", "p1")
+
+		require.Equal(t, Result{
+			SampledPosts:       1,
+			ExpectedDeliveries: 2,
+			MissingDeliveries:  1,
+			Missing: []MissingDelivery{
+				{PendingPostId: "p1", ChannelId: "ch1", UserIds: []string{"user1"}},
+			},
+		}, tr.Check())
+	})
+
+	t.Run("disconnected before receiving", func(t *testing.T) {
+		tr := NewTracker(10)
+		tr.SetConnected("user1", memberOf("ch1"))
+		tr.SetConnected("user2", memberOf("ch1"))
+
+		tr.TrackSent("p1", "ch1")
+		tr.TrackSent("p2", "ch1")
+		tr.TrackReceived("user1", "p1")
+		tr.SetConnected("user1", nil)
+		tr.SetConnected("user2", nil)
+		tr.TrackReceived("user2", "p2")
+
+		require.Equal(t, Result{
+			SampledPosts:       2,
+			ExpectedDeliveries: 1,
+		}, tr.Check())
+	})
+
+	t.Run("membership checked without lock", func(t *testing.T) {
+		tr := NewTracker(10)
+		tr.SetConnected("user1", func(channelId string) bool {
+			// The tracker can be used while checking memberships.
+			tr.SetConnected("user2", memberOf("ch1"))
+			return true
+		})
+
+		tr.TrackSent("p1", "ch1")
+		tr.TrackReceived("user1", "p1")
+
+		require.Equal(t, Result{
+			SampledPosts:       1,
+			ExpectedDeliveries: 1,
+		}, tr.Check())
+	})
+
+	t.Run("untrack", func(t *testing.T) {
+		tr := NewTracker(10)
+		tr.SetConnected("user1", memberOf("ch1"))
+
+		tr.TrackSent("p1", "ch1")
+		tr.Untrack("p1")
+
+		require.Equal(t, Result{}, tr.Check())
+	})
+
+	t.Run("sample size", func(t *testing.T) {
+		tr := NewTracker(5)
+		tr.SetConnected("user1", memberOf("ch1"))
+
+		for i := 0; i < 100; i++ {
+			tr.TrackSent(string(rune('a'+i)), "ch1")
+		}
+
+		res := tr.Check()
+		require.Equal(t, 5, res.SampledPosts)
+		require.Equal(t, 5, res.MissingDeliveries)
+		require.Len(t, tr.byId, 5)
+	})
+}
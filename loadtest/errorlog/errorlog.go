@@ -0,0 +1,202 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package errorlog writes the errors reported by the users of a load-test to
+// gzip compressed files, one per user type, so that they can be inspected
+// once the load-test is over. The files are rotated once they reach a
+// configured size.
+package errorlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// Config holds the settings of a Logger.
+type Config struct {
+	// Dir is the directory the files are written to. It's created if missing.
+	Dir string
+	// MaxFileSize is the size, in uncompressed bytes, after which a file is
+	// rotated.
+	MaxFileSize int64
+	// MaxBackups is the number of rotated files kept for each user type,
+	// besides the one being written. The oldest ones are removed.
+	MaxBackups int
+}
+
+type entry struct {
+	userType string
+	at       time.Time
+	msg      string
+}
+
+// Logger writes errors to a size-rotating, gzip compressed file per user
+// type. Errors are queued in memory and written by a separate goroutine, so
+// that logging never blocks the callers on disk writes. It's safe for
+// concurrent use.
+type Logger struct {
+	config Config
+
+	mut     sync.Mutex
+	queue   []entry
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	// files is only accessed by the writing goroutine. It's keyed by file
+	// name, since different user types can map to a same file.
+	files map[string]*rotatingFile
+	err   error
+}
+
+// New returns a new Logger writing to the configured directory. Close must
+// be called to write the queued errors and release the files.
+func New(config Config) (*Logger, error) {
+	if config.Dir == "" {
+		return nil, errors.New("errorlog: directory should not be empty")
+	}
+	if config.MaxFileSize <= 0 {
+		return nil, errors.New("errorlog: max file size should be greater than zero")
+	}
+	if config.MaxBackups < 0 {
+		return nil, errors.New("errorlog: max backups should not be negative")
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("errorlog: failed to create directory: %w", err)
+	}
+
+	l := &Logger{
+		config:  config,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		files:   map[string]*rotatingFile{},
+	}
+	go l.run()
+	return l, nil
+}
+
+// Log queues the given error to be written to the file of the given user
+// type. It never blocks on writing. Errors logged after Close are discarded.
+func (l *Logger) Log(userType string, err error) {
+	if err == nil {
+		return
+	}
+
+	l.mut.Lock()
+	if l.closed {
+		l.mut.Unlock()
+		return
+	}
+	l.queue = append(l.queue, entry{userType: userType, at: time.Now(), msg: err.Error()})
+	l.mut.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Tee returns a channel receiving the errors received from errChan, which
+// are also logged to the file of the given user type. The returned channel
+// is closed once errChan is.
+func (l *Logger) Tee(userType string, errChan <-chan error) <-chan error {
+	out := make(chan error, cap(errChan))
+	go func() {
+		defer close(out)
+		for err := range errChan {
+			l.Log(userType, err)
+			out <- err
+		}
+	}()
+	return out
+}
+
+// Close writes the queued errors and closes the files, returning the first
+// error encountered while writing, if any.
+func (l *Logger) Close() error {
+	l.mut.Lock()
+	if l.closed {
+		l.mut.Unlock()
+		<-l.stopped
+		return l.err
+	}
+	l.closed = true
+	l.mut.Unlock()
+
+	close(l.done)
+	<-l.stopped
+	return l.err
+}
+
+func (l *Logger) run() {
+	defer close(l.stopped)
+	for {
+		select {
+		case <-l.wake:
+			l.writeQueued()
+		case <-l.done:
+			l.writeQueued()
+			for _, f := range l.files {
+				l.setErr(f.close())
+			}
+			return
+		}
+	}
+}
+
+func (l *Logger) writeQueued() {
+	l.mut.Lock()
+	entries := l.queue
+	l.queue = nil
+	l.mut.Unlock()
+
+	touched := map[*rotatingFile]bool{}
+	for _, e := range entries {
+		f, err := l.file(e.userType)
+		if err != nil {
+			l.setErr(err)
+			continue
+		}
+		line := e.at.UTC().Format(time.RFC3339Nano) + " " + e.msg + "\n"
+		if err := f.write([]byte(line)); err != nil {
+			l.setErr(err)
+			continue
+		}
+		touched[f] = true
+	}
+	// Flushing keeps the written errors readable even if the process exits
+	// without closing the logger.
+	for f := range touched {
+		l.setErr(f.flush())
+	}
+}
+
+func (l *Logger) file(userType string) (*rotatingFile, error) {
+	name := fileName(userType)
+	if f, ok := l.files[name]; ok {
+		return f, nil
+	}
+	f, err := newRotatingFile(l.config.Dir, name, l.config.MaxFileSize, l.config.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	l.files[name] = f
+	return f, nil
+}
+
+func (l *Logger) setErr(err error) {
+	if err == nil {
+		return
+	}
+	mlog.Warn("errorlog: failed to write errors", mlog.Err(err))
+	if l.err == nil {
+		l.err = err
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package errorlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readLines returns the lines of the given gzip compressed file.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	defer gz.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{MaxFileSize: 1})
+	require.Error(t, err)
+	_, err = New(Config{Dir: t.TempDir()})
+	require.Error(t, err)
+	_, err = New(Config{Dir: t.TempDir(), MaxFileSize: 1, MaxBackups: -1})
+	require.Error(t, err)
+
+	dir := filepath.Join(t.TempDir(), "errors")
+	l, err := New(Config{Dir: dir, MaxFileSize: 1})
+	require.NoError(t, err)
+	require.DirExists(t, dir)
+	require.NoError(t, l.Close())
+	// Closing twice is fine.
+	require.NoError(t, l.Close())
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := New(Config{Dir: dir, MaxFileSize: 1 << 20, MaxBackups: 1})
+	require.NoError(t, err)
+
+	l.Log("default", errors.New("first"))
+	l.Log("receiver", errors.New("second"))
+	l.Log("", errors.New("third"))
+	l.Log("../escape", errors.New("fourth"))
+	l.Log("default", nil)
+	require.NoError(t, l.Close())
+	// Errors logged once closed are discarded.
+	l.Log("default", errors.New("discarded"))
+
+	lines := readLines(t, filepath.Join(dir, "default.log.gz"))
+	require.Len(t, lines, 2)
+	require.Regexp(t, `^\S+ first$`, lines[0])
+	require.Regexp(t, `^\S+ third$`, lines[1])
+	require.Len(t, readLines(t, filepath.Join(dir, "receiver.log.gz")), 1)
+	require.Len(t, readLines(t, filepath.Join(dir, "___escape.log.gz")), 1)
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	l, err := New(Config{Dir: dir, MaxFileSize: 100, MaxBackups: 2})
+	require.NoError(t, err)
+
+	// Each line is about 40 bytes long, so each file holds two of them.
+	for i := 0; i < 10; i++ {
+		l.Log("default", fmt.Errorf("error %d", i))
+	}
+	require.NoError(t, l.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	sort.Strings(files)
+	require.Equal(t, []string{
+		filepath.Join(dir, "default.1.log.gz"),
+		filepath.Join(dir, "default.2.log.gz"),
+		filepath.Join(dir, "default.log.gz"),
+	}, files)
+
+	// The oldest errors got removed along with the files holding them.
+	require.Regexp(t, `error 8$`, readLines(t, filepath.Join(dir, "default.log.gz"))[0])
+	require.Regexp(t, `error 6$`, readLines(t, filepath.Join(dir, "default.1.log.gz"))[0])
+	require.Regexp(t, `error 4$`, readLines(t, filepath.Join(dir, "default.2.log.gz"))[0])
+	for _, file := range files {
+		require.Len(t, readLines(t, file), 2)
+	}
+}
+
+func TestTeeUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	l, err := New(Config{Dir: dir, MaxFileSize: 1 << 16, MaxBackups: 100})
+	require.NoError(t, err)
+
+	const numUsers = 20
+	const numErrors = 500
+	userTypes := []string{"default", "receiver"}
+
+	var wg sync.WaitGroup
+	var forwarded int64
+	var mut sync.Mutex
+	for i := 0; i < numUsers; i++ {
+		errChan := make(chan error, 1)
+		out := l.Tee(userTypes[i%len(userTypes)], errChan)
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < numErrors; j++ {
+				errChan <- fmt.Errorf("user %d error %d", i, j)
+			}
+			close(errChan)
+		}(i)
+		go func() {
+			defer wg.Done()
+			// All the errors are still forwarded to the consumer.
+			var n int64
+			for range out {
+				n++
+			}
+			mut.Lock()
+			forwarded += n
+			mut.Unlock()
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, l.Close())
+	require.Equal(t, int64(numUsers*numErrors), forwarded)
+
+	// No error is lost, be it in the rotated files or the current ones.
+	files, err := filepath.Glob(filepath.Join(dir, "*.log.gz"))
+	require.NoError(t, err)
+	require.Greater(t, len(files), len(userTypes))
+	var logged int
+	for _, file := range files {
+		logged += len(readLines(t, file))
+	}
+	require.Equal(t, numUsers*numErrors, logged)
+}
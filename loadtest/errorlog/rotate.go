@@ -0,0 +1,129 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package errorlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rotatingFile is a gzip compressed file which is rotated once the
+// uncompressed bytes written to it reach a maximum size. The rotated files
+// are named after the original one with an increasing index, the oldest ones
+// being removed.
+//
+// rotatingFile is not safe for concurrent use.
+type rotatingFile struct {
+	dir        string
+	name       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	gz   *gzip.Writer
+	size int64
+}
+
+// fileName returns the name of the file of the given user type, replacing
+// the characters which aren't safe in a file name.
+func fileName(userType string) string {
+	if userType == "" {
+		userType = "default"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, userType)
+}
+
+func newRotatingFile(dir, name string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		dir:        dir,
+		name:       name,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// path returns the path of the file with the given index, 0 being the one
+// being written.
+func (rf *rotatingFile) path(index int) string {
+	if index == 0 {
+		return filepath.Join(rf.dir, rf.name+".log.gz")
+	}
+	return filepath.Join(rf.dir, rf.name+"."+strconv.Itoa(index)+".log.gz")
+}
+
+func (rf *rotatingFile) open() error {
+	// Any previous file is overwritten, as with a new load-test.
+	file, err := os.Create(rf.path(0))
+	if err != nil {
+		return fmt.Errorf("errorlog: failed to create file: %w", err)
+	}
+	rf.file = file
+	rf.gz = gzip.NewWriter(file)
+	rf.size = 0
+	return nil
+}
+
+func (rf *rotatingFile) write(p []byte) error {
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.gz.Write(p)
+	rf.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("errorlog: failed to write: %w", err)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.close(); err != nil {
+		return err
+	}
+	if rf.maxBackups == 0 {
+		return rf.open()
+	}
+
+	// Renaming over the last backup removes it.
+	for i := rf.maxBackups - 1; i >= 0; i-- {
+		if err := os.Rename(rf.path(i), rf.path(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("errorlog: failed to rotate file: %w", err)
+		}
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) flush() error {
+	if err := rf.gz.Flush(); err != nil {
+		return fmt.Errorf("errorlog: failed to flush: %w", err)
+	}
+	return nil
+}
+
+func (rf *rotatingFile) close() error {
+	if err := rf.gz.Close(); err != nil {
+		rf.file.Close()
+		return fmt.Errorf("errorlog: failed to close: %w", err)
+	}
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("errorlog: failed to close: %w", err)
+	}
+	return nil
+}
@@ -33,6 +33,10 @@ type LoadTester struct {
 
 	activeControllers []control.UserController
 	idleControllers   []control.UserController
+	arrivalsStopChan  chan struct{}
+	// numArrivalIds is not reset between runs so that new users keep
+	// arriving with accounts that don't exist yet.
+	numArrivalIds int
 
 	log *mlog.Logger
 }
@@ -110,6 +114,36 @@ func (lt *LoadTester) addUser() error {
 		}
 	}
 
+	return lt.startController(controller)
+}
+
+// addArrival is an internal API called when a new user arrives.
+// DO NOT call this by itself, because this method is not protected by a mutex.
+func (lt *LoadTester) addArrival() error {
+	if len(lt.activeControllers) == lt.config.UsersConfiguration.MaxActiveUsers {
+		return ErrMaxUsersReached
+	}
+
+	// Arriving users get ids past the ones used by regular users so that
+	// their accounts don't exist yet.
+	lt.numArrivalIds++
+	userId := lt.config.UsersConfiguration.MaxActiveUsers + lt.numArrivalIds
+	controller, err := lt.newController(userId, lt.statusChan)
+	if err != nil {
+		return err
+	}
+
+	if err := lt.startController(controller); err != nil {
+		return err
+	}
+	lt.status.NumArrivals++
+	return nil
+}
+
+// startController sets the rate of the given controller and starts running
+// it as an active user.
+// DO NOT call this by itself, because this method is not protected by a mutex.
+func (lt *LoadTester) startController(controller control.UserController) error {
 	rate, err := pickRate(lt.config.UserControllerConfiguration)
 	if err != nil {
 		return fmt.Errorf("loadtest: failed to pick rate: %w", err)
@@ -129,6 +163,39 @@ func (lt *LoadTester) addUser() error {
 	return nil
 }
 
+// runArrivals adds a new user at the configured arrival rate until the
+// configured maximum number of arrivals is reached or stopChan is closed.
+func (lt *LoadTester) runArrivals(stopChan <-chan struct{}) {
+	interval := time.Duration(float64(time.Minute) / lt.config.UsersConfiguration.ArrivalsPerMinute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		lt.mut.Lock()
+		select {
+		case <-stopChan:
+			// The load-test was stopped while waiting for the lock.
+			lt.mut.Unlock()
+			return
+		default:
+		}
+		if lt.status.NumArrivals >= int64(lt.config.UsersConfiguration.MaxArrivals) {
+			lt.mut.Unlock()
+			return
+		}
+		if err := lt.addArrival(); err != nil && !errors.Is(err, ErrMaxUsersReached) {
+			lt.log.Error("loadtest: failed to add arriving user", mlog.Err(err))
+		}
+		lt.mut.Unlock()
+	}
+}
+
 // RemoveUsers attempts to decrement by numUsers the number of concurrently active users.
 // Returns the number of users successfully removed.
 func (lt *LoadTester) RemoveUsers(numUsers int) (int, error) {
@@ -187,6 +254,7 @@ func (lt *LoadTester) Run() error {
 	lt.status.NumUsersRemoved = 0
 	lt.status.NumUsersAdded = 0
 	lt.status.NumUsersStopped = 0
+	lt.status.NumArrivals = 0
 	lt.status.NumErrors = 0
 	lt.status.StartTime = time.Now()
 	lt.statusChan = make(chan control.UserStatus, lt.config.UsersConfiguration.MaxActiveUsers)
@@ -199,6 +267,12 @@ func (lt *LoadTester) Run() error {
 		}
 	}
 	lt.status.State = Running
+
+	if lt.config.UsersConfiguration.ArrivalsPerMinute > 0 && lt.config.UsersConfiguration.MaxArrivals > 0 {
+		lt.arrivalsStopChan = make(chan struct{})
+		go lt.runArrivals(lt.arrivalsStopChan)
+	}
+
 	return nil
 }
 
@@ -213,6 +287,11 @@ func (lt *LoadTester) Stop() error {
 	}
 	lt.status.State = Stopping
 
+	if lt.arrivalsStopChan != nil {
+		close(lt.arrivalsStopChan)
+		lt.arrivalsStopChan = nil
+	}
+
 	if _, err := lt.removeUsers(len(lt.activeControllers)); err != nil {
 		lt.log.Error(err.Error())
 	}
@@ -240,6 +319,7 @@ func (lt *LoadTester) Status() *Status {
 		NumUsersAdded:   lt.status.NumUsersAdded,
 		NumUsersRemoved: lt.status.NumUsersRemoved,
 		NumUsersStopped: numStopped,
+		NumArrivals:     lt.status.NumArrivals,
 		NumErrors:       numErrors,
 		StartTime:       lt.status.StartTime,
 	}
@@ -4,6 +4,8 @@
 package loadtest
 
 import (
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/userentity"
 	"github.com/mattermost/mattermost-load-test-ng/logger"
 
+	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -31,6 +34,7 @@ var ltConfig = Config{
 		MaxActiveUsers:     8,
 		InitialActiveUsers: 0,
 		AvgSessionsPerUser: 1,
+		MaxStoredPosts:     500,
 	},
 	InstanceConfiguration: InstanceConfiguration{
 		NumTeams:                    1,
@@ -227,6 +231,45 @@ func TestStop(t *testing.T) {
 	require.Empty(t, lt.activeControllers)
 }
 
+func TestArrivals(t *testing.T) {
+	log := logger.New(&ltConfig.LogSettings)
+	config := ltConfig
+	config.UsersConfiguration.ArrivalsPerMinute = 600
+	config.UsersConfiguration.MaxArrivals = 2
+
+	var ids []int
+	var mut sync.Mutex
+	nc := func(id int, status chan<- control.UserStatus) (control.UserController, error) {
+		mut.Lock()
+		ids = append(ids, id)
+		mut.Unlock()
+		return newController(id, status)
+	}
+
+	lt, err := New(&config, nc, log)
+	require.NoError(t, err)
+
+	err = lt.Run()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return lt.Status().NumArrivals == 2
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// No more users arrive once the maximum is reached.
+	time.Sleep(300 * time.Millisecond)
+	st := lt.Status()
+	require.Equal(t, int64(2), st.NumArrivals)
+	require.Equal(t, int64(2), st.NumUsersAdded)
+
+	mut.Lock()
+	require.Equal(t, []int{9, 10}, ids)
+	mut.Unlock()
+
+	err = lt.Stop()
+	require.NoError(t, err)
+}
+
 func TestStatus(t *testing.T) {
 	log := logger.New(&ltConfig.LogSettings)
 	lt, err := New(&ltConfig, newController, log)
@@ -276,3 +319,80 @@ func TestStatus(t *testing.T) {
 	assert.True(t, startTime.Before(st.StartTime))
 	assert.Equal(t, Running, st.State)
 }
+
+func TestPickPersona(t *testing.T) {
+	t.Run("empty distribution", func(t *testing.T) {
+		persona, err := PickPersona(UsersConfiguration{})
+		require.NoError(t, err)
+		require.Equal(t, DefaultPersona, persona)
+	})
+
+	t.Run("single persona", func(t *testing.T) {
+		persona, err := PickPersona(UsersConfiguration{
+			PersonasDistribution: []PersonaDistribution{
+				{Persona: "lurker", Percentage: 1.0},
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "lurker", persona)
+	})
+
+	t.Run("multiple personas", func(t *testing.T) {
+		config := UsersConfiguration{
+			PersonasDistribution: []PersonaDistribution{
+				{Persona: "lurker", Percentage: 0.5},
+				{Persona: "poster", Percentage: 0.5},
+			},
+		}
+		for i := 0; i < 10; i++ {
+			persona, err := PickPersona(config)
+			require.NoError(t, err)
+			require.Contains(t, []string{"lurker", "poster"}, persona)
+		}
+	})
+}
+
+func TestPickLocale(t *testing.T) {
+	t.Run("empty distribution", func(t *testing.T) {
+		locale, err := PickLocale(UsersConfiguration{})
+		require.NoError(t, err)
+		require.Empty(t, locale)
+	})
+
+	t.Run("multiple locales", func(t *testing.T) {
+		config := UsersConfiguration{
+			LocalesDistribution: []LocaleDistribution{
+				{Locale: "en", Percentage: 0.5},
+				{Locale: "ja", Percentage: 0.5},
+			},
+		}
+		for i := 0; i < 10; i++ {
+			locale, err := PickLocale(config)
+			require.NoError(t, err)
+			require.Contains(t, []string{"en", "ja"}, locale)
+		}
+	})
+}
+
+func TestPickClockSkew(t *testing.T) {
+	require.Zero(t, PickClockSkew(UsersConfiguration{}))
+
+	config := UsersConfiguration{ClockSkewMaxMs: 500}
+	for i := 0; i < 100; i++ {
+		skew := PickClockSkew(config)
+		require.GreaterOrEqual(t, skew, -500*time.Millisecond)
+		require.LessOrEqual(t, skew, 500*time.Millisecond)
+	}
+}
+
+func TestPickDeviceId(t *testing.T) {
+	require.Empty(t, PickDeviceId(UsersConfiguration{}))
+
+	deviceId := PickDeviceId(UsersConfiguration{PercentMobileSessions: 1})
+	require.True(t, strings.HasPrefix(deviceId, model.PushNotifyAndroidReactNative+":"))
+}
+
+func TestPickReceiver(t *testing.T) {
+	require.False(t, PickReceiver(UsersConfiguration{}))
+	require.True(t, PickReceiver(UsersConfiguration{PercentReceivers: 1}))
+}
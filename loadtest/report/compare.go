@@ -4,9 +4,11 @@
 package report
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"time"
 
 	"github.com/prometheus/common/model"
@@ -27,8 +29,27 @@ type diff struct {
 type avgp99 [2][]diff
 
 type comp struct {
-	store map[model.LabelValue]avgp99
-	api   map[model.LabelValue]avgp99
+	store  map[model.LabelValue]avgp99
+	api    map[model.LabelValue]avgp99
+	client map[model.LabelValue]avgp99
+	config []configDiff
+	server []serverMetricDiff
+}
+
+// serverMetricDiff contains the values of a server metric recorded in the
+// base report and in the others.
+type serverMetricDiff struct {
+	name   string
+	base   float64
+	actual []float64
+}
+
+// configDiff contains the values of a configuration setting which differ
+// between the base report and the others.
+type configDiff struct {
+	key    string
+	base   string
+	actual []string
 }
 
 // labelValues is used to compare a single metric from different load tests.
@@ -56,6 +77,14 @@ func Compare(target io.Writer, opts CompareOpts, reports ...Report) error {
 	// Calculate the deltas.
 	c := calculateDeltas(reports...)
 
+	var err error
+	c.config, err = compareConfigs(reports...)
+	if err != nil {
+		return fmt.Errorf("error while comparing configs: %w", err)
+	}
+
+	c.server = compareServerMetrics(reports...)
+
 	// Now display the comparison in markdown.
 	displayMarkdown(c, target, base, len(reports[1:]))
 
@@ -115,8 +144,9 @@ func getPlots(reports ...Report) []gplot {
 func calculateDeltas(reports ...Report) comp {
 	base := reports[0]
 	c := comp{
-		store: make(map[model.LabelValue]avgp99),
-		api:   make(map[model.LabelValue]avgp99),
+		store:  make(map[model.LabelValue]avgp99),
+		api:    make(map[model.LabelValue]avgp99),
+		client: make(map[model.LabelValue]avgp99),
 	}
 	for _, r := range reports[1:] {
 		// XXX: This can be somewhat refactored but whether absolute metrics
@@ -192,6 +222,136 @@ func calculateDeltas(reports ...Report) comp {
 			})
 			c.api[label] = diffs
 		}
+
+		for label, value := range base.AvgClientTimes {
+			actual := getDuration(float64(r.AvgClientTimes[label]))
+			delta := actual - getDuration(float64(value))
+			deltaP := (delta.Seconds() / float64(value)) * 100
+			if math.IsNaN(deltaP) {
+				deltaP = 0
+			}
+
+			diffs := c.client[label]
+			diffs[0] = append(diffs[0], diff{
+				base:         getDuration(float64(value)),
+				actual:       actual,
+				delta:        delta,
+				deltaPercent: deltaP,
+			})
+			c.client[label] = diffs
+		}
+
+		for label, value := range base.P99ClientTimes {
+			actual := getDuration(float64(r.P99ClientTimes[label]))
+			delta := actual - getDuration(float64(value))
+			deltaP := (delta.Seconds() / float64(value)) * 100
+			if math.IsNaN(deltaP) {
+				deltaP = 0
+			}
+
+			diffs := c.client[label]
+			diffs[1] = append(diffs[1], diff{
+				base:         getDuration(float64(value)),
+				actual:       actual,
+				delta:        delta,
+				deltaPercent: deltaP,
+			})
+			c.client[label] = diffs
+		}
 	}
 	return c
 }
+
+// flattenConfig returns the settings of the given JSON encoded configuration
+// keyed by their full path (e.g. "UsersConfiguration.MaxActiveUsers").
+func flattenConfig(data json.RawMessage) (map[string]string, error) {
+	var cfg interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+	var flatten func(prefix string, value interface{})
+	flatten = func(prefix string, value interface{}) {
+		if obj, ok := value.(map[string]interface{}); ok && len(obj) > 0 {
+			for k, v := range obj {
+				key := k
+				if prefix != "" {
+					key = prefix + "." + k
+				}
+				flatten(key, v)
+			}
+			return
+		}
+		buf, _ := json.Marshal(value)
+		settings[prefix] = string(buf)
+	}
+	flatten("", cfg)
+
+	return settings, nil
+}
+
+// compareConfigs returns the configuration settings which differ between the
+// base report and any of the others. Reports are only compared if all of them
+// carry a configuration.
+func compareConfigs(reports ...Report) ([]configDiff, error) {
+	all := make([]map[string]string, len(reports))
+	keySet := make(map[string]bool)
+	for i, r := range reports {
+		if len(r.Config) == 0 {
+			return nil, nil
+		}
+		settings, err := flattenConfig(r.Config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config for report %q: %w", r.Label, err)
+		}
+		for key := range settings {
+			keySet[key] = true
+		}
+		all[i] = settings
+	}
+
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var diffs []configDiff
+	for _, key := range keys {
+		d := configDiff{key: key, base: all[0][key]}
+		var differs bool
+		for _, settings := range all[1:] {
+			if settings[key] != d.base {
+				differs = true
+			}
+			d.actual = append(d.actual, settings[key])
+		}
+		if differs {
+			diffs = append(diffs, d)
+		}
+	}
+
+	return diffs, nil
+}
+
+// compareServerMetrics returns the values of the server metrics recorded in
+// the base report alongside the ones recorded in the others, sorted by name.
+func compareServerMetrics(reports ...Report) []serverMetricDiff {
+	base := reports[0]
+	names := make([]string, 0, len(base.ServerMetrics))
+	for name := range base.ServerMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	diffs := make([]serverMetricDiff, 0, len(names))
+	for _, name := range names {
+		d := serverMetricDiff{name: name, base: base.ServerMetrics[name]}
+		for _, r := range reports[1:] {
+			d.actual = append(d.actual, r.ServerMetrics[name])
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
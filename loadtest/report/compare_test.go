@@ -125,3 +125,60 @@ func TestGetPlots(t *testing.T) {
 		}
 	}
 }
+
+func TestCompareConfigs(t *testing.T) {
+	newReport := func(t *testing.T, label string, cfg interface{}) Report {
+		t.Helper()
+		r := Report{Label: label}
+		if cfg != nil {
+			require.NoError(t, r.SetConfig(cfg))
+		}
+		return r
+	}
+
+	t.Run("missing config", func(t *testing.T) {
+		diffs, err := compareConfigs(
+			newReport(t, "base", map[string]interface{}{"A": 1}),
+			newReport(t, "new", nil),
+		)
+		require.NoError(t, err)
+		require.Empty(t, diffs)
+	})
+
+	t.Run("same config", func(t *testing.T) {
+		cfg := map[string]interface{}{"A": 1, "B": map[string]interface{}{"C": "value"}}
+		diffs, err := compareConfigs(newReport(t, "base", cfg), newReport(t, "new", cfg))
+		require.NoError(t, err)
+		require.Empty(t, diffs)
+	})
+
+	t.Run("different config", func(t *testing.T) {
+		base := map[string]interface{}{
+			"A": 1,
+			"B": map[string]interface{}{"C": "value", "D": true},
+		}
+		other := map[string]interface{}{
+			"A": 1,
+			"B": map[string]interface{}{"C": "other", "D": true},
+			"E": []int{1, 2},
+		}
+		diffs, err := compareConfigs(newReport(t, "base", base), newReport(t, "new", other))
+		require.NoError(t, err)
+		require.Equal(t, []configDiff{
+			{key: "B.C", base: `"value"`, actual: []string{`"other"`}},
+			{key: "E", base: "", actual: []string{"[1,2]"}},
+		}, diffs)
+	})
+}
+
+func TestCompareServerMetrics(t *testing.T) {
+	base := Report{Label: "base", ServerMetrics: map[string]float64{"b": 2, "a": 1}}
+	other := Report{Label: "new", ServerMetrics: map[string]float64{"a": 3}}
+
+	require.Equal(t, []serverMetricDiff{
+		{name: "a", base: 1, actual: []float64{3}},
+		{name: "b", base: 2, actual: []float64{0}},
+	}, compareServerMetrics(base, other))
+
+	require.Empty(t, compareServerMetrics(Report{}, other))
+}
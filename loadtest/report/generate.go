@@ -45,7 +45,17 @@ type Report struct {
 	P99StoreTimes map[model.LabelValue]model.SampleValue
 	AvgAPITimes   map[model.LabelValue]model.SampleValue
 	P99APITimes   map[model.LabelValue]model.SampleValue
-	Graphs        []graph
+	// Client-side request times as measured by the load-test agents,
+	// grouped by persona.
+	AvgClientTimes map[model.LabelValue]model.SampleValue
+	P99ClientTimes map[model.LabelValue]model.SampleValue
+	Graphs         []graph
+	// The effective configuration of the load-test the report refers to.
+	// It's used to surface configuration differences when comparing reports.
+	Config json.RawMessage `json:",omitempty"`
+	// Metrics recorded from the target instance during the load-test, keyed
+	// by name. They are compared as plain values between reports.
+	ServerMetrics map[string]float64 `json:",omitempty"`
 }
 
 // graph contains data for a single metric.
@@ -77,6 +87,17 @@ func Load(path string) (Report, error) {
 	return r, nil
 }
 
+// SetConfig stores the given configuration in the report so that the load-test
+// it refers to can be reproduced.
+func (r *Report) SetConfig(config interface{}) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	r.Config = data
+	return nil
+}
+
 // Generate returns a report from a given start time to end time.
 func (g *Generator) Generate(startTime, endTime time.Time) (Report, error) {
 	data := Report{
@@ -125,6 +146,22 @@ func (g *Generator) Generate(startTime, endTime time.Time) (Report, error) {
 		return data, fmt.Errorf("error while getting p99 API times: %w", err)
 	}
 
+	// Avg client times by persona.
+	tmpl = `sum(rate(loadtest_http_request_time_sum[%ds])) by (persona) / sum(rate(loadtest_http_request_time_count[%ds])) by (persona)`
+	query = fmt.Sprintf(tmpl, sec, sec)
+	data.AvgClientTimes, err = g.getValue(endTime, query, "persona")
+	if err != nil {
+		return data, fmt.Errorf("error while getting avg client times: %w", err)
+	}
+
+	// P99 client times by persona.
+	tmpl = `histogram_quantile(0.99, sum(rate(loadtest_http_request_time_bucket[%ds])) by (le,persona))`
+	query = fmt.Sprintf(tmpl, sec)
+	data.P99ClientTimes, err = g.getValue(endTime, query, "persona")
+	if err != nil {
+		return data, fmt.Errorf("error while getting p99 client times: %w", err)
+	}
+
 	for _, gq := range g.cfg.GraphQueries {
 		res, err := g.helper.Matrix(gq.Query, startTime, endTime)
 		if err != nil {
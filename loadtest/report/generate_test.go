@@ -48,6 +48,10 @@ func TestGenerate(t *testing.T) {
 		"method1": 0.01,
 		"method2": 0.02,
 	}
+	var clientMap = map[model.LabelValue]model.SampleValue{
+		"lurker": 0.01,
+		"poster": 0.02,
+	}
 
 	var input = map[string]model.Matrix{
 		"sum(rate(mattermost_db_store_time_sum[10s])) by (method) / sum(rate(mattermost_db_store_time_count[10s])) by (method)": {
@@ -146,6 +150,54 @@ func TestGenerate(t *testing.T) {
 				},
 			},
 		},
+		"sum(rate(loadtest_http_request_time_sum[10s])) by (persona) / sum(rate(loadtest_http_request_time_count[10s])) by (persona)": {
+			&model.SampleStream{
+				Metric: model.Metric{
+					"persona": "lurker",
+				},
+				Values: []model.SamplePair{
+					{
+						Timestamp: model.Time(time.Now().Unix()),
+						Value:     0.01,
+					},
+				},
+			},
+			&model.SampleStream{
+				Metric: model.Metric{
+					"persona": "poster",
+				},
+				Values: []model.SamplePair{
+					{
+						Timestamp: model.Time(time.Now().Unix()),
+						Value:     0.02,
+					},
+				},
+			},
+		},
+		"histogram_quantile(0.99, sum(rate(loadtest_http_request_time_bucket[10s])) by (le,persona))": {
+			&model.SampleStream{
+				Metric: model.Metric{
+					"persona": "lurker",
+				},
+				Values: []model.SamplePair{
+					{
+						Timestamp: model.Time(time.Now().Unix()),
+						Value:     0.01,
+					},
+				},
+			},
+			&model.SampleStream{
+				Metric: model.Metric{
+					"persona": "poster",
+				},
+				Values: []model.SamplePair{
+					{
+						Timestamp: model.Time(time.Now().Unix()),
+						Value:     0.02,
+					},
+				},
+			},
+		},
 		`avg(irate(mattermost_process_cpu_seconds_total{instance=~"app.*"}[1m])* 100)`: {
 			&model.SampleStream{
 				Metric: model.Metric{},
@@ -208,13 +260,15 @@ func TestGenerate(t *testing.T) {
 	endTime := time.Now()
 	startTime := endTime.Add(-10 * time.Second)
 	var output = Report{
-		Label:         label,
-		StartTime:     startTime,
-		EndTime:       endTime,
-		AvgStoreTimes: storeMap,
-		P99StoreTimes: storeMap,
-		AvgAPITimes:   apiMap,
-		P99APITimes:   apiMap,
+		Label:          label,
+		StartTime:      startTime,
+		EndTime:        endTime,
+		AvgStoreTimes:  storeMap,
+		P99StoreTimes:  storeMap,
+		AvgAPITimes:    apiMap,
+		P99APITimes:    apiMap,
+		AvgClientTimes: clientMap,
+		P99ClientTimes: clientMap,
 		Graphs: []graph{
 			{
 				Name:   "CPU Utilization",
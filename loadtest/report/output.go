@@ -103,8 +103,58 @@ func printSummary(c comp, target io.Writer, cols int) {
 	printTimes(c.api, "p99", true)
 }
 
+// printConfigDiffs prints the configuration settings that differ between the
+// compared reports.
+func printConfigDiffs(c comp, target io.Writer, cols int) {
+	if len(c.config) == 0 {
+		return
+	}
+
+	fmt.Fprintln(target, "### Configuration differences:")
+	fmt.Fprint(target, "| Setting | Base | ")
+	fmt.Fprintln(target, strings.Repeat("Actual |", cols))
+	fmt.Fprint(target, "| --- | --- | ")
+	fmt.Fprintln(target, strings.Repeat("--- |", cols))
+
+	for _, d := range c.config {
+		fmt.Fprintf(target, "| %s | %s ", d.key, d.base)
+		for _, actual := range d.actual {
+			fmt.Fprintf(target, "| %s ", actual)
+		}
+		fmt.Fprintln(target, "|")
+	}
+}
+
+// printServerMetrics prints the server metrics recorded in the compared
+// reports.
+func printServerMetrics(c comp, target io.Writer, cols int) {
+	if len(c.server) == 0 {
+		return
+	}
+
+	fmt.Fprintln(target, "### Server metrics:")
+	fmt.Fprint(target, "| Metric | Base | ")
+	fmt.Fprintln(target, strings.Repeat("Actual | Delta | Delta % |", cols))
+	fmt.Fprint(target, "| --- | --- | ")
+	fmt.Fprintln(target, strings.Repeat("--- | --- | --- |", cols))
+
+	for _, d := range c.server {
+		fmt.Fprintf(target, "| %s | %.3f ", d.name, d.base)
+		for _, actual := range d.actual {
+			delta := actual - d.base
+			deltaP := (delta / d.base) * 100
+			if math.IsNaN(deltaP) || math.IsInf(deltaP, 0) {
+				deltaP = 0
+			}
+			fmt.Fprintf(target, "| %.3f | %.3f | %.3f ", actual, delta, deltaP)
+		}
+		fmt.Fprintln(target, "|")
+	}
+}
+
 // displayMarkdown prints a given comparison in markdown to the given target.
 func displayMarkdown(c comp, target io.Writer, base Report, cols int) {
+	printConfigDiffs(c, target, cols)
 	printSummary(c, target, cols)
 
 	fmt.Fprintln(target, "### Store times:")
@@ -156,6 +206,33 @@ func displayMarkdown(c comp, target io.Writer, base Report, cols int) {
 		}
 		fmt.Fprintln(target)
 	}
+
+	fmt.Fprintln(target, "### Client times by persona:")
+	printHeader(target, cols)
+
+	keys = sortKeys(c.client, sortByLabel, false)
+	for _, label := range keys {
+		measurement := c.client[label]
+		fmt.Fprint(target, "| ", label)
+		avg := measurement[0]
+		p99 := measurement[1]
+
+		fmt.Fprint(target, " | Avg")
+		fmt.Fprintf(target, "| %s", getDuration(float64(base.AvgClientTimes[label])))
+		for i := 0; i < len(avg); i++ {
+			fmt.Fprintf(target, "| %s | %s | %.3f", avg[i].actual, avg[i].delta, avg[i].deltaPercent)
+		}
+		fmt.Fprintln(target)
+
+		fmt.Fprint(target, "| | P99")
+		fmt.Fprintf(target, "| %s", getDuration(float64(base.P99ClientTimes[label])))
+		for i := 0; i < len(p99); i++ {
+			fmt.Fprintf(target, "| %s | %s | %.3f", p99[i].actual, p99[i].delta, p99[i].deltaPercent)
+		}
+		fmt.Fprintln(target)
+	}
+
+	printServerMetrics(c, target, cols)
 }
 
 // printHeader prints the header row of a markdown table.
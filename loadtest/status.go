@@ -73,6 +73,7 @@ type Status struct {
 	NumUsersAdded   int64     // Number of users added since the start of the test.
 	NumUsersRemoved int64     // Number of users removed since the start of the test.
 	NumUsersStopped int64     // Number of users that stopped running.
+	NumArrivals     int64     // Number of new users that arrived since the start of the test.
 	NumErrors       int64     // Number of errors that have occurred.
 	StartTime       time.Time // Time when the load test was started. This only logs the time when the load test was first started, and does not get reset if it was subsequently restarted.
 }
@@ -0,0 +1,13 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package store
+
+// PostAcknowledgement holds information about a user acknowledging a post.
+// It mirrors the server's data model, which is not available in the version of
+// the model package currently in use.
+type PostAcknowledgement struct {
+	UserId         string `json:"user_id"`
+	PostId         string `json:"post_id"`
+	AcknowledgedAt int64  `json:"acknowledged_at"`
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package store
+
+// ChannelBookmarkType defines the type of a channel bookmark.
+type ChannelBookmarkType string
+
+// Supported channel bookmark types.
+const (
+	ChannelBookmarkLink ChannelBookmarkType = "link"
+	ChannelBookmarkFile ChannelBookmarkType = "file"
+)
+
+// ChannelBookmark holds information about a bookmark added to a channel.
+// It mirrors the server's data model, which is not available in the version of
+// the model package currently in use, and only includes the fields we need.
+type ChannelBookmark struct {
+	Id          string              `json:"id"`
+	CreateAt    int64               `json:"create_at"`
+	UpdateAt    int64               `json:"update_at"`
+	DeleteAt    int64               `json:"delete_at"`
+	ChannelId   string              `json:"channel_id"`
+	OwnerId     string              `json:"owner_id"`
+	FileId      string              `json:"file_id,omitempty"`
+	DisplayName string              `json:"display_name"`
+	SortOrder   int64               `json:"sort_order"`
+	LinkUrl     string              `json:"link_url,omitempty"`
+	Emoji       string              `json:"emoji,omitempty"`
+	Type        ChannelBookmarkType `json:"type"`
+}
@@ -0,0 +1,933 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+// Package faultstore provides a store.MutableUserStore wrapper that injects
+// artificial errors and latency into store operations. It's meant to be used
+// in tests to verify that controllers gracefully handle a misbehaving store.
+package faultstore
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// ErrInjected is the error returned by store methods when a fault is injected.
+var ErrInjected = errors.New("faultstore: injected error")
+
+// Config holds the fault injection settings of a FaultStore.
+type Config struct {
+	// The probability, between 0 and 1, of a call returning ErrInjected.
+	ErrorRate float64
+	// The latency added to each call.
+	Latency time.Duration
+	// An optional list of method names to restrict fault injection to.
+	// If empty, faults are injected into all methods returning an error.
+	Methods []string
+}
+
+// FaultStore wraps a store.MutableUserStore injecting faults, as defined by
+// its Config, into every method returning an error. Methods not returning
+// an error are passed through as they are.
+type FaultStore struct {
+	store.MutableUserStore
+	config  Config
+	methods map[string]bool
+
+	mut       sync.Mutex
+	rnd       *rand.Rand
+	numFaults int
+}
+
+// New returns a new FaultStore wrapping the given store.
+func New(s store.MutableUserStore, config Config) (*FaultStore, error) {
+	if s == nil {
+		return nil, errors.New("faultstore: store should not be nil")
+	}
+	if config.ErrorRate < 0 || config.ErrorRate > 1 {
+		return nil, errors.New("faultstore: ErrorRate should be between 0 and 1")
+	}
+	if config.Latency < 0 {
+		return nil, errors.New("faultstore: Latency should not be negative")
+	}
+
+	methods := make(map[string]bool, len(config.Methods))
+	for _, m := range config.Methods {
+		methods[m] = true
+	}
+
+	return &FaultStore{
+		MutableUserStore: s,
+		config:           config,
+		methods:          methods,
+		rnd:              rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// SetErrorRate updates the probability of a call returning ErrInjected.
+func (s *FaultStore) SetErrorRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return errors.New("faultstore: rate should be between 0 and 1")
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.config.ErrorRate = rate
+
+	return nil
+}
+
+// NumFaults returns the number of errors injected so far.
+func (s *FaultStore) NumFaults() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.numFaults
+}
+
+// inject applies the configured latency and returns ErrInjected with the
+// configured probability for the given method.
+func (s *FaultStore) inject(method string) error {
+	if len(s.methods) > 0 && !s.methods[method] {
+		return nil
+	}
+
+	if s.config.Latency > 0 {
+		time.Sleep(s.config.Latency)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.rnd.Float64() < s.config.ErrorRate {
+		s.numFaults++
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// The following methods wrap the ones of the underlying store, injecting
+// faults before calling them.
+
+func (s *FaultStore) Channel(channelId string) (*model.Channel, error) {
+	if err := s.inject("Channel"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Channel(channelId)
+}
+
+func (s *FaultStore) Channels(teamId string) ([]model.Channel, error) {
+	if err := s.inject("Channels"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Channels(teamId)
+}
+
+func (s *FaultStore) CurrentChannel() (*model.Channel, error) {
+	if err := s.inject("CurrentChannel"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.CurrentChannel()
+}
+
+func (s *FaultStore) ChannelMember(channelId string, userId string) (model.ChannelMember, error) {
+	if err := s.inject("ChannelMember"); err != nil {
+		return model.ChannelMember{}, err
+	}
+	return s.MutableUserStore.ChannelMember(channelId, userId)
+}
+
+func (s *FaultStore) ChannelPosts(channelId string) ([]*model.Post, error) {
+	if err := s.inject("ChannelPosts"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.ChannelPosts(channelId)
+}
+
+func (s *FaultStore) ChannelPostsSorted(channelId string, asc bool) ([]*model.Post, error) {
+	if err := s.inject("ChannelPostsSorted"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.ChannelPostsSorted(channelId, asc)
+}
+
+func (s *FaultStore) ChannelView(channelId string) (int64, error) {
+	if err := s.inject("ChannelView"); err != nil {
+		return 0, err
+	}
+	return s.MutableUserStore.ChannelView(channelId)
+}
+
+func (s *FaultStore) RecentlyViewedChannels(limit int) ([]string, error) {
+	if err := s.inject("RecentlyViewedChannels"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.RecentlyViewedChannels(limit)
+}
+
+func (s *FaultStore) ChannelLastPostAt(channelId string) (int64, error) {
+	if err := s.inject("ChannelLastPostAt"); err != nil {
+		return 0, err
+	}
+	return s.MutableUserStore.ChannelLastPostAt(channelId)
+}
+
+func (s *FaultStore) GetChannelPostRate(channelId string) (float64, error) {
+	if err := s.inject("GetChannelPostRate"); err != nil {
+		return 0, err
+	}
+	return s.MutableUserStore.GetChannelPostRate(channelId)
+}
+
+func (s *FaultStore) ChannelStats(channelId string) (*model.ChannelStats, error) {
+	if err := s.inject("ChannelStats"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.ChannelStats(channelId)
+}
+
+func (s *FaultStore) ChannelBookmarks(channelId string) ([]store.ChannelBookmark, error) {
+	if err := s.inject("ChannelBookmarks"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.ChannelBookmarks(channelId)
+}
+
+func (s *FaultStore) GetUser(userId string) (model.User, error) {
+	if err := s.inject("GetUser"); err != nil {
+		return model.User{}, err
+	}
+	return s.MutableUserStore.GetUser(userId)
+}
+
+func (s *FaultStore) Status(userId string) (model.Status, error) {
+	if err := s.inject("Status"); err != nil {
+		return model.Status{}, err
+	}
+	return s.MutableUserStore.Status(userId)
+}
+
+func (s *FaultStore) OnlineUsers() ([]string, error) {
+	if err := s.inject("OnlineUsers"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.OnlineUsers()
+}
+
+func (s *FaultStore) GetTypingUsers(channelId string) ([]string, error) {
+	if err := s.inject("GetTypingUsers"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.GetTypingUsers(channelId)
+}
+
+func (s *FaultStore) Teams() ([]model.Team, error) {
+	if err := s.inject("Teams"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Teams()
+}
+
+func (s *FaultStore) CurrentTeam() (*model.Team, error) {
+	if err := s.inject("CurrentTeam"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.CurrentTeam()
+}
+
+func (s *FaultStore) TeamMember(teamdId string, userId string) (model.TeamMember, error) {
+	if err := s.inject("TeamMember"); err != nil {
+		return model.TeamMember{}, err
+	}
+	return s.MutableUserStore.TeamMember(teamdId, userId)
+}
+
+func (s *FaultStore) Preferences() (model.Preferences, error) {
+	if err := s.inject("Preferences"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Preferences()
+}
+
+func (s *FaultStore) Roles() ([]model.Role, error) {
+	if err := s.inject("Roles"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Roles()
+}
+
+func (s *FaultStore) PostAcknowledgements(postId string) ([]store.PostAcknowledgement, error) {
+	if err := s.inject("PostAcknowledgements"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.PostAcknowledgements(postId)
+}
+
+func (s *FaultStore) Reactions(postId string) ([]model.Reaction, error) {
+	if err := s.inject("Reactions"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Reactions(postId)
+}
+
+func (s *FaultStore) Emojis() ([]model.Emoji, error) {
+	if err := s.inject("Emojis"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Emojis()
+}
+
+func (s *FaultStore) PluginStatuses() ([]model.PluginStatus, error) {
+	if err := s.inject("PluginStatuses"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.PluginStatuses()
+}
+
+func (s *FaultStore) RandomChannel(teamId string, st store.SelectionType) (model.Channel, error) {
+	if err := s.inject("RandomChannel"); err != nil {
+		return model.Channel{}, err
+	}
+	return s.MutableUserStore.RandomChannel(teamId, st)
+}
+
+func (s *FaultStore) RandomTeam(st store.SelectionType) (model.Team, error) {
+	if err := s.inject("RandomTeam"); err != nil {
+		return model.Team{}, err
+	}
+	return s.MutableUserStore.RandomTeam(st)
+}
+
+func (s *FaultStore) RandomUser() (model.User, error) {
+	if err := s.inject("RandomUser"); err != nil {
+		return model.User{}, err
+	}
+	return s.MutableUserStore.RandomUser()
+}
+
+func (s *FaultStore) RandomUsers(n int) ([]model.User, error) {
+	if err := s.inject("RandomUsers"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.RandomUsers(n)
+}
+
+func (s *FaultStore) RandomPost() (model.Post, error) {
+	if err := s.inject("RandomPost"); err != nil {
+		return model.Post{}, err
+	}
+	return s.MutableUserStore.RandomPost()
+}
+
+func (s *FaultStore) RandomPostForChannel(channelId string) (model.Post, error) {
+	if err := s.inject("RandomPostForChannel"); err != nil {
+		return model.Post{}, err
+	}
+	return s.MutableUserStore.RandomPostForChannel(channelId)
+}
+
+func (s *FaultStore) RandomReplyPostForChannel(channelId string) (model.Post, error) {
+	if err := s.inject("RandomReplyPostForChannel"); err != nil {
+		return model.Post{}, err
+	}
+	return s.MutableUserStore.RandomReplyPostForChannel(channelId)
+}
+
+func (s *FaultStore) RandomPostForChannelByUser(channelId string, userId string) (model.Post, error) {
+	if err := s.inject("RandomPostForChannelByUser"); err != nil {
+		return model.Post{}, err
+	}
+	return s.MutableUserStore.RandomPostForChannelByUser(channelId, userId)
+}
+
+func (s *FaultStore) RandomEmoji() (model.Emoji, error) {
+	if err := s.inject("RandomEmoji"); err != nil {
+		return model.Emoji{}, err
+	}
+	return s.MutableUserStore.RandomEmoji()
+}
+
+func (s *FaultStore) RandomChannelMember(channelId string) (model.ChannelMember, error) {
+	if err := s.inject("RandomChannelMember"); err != nil {
+		return model.ChannelMember{}, err
+	}
+	return s.MutableUserStore.RandomChannelMember(channelId)
+}
+
+func (s *FaultStore) RandomTeamMember(teamId string) (model.TeamMember, error) {
+	if err := s.inject("RandomTeamMember"); err != nil {
+		return model.TeamMember{}, err
+	}
+	return s.MutableUserStore.RandomTeamMember(teamId)
+}
+
+func (s *FaultStore) RandomThread() (model.ThreadResponse, error) {
+	if err := s.inject("RandomThread"); err != nil {
+		return model.ThreadResponse{}, err
+	}
+	return s.MutableUserStore.RandomThread()
+}
+
+func (s *FaultStore) RandomCategory(teamID string) (model.SidebarCategoryWithChannels, error) {
+	if err := s.inject("RandomCategory"); err != nil {
+		return model.SidebarCategoryWithChannels{}, err
+	}
+	return s.MutableUserStore.RandomCategory(teamID)
+}
+
+func (s *FaultStore) ProfileImage(userId string) (bool, error) {
+	if err := s.inject("ProfileImage"); err != nil {
+		return false, err
+	}
+	return s.MutableUserStore.ProfileImage(userId)
+}
+
+func (s *FaultStore) Post(postId string) (*model.Post, error) {
+	if err := s.inject("Post"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Post(postId)
+}
+
+func (s *FaultStore) UserForPost(postId string) (string, error) {
+	if err := s.inject("UserForPost"); err != nil {
+		return "", err
+	}
+	return s.MutableUserStore.UserForPost(postId)
+}
+
+func (s *FaultStore) FileInfoForPost(postId string) ([]*model.FileInfo, error) {
+	if err := s.inject("FileInfoForPost"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.FileInfoForPost(postId)
+}
+
+func (s *FaultStore) GetPostFileIds(postId string) ([]string, error) {
+	if err := s.inject("GetPostFileIds"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.GetPostFileIds(postId)
+}
+
+func (s *FaultStore) PostsIdsSince(ts int64) ([]string, error) {
+	if err := s.inject("PostsIdsSince"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.PostsIdsSince(ts)
+}
+
+func (s *FaultStore) ServerVersion() (string, error) {
+	if err := s.inject("ServerVersion"); err != nil {
+		return "", err
+	}
+	return s.MutableUserStore.ServerVersion()
+}
+
+func (s *FaultStore) Thread(threadId string) (*model.ThreadResponse, error) {
+	if err := s.inject("Thread"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Thread(threadId)
+}
+
+func (s *FaultStore) ThreadsSorted(unreadOnly bool, asc bool) ([]*model.ThreadResponse, error) {
+	if err := s.inject("ThreadsSorted"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.ThreadsSorted(unreadOnly, asc)
+}
+
+func (s *FaultStore) GetThreadState(rootId string) (store.ThreadState, error) {
+	if err := s.inject("GetThreadState"); err != nil {
+		return store.ThreadState{}, err
+	}
+	return s.MutableUserStore.GetThreadState(rootId)
+}
+
+func (s *FaultStore) GetUnreadThreads() ([]string, error) {
+	if err := s.inject("GetUnreadThreads"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.GetUnreadThreads()
+}
+
+func (s *FaultStore) SidebarCategory(teamID, categoryID string) (*model.SidebarCategoryWithChannels, error) {
+	if err := s.inject("SidebarCategory"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.SidebarCategory(teamID, categoryID)
+}
+
+func (s *FaultStore) SidebarCategories(teamID string) (*model.OrderedSidebarCategories, error) {
+	if err := s.inject("SidebarCategories"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.SidebarCategories(teamID)
+}
+
+func (s *FaultStore) SetUser(user *model.User) error {
+	if err := s.inject("SetUser"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetUser(user)
+}
+
+func (s *FaultStore) User() (*model.User, error) {
+	if err := s.inject("User"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.User()
+}
+
+func (s *FaultStore) UpdateUserRoles(userId, roles string) error {
+	if err := s.inject("UpdateUserRoles"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.UpdateUserRoles(userId, roles)
+}
+
+func (s *FaultStore) IsSysAdmin() (bool, error) {
+	if err := s.inject("IsSysAdmin"); err != nil {
+		return false, err
+	}
+	return s.MutableUserStore.IsSysAdmin()
+}
+
+func (s *FaultStore) SetUsers(users []*model.User) error {
+	if err := s.inject("SetUsers"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetUsers(users)
+}
+
+func (s *FaultStore) SetStatus(userId string, status *model.Status) error {
+	if err := s.inject("SetStatus"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetStatus(userId, status)
+}
+
+func (s *FaultStore) SetTyping(channelId, userId string, expiresAt time.Time) error {
+	if err := s.inject("SetTyping"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetTyping(channelId, userId, expiresAt)
+}
+
+func (s *FaultStore) RecordChannelPost(channelId, postId string, at time.Time) (bool, error) {
+	if err := s.inject("RecordChannelPost"); err != nil {
+		return false, err
+	}
+	return s.MutableUserStore.RecordChannelPost(channelId, postId, at)
+}
+
+func (s *FaultStore) SetPost(post *model.Post) error {
+	if err := s.inject("SetPost"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetPost(post)
+}
+
+func (s *FaultStore) SetPostFileIds(postId string, fileIds []string) error {
+	if err := s.inject("SetPostFileIds"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetPostFileIds(postId, fileIds)
+}
+
+func (s *FaultStore) UpdateThreadOnReply(reply *model.Post) error {
+	if err := s.inject("UpdateThreadOnReply"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.UpdateThreadOnReply(reply)
+}
+
+func (s *FaultStore) DeletePost(postId string) error {
+	if err := s.inject("DeletePost"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.DeletePost(postId)
+}
+
+func (s *FaultStore) SetPosts(posts []*model.Post) error {
+	if err := s.inject("SetPosts"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetPosts(posts)
+}
+
+func (s *FaultStore) ApplyPostWrites(writes []store.PostWrite) error {
+	if err := s.inject("ApplyPostWrites"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.ApplyPostWrites(writes)
+}
+
+func (s *FaultStore) SetPostAcknowledgement(ack *store.PostAcknowledgement) error {
+	if err := s.inject("SetPostAcknowledgement"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetPostAcknowledgement(ack)
+}
+
+func (s *FaultStore) DeletePostAcknowledgement(postId, userId string) error {
+	if err := s.inject("DeletePostAcknowledgement"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.DeletePostAcknowledgement(postId, userId)
+}
+
+func (s *FaultStore) SetReactions(postId string, reactions []*model.Reaction) error {
+	if err := s.inject("SetReactions"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetReactions(postId, reactions)
+}
+
+func (s *FaultStore) SetReaction(reaction *model.Reaction) error {
+	if err := s.inject("SetReaction"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetReaction(reaction)
+}
+
+func (s *FaultStore) DeleteReaction(reaction *model.Reaction) (bool, error) {
+	if err := s.inject("DeleteReaction"); err != nil {
+		return false, err
+	}
+	return s.MutableUserStore.DeleteReaction(reaction)
+}
+
+func (s *FaultStore) SetPreferences(preferences model.Preferences) error {
+	if err := s.inject("SetPreferences"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetPreferences(preferences)
+}
+
+func (s *FaultStore) UpsertPreferences(preferences model.Preferences) error {
+	if err := s.inject("UpsertPreferences"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.UpsertPreferences(preferences)
+}
+
+func (s *FaultStore) SetChannel(channel *model.Channel) error {
+	if err := s.inject("SetChannel"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannel(channel)
+}
+
+func (s *FaultStore) SetChannels(channels []*model.Channel) error {
+	if err := s.inject("SetChannels"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannels(channels)
+}
+
+func (s *FaultStore) DeleteChannel(channelId string) error {
+	if err := s.inject("DeleteChannel"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.DeleteChannel(channelId)
+}
+
+func (s *FaultStore) SetChannelIfMissing(channel *model.Channel) (bool, error) {
+	if err := s.inject("SetChannelIfMissing"); err != nil {
+		return false, err
+	}
+	return s.MutableUserStore.SetChannelIfMissing(channel)
+}
+
+func (s *FaultStore) SetCurrentChannel(channel *model.Channel) error {
+	if err := s.inject("SetCurrentChannel"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetCurrentChannel(channel)
+}
+
+func (s *FaultStore) SetChannelView(channelId string) error {
+	if err := s.inject("SetChannelView"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelView(channelId)
+}
+
+func (s *FaultStore) MarkChannelRead(channelId string) error {
+	if err := s.inject("MarkChannelRead"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.MarkChannelRead(channelId)
+}
+
+func (s *FaultStore) SetChannelUnread(channelId string, unread *model.ChannelUnreadAt) error {
+	if err := s.inject("SetChannelUnread"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelUnread(channelId, unread)
+}
+
+func (s *FaultStore) IncrementMentionCount(channelId string, isRoot bool) error {
+	if err := s.inject("IncrementMentionCount"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.IncrementMentionCount(channelId, isRoot)
+}
+
+func (s *FaultStore) SetChannelMembers(channelMembers model.ChannelMembers) error {
+	if err := s.inject("SetChannelMembers"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelMembers(channelMembers)
+}
+
+func (s *FaultStore) ChannelMembers(channelId string) (model.ChannelMembers, error) {
+	if err := s.inject("ChannelMembers"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.ChannelMembers(channelId)
+}
+
+func (s *FaultStore) SetChannelMember(channelId string, channelMember *model.ChannelMember) error {
+	if err := s.inject("SetChannelMember"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelMember(channelId, channelMember)
+}
+
+func (s *FaultStore) RemoveChannelMember(channelId string, userId string) error {
+	if err := s.inject("RemoveChannelMember"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.RemoveChannelMember(channelId, userId)
+}
+
+func (s *FaultStore) GroupChannels(groupId string) ([]string, error) {
+	if err := s.inject("GroupChannels"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.GroupChannels(groupId)
+}
+
+func (s *FaultStore) GroupMembers(groupId string) ([]string, error) {
+	if err := s.inject("GroupMembers"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.GroupMembers(groupId)
+}
+
+func (s *FaultStore) SetGroupChannels(groupId string, channelIds []string) error {
+	if err := s.inject("SetGroupChannels"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetGroupChannels(groupId, channelIds)
+}
+
+func (s *FaultStore) SetGroupMember(groupId, userId string) error {
+	if err := s.inject("SetGroupMember"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetGroupMember(groupId, userId)
+}
+
+func (s *FaultStore) RemoveGroupMember(groupId, userId string) error {
+	if err := s.inject("RemoveGroupMember"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.RemoveGroupMember(groupId, userId)
+}
+
+func (s *FaultStore) SetChannelStats(channelId string, stats *model.ChannelStats) error {
+	if err := s.inject("SetChannelStats"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelStats(channelId, stats)
+}
+
+func (s *FaultStore) SetChannelBookmarks(channelId string, bookmarks []*store.ChannelBookmark) error {
+	if err := s.inject("SetChannelBookmarks"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelBookmarks(channelId, bookmarks)
+}
+
+func (s *FaultStore) SetChannelBookmark(bookmark *store.ChannelBookmark) error {
+	if err := s.inject("SetChannelBookmark"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetChannelBookmark(bookmark)
+}
+
+func (s *FaultStore) DeleteChannelBookmark(channelId, bookmarkId string) error {
+	if err := s.inject("DeleteChannelBookmark"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.DeleteChannelBookmark(channelId, bookmarkId)
+}
+
+func (s *FaultStore) SetTeam(team *model.Team) error {
+	if err := s.inject("SetTeam"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetTeam(team)
+}
+
+func (s *FaultStore) Team(teamId string) (*model.Team, error) {
+	if err := s.inject("Team"); err != nil {
+		return nil, err
+	}
+	return s.MutableUserStore.Team(teamId)
+}
+
+func (s *FaultStore) SetTeams(teams []*model.Team) error {
+	if err := s.inject("SetTeams"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetTeams(teams)
+}
+
+func (s *FaultStore) DeleteTeam(teamId string) error {
+	if err := s.inject("DeleteTeam"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.DeleteTeam(teamId)
+}
+
+func (s *FaultStore) SetCurrentTeam(team *model.Team) error {
+	if err := s.inject("SetCurrentTeam"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetCurrentTeam(team)
+}
+
+func (s *FaultStore) SetTeamMember(teamId string, teamMember *model.TeamMember) error {
+	if err := s.inject("SetTeamMember"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetTeamMember(teamId, teamMember)
+}
+
+func (s *FaultStore) RemoveTeamMember(teamId string, memberId string) error {
+	if err := s.inject("RemoveTeamMember"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.RemoveTeamMember(teamId, memberId)
+}
+
+func (s *FaultStore) SetTeamMembers(teamId string, teamMember []*model.TeamMember) error {
+	if err := s.inject("SetTeamMembers"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetTeamMembers(teamId, teamMember)
+}
+
+func (s *FaultStore) SetRoles(roles []*model.Role) error {
+	if err := s.inject("SetRoles"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetRoles(roles)
+}
+
+func (s *FaultStore) UpdateRole(role *model.Role) error {
+	if err := s.inject("UpdateRole"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.UpdateRole(role)
+}
+
+func (s *FaultStore) SetEmojis(emoji []*model.Emoji) error {
+	if err := s.inject("SetEmojis"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetEmojis(emoji)
+}
+
+func (s *FaultStore) SetEmoji(emoji *model.Emoji) error {
+	if err := s.inject("SetEmoji"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetEmoji(emoji)
+}
+
+func (s *FaultStore) SetPluginStatuses(statuses model.PluginStatuses) error {
+	if err := s.inject("SetPluginStatuses"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetPluginStatuses(statuses)
+}
+
+func (s *FaultStore) SetLicense(license map[string]string) error {
+	if err := s.inject("SetLicense"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetLicense(license)
+}
+
+func (s *FaultStore) SetProfileImage(userId string) error {
+	if err := s.inject("SetProfileImage"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetProfileImage(userId)
+}
+
+func (s *FaultStore) SetServerVersion(version string) error {
+	if err := s.inject("SetServerVersion"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetServerVersion(version)
+}
+
+func (s *FaultStore) SetThreads(threads []*model.ThreadResponse) error {
+	if err := s.inject("SetThreads"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetThreads(threads)
+}
+
+func (s *FaultStore) MarkAllThreadsInTeamAsRead(teamId string) error {
+	if err := s.inject("MarkAllThreadsInTeamAsRead"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.MarkAllThreadsInTeamAsRead(teamId)
+}
+
+func (s *FaultStore) SetThreadState(rootId string, state store.ThreadState) error {
+	if err := s.inject("SetThreadState"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetThreadState(rootId, state)
+}
+
+func (s *FaultStore) SetCategories(teamID string, sidebarCategories *model.OrderedSidebarCategories) error {
+	if err := s.inject("SetCategories"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetCategories(teamID, sidebarCategories)
+}
+
+func (s *FaultStore) SetCategory(teamID string, category *model.SidebarCategoryWithChannels) error {
+	if err := s.inject("SetCategory"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetCategory(teamID, category)
+}
+
+func (s *FaultStore) SetCategoryOrder(teamID string, order []string) error {
+	if err := s.inject("SetCategoryOrder"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.SetCategoryOrder(teamID, order)
+}
+
+func (s *FaultStore) DeleteCategory(teamID, categoryID string) error {
+	if err := s.inject("DeleteCategory"); err != nil {
+		return err
+	}
+	return s.MutableUserStore.DeleteCategory(teamID, categoryID)
+}
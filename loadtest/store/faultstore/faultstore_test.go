@@ -0,0 +1,113 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package faultstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newStore(tb testing.TB, config Config) *FaultStore {
+	tb.Helper()
+	ms, err := memstore.New(nil)
+	require.NoError(tb, err)
+	s, err := New(ms, config)
+	require.NoError(tb, err)
+	require.NotNil(tb, s)
+	return s
+}
+
+func TestNew(t *testing.T) {
+	ms, err := memstore.New(nil)
+	require.NoError(t, err)
+
+	s, err := New(nil, Config{})
+	require.Error(t, err)
+	require.Nil(t, s)
+
+	s, err = New(ms, Config{ErrorRate: 1.5})
+	require.Error(t, err)
+	require.Nil(t, s)
+
+	s, err = New(ms, Config{Latency: -time.Second})
+	require.Error(t, err)
+	require.Nil(t, s)
+
+	s, err = New(ms, Config{ErrorRate: 0.5, Latency: time.Millisecond})
+	require.NoError(t, err)
+	require.NotNil(t, s)
+}
+
+func TestInject(t *testing.T) {
+	channel := &model.Channel{Id: model.NewId()}
+
+	t.Run("no faults", func(t *testing.T) {
+		s := newStore(t, Config{})
+
+		err := s.SetChannel(channel)
+		require.NoError(t, err)
+		c, err := s.Channel(channel.Id)
+		require.NoError(t, err)
+		require.Equal(t, channel, c)
+		require.Zero(t, s.NumFaults())
+	})
+
+	t.Run("all faults", func(t *testing.T) {
+		s := newStore(t, Config{ErrorRate: 1})
+
+		err := s.SetChannel(channel)
+		require.Equal(t, ErrInjected, err)
+		c, err := s.Channel(channel.Id)
+		require.Equal(t, ErrInjected, err)
+		require.Nil(t, c)
+		require.Equal(t, 2, s.NumFaults())
+
+		// Methods not returning an error are not affected.
+		require.Empty(t, s.Id())
+	})
+
+	t.Run("set error rate", func(t *testing.T) {
+		s := newStore(t, Config{})
+
+		err := s.SetErrorRate(-1)
+		require.Error(t, err)
+
+		err = s.SetChannel(channel)
+		require.NoError(t, err)
+
+		err = s.SetErrorRate(1)
+		require.NoError(t, err)
+
+		err = s.SetChannel(channel)
+		require.Equal(t, ErrInjected, err)
+		require.Equal(t, 1, s.NumFaults())
+	})
+
+	t.Run("restricted methods", func(t *testing.T) {
+		s := newStore(t, Config{ErrorRate: 1, Methods: []string{"Channel"}})
+
+		err := s.SetChannel(channel)
+		require.NoError(t, err)
+		c, err := s.Channel(channel.Id)
+		require.Equal(t, ErrInjected, err)
+		require.Nil(t, c)
+		require.Equal(t, 1, s.NumFaults())
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		latency := 10 * time.Millisecond
+		s := newStore(t, Config{Latency: latency})
+
+		start := time.Now()
+		_, err := s.CurrentTeam()
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), latency)
+	})
+}
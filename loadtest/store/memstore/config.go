@@ -5,6 +5,9 @@ package memstore
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"time"
 )
 
 // Config holds information used to create a new MemStore.
@@ -14,6 +17,12 @@ type Config struct {
 	MaxStoredChannelMembers int // The maximum number of channel members to be stored.
 	MaxStoredStatuses       int // The maximum number of statuses to be stored.
 	MaxStoredThreads        int // The maximum number of statuses to be stored.
+	// The directory where posts evicted from memory are spilled to. If empty,
+	// evicted posts are discarded.
+	PostsSpillDir string
+	// The time window over which the post rate of each channel is computed.
+	// Defaults to one minute if zero.
+	PostRateWindow time.Duration
 }
 
 // IsValid checks whether a Config is valid or not.
@@ -39,6 +48,18 @@ func (c *Config) IsValid() error {
 		return errors.New("MaxStoredThreads should be > 0")
 	}
 
+	if c.PostRateWindow < 0 {
+		return errors.New("PostRateWindow should not be negative")
+	}
+
+	if c.PostsSpillDir != "" {
+		if info, err := os.Stat(c.PostsSpillDir); err != nil {
+			return fmt.Errorf("PostsSpillDir is not accessible: %w", err)
+		} else if !info.IsDir() {
+			return errors.New("PostsSpillDir should be a directory")
+		}
+	}
+
 	return nil
 }
 
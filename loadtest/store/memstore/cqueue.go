@@ -51,6 +51,16 @@ func (q *CQueue) Get() interface{} {
 	return el
 }
 
+// Peek returns a pointer to the element the next call to Get will return,
+// without advancing the queue. Nil is returned if data for it is not yet
+// allocated.
+func (q *CQueue) Peek() interface{} {
+	if q.next == len(q.data) {
+		return nil
+	}
+	return q.data[q.next]
+}
+
 // Reset re-initializes the queue to be reused.
 func (q *CQueue) Reset() {
 	q.next = 0
@@ -75,4 +75,20 @@ func TestCQueue(t *testing.T) {
 		require.Equal(t, first, s)
 		require.Equal(t, "test0", *s)
 	})
+
+	t.Run("peek", func(t *testing.T) {
+		q, err := NewCQueue(2, func() interface{} {
+			return new(string)
+		})
+		require.NotNil(t, q)
+		require.NoError(t, err)
+
+		require.Nil(t, q.Peek())
+		first := q.Get().(*string)
+		require.Nil(t, q.Peek())
+		q.Get()
+		require.Equal(t, first, q.Peek())
+		require.Equal(t, first, q.Peek())
+		require.Equal(t, first, q.Get())
+	})
 }
@@ -0,0 +1,73 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package memstore
+
+import (
+	"errors"
+)
+
+// SetPostFileIds stores the ids of the files attached to the specified post,
+// replacing any previous ones. An empty list removes them. The file ids are
+// kept independently of the post, so that they are known for the posts which
+// aren't stored, and only for the most recent posts, up to MaxStoredPosts.
+func (s *MemStore) SetPostFileIds(postId string, fileIds []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if postId == "" {
+		return errors.New("memstore: postId should not be empty")
+	}
+
+	if len(fileIds) == 0 {
+		if _, ok := s.postFileIds[postId]; ok {
+			delete(s.postFileIds, postId)
+			s.removePostFileIdsOrder(postId)
+		}
+		return nil
+	}
+
+	if _, ok := s.postFileIds[postId]; !ok {
+		if len(s.postFileIdsOrder) >= s.maxPostFileIds {
+			delete(s.postFileIds, s.postFileIdsOrder[0])
+			s.postFileIdsOrder = s.postFileIdsOrder[1:]
+		}
+		s.postFileIdsOrder = append(s.postFileIdsOrder, postId)
+	}
+	ids := make([]string, len(fileIds))
+	copy(ids, fileIds)
+	s.postFileIds[postId] = ids
+
+	return nil
+}
+
+// removePostFileIdsOrder removes the given post from the eviction order. The
+// lock is expected to be held for writing.
+func (s *MemStore) removePostFileIdsOrder(postId string) {
+	for i, id := range s.postFileIdsOrder {
+		if id == postId {
+			s.postFileIdsOrder = append(s.postFileIdsOrder[:i], s.postFileIdsOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetPostFileIds returns the ids of the files attached to the specified post,
+// if any. Posts without files, or whose file ids got evicted, return an empty
+// list.
+func (s *MemStore) GetPostFileIds(postId string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if postId == "" {
+		return nil, errors.New("memstore: postId should not be empty")
+	}
+
+	fileIds := s.postFileIds[postId]
+	if len(fileIds) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(fileIds))
+	copy(ids, fileIds)
+	return ids, nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package memstore
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	// postRateBuckets is the number of buckets the post rate window is split
+	// into.
+	postRateBuckets       = 60
+	defaultPostRateWindow = time.Minute
+)
+
+// postRate counts the posts made in a channel over a sliding window. The
+// window is split into buckets so that the memory used for the counts doesn't
+// depend on the rate, at the cost of the window sliding one bucket at a time.
+type postRate struct {
+	counts [postRateBuckets]int
+	// last is the bucket of the latest post, counted from the Unix epoch.
+	last int64
+	// buckets holds the bucket of each post counted within the window, so
+	// that a post is only counted once.
+	buckets map[string]int64
+}
+
+// add counts the post with the given id made in the given bucket, returning
+// whether it did so. Posts older than the window ending with the latest
+// bucket, and posts already counted within it, are ignored.
+func (r *postRate) add(postId string, bucket int64) bool {
+	if bucket <= r.last-postRateBuckets {
+		return false
+	}
+	end := r.last
+	if bucket > end {
+		end = bucket
+	}
+	if b, ok := r.buckets[postId]; ok && b > end-postRateBuckets {
+		return false
+	}
+	if bucket > r.last {
+		if bucket-r.last >= postRateBuckets {
+			r.counts = [postRateBuckets]int{}
+		} else {
+			for b := r.last + 1; b <= bucket; b++ {
+				r.counts[b%postRateBuckets] = 0
+			}
+		}
+		r.last = bucket
+		for id, b := range r.buckets {
+			if b <= r.last-postRateBuckets {
+				delete(r.buckets, id)
+			}
+		}
+	}
+	if r.buckets == nil {
+		r.buckets = map[string]int64{}
+	}
+	r.buckets[postId] = bucket
+	r.counts[bucket%postRateBuckets]++
+	return true
+}
+
+// count returns the number of posts made in the window ending with the given
+// bucket.
+func (r *postRate) count(bucket int64) int {
+	var n int
+	for b := r.last; b > r.last-postRateBuckets && b > bucket-postRateBuckets; b-- {
+		if b <= bucket {
+			n += r.counts[b%postRateBuckets]
+		}
+	}
+	return n
+}
+
+// postRateBucket returns the bucket the given time falls into.
+func (s *MemStore) postRateBucket(t time.Time) int64 {
+	size := s.postRateWindow / postRateBuckets
+	if size <= 0 {
+		size = 1
+	}
+	return t.UnixNano() / int64(size)
+}
+
+// RecordChannelPost counts the post with the given id, made in the given
+// channel at the given time, towards the channel's post rate. It returns
+// whether the post was counted: a post already counted within the window,
+// e.g. when delivered again after reconnecting, is not counted twice.
+func (s *MemStore) RecordChannelPost(channelId, postId string, at time.Time) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if channelId == "" {
+		return false, errors.New("memstore: channelId should not be empty")
+	}
+	if postId == "" {
+		return false, errors.New("memstore: postId should not be empty")
+	}
+
+	bucket := s.postRateBucket(at)
+	s.prunePostRates(bucket)
+
+	r, ok := s.postRates[channelId]
+	if !ok {
+		r = &postRate{}
+		s.postRates[channelId] = r
+	}
+	return r.add(postId, bucket), nil
+}
+
+// GetChannelPostRate returns the number of posts per minute made in the given
+// channel over the post rate window. It returns zero for channels without any
+// recent post.
+func (s *MemStore) GetChannelPostRate(channelId string) (float64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if channelId == "" {
+		return 0, errors.New("memstore: channelId should not be empty")
+	}
+
+	r, ok := s.postRates[channelId]
+	if !ok {
+		return 0, nil
+	}
+	return float64(r.count(s.postRateBucket(time.Now()))) / s.postRateWindow.Minutes(), nil
+}
+
+// KnownChannels returns the sorted ids of the stored channels and of the
+// channels with a recent post, even if not stored.
+func (s *MemStore) KnownChannels() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	bucket := s.postRateBucket(time.Now())
+	ids := make([]string, 0, len(s.channels))
+	for channelId := range s.channels {
+		ids = append(ids, channelId)
+	}
+	for channelId, r := range s.postRates {
+		if _, ok := s.channels[channelId]; !ok && r.count(bucket) > 0 {
+			ids = append(ids, channelId)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// prunePostRates removes the channels without any post within the window
+// ending with the given bucket, so that inactive channels don't use memory.
+// It only goes through the channels once per window. The lock is expected to
+// be held for writing.
+func (s *MemStore) prunePostRates(bucket int64) {
+	if bucket-s.postRatesPruned < postRateBuckets {
+		return
+	}
+	for channelId, r := range s.postRates {
+		if r.last <= bucket-postRateBuckets {
+			delete(s.postRates, channelId)
+		}
+	}
+	s.postRatesPruned = bucket
+}
@@ -24,6 +24,7 @@ var (
 	ErrPostNotFound      = errors.New("memstore: post not found")
 	ErrInvalidData       = errors.New("memstore: invalid data found")
 	ErrThreadNotFound    = errors.New("memstore: thread not found")
+	ErrGroupNotFound     = errors.New("memstore: group not found")
 )
 
 func isSelectionType(st, t store.SelectionType) bool {
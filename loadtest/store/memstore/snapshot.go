@@ -0,0 +1,143 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package memstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// snapshot holds the store contents written by WriteSnapshot. Entries are
+// sorted so that snapshots of a same state are identical, which makes them
+// suitable as golden files.
+type snapshot struct {
+	Channels       []*model.Channel       `json:"channels"`
+	ChannelMembers []*model.ChannelMember `json:"channel_members"`
+	Posts          []*model.Post          `json:"posts"`
+	Reactions      []*model.Reaction      `json:"reactions"`
+}
+
+// WriteSnapshot writes the channels, channel members, posts (including the
+// spilled ones) and reactions currently in the store to w, as JSON. The
+// snapshot is taken while holding the store lock so that it's consistent.
+func (s *MemStore) WriteSnapshot(w io.Writer) error {
+	snap, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return fmt.Errorf("memstore: failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *MemStore) snapshot() (*snapshot, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var snap snapshot
+
+	for _, channel := range s.channels {
+		channelCopy := *channel
+		snap.Channels = append(snap.Channels, &channelCopy)
+	}
+	sort.Slice(snap.Channels, func(i, j int) bool {
+		return snap.Channels[i].Id < snap.Channels[j].Id
+	})
+
+	for _, members := range s.channelMembers {
+		for _, member := range members {
+			memberCopy := *member
+			snap.ChannelMembers = append(snap.ChannelMembers, &memberCopy)
+		}
+	}
+	sort.Slice(snap.ChannelMembers, func(i, j int) bool {
+		a, b := snap.ChannelMembers[i], snap.ChannelMembers[j]
+		if a.ChannelId != b.ChannelId {
+			return a.ChannelId < b.ChannelId
+		}
+		return a.UserId < b.UserId
+	})
+
+	for _, post := range s.posts {
+		snap.Posts = append(snap.Posts, post.Clone())
+	}
+	if s.postSpill != nil {
+		for postId := range s.postSpill.index {
+			if _, ok := s.posts[postId]; ok {
+				continue
+			}
+			post, err := s.postSpill.get(postId)
+			if err != nil {
+				return nil, err
+			}
+			snap.Posts = append(snap.Posts, post)
+		}
+	}
+	sort.Slice(snap.Posts, func(i, j int) bool {
+		return snap.Posts[i].Id < snap.Posts[j].Id
+	})
+
+	for _, reactions := range s.reactions {
+		for _, reaction := range reactions {
+			reactionCopy := *reaction
+			snap.Reactions = append(snap.Reactions, &reactionCopy)
+		}
+	}
+	sort.Slice(snap.Reactions, func(i, j int) bool {
+		a, b := snap.Reactions[i], snap.Reactions[j]
+		if a.PostId != b.PostId {
+			return a.PostId < b.PostId
+		}
+		if a.UserId != b.UserId {
+			return a.UserId < b.UserId
+		}
+		return a.EmojiName < b.EmojiName
+	})
+
+	return &snap, nil
+}
+
+// LoadSnapshot clears the store and fills it with the contents of a snapshot
+// read from r, as written by WriteSnapshot. It's meant to load test fixtures.
+func (s *MemStore) LoadSnapshot(r io.Reader) error {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("memstore: failed to read snapshot: %w", err)
+	}
+
+	s.Clear()
+
+	for _, channel := range snap.Channels {
+		if err := s.SetChannel(channel); err != nil {
+			return err
+		}
+	}
+	members := make(model.ChannelMembers, 0, len(snap.ChannelMembers))
+	for _, member := range snap.ChannelMembers {
+		members = append(members, *member)
+	}
+	if err := s.SetChannelMembers(members); err != nil {
+		return err
+	}
+	for _, post := range snap.Posts {
+		if err := s.SetPost(post); err != nil {
+			return err
+		}
+	}
+	for _, reaction := range snap.Reactions {
+		if err := s.SetReaction(reaction); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
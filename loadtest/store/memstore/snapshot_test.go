@@ -0,0 +1,97 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package memstore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := newStore(t)
+
+	channel := &model.Channel{Id: model.NewId(), Name: "channel"}
+	require.NoError(t, s.SetChannel(channel))
+	userIds := []string{model.NewId(), model.NewId()}
+	for _, userId := range userIds {
+		require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{ChannelId: channel.Id, UserId: userId}))
+	}
+	var posts []*model.Post
+	for i := 0; i < 3; i++ {
+		post := &model.Post{Id: model.NewId(), ChannelId: channel.Id, UserId: userIds[i%2], Message: "message"}
+		require.NoError(t, s.SetPost(post))
+		posts = append(posts, post)
+	}
+	for _, userId := range userIds {
+		require.NoError(t, s.SetReaction(&model.Reaction{PostId: posts[0].Id, UserId: userId, EmojiName: "smile"}))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, s.WriteSnapshot(&buf))
+
+	t.Run("deterministic", func(t *testing.T) {
+		var other bytes.Buffer
+		require.NoError(t, s.WriteSnapshot(&other))
+		require.Equal(t, buf.String(), other.String())
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		loaded := newStore(t)
+		require.NoError(t, loaded.SetPost(&model.Post{Id: model.NewId()}))
+		require.NoError(t, loaded.LoadSnapshot(bytes.NewReader(buf.Bytes())))
+
+		// The previous contents are cleared.
+		require.Len(t, loaded.posts, len(posts))
+
+		c, err := loaded.Channel(channel.Id)
+		require.NoError(t, err)
+		require.Equal(t, channel, c)
+		members, err := loaded.ChannelMembers(channel.Id)
+		require.NoError(t, err)
+		require.Len(t, members, len(userIds))
+		for _, post := range posts {
+			p, err := loaded.Post(post.Id)
+			require.NoError(t, err)
+			require.Equal(t, post, p)
+		}
+		reactions, err := loaded.Reactions(posts[0].Id)
+		require.NoError(t, err)
+		require.Len(t, reactions, len(userIds))
+
+		var other bytes.Buffer
+		require.NoError(t, loaded.WriteSnapshot(&other))
+		require.Equal(t, buf.String(), other.String())
+	})
+
+	t.Run("spilled posts", func(t *testing.T) {
+		spilling, err := New(&Config{
+			MaxStoredPosts:          1,
+			MaxStoredUsers:          1,
+			MaxStoredChannelMembers: 1,
+			MaxStoredStatuses:       1,
+			MaxStoredThreads:        1,
+			PostsSpillDir:           t.TempDir(),
+		})
+		require.NoError(t, err)
+		for _, post := range posts {
+			require.NoError(t, spilling.SetPost(post))
+		}
+		require.Len(t, spilling.posts, 1)
+
+		var other bytes.Buffer
+		require.NoError(t, spilling.WriteSnapshot(&other))
+		for _, post := range posts {
+			require.Contains(t, other.String(), post.Id)
+		}
+	})
+
+	t.Run("invalid snapshot", func(t *testing.T) {
+		require.Error(t, s.LoadSnapshot(strings.NewReader("invalid")))
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package memstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+type spillEntry struct {
+	offset    int64
+	size      int
+	channelId string
+}
+
+// spillCompactMinSize is the minimum amount of space, in bytes, taken by
+// overwritten or deleted entries before the spill file gets compacted.
+const spillCompactMinSize = 4 << 20
+
+// postSpill is an append-only on-disk store holding the posts evicted from
+// memory. Only a small index of offsets is kept in memory. Entries that get
+// overwritten or deleted are left in the file until they take more space
+// than the live ones, at which point the file is compacted.
+//
+// postSpill is not safe for concurrent writes. The MemStore lock is
+// expected to be held for writing when calling put, remove, reset and close.
+type postSpill struct {
+	dir   string
+	file  *os.File
+	index map[string]spillEntry
+	// The size of the file, in bytes.
+	size int64
+	// The size of the entries in the index, in bytes.
+	live           int64
+	compactMinSize int64
+}
+
+func newPostSpill(dir string) (*postSpill, error) {
+	file, err := createSpillFile(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &postSpill{
+		dir:            dir,
+		file:           file,
+		index:          map[string]spillEntry{},
+		compactMinSize: spillCompactMinSize,
+	}, nil
+}
+
+func createSpillFile(dir string) (*os.File, error) {
+	file, err := os.CreateTemp(dir, "memstore-posts-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("memstore: failed to create spill file: %w", err)
+	}
+
+	// The file is unlinked right away so that it gets removed once closed,
+	// including when the process exits abruptly.
+	if err := os.Remove(file.Name()); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("memstore: failed to unlink spill file: %w", err)
+	}
+
+	return file, nil
+}
+
+// put writes the given post to the spill. On failure, the spill is left
+// unchanged.
+func (ps *postSpill) put(post *model.Post) error {
+	if garbage := ps.size - ps.live; garbage >= ps.compactMinSize && garbage > ps.live {
+		if err := ps.compact(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		return fmt.Errorf("memstore: failed to marshal post: %w", err)
+	}
+	if _, err := ps.file.WriteAt(data, ps.size); err != nil {
+		return fmt.Errorf("memstore: failed to write post to spill: %w", err)
+	}
+	ps.remove(post.Id)
+	ps.index[post.Id] = spillEntry{offset: ps.size, size: len(data), channelId: post.ChannelId}
+	ps.size += int64(len(data))
+	ps.live += int64(len(data))
+	return nil
+}
+
+// compact rewrites the live entries to a new file, dropping the space taken
+// by the overwritten and deleted ones. On failure, the spill is left
+// unchanged.
+func (ps *postSpill) compact() error {
+	file, err := createSpillFile(ps.dir)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]spillEntry, len(ps.index))
+	var size int64
+	var data []byte
+	for postId, entry := range ps.index {
+		if cap(data) < entry.size {
+			data = make([]byte, entry.size)
+		}
+		data = data[:entry.size]
+		if _, err := ps.file.ReadAt(data, entry.offset); err != nil {
+			file.Close()
+			return fmt.Errorf("memstore: failed to read post from spill: %w", err)
+		}
+		if _, err := file.WriteAt(data, size); err != nil {
+			file.Close()
+			return fmt.Errorf("memstore: failed to write post to spill: %w", err)
+		}
+		entry.offset = size
+		index[postId] = entry
+		size += int64(entry.size)
+	}
+
+	// The old file is unlinked already so closing it is enough to release
+	// it. Failing here is harmless since we are done with it.
+	_ = ps.file.Close()
+	ps.file = file
+	ps.index = index
+	ps.size = size
+	return nil
+}
+
+func (ps *postSpill) get(postId string) (*model.Post, error) {
+	entry, ok := ps.index[postId]
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+	data := make([]byte, entry.size)
+	if _, err := ps.file.ReadAt(data, entry.offset); err != nil {
+		return nil, fmt.Errorf("memstore: failed to read post from spill: %w", err)
+	}
+	var post model.Post
+	if err := json.Unmarshal(data, &post); err != nil {
+		return nil, fmt.Errorf("memstore: failed to unmarshal post: %w", err)
+	}
+	return &post, nil
+}
+
+func (ps *postSpill) remove(postId string) {
+	if entry, ok := ps.index[postId]; ok {
+		ps.live -= int64(entry.size)
+		delete(ps.index, postId)
+	}
+}
+
+func (ps *postSpill) removeChannel(channelId string) {
+	for postId, entry := range ps.index {
+		if entry.channelId == channelId {
+			ps.remove(postId)
+		}
+	}
+}
+
+func (ps *postSpill) reset() {
+	ps.index = map[string]spillEntry{}
+	ps.size = 0
+	ps.live = 0
+	// Truncating is only done to reclaim disk space. New writes start from
+	// the beginning of the file regardless so failing here is harmless.
+	_ = ps.file.Truncate(0)
+}
+
+// close releases the spill file. The spill must not be used afterwards.
+func (ps *postSpill) close() error {
+	if err := ps.file.Close(); err != nil {
+		return fmt.Errorf("memstore: failed to close spill file: %w", err)
+	}
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+
 	"github.com/mattermost/mattermost-server/v6/model"
 )
 
@@ -22,11 +24,15 @@ type MemStore struct {
 	config              *model.Config
 	clientConfig        map[string]string
 	emojis              []*model.Emoji
+	pluginStatuses      []model.PluginStatus
 	posts               map[string]*model.Post
 	postsQueue          *CQueue
+	postSpill           *postSpill
+	postsSpillDir       string
 	teams               map[string]*model.Team
 	channels            map[string]*model.Channel
 	channelStats        map[string]*model.ChannelStats
+	channelBookmarks    map[string]map[string]*store.ChannelBookmark
 	channelMembers      map[string]map[string]*model.ChannelMember
 	channelMembersQueue *CQueue
 	teamMembers         map[string]map[string]*model.TeamMember
@@ -35,6 +41,8 @@ type MemStore struct {
 	statuses            map[string]*model.Status
 	statusesQueue       *CQueue
 	reactions           map[string][]*model.Reaction
+	acks                map[string]map[string]*store.PostAcknowledgement
+	threadStates        map[string]*store.ThreadState
 	roles               map[string]*model.Role
 	license             map[string]string
 	currentChannel      *model.Channel
@@ -45,6 +53,21 @@ type MemStore struct {
 	threads             map[string]*model.ThreadResponse
 	threadsQueue        *CQueue
 	sidebarCategories   map[string]map[string]*model.SidebarCategoryWithChannels
+	categoryOrders      map[string][]string
+	typing              map[string]map[string]time.Time
+	groups              map[string]*group
+	postRates           map[string]*postRate
+	postRateWindow      time.Duration
+	postRatesPruned     int64
+	postFileIds         map[string][]string
+	postFileIdsOrder    []string
+	maxPostFileIds      int
+}
+
+// group holds the channels synced with a group and its members.
+type group struct {
+	channelIds []string
+	members    map[string]bool
 }
 
 // New returns a new instance of MemStore with the given config.
@@ -58,18 +81,47 @@ func New(config *Config) (*MemStore, error) {
 		return nil, fmt.Errorf("memstore: config validation failed %w", err)
 	}
 
-	s := &MemStore{}
+	s := &MemStore{
+		postRateWindow: config.PostRateWindow,
+		maxPostFileIds: config.MaxStoredPosts,
+	}
+	if s.postRateWindow == 0 {
+		s.postRateWindow = defaultPostRateWindow
+	}
 
 	if err := s.setupQueues(config); err != nil {
 		return nil, err
 	}
 
+	if config.PostsSpillDir != "" {
+		s.postsSpillDir = config.PostsSpillDir
+		spill, err := newPostSpill(config.PostsSpillDir)
+		if err != nil {
+			return nil, err
+		}
+		s.postSpill = spill
+	}
+
 	s.Clear()
 	s.profileImages = map[string]bool{}
 
 	return s, nil
 }
 
+// Close releases the file the evicted posts are spilled to, dropping them.
+// The store can still be used afterwards, in which case a new file is
+// created as needed.
+func (s *MemStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.postSpill == nil {
+		return nil
+	}
+	err := s.postSpill.close()
+	s.postSpill = nil
+	return err
+}
+
 // Clear resets the store and removes all entries with the exception of the
 // user object and state information (current team/channel) which are preserved.
 func (s *MemStore) Clear() {
@@ -78,9 +130,13 @@ func (s *MemStore) Clear() {
 	s.preferences = nil
 	s.config = nil
 	s.emojis = []*model.Emoji{}
+	s.pluginStatuses = nil
 	s.posts = map[string]*model.Post{}
 	s.clientConfig = map[string]string{}
 	s.postsQueue.Reset()
+	if s.postSpill != nil {
+		s.postSpill.reset()
+	}
 	s.teams = map[string]*model.Team{}
 	s.channels = map[string]*model.Channel{}
 	channelStats := map[string]*model.ChannelStats{}
@@ -88,6 +144,7 @@ func (s *MemStore) Clear() {
 		channelStats[s.currentChannel.Id] = s.channelStats[s.currentChannel.Id]
 	}
 	s.channelStats = channelStats
+	s.channelBookmarks = map[string]map[string]*store.ChannelBookmark{}
 	s.channelMembers = map[string]map[string]*model.ChannelMember{}
 	s.channelMembersQueue.Reset()
 	s.teamMembers = map[string]map[string]*model.TeamMember{}
@@ -96,12 +153,20 @@ func (s *MemStore) Clear() {
 	s.statuses = map[string]*model.Status{}
 	s.statusesQueue.Reset()
 	s.reactions = map[string][]*model.Reaction{}
+	s.acks = map[string]map[string]*store.PostAcknowledgement{}
 	s.roles = map[string]*model.Role{}
 	s.license = map[string]string{}
 	s.channelViews = map[string]int64{}
 	s.threads = map[string]*model.ThreadResponse{}
 	s.threadsQueue.Reset()
+	s.threadStates = map[string]*store.ThreadState{}
 	s.sidebarCategories = map[string]map[string]*model.SidebarCategoryWithChannels{}
+	s.categoryOrders = map[string][]string{}
+	s.typing = map[string]map[string]time.Time{}
+	s.groups = map[string]*group{}
+	s.postRates = map[string]*postRate{}
+	s.postFileIds = map[string][]string{}
+	s.postFileIdsOrder = nil
 }
 
 func (s *MemStore) setupQueues(config *Config) error {
@@ -205,6 +270,21 @@ func (s *MemStore) ClientConfig() map[string]string {
 	return s.clientConfig
 }
 
+// ClientLicense returns the client license of the server.
+func (s *MemStore) ClientLicense() map[string]string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.license
+}
+
+// IsFeatureLicensed returns whether the stored client license enables the
+// given feature.
+func (s *MemStore) IsFeatureLicensed(feature string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.license["IsLicensed"] == "true" && s.license[feature] == "true"
+}
+
 // Config returns the server configuration settings.
 func (s *MemStore) Config() model.Config {
 	s.lock.RLock()
@@ -246,6 +326,38 @@ func (s *MemStore) SetUser(user *model.User) error {
 	return nil
 }
 
+// UpdateUserRoles sets the roles of the user with the given id, be it the
+// stored user or one of the other stored users. It's a no-op if the user is
+// missing from the store.
+func (s *MemStore) UpdateUserRoles(userId, roles string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The stored user is replaced rather than updated in place since User
+	// hands it out to callers.
+	if s.user != nil && s.user.Id == userId {
+		user := *s.user
+		user.Roles = roles
+		s.user = &user
+	}
+	if u, ok := s.users[userId]; ok {
+		u.Roles = roles
+	}
+
+	return nil
+}
+
+// IsSysAdmin returns whether the stored user is a system admin.
+func (s *MemStore) IsSysAdmin() (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.user == nil {
+		return false, ErrUserNotSet
+	}
+	return s.user.IsInRole(model.SystemAdminRoleId), nil
+}
+
 // Preferences returns the preferences for the stored user.
 func (s *MemStore) Preferences() (model.Preferences, error) {
 	s.lock.RLock()
@@ -266,6 +378,34 @@ func (s *MemStore) SetPreferences(preferences model.Preferences) error {
 	return nil
 }
 
+// UpsertPreferences stores the given preferences for the stored user,
+// replacing the ones with a matching category and name.
+func (s *MemStore) UpsertPreferences(preferences model.Preferences) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// A new slice is built as the stored one may be shared with the caller
+	// of SetPreferences.
+	newPrefs := make(model.Preferences, len(s.preferences), len(s.preferences)+len(preferences))
+	copy(newPrefs, s.preferences)
+	for _, p := range preferences {
+		var found bool
+		for i := range newPrefs {
+			if newPrefs[i].Category == p.Category && newPrefs[i].Name == p.Name {
+				newPrefs[i] = p
+				found = true
+				break
+			}
+		}
+		if !found {
+			newPrefs = append(newPrefs, p)
+		}
+	}
+	s.preferences = newPrefs
+
+	return nil
+}
+
 // Post returns the post for the given postId.
 func (s *MemStore) Post(postId string) (*model.Post, error) {
 	s.lock.RLock()
@@ -274,6 +414,9 @@ func (s *MemStore) Post(postId string) (*model.Post, error) {
 		p := post.Clone()
 		return p, nil
 	}
+	if s.postSpill != nil {
+		return s.postSpill.get(postId)
+	}
 	return nil, ErrPostNotFound
 }
 
@@ -287,6 +430,13 @@ func (s *MemStore) UserForPost(postId string) (string, error) {
 	if post, ok := s.posts[postId]; ok {
 		return post.UserId, nil
 	}
+	if s.postSpill != nil {
+		post, err := s.postSpill.get(postId)
+		if err != nil {
+			return "", err
+		}
+		return post.UserId, nil
+	}
 	return "", ErrPostNotFound
 }
 
@@ -377,6 +527,12 @@ func (s *MemStore) UsersIdsForPostsIds(postIds []string) ([]string, error) {
 func (s *MemStore) SetPost(post *model.Post) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	return s.setPost(post)
+}
+
+// setPost stores the given post. The lock is expected to be held for
+// writing.
+func (s *MemStore) setPost(post *model.Post) error {
 	if post == nil {
 		return errors.New("memstore: post should not be nil")
 	}
@@ -390,6 +546,17 @@ func (s *MemStore) SetPost(post *model.Post) error {
 		return nil
 	}
 
+	// The post about to be evicted is spilled to disk first so that nothing
+	// changes in memory if that fails. It isn't spilled if it's the one being
+	// updated, in which case the fresh copy stays in memory.
+	if p, ok := s.postsQueue.Peek().(*model.Post); ok && s.postsSpillDir != "" && p.Id != post.Id {
+		if pp, ok := s.posts[p.Id]; ok && pp == p {
+			if err := s.spillPost(p); err != nil {
+				return err
+			}
+		}
+	}
+
 	// We get an element from the queue and check if we have it in the map and
 	// if it points to the same memory location. If so, we delete it since it means the queue is full.
 	// This is done to keep the data pointed by the map consistent with the data stored in the queue.
@@ -397,17 +564,139 @@ func (s *MemStore) SetPost(post *model.Post) error {
 	if pp, ok := s.posts[p.Id]; ok && pp == p {
 		delete(s.posts, p.Id)
 	}
+	if s.postSpill != nil {
+		s.postSpill.remove(post.Id)
+	}
 	post.ShallowCopy(p)
 	s.posts[post.Id] = p
 
 	return nil
 }
 
+// spillPost writes the given post to the spill, creating it if it was
+// closed. The lock is expected to be held for writing.
+func (s *MemStore) spillPost(post *model.Post) error {
+	if s.postSpill == nil {
+		spill, err := newPostSpill(s.postsSpillDir)
+		if err != nil {
+			return err
+		}
+		s.postSpill = spill
+	}
+	return s.postSpill.put(post)
+}
+
+// UpdateThreadOnReply updates the reply count, last reply time and
+// participants of the root post, and of the related thread if any, of the
+// given reply. It's a no-op if the root post is missing from the store or if
+// the reply was already stored, so that replies delivered more than once
+// aren't counted twice.
+func (s *MemStore) UpdateThreadOnReply(reply *model.Post) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if reply == nil {
+		return errors.New("memstore: reply should not be nil")
+	}
+	if reply.RootId == "" {
+		return errors.New("memstore: reply should have a root id")
+	}
+
+	if _, ok := s.posts[reply.Id]; ok {
+		return nil
+	}
+	if s.postSpill != nil {
+		if _, ok := s.postSpill.index[reply.Id]; ok {
+			return nil
+		}
+	}
+
+	root, ok := s.posts[reply.RootId]
+	spilled := false
+	if !ok && s.postSpill != nil {
+		var err error
+		if root, err = s.postSpill.get(reply.RootId); err == nil {
+			spilled = true
+		} else if !errors.Is(err, ErrPostNotFound) {
+			return err
+		}
+	}
+	if root == nil {
+		return nil
+	}
+
+	root.ReplyCount++
+	if reply.CreateAt > root.LastReplyAt {
+		root.LastReplyAt = reply.CreateAt
+	}
+	root.Participants = addParticipant(root.Participants, reply.UserId)
+	if spilled {
+		if err := s.postSpill.put(root); err != nil {
+			return err
+		}
+	}
+
+	// With collapsed reply threads the thread tracks the same information.
+	if thread, ok := s.threads[reply.RootId]; ok {
+		thread.ReplyCount++
+		if reply.CreateAt > thread.LastReplyAt {
+			thread.LastReplyAt = reply.CreateAt
+		}
+		thread.Participants = addParticipant(thread.Participants, reply.UserId)
+	}
+
+	return nil
+}
+
+// addParticipant returns the given participants with the specified user
+// added if not already present. A new slice is always returned since the
+// given one may be shared with other copies.
+func addParticipant(participants []*model.User, userId string) []*model.User {
+	updated := make([]*model.User, 0, len(participants)+1)
+	for _, p := range participants {
+		if p != nil && p.Id == userId {
+			return participants
+		}
+		updated = append(updated, p)
+	}
+	return append(updated, &model.User{Id: userId})
+}
+
 // DeletePost deletes the specified post.
 func (s *MemStore) DeletePost(postId string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.deletePost(postId)
+	return nil
+}
+
+// deletePost deletes the specified post. The lock is expected to be held for
+// writing.
+func (s *MemStore) deletePost(postId string) {
 	delete(s.posts, postId)
+	delete(s.acks, postId)
+	delete(s.threadStates, postId)
+	if s.postSpill != nil {
+		s.postSpill.remove(postId)
+	}
+}
+
+// ApplyPostWrites stores or deletes the given posts, in order, while holding
+// the lock only once. It stops at the first write failing.
+func (s *MemStore) ApplyPostWrites(writes []store.PostWrite) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, w := range writes {
+		if w.Post == nil {
+			return errors.New("memstore: post should not be nil")
+		}
+		if w.Delete {
+			s.deletePost(w.Post.Id)
+			continue
+		}
+		if err := s.setPost(w.Post); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -495,6 +784,63 @@ func (s *MemStore) SetChannels(channels []*model.Channel) error {
 	return nil
 }
 
+// DeleteChannel removes the given channel and any related data from the
+// store.
+func (s *MemStore) DeleteChannel(channelId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(channelId) == 0 {
+		return errors.New("memstore: channelId should not be empty")
+	}
+
+	return s.deleteChannel(channelId)
+}
+
+// deleteChannel removes the given channel and any related data. The lock is
+// expected to be held for writing.
+func (s *MemStore) deleteChannel(channelId string) error {
+	delete(s.channels, channelId)
+	delete(s.channelStats, channelId)
+	delete(s.channelBookmarks, channelId)
+	delete(s.channelMembers, channelId)
+	delete(s.channelViews, channelId)
+	delete(s.postRates, channelId)
+	if s.currentChannel != nil && s.currentChannel.Id == channelId {
+		s.currentChannel = nil
+	}
+
+	// Posts are purged as well so that nothing refers to the deleted channel.
+	for postId, post := range s.posts {
+		if post.ChannelId == channelId {
+			delete(s.posts, postId)
+			delete(s.reactions, postId)
+			delete(s.acks, postId)
+			delete(s.threadStates, postId)
+		}
+	}
+	if s.postSpill != nil {
+		s.postSpill.removeChannel(channelId)
+	}
+
+	return nil
+}
+
+// SetChannelIfMissing stores the given channel unless a channel with the same
+// id is already stored. It returns whether the channel was stored.
+func (s *MemStore) SetChannelIfMissing(channel *model.Channel) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if channel == nil {
+		return false, errors.New("memstore: channel should not be nil")
+	}
+	if _, ok := s.channels[channel.Id]; ok {
+		return false, nil
+	}
+	s.channels[channel.Id] = channel
+	return true, nil
+}
+
 // SetChannelView marks the given channel as viewed and updates the store with the
 // current timestamp.
 func (s *MemStore) SetChannelView(channelId string) error {
@@ -510,6 +856,96 @@ func (s *MemStore) SetChannelView(channelId string) error {
 	return nil
 }
 
+// MarkChannelRead resets the unread message and mention counts of the user's
+// membership for the given channel. It's a no-op if either the channel or the
+// membership are missing from the store.
+func (s *MemStore) MarkChannelRead(channelId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(channelId) == 0 {
+		return errors.New("memstore: channelId should not be empty")
+	}
+
+	channel, ok := s.channels[channelId]
+	if !ok || s.user == nil {
+		return nil
+	}
+	cm := s.channelMembers[channelId][s.user.Id]
+	if cm == nil {
+		return nil
+	}
+
+	cm.MsgCount = channel.TotalMsgCount
+	cm.MsgCountRoot = channel.TotalMsgCountRoot
+	cm.MentionCount = 0
+	cm.MentionCountRoot = 0
+	cm.LastViewedAt = model.GetMillis()
+
+	return nil
+}
+
+// SetChannelUnread updates the unread message and mention counts of the
+// user's membership for the given channel. It's a no-op if either the
+// channel or the membership are missing from the store.
+func (s *MemStore) SetChannelUnread(channelId string, unread *model.ChannelUnreadAt) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(channelId) == 0 {
+		return errors.New("memstore: channelId should not be empty")
+	}
+	if unread == nil {
+		return errors.New("memstore: unread should not be nil")
+	}
+
+	if _, ok := s.channels[channelId]; !ok || s.user == nil {
+		return nil
+	}
+	cm := s.channelMembers[channelId][s.user.Id]
+	if cm == nil {
+		return nil
+	}
+
+	cm.MsgCount = unread.MsgCount
+	cm.MsgCountRoot = unread.MsgCountRoot
+	cm.MentionCount = unread.MentionCount
+	cm.MentionCountRoot = unread.MentionCountRoot
+	if unread.LastViewedAt != 0 {
+		cm.LastViewedAt = unread.LastViewedAt
+	}
+
+	return nil
+}
+
+// IncrementMentionCount increments the mention count of the user's
+// membership for the given channel, and the root one as well if isRoot is
+// true. It's a no-op if either the channel or the membership are missing from
+// the store.
+func (s *MemStore) IncrementMentionCount(channelId string, isRoot bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(channelId) == 0 {
+		return errors.New("memstore: channelId should not be empty")
+	}
+
+	if _, ok := s.channels[channelId]; !ok || s.user == nil {
+		return nil
+	}
+	cm := s.channelMembers[channelId][s.user.Id]
+	if cm == nil {
+		return nil
+	}
+
+	cm.MentionCount++
+	if isRoot {
+		cm.MentionCountRoot++
+	}
+
+	return nil
+}
+
 // ChannelView returns the timestamp of the last view for the given channelId.
 func (s *MemStore) ChannelView(channelId string) (int64, error) {
 	s.lock.RLock()
@@ -522,6 +958,57 @@ func (s *MemStore) ChannelView(channelId string) (int64, error) {
 	return s.channelViews[channelId], nil
 }
 
+// RecentlyViewedChannels returns the ids of up to limit channels, sorted
+// from the most to the least recently viewed.
+func (s *MemStore) RecentlyViewedChannels(limit int) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if limit <= 0 {
+		return nil, errors.New("memstore: limit should be greater than zero")
+	}
+
+	channelIds := make([]string, 0, len(s.channelViews))
+	for channelId := range s.channelViews {
+		channelIds = append(channelIds, channelId)
+	}
+	sort.Slice(channelIds, func(i, j int) bool {
+		return s.channelViews[channelIds[i]] > s.channelViews[channelIds[j]]
+	})
+
+	if len(channelIds) > limit {
+		channelIds = channelIds[:limit]
+	}
+
+	return channelIds, nil
+}
+
+// ChannelLastPostAt returns the timestamp (in milliseconds) of the most
+// recently created or updated post stored for the given channelId.
+func (s *MemStore) ChannelLastPostAt(channelId string) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(channelId) == 0 {
+		return 0, errors.New("memstore: channelId should not be empty")
+	}
+
+	var lastPostAt int64
+	for _, post := range s.posts {
+		if post.ChannelId != channelId {
+			continue
+		}
+		if post.CreateAt > lastPostAt {
+			lastPostAt = post.CreateAt
+		}
+		if post.UpdateAt > lastPostAt {
+			lastPostAt = post.UpdateAt
+		}
+	}
+
+	return lastPostAt, nil
+}
+
 // ChannelStats returns statistics for the given channelId.
 func (s *MemStore) ChannelStats(channelId string) (*model.ChannelStats, error) {
 	s.lock.RLock()
@@ -531,11 +1018,85 @@ func (s *MemStore) ChannelStats(channelId string) (*model.ChannelStats, error) {
 		return nil, errors.New("memstore: channelId should not be empty")
 	}
 
-	return s.channelStats[channelId], nil
+	return s.channelStats[channelId], nil
+}
+
+// SetChannelStats stores statistics for the given channelId.
+func (s *MemStore) SetChannelStats(channelId string, stats *model.ChannelStats) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if channelId == "" {
+		return errors.New("memstore: channelId should not be empty")
+	}
+
+	s.channelStats[channelId] = stats
+
+	return nil
+}
+
+// ChannelBookmarks returns the bookmarks for the given channelId.
+func (s *MemStore) ChannelBookmarks(channelId string) ([]store.ChannelBookmark, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if channelId == "" {
+		return nil, errors.New("memstore: channelId should not be empty")
+	}
+
+	var bookmarks []store.ChannelBookmark
+	for _, b := range s.channelBookmarks[channelId] {
+		bookmarks = append(bookmarks, *b)
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].SortOrder < bookmarks[j].SortOrder
+	})
+
+	return bookmarks, nil
+}
+
+// SetChannelBookmarks replaces the bookmarks for the given channelId.
+func (s *MemStore) SetChannelBookmarks(channelId string, bookmarks []*store.ChannelBookmark) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if channelId == "" {
+		return errors.New("memstore: channelId should not be empty")
+	}
+
+	s.channelBookmarks[channelId] = map[string]*store.ChannelBookmark{}
+	for _, b := range bookmarks {
+		if b == nil || b.DeleteAt > 0 {
+			continue
+		}
+		s.channelBookmarks[channelId][b.Id] = b
+	}
+
+	return nil
+}
+
+// SetChannelBookmark stores the given channel bookmark.
+func (s *MemStore) SetChannelBookmark(bookmark *store.ChannelBookmark) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if bookmark == nil {
+		return errors.New("memstore: bookmark should not be nil")
+	}
+	if bookmark.ChannelId == "" {
+		return errors.New("memstore: channelId should not be empty")
+	}
+
+	if s.channelBookmarks[bookmark.ChannelId] == nil {
+		s.channelBookmarks[bookmark.ChannelId] = map[string]*store.ChannelBookmark{}
+	}
+	s.channelBookmarks[bookmark.ChannelId][bookmark.Id] = bookmark
+
+	return nil
 }
 
-// SetChannelStats stores statistics for the given channelId.
-func (s *MemStore) SetChannelStats(channelId string, stats *model.ChannelStats) error {
+// DeleteChannelBookmark deletes the specified bookmark from the given channelId.
+func (s *MemStore) DeleteChannelBookmark(channelId, bookmarkId string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -543,7 +1104,7 @@ func (s *MemStore) SetChannelStats(channelId string, stats *model.ChannelStats)
 		return errors.New("memstore: channelId should not be empty")
 	}
 
-	s.channelStats[channelId] = stats
+	delete(s.channelBookmarks[channelId], bookmarkId)
 
 	return nil
 }
@@ -616,6 +1177,36 @@ func (s *MemStore) SetTeams(teams []*model.Team) error {
 	return nil
 }
 
+// DeleteTeam removes the given team from the store, along with its members,
+// its sidebar categories and the channels belonging to it, so that nothing
+// refers to the team anymore.
+func (s *MemStore) DeleteTeam(teamId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if teamId == "" {
+		return errors.New("memstore: teamId should not be empty")
+	}
+
+	delete(s.teams, teamId)
+	delete(s.teamMembers, teamId)
+	delete(s.sidebarCategories, teamId)
+	delete(s.categoryOrders, teamId)
+	if s.currentTeam != nil && s.currentTeam.Id == teamId {
+		s.currentTeam = nil
+	}
+
+	for channelId, channel := range s.channels {
+		if channel.TeamId == teamId {
+			if err := s.deleteChannel(channelId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // SetChannelMembers stores the given channel members in the store.
 func (s *MemStore) SetChannelMembers(channelMembers model.ChannelMembers) error {
 	s.lock.Lock()
@@ -717,6 +1308,90 @@ func (s *MemStore) RemoveChannelMember(channelId string, userId string) error {
 	return nil
 }
 
+// SetGroupChannels stores the given groupId along with the ids of the
+// channels synced with it. The members of an already stored group are kept.
+func (s *MemStore) SetGroupChannels(groupId string, channelIds []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if groupId == "" {
+		return errors.New("memstore: groupId should not be empty")
+	}
+
+	g, ok := s.groups[groupId]
+	if !ok {
+		g = &group{members: map[string]bool{}}
+		s.groups[groupId] = g
+	}
+	g.channelIds = append([]string(nil), channelIds...)
+
+	return nil
+}
+
+// GroupChannels returns the ids of the channels synced with the given
+// groupId. It returns ErrGroupNotFound if the group is not stored.
+func (s *MemStore) GroupChannels(groupId string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	g, ok := s.groups[groupId]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	return append([]string(nil), g.channelIds...), nil
+}
+
+// SetGroupMember stores the given userId as a member of the given groupId.
+// It returns ErrGroupNotFound if the group is not stored.
+func (s *MemStore) SetGroupMember(groupId, userId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if userId == "" {
+		return errors.New("memstore: userId should not be empty")
+	}
+
+	g, ok := s.groups[groupId]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	g.members[userId] = true
+
+	return nil
+}
+
+// RemoveGroupMember removes the given userId from the members of the given
+// groupId. It returns ErrGroupNotFound if the group is not stored.
+func (s *MemStore) RemoveGroupMember(groupId, userId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	g, ok := s.groups[groupId]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	delete(g.members, userId)
+
+	return nil
+}
+
+// GroupMembers returns the ids of the stored members of the given groupId.
+// It returns ErrGroupNotFound if the group is not stored.
+func (s *MemStore) GroupMembers(groupId string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	g, ok := s.groups[groupId]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	userIds := make([]string, 0, len(g.members))
+	for userId := range g.members {
+		userIds = append(userIds, userId)
+	}
+	return userIds, nil
+}
+
 // RemoveTeamMember removes the team member for the specified team and user..
 func (s *MemStore) RemoveTeamMember(teamId string, userId string) error {
 	s.lock.Lock()
@@ -775,6 +1450,72 @@ func (s *MemStore) SetEmojis(emoji []*model.Emoji) error {
 	return nil
 }
 
+// SetEmoji stores the given emoji, replacing any previous one with the same
+// id.
+func (s *MemStore) SetEmoji(emoji *model.Emoji) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if emoji == nil {
+		return errors.New("memstore: emoji should not be nil")
+	}
+	if emoji.Id == "" || emoji.Name == "" {
+		return errors.New("memstore: emoji is not valid")
+	}
+
+	// A new slice is always built since the current one may be shared with
+	// the caller of SetEmojis.
+	emojis := make([]*model.Emoji, 0, len(s.emojis)+1)
+	for _, e := range s.emojis {
+		if e.Id != emoji.Id {
+			emojis = append(emojis, e)
+		}
+	}
+	emojiCopy := *emoji
+	s.emojis = append(emojis, &emojiCopy)
+
+	return nil
+}
+
+// Emojis returns the stored custom emojis.
+func (s *MemStore) Emojis() ([]model.Emoji, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	emojis := make([]model.Emoji, 0, len(s.emojis))
+	for _, e := range s.emojis {
+		emojis = append(emojis, *e)
+	}
+	return emojis, nil
+}
+
+// SetPluginStatuses replaces the stored plugin statuses with the given ones.
+func (s *MemStore) SetPluginStatuses(statuses model.PluginStatuses) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pluginStatuses := make([]model.PluginStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if status == nil {
+			return errors.New("memstore: plugin status should not be nil")
+		}
+		pluginStatuses = append(pluginStatuses, *status)
+	}
+	s.pluginStatuses = pluginStatuses
+
+	return nil
+}
+
+// PluginStatuses returns the latest statuses of the server plugins.
+func (s *MemStore) PluginStatuses() ([]model.PluginStatus, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	statuses := make([]model.PluginStatus, len(s.pluginStatuses))
+	copy(statuses, s.pluginStatuses)
+	return statuses, nil
+}
+
 // SetReactions stores the given reactions for the specified post.
 func (s *MemStore) SetReactions(postId string, reactions []*model.Reaction) error {
 	s.lock.Lock()
@@ -827,6 +1568,62 @@ func (s *MemStore) DeleteReaction(reaction *model.Reaction) (bool, error) {
 	return false, nil
 }
 
+// SetPostAcknowledgement stores the given post acknowledgement, replacing any
+// previous one by the same user.
+func (s *MemStore) SetPostAcknowledgement(ack *store.PostAcknowledgement) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if ack == nil {
+		return errors.New("memstore: acknowledgement should not be nil")
+	}
+	if ack.PostId == "" || ack.UserId == "" {
+		return errors.New("memstore: acknowledgement is not valid")
+	}
+
+	if s.acks[ack.PostId] == nil {
+		s.acks[ack.PostId] = map[string]*store.PostAcknowledgement{}
+	}
+	ackCopy := *ack
+	s.acks[ack.PostId][ack.UserId] = &ackCopy
+
+	return nil
+}
+
+// DeletePostAcknowledgement deletes the acknowledgement of the given post by
+// the given user.
+func (s *MemStore) DeletePostAcknowledgement(postId, userId string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.acks[postId], userId)
+	if len(s.acks[postId]) == 0 {
+		delete(s.acks, postId)
+	}
+
+	return nil
+}
+
+// PostAcknowledgements returns the acknowledgements for the specified post,
+// sorted by time.
+func (s *MemStore) PostAcknowledgements(postId string) ([]store.PostAcknowledgement, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var acks []store.PostAcknowledgement
+	for _, ack := range s.acks[postId] {
+		acks = append(acks, *ack)
+	}
+	sort.Slice(acks, func(i, j int) bool {
+		if acks[i].AcknowledgedAt != acks[j].AcknowledgedAt {
+			return acks[i].AcknowledgedAt < acks[j].AcknowledgedAt
+		}
+		return acks[i].UserId < acks[j].UserId
+	})
+
+	return acks, nil
+}
+
 // GetUser returns the user for the given userId.
 func (s *MemStore) GetUser(userId string) (model.User, error) {
 	s.lock.RLock()
@@ -881,6 +1678,20 @@ func (s *MemStore) Status(userId string) (model.Status, error) {
 	return status, nil
 }
 
+// OnlineUsers returns the ids of the users whose stored status is online.
+func (s *MemStore) OnlineUsers() ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var userIds []string
+	for userId, st := range s.statuses {
+		if st.Status == model.StatusOnline {
+			userIds = append(userIds, userId)
+		}
+	}
+	return userIds, nil
+}
+
 // SetStatus stores the status for the given userId.
 func (s *MemStore) SetStatus(userId string, status *model.Status) error {
 	s.lock.Lock()
@@ -912,6 +1723,64 @@ func (s *MemStore) SetStatus(userId string, status *model.Status) error {
 	return nil
 }
 
+// SetTyping records that the given userId is typing in the given channelId
+// until expiresAt.
+func (s *MemStore) SetTyping(channelId, userId string, expiresAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if channelId == "" {
+		return errors.New("memstore: channelId should not be empty")
+	}
+	if userId == "" {
+		return errors.New("memstore: userId should not be empty")
+	}
+
+	s.pruneTyping(time.Now())
+
+	if s.typing[channelId] == nil {
+		s.typing[channelId] = map[string]time.Time{}
+	}
+	s.typing[channelId][userId] = expiresAt
+
+	return nil
+}
+
+// GetTypingUsers returns the ids of the users currently typing in the given
+// channelId.
+func (s *MemStore) GetTypingUsers(channelId string) ([]string, error) {
+	// Expired entries are pruned on access, hence the write lock.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if channelId == "" {
+		return nil, errors.New("memstore: channelId should not be empty")
+	}
+
+	s.pruneTyping(time.Now())
+
+	var userIds []string
+	for userId := range s.typing[channelId] {
+		userIds = append(userIds, userId)
+	}
+	return userIds, nil
+}
+
+// pruneTyping removes the typing entries expired by now so that the map
+// doesn't grow unbounded. The lock is expected to be held for writing.
+func (s *MemStore) pruneTyping(now time.Time) {
+	for channelId, users := range s.typing {
+		for userId, expiresAt := range users {
+			if !now.Before(expiresAt) {
+				delete(users, userId)
+			}
+		}
+		if len(users) == 0 {
+			delete(s.typing, channelId)
+		}
+	}
+}
+
 // SetRoles stores the given roles.
 func (s *MemStore) SetRoles(roles []*model.Role) error {
 	s.lock.Lock()
@@ -924,6 +1793,21 @@ func (s *MemStore) SetRoles(roles []*model.Role) error {
 	return nil
 }
 
+// UpdateRole replaces the stored role with the same id as the given one. It's
+// a no-op if no such role is stored, as only the roles of the user are.
+func (s *MemStore) UpdateRole(role *model.Role) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if role == nil {
+		return errors.New("memstore: role should not be nil")
+	}
+	if _, ok := s.roles[role.Id]; ok {
+		s.roles[role.Id] = role
+	}
+	return nil
+}
+
 // Roles returns the roles of the user.
 func (s *MemStore) Roles() ([]model.Role, error) {
 	s.lock.RLock()
@@ -1031,9 +1915,111 @@ func (s *MemStore) SetCategories(teamID string, sidebarCategories *model.Ordered
 		teamCat[cat.SidebarCategory.Id] = cat
 	}
 	s.sidebarCategories[teamID] = teamCat
+	s.categoryOrders[teamID] = append([]string(nil), sidebarCategories.Order...)
+	return nil
+}
+
+// SetCategory stores the given sidebar category of the given team, replacing
+// the one with the same id, if any. New categories are added last to the
+// order until it's updated.
+func (s *MemStore) SetCategory(teamID string, category *model.SidebarCategoryWithChannels) error {
+	if category == nil {
+		return errors.New("memstore: category should not be nil")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	teamCat, ok := s.sidebarCategories[teamID]
+	if !ok {
+		teamCat = make(map[string]*model.SidebarCategoryWithChannels)
+		s.sidebarCategories[teamID] = teamCat
+	}
+	if _, ok := teamCat[category.Id]; !ok {
+		s.categoryOrders[teamID] = append(s.categoryOrders[teamID], category.Id)
+	}
+	teamCat[category.Id] = copyCategory(category)
+	return nil
+}
+
+// SetCategoryOrder stores the order of the sidebar categories of the given
+// team.
+func (s *MemStore) SetCategoryOrder(teamID string, order []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.categoryOrders[teamID] = append([]string(nil), order...)
+	return nil
+}
+
+// DeleteCategory removes the given sidebar category of the given team. Its
+// channels are left uncategorized rather than moved to another category.
+func (s *MemStore) DeleteCategory(teamID, categoryID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sidebarCategories[teamID], categoryID)
+	order := s.categoryOrders[teamID][:0]
+	for _, id := range s.categoryOrders[teamID] {
+		if id != categoryID {
+			order = append(order, id)
+		}
+	}
+	s.categoryOrders[teamID] = order
 	return nil
 }
 
+// SidebarCategory returns the sidebar category with the given id in the given
+// team, or nil if it isn't stored.
+func (s *MemStore) SidebarCategory(teamID, categoryID string) (*model.SidebarCategoryWithChannels, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if category, ok := s.sidebarCategories[teamID][categoryID]; ok {
+		return copyCategory(category), nil
+	}
+	return nil, nil
+}
+
+// SidebarCategories returns the sidebar categories of the given team, in the
+// stored order. The categories missing from the order go last, sorted by id.
+func (s *MemStore) SidebarCategories(teamID string) (*model.OrderedSidebarCategories, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	teamCat := s.sidebarCategories[teamID]
+	categories := &model.OrderedSidebarCategories{
+		Categories: make(model.SidebarCategoriesWithChannels, 0, len(teamCat)),
+		Order:      make(model.SidebarCategoryOrder, 0, len(teamCat)),
+	}
+	ordered := make(map[string]bool, len(teamCat))
+	for _, id := range s.categoryOrders[teamID] {
+		if category, ok := teamCat[id]; ok && !ordered[id] {
+			ordered[id] = true
+			categories.Categories = append(categories.Categories, copyCategory(category))
+			categories.Order = append(categories.Order, id)
+		}
+	}
+	var unordered []string
+	for id := range teamCat {
+		if !ordered[id] {
+			unordered = append(unordered, id)
+		}
+	}
+	sort.Strings(unordered)
+	for _, id := range unordered {
+		categories.Categories = append(categories.Categories, copyCategory(teamCat[id]))
+		categories.Order = append(categories.Order, id)
+	}
+	return categories, nil
+}
+
+func copyCategory(category *model.SidebarCategoryWithChannels) *model.SidebarCategoryWithChannels {
+	categoryCopy := *category
+	categoryCopy.Channels = append([]string(nil), category.Channels...)
+	return &categoryCopy
+}
+
 func (s *MemStore) getThreads(unreadOnly bool) ([]*model.ThreadResponse, error) {
 	var threads []*model.ThreadResponse
 	for _, thread := range s.threads {
@@ -1107,7 +2093,85 @@ func (s *MemStore) MarkAllThreadsInTeamAsRead(teamId string) error {
 		thread.UnreadReplies = 0
 		thread.LastViewedAt = now
 	}
-	return s.SetThreads(threads)
+	if err := s.SetThreads(threads); err != nil {
+		return err
+	}
+
+	// The read state is also tracked for the threads the user doesn't
+	// follow, as long as their root post is stored.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for rootId, state := range s.threadStates {
+		post, ok := s.posts[rootId]
+		if !ok {
+			continue
+		}
+		if ch, ok := s.channels[post.ChannelId]; !ok || ch.TeamId != teamId {
+			continue
+		}
+		state.UnreadMentions = 0
+		state.UnreadReplies = 0
+		state.LastViewedAt = now
+	}
+	return nil
+}
+
+// SetThreadState stores the read state of the thread with the given root post
+// id. The related thread, if any, is updated as well. It's a no-op if the root
+// post is missing from the store.
+func (s *MemStore) SetThreadState(rootId string, state store.ThreadState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if rootId == "" {
+		return errors.New("memstore: root id should not be empty")
+	}
+
+	if _, ok := s.posts[rootId]; !ok {
+		if s.postSpill == nil {
+			return nil
+		}
+		if _, ok := s.postSpill.index[rootId]; !ok {
+			return nil
+		}
+	}
+
+	s.threadStates[rootId] = &state
+	if thread, ok := s.threads[rootId]; ok {
+		thread.LastViewedAt = state.LastViewedAt
+		thread.UnreadReplies = state.UnreadReplies
+		thread.UnreadMentions = state.UnreadMentions
+	}
+
+	return nil
+}
+
+// GetThreadState returns the read state of the thread with the given root post
+// id.
+func (s *MemStore) GetThreadState(rootId string) (store.ThreadState, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if state, ok := s.threadStates[rootId]; ok {
+		return *state, nil
+	}
+	return store.ThreadState{}, ErrThreadNotFound
+}
+
+// GetUnreadThreads returns the sorted root post ids of the threads having
+// unread replies.
+func (s *MemStore) GetUnreadThreads() ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var rootIds []string
+	for rootId, state := range s.threadStates {
+		if state.UnreadReplies > 0 {
+			rootIds = append(rootIds, rootId)
+		}
+	}
+	sort.Strings(rootIds)
+
+	return rootIds, nil
 }
 
 // Thread returns the thread for the given the threadId.
@@ -5,9 +5,12 @@ package memstore
 
 import (
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+
 	"github.com/mattermost/mattermost-server/v6/model"
 
 	"github.com/stretchr/testify/require"
@@ -101,6 +104,30 @@ func TestUser(t *testing.T) {
 		require.Equal(t, p, pp)
 	})
 
+	t.Run("UpsertPreferences", func(t *testing.T) {
+		p := model.Preferences{
+			{UserId: "user-id-1", Category: "category-1", Name: "name-1", Value: "value-1"},
+			{UserId: "user-id-1", Category: "category-1", Name: "name-2", Value: "value-2"},
+		}
+		require.NoError(t, s.SetPreferences(p))
+
+		err := s.UpsertPreferences(model.Preferences{
+			{UserId: "user-id-1", Category: "category-1", Name: "name-2", Value: "updated"},
+			{UserId: "user-id-1", Category: "category-2", Name: "name-2", Value: "value-3"},
+		})
+		require.NoError(t, err)
+		pp, err := s.Preferences()
+		require.NoError(t, err)
+		require.Equal(t, model.Preferences{
+			{UserId: "user-id-1", Category: "category-1", Name: "name-1", Value: "value-1"},
+			{UserId: "user-id-1", Category: "category-1", Name: "name-2", Value: "updated"},
+			{UserId: "user-id-1", Category: "category-2", Name: "name-2", Value: "value-3"},
+		}, pp)
+
+		// The preferences given to SetPreferences are left untouched.
+		require.Equal(t, "value-2", p[1].Value)
+	})
+
 	t.Run("Post", func(t *testing.T) {
 		p, err := s.Post("someid")
 		require.Empty(t, p)
@@ -326,6 +353,172 @@ func TestChannel(t *testing.T) {
 	})
 }
 
+func TestClientLicense(t *testing.T) {
+	s := newStore(t)
+	require.Empty(t, s.ClientLicense())
+
+	license := map[string]string{"IsLicensed": "true"}
+	require.NoError(t, s.SetLicense(license))
+	require.Equal(t, license, s.ClientLicense())
+}
+
+func TestMarkChannelRead(t *testing.T) {
+	s := newStore(t)
+	require.Error(t, s.MarkChannelRead(""))
+
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+
+	// Missing channel.
+	require.NoError(t, s.MarkChannelRead(model.NewId()))
+
+	channel := &model.Channel{Id: model.NewId(), TotalMsgCount: 10, TotalMsgCountRoot: 8}
+	require.NoError(t, s.SetChannel(channel))
+
+	// Missing membership.
+	require.NoError(t, s.MarkChannelRead(channel.Id))
+
+	require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+		ChannelId:        channel.Id,
+		UserId:           user.Id,
+		MsgCount:         4,
+		MsgCountRoot:     3,
+		MentionCount:     2,
+		MentionCountRoot: 1,
+	}))
+	require.NoError(t, s.MarkChannelRead(channel.Id))
+
+	member, err := s.ChannelMember(channel.Id, user.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), member.MsgCount)
+	require.Equal(t, int64(8), member.MsgCountRoot)
+	require.Zero(t, member.MentionCount)
+	require.Zero(t, member.MentionCountRoot)
+	require.NotZero(t, member.LastViewedAt)
+}
+
+func TestSetChannelUnread(t *testing.T) {
+	s := newStore(t)
+	require.Error(t, s.SetChannelUnread("", &model.ChannelUnreadAt{}))
+
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+	channel := &model.Channel{Id: model.NewId()}
+	require.Error(t, s.SetChannelUnread(channel.Id, nil))
+
+	// Missing channel.
+	require.NoError(t, s.SetChannelUnread(channel.Id, &model.ChannelUnreadAt{}))
+
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+		ChannelId:    channel.Id,
+		UserId:       user.Id,
+		LastViewedAt: 100,
+	}))
+	require.NoError(t, s.SetChannelUnread(channel.Id, &model.ChannelUnreadAt{
+		MsgCount:         4,
+		MsgCountRoot:     3,
+		MentionCount:     2,
+		MentionCountRoot: 1,
+	}))
+
+	member, err := s.ChannelMember(channel.Id, user.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), member.MsgCount)
+	require.Equal(t, int64(3), member.MsgCountRoot)
+	require.Equal(t, int64(2), member.MentionCount)
+	require.Equal(t, int64(1), member.MentionCountRoot)
+	require.Equal(t, int64(100), member.LastViewedAt)
+}
+
+func TestIncrementMentionCount(t *testing.T) {
+	s := newStore(t)
+	require.Error(t, s.IncrementMentionCount("", false))
+
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+	channel := &model.Channel{Id: model.NewId()}
+
+	// Missing channel.
+	require.NoError(t, s.IncrementMentionCount(channel.Id, false))
+
+	require.NoError(t, s.SetChannel(channel))
+	// Missing membership.
+	require.NoError(t, s.IncrementMentionCount(channel.Id, false))
+
+	require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+		ChannelId:    channel.Id,
+		UserId:       user.Id,
+		MentionCount: 1,
+	}))
+	require.NoError(t, s.IncrementMentionCount(channel.Id, true))
+	require.NoError(t, s.IncrementMentionCount(channel.Id, false))
+
+	member, err := s.ChannelMember(channel.Id, user.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), member.MentionCount)
+	require.Equal(t, int64(1), member.MentionCountRoot)
+}
+
+func TestSetChannelIfMissing(t *testing.T) {
+	s := newStore(t)
+	_, err := s.SetChannelIfMissing(nil)
+	require.Error(t, err)
+
+	channel := &model.Channel{Id: model.NewId(), Name: "name"}
+	ok, err := s.SetChannelIfMissing(channel)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = s.SetChannelIfMissing(&model.Channel{Id: channel.Id})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	c, err := s.Channel(channel.Id)
+	require.NoError(t, err)
+	require.Equal(t, "name", c.Name)
+}
+
+func TestDeleteChannel(t *testing.T) {
+	s := newStore(t)
+	require.Error(t, s.DeleteChannel(""))
+
+	channel := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetCurrentChannel(channel))
+	require.NoError(t, s.SetChannelView(channel.Id))
+	require.NoError(t, s.SetChannelStats(channel.Id, &model.ChannelStats{ChannelId: channel.Id}))
+	post := &model.Post{Id: model.NewId(), ChannelId: channel.Id}
+	require.NoError(t, s.SetPost(post))
+	require.NoError(t, s.SetReaction(&model.Reaction{PostId: post.Id, UserId: model.NewId(), EmojiName: "smile"}))
+	otherPost := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+	require.NoError(t, s.SetPost(otherPost))
+
+	require.NoError(t, s.DeleteChannel(channel.Id))
+
+	c, err := s.Channel(channel.Id)
+	require.NoError(t, err)
+	require.Nil(t, c)
+	_, err = s.CurrentChannel()
+	require.ErrorIs(t, err, ErrChannelNotFound)
+	view, err := s.ChannelView(channel.Id)
+	require.NoError(t, err)
+	require.Zero(t, view)
+	stats, err := s.ChannelStats(channel.Id)
+	require.NoError(t, err)
+	require.Nil(t, stats)
+	_, err = s.Post(post.Id)
+	require.ErrorIs(t, err, ErrPostNotFound)
+	reactions, err := s.Reactions(post.Id)
+	require.NoError(t, err)
+	require.Empty(t, reactions)
+	_, err = s.Post(otherPost.Id)
+	require.NoError(t, err)
+
+	// Deleting a missing channel is not an error.
+	require.NoError(t, s.DeleteChannel(model.NewId()))
+}
+
 func TestCurrentChannel(t *testing.T) {
 	s := newStore(t)
 	channel, err := s.CurrentChannel()
@@ -530,6 +723,61 @@ func TestCurrentTeam(t *testing.T) {
 	})
 }
 
+func TestDeleteTeam(t *testing.T) {
+	s := newStore(t)
+	require.Error(t, s.DeleteTeam(""))
+
+	team := &model.Team{Id: model.NewId()}
+	otherTeam := &model.Team{Id: model.NewId()}
+	require.NoError(t, s.SetTeams([]*model.Team{team, otherTeam}))
+	require.NoError(t, s.SetCurrentTeam(team))
+	require.NoError(t, s.SetTeamMember(team.Id, &model.TeamMember{TeamId: team.Id, UserId: model.NewId()}))
+	channel := &model.Channel{Id: model.NewId(), TeamId: team.Id}
+	otherChannel := &model.Channel{Id: model.NewId(), TeamId: otherTeam.Id}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetChannel(otherChannel))
+	require.NoError(t, s.SetCurrentChannel(channel))
+	post := &model.Post{Id: model.NewId(), ChannelId: channel.Id}
+	require.NoError(t, s.SetPost(post))
+	require.NoError(t, s.SetCategory(team.Id, &model.SidebarCategoryWithChannels{
+		SidebarCategory: model.SidebarCategory{Id: model.NewId(), TeamId: team.Id},
+	}))
+
+	require.NoError(t, s.DeleteTeam(team.Id))
+
+	tm, err := s.Team(team.Id)
+	require.NoError(t, err)
+	require.Nil(t, tm)
+	tm, err = s.CurrentTeam()
+	require.NoError(t, err)
+	require.Nil(t, tm)
+	_, err = s.RandomTeamMember(team.Id)
+	require.Error(t, err)
+	categories, err := s.SidebarCategories(team.Id)
+	require.NoError(t, err)
+	require.Empty(t, categories.Categories)
+
+	// The channels of the team are purged along with their posts.
+	c, err := s.Channel(channel.Id)
+	require.NoError(t, err)
+	require.Nil(t, c)
+	_, err = s.CurrentChannel()
+	require.ErrorIs(t, err, ErrChannelNotFound)
+	_, err = s.Post(post.Id)
+	require.ErrorIs(t, err, ErrPostNotFound)
+
+	// Other teams are kept.
+	tm, err = s.Team(otherTeam.Id)
+	require.NoError(t, err)
+	require.Equal(t, otherTeam, tm)
+	c, err = s.Channel(otherChannel.Id)
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	// Deleting a missing team is not an error.
+	require.NoError(t, s.DeleteTeam(model.NewId()))
+}
+
 func TestTeamMembers(t *testing.T) {
 	s := newStore(t)
 
@@ -670,6 +918,119 @@ func TestChannelStats(t *testing.T) {
 	})
 }
 
+func TestRecentlyViewedChannels(t *testing.T) {
+	s := newStore(t)
+
+	ids, err := s.RecentlyViewedChannels(0)
+	require.Error(t, err)
+	require.Nil(t, ids)
+
+	ids, err = s.RecentlyViewedChannels(2)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	channelIds := []string{model.NewId(), model.NewId(), model.NewId()}
+	for i, id := range channelIds {
+		s.channelViews[id] = int64(i + 1)
+	}
+
+	ids, err = s.RecentlyViewedChannels(2)
+	require.NoError(t, err)
+	require.Equal(t, []string{channelIds[2], channelIds[1]}, ids)
+
+	ids, err = s.RecentlyViewedChannels(10)
+	require.NoError(t, err)
+	require.Equal(t, []string{channelIds[2], channelIds[1], channelIds[0]}, ids)
+}
+
+func TestChannelLastPostAt(t *testing.T) {
+	s := newStore(t)
+
+	_, err := s.ChannelLastPostAt("")
+	require.Error(t, err)
+
+	channelId := model.NewId()
+	lastPostAt, err := s.ChannelLastPostAt(channelId)
+	require.NoError(t, err)
+	require.Zero(t, lastPostAt)
+
+	err = s.SetPosts([]*model.Post{
+		{Id: model.NewId(), ChannelId: channelId, CreateAt: 100},
+		{Id: model.NewId(), ChannelId: channelId, CreateAt: 200, UpdateAt: 300},
+		{Id: model.NewId(), ChannelId: model.NewId(), CreateAt: 400},
+	})
+	require.NoError(t, err)
+
+	lastPostAt, err = s.ChannelLastPostAt(channelId)
+	require.NoError(t, err)
+	require.Equal(t, int64(300), lastPostAt)
+}
+
+func TestChannelBookmarks(t *testing.T) {
+	s := newStore(t)
+	channelId := model.NewId()
+
+	_, err := s.ChannelBookmarks("")
+	require.Error(t, err)
+
+	bookmarks, err := s.ChannelBookmarks(channelId)
+	require.NoError(t, err)
+	require.Empty(t, bookmarks)
+
+	b1 := &store.ChannelBookmark{Id: model.NewId(), ChannelId: channelId, SortOrder: 1}
+	b2 := &store.ChannelBookmark{Id: model.NewId(), ChannelId: channelId, SortOrder: 0}
+	deleted := &store.ChannelBookmark{Id: model.NewId(), ChannelId: channelId, DeleteAt: 1}
+
+	t.Run("SetChannelBookmarks", func(t *testing.T) {
+		err := s.SetChannelBookmarks("", nil)
+		require.Error(t, err)
+
+		err = s.SetChannelBookmarks(channelId, []*store.ChannelBookmark{b1, b2, deleted})
+		require.NoError(t, err)
+
+		bookmarks, err := s.ChannelBookmarks(channelId)
+		require.NoError(t, err)
+		require.Equal(t, []store.ChannelBookmark{*b2, *b1}, bookmarks)
+	})
+
+	t.Run("SetChannelBookmark", func(t *testing.T) {
+		err := s.SetChannelBookmark(nil)
+		require.Error(t, err)
+
+		err = s.SetChannelBookmark(&store.ChannelBookmark{Id: model.NewId()})
+		require.Error(t, err)
+
+		b3 := &store.ChannelBookmark{Id: model.NewId(), ChannelId: channelId, SortOrder: 2}
+		err = s.SetChannelBookmark(b3)
+		require.NoError(t, err)
+
+		bookmarks, err := s.ChannelBookmarks(channelId)
+		require.NoError(t, err)
+		require.Equal(t, []store.ChannelBookmark{*b2, *b1, *b3}, bookmarks)
+	})
+
+	t.Run("DeleteChannelBookmark", func(t *testing.T) {
+		err := s.DeleteChannelBookmark("", b1.Id)
+		require.Error(t, err)
+
+		err = s.DeleteChannelBookmark(channelId, b1.Id)
+		require.NoError(t, err)
+
+		bookmarks, err := s.ChannelBookmarks(channelId)
+		require.NoError(t, err)
+		require.Len(t, bookmarks, 2)
+		require.NotContains(t, bookmarks, *b1)
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		s.Clear()
+
+		bookmarks, err := s.ChannelBookmarks(channelId)
+		require.NoError(t, err)
+		require.Empty(t, bookmarks)
+	})
+}
+
 func TestThreads(t *testing.T) {
 	t.Run("SetThreads", func(t *testing.T) {
 		s := newStore(t)
@@ -727,6 +1088,24 @@ func TestThreads(t *testing.T) {
 		require.Equal(t, int64(3), th3.UnreadMentions)
 		require.Equal(t, int64(3), th3.UnreadReplies)
 		require.Equal(t, int64(0), th3.LastViewedAt)
+
+		t.Run("thread states", func(t *testing.T) {
+			root1 := &model.Post{Id: model.NewId(), ChannelId: channelId1}
+			root2 := &model.Post{Id: model.NewId(), ChannelId: channelId2}
+			require.NoError(t, s.SetPosts([]*model.Post{root1, root2}))
+			require.NoError(t, s.SetThreadState(root1.Id, store.ThreadState{UnreadReplies: 2, UnreadMentions: 1}))
+			require.NoError(t, s.SetThreadState(root2.Id, store.ThreadState{UnreadReplies: 2, UnreadMentions: 1}))
+
+			require.NoError(t, s.MarkAllThreadsInTeamAsRead(teamId1))
+			state, err := s.GetThreadState(root1.Id)
+			require.NoError(t, err)
+			require.Zero(t, state.UnreadReplies)
+			require.Zero(t, state.UnreadMentions)
+			require.GreaterOrEqual(t, state.LastViewedAt, now)
+			unread, err := s.GetUnreadThreads()
+			require.NoError(t, err)
+			require.Equal(t, []string{root2.Id}, unread)
+		})
 	})
 
 	t.Run("ThreadsSorted", func(t *testing.T) {
@@ -771,3 +1150,860 @@ func TestThreads(t *testing.T) {
 
 	})
 }
+
+func TestUpdateThreadOnReply(t *testing.T) {
+	s := newStore(t)
+	require.Error(t, s.UpdateThreadOnReply(nil))
+	require.Error(t, s.UpdateThreadOnReply(&model.Post{Id: model.NewId()}))
+
+	root := &model.Post{Id: model.NewId(), UserId: model.NewId()}
+	newReply := func(userId string, createAt int64) *model.Post {
+		return &model.Post{
+			Id:       model.NewId(),
+			RootId:   root.Id,
+			UserId:   userId,
+			CreateAt: createAt,
+		}
+	}
+
+	t.Run("root not stored", func(t *testing.T) {
+		require.NoError(t, s.UpdateThreadOnReply(newReply(model.NewId(), 1)))
+		_, err := s.Post(root.Id)
+		require.ErrorIs(t, err, ErrPostNotFound)
+	})
+
+	require.NoError(t, s.SetPost(root))
+	require.NoError(t, s.SetThread(&model.ThreadResponse{PostId: root.Id}))
+
+	userId := model.NewId()
+	reply := newReply(userId, 100)
+	require.NoError(t, s.UpdateThreadOnReply(reply))
+	require.NoError(t, s.SetPost(reply))
+	require.NoError(t, s.UpdateThreadOnReply(newReply(userId, 50)))
+
+	p, err := s.Post(root.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), p.ReplyCount)
+	require.Equal(t, int64(100), p.LastReplyAt)
+	require.Len(t, p.Participants, 1)
+	require.Equal(t, userId, p.Participants[0].Id)
+
+	thread, err := s.Thread(root.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), thread.ReplyCount)
+	require.Equal(t, int64(100), thread.LastReplyAt)
+	require.Len(t, thread.Participants, 1)
+
+	t.Run("stored reply is not counted twice", func(t *testing.T) {
+		require.NoError(t, s.UpdateThreadOnReply(reply))
+		p, err := s.Post(root.Id)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), p.ReplyCount)
+	})
+}
+
+func TestOnlineUsers(t *testing.T) {
+	s := newStore(t)
+
+	userIds, err := s.OnlineUsers()
+	require.NoError(t, err)
+	require.Empty(t, userIds)
+
+	online := model.NewId()
+	away := model.NewId()
+	require.NoError(t, s.SetStatus(online, &model.Status{UserId: online, Status: model.StatusOnline}))
+	require.NoError(t, s.SetStatus(away, &model.Status{UserId: away, Status: model.StatusAway}))
+
+	userIds, err = s.OnlineUsers()
+	require.NoError(t, err)
+	require.Equal(t, []string{online}, userIds)
+
+	require.NoError(t, s.SetStatus(online, &model.Status{UserId: online, Status: model.StatusOffline}))
+	userIds, err = s.OnlineUsers()
+	require.NoError(t, err)
+	require.Empty(t, userIds)
+}
+
+func TestTypingUsers(t *testing.T) {
+	s := newStore(t)
+	channelId := model.NewId()
+
+	require.Error(t, s.SetTyping("", model.NewId(), time.Now()))
+	require.Error(t, s.SetTyping(channelId, "", time.Now()))
+	_, err := s.GetTypingUsers("")
+	require.Error(t, err)
+
+	userIds, err := s.GetTypingUsers(channelId)
+	require.NoError(t, err)
+	require.Empty(t, userIds)
+
+	typing := model.NewId()
+	expired := model.NewId()
+	require.NoError(t, s.SetTyping(channelId, typing, time.Now().Add(time.Hour)))
+	require.NoError(t, s.SetTyping(channelId, expired, time.Now().Add(-time.Second)))
+
+	userIds, err = s.GetTypingUsers(channelId)
+	require.NoError(t, err)
+	require.Equal(t, []string{typing}, userIds)
+
+	t.Run("expiry", func(t *testing.T) {
+		userId := model.NewId()
+		require.NoError(t, s.SetTyping(channelId, userId, time.Now().Add(50*time.Millisecond)))
+		userIds, err := s.GetTypingUsers(channelId)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{typing, userId}, userIds)
+
+		require.Eventually(t, func() bool {
+			userIds, err := s.GetTypingUsers(channelId)
+			require.NoError(t, err)
+			return len(userIds) == 1 && userIds[0] == typing
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("pruning", func(t *testing.T) {
+		otherChannelId := model.NewId()
+		require.NoError(t, s.SetTyping(otherChannelId, model.NewId(), time.Now().Add(-time.Second)))
+		_, err := s.GetTypingUsers(channelId)
+		require.NoError(t, err)
+		require.NotContains(t, s.typing, otherChannelId)
+		require.Len(t, s.typing[channelId], 1)
+	})
+
+	s.Clear()
+	userIds, err = s.GetTypingUsers(channelId)
+	require.NoError(t, err)
+	require.Empty(t, userIds)
+}
+
+func TestGroups(t *testing.T) {
+	s := newStore(t)
+	groupId := model.NewId()
+	userId := model.NewId()
+
+	_, err := s.GroupChannels(groupId)
+	require.ErrorIs(t, err, ErrGroupNotFound)
+	_, err = s.GroupMembers(groupId)
+	require.ErrorIs(t, err, ErrGroupNotFound)
+	require.ErrorIs(t, s.SetGroupMember(groupId, userId), ErrGroupNotFound)
+	require.ErrorIs(t, s.RemoveGroupMember(groupId, userId), ErrGroupNotFound)
+	require.Error(t, s.SetGroupChannels("", nil))
+
+	channelIds := []string{model.NewId(), model.NewId()}
+	require.NoError(t, s.SetGroupChannels(groupId, channelIds))
+	ids, err := s.GroupChannels(groupId)
+	require.NoError(t, err)
+	require.Equal(t, channelIds, ids)
+
+	require.Error(t, s.SetGroupMember(groupId, ""))
+	require.NoError(t, s.SetGroupMember(groupId, userId))
+	members, err := s.GroupMembers(groupId)
+	require.NoError(t, err)
+	require.Equal(t, []string{userId}, members)
+
+	// Members are kept when the synced channels are updated.
+	require.NoError(t, s.SetGroupChannels(groupId, channelIds[:1]))
+	members, err = s.GroupMembers(groupId)
+	require.NoError(t, err)
+	require.Equal(t, []string{userId}, members)
+
+	require.NoError(t, s.RemoveGroupMember(groupId, userId))
+	members, err = s.GroupMembers(groupId)
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	s.Clear()
+	_, err = s.GroupChannels(groupId)
+	require.ErrorIs(t, err, ErrGroupNotFound)
+}
+
+func TestPostsSpill(t *testing.T) {
+	s, err := New(&Config{
+		MaxStoredPosts:          2,
+		MaxStoredUsers:          1,
+		MaxStoredChannelMembers: 1,
+		MaxStoredStatuses:       1,
+		MaxStoredThreads:        1,
+		PostsSpillDir:           t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	posts := make([]*model.Post, 4)
+	for i := range posts {
+		posts[i] = &model.Post{
+			Id:      model.NewId(),
+			UserId:  model.NewId(),
+			Message: fmt.Sprintf("message %d", i),
+		}
+		require.NoError(t, s.SetPost(posts[i]))
+	}
+	require.Len(t, s.posts, 2)
+
+	t.Run("spilled posts are returned", func(t *testing.T) {
+		for _, post := range posts {
+			p, err := s.Post(post.Id)
+			require.NoError(t, err)
+			require.Equal(t, post.Message, p.Message)
+
+			userId, err := s.UserForPost(post.Id)
+			require.NoError(t, err)
+			require.Equal(t, post.UserId, userId)
+		}
+	})
+
+	t.Run("updated post replaces spilled one", func(t *testing.T) {
+		updated := posts[0].Clone()
+		updated.Message = "updated"
+		require.NoError(t, s.SetPost(updated))
+		p, err := s.Post(updated.Id)
+		require.NoError(t, err)
+		require.Equal(t, "updated", p.Message)
+	})
+
+	t.Run("deleted post is not returned", func(t *testing.T) {
+		require.NoError(t, s.DeletePost(posts[1].Id))
+		_, err := s.Post(posts[1].Id)
+		require.ErrorIs(t, err, ErrPostNotFound)
+	})
+
+	t.Run("clear empties the spill", func(t *testing.T) {
+		s.Clear()
+		for _, post := range posts {
+			_, err := s.Post(post.Id)
+			require.ErrorIs(t, err, ErrPostNotFound)
+		}
+	})
+
+	t.Run("failed spill leaves the store unchanged", func(t *testing.T) {
+		s.Clear()
+		require.NoError(t, s.SetPost(posts[0]))
+		require.NoError(t, s.SetPost(posts[1]))
+
+		// Writing to a closed file fails.
+		require.NoError(t, s.postSpill.file.Close())
+		require.Error(t, s.SetPost(posts[2]))
+		require.Len(t, s.posts, 2)
+		require.Contains(t, s.posts, posts[0].Id)
+		require.Contains(t, s.posts, posts[1].Id)
+		require.NotContains(t, s.posts, posts[2].Id)
+		require.Empty(t, s.postSpill.index)
+		// Dropping the broken spill so that a new one gets created.
+		s.postSpill = nil
+	})
+
+	t.Run("close releases the spill", func(t *testing.T) {
+		s.Clear()
+		require.NoError(t, s.Close())
+		require.Nil(t, s.postSpill)
+		require.NoError(t, s.Close())
+
+		// The spill is created again as needed.
+		for _, post := range posts {
+			require.NoError(t, s.SetPost(post))
+		}
+		require.NotNil(t, s.postSpill)
+		p, err := s.Post(posts[0].Id)
+		require.NoError(t, err)
+		require.Equal(t, posts[0].Message, p.Message)
+		require.NoError(t, s.Close())
+	})
+}
+
+func TestPostSpillCompaction(t *testing.T) {
+	ps, err := newPostSpill(t.TempDir())
+	require.NoError(t, err)
+	defer ps.close()
+	ps.compactMinSize = 1
+
+	post := &model.Post{Id: model.NewId(), ChannelId: model.NewId(), Message: "message"}
+	other := &model.Post{Id: model.NewId(), ChannelId: model.NewId(), Message: "other"}
+	require.NoError(t, ps.put(post))
+	require.NoError(t, ps.put(other))
+	size := ps.size
+
+	// Overwriting the same post doesn't make the file grow unbounded.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, ps.put(post))
+		require.LessOrEqual(t, ps.size, 3*ps.live)
+	}
+	require.Equal(t, size, ps.live)
+	require.Less(t, ps.size, 4*size)
+
+	for _, p := range []*model.Post{post, other} {
+		got, err := ps.get(p.Id)
+		require.NoError(t, err)
+		require.Equal(t, p.Message, got.Message)
+	}
+
+	ps.remove(other.Id)
+	require.Less(t, ps.live, size)
+	require.NoError(t, ps.put(post))
+	got, err := ps.get(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, post.Message, got.Message)
+}
+
+func TestPostAcknowledgements(t *testing.T) {
+	s := newStore(t)
+	post := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+	require.NoError(t, s.SetPost(post))
+
+	require.Error(t, s.SetPostAcknowledgement(nil))
+	require.Error(t, s.SetPostAcknowledgement(&store.PostAcknowledgement{PostId: post.Id}))
+
+	acks, err := s.PostAcknowledgements(post.Id)
+	require.NoError(t, err)
+	require.Empty(t, acks)
+
+	userIds := []string{model.NewId(), model.NewId()}
+	require.NoError(t, s.SetPostAcknowledgement(&store.PostAcknowledgement{PostId: post.Id, UserId: userIds[0], AcknowledgedAt: 200}))
+	require.NoError(t, s.SetPostAcknowledgement(&store.PostAcknowledgement{PostId: post.Id, UserId: userIds[1], AcknowledgedAt: 100}))
+	// Acknowledging again replaces the previous acknowledgement.
+	require.NoError(t, s.SetPostAcknowledgement(&store.PostAcknowledgement{PostId: post.Id, UserId: userIds[0], AcknowledgedAt: 300}))
+
+	acks, err = s.PostAcknowledgements(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, []store.PostAcknowledgement{
+		{PostId: post.Id, UserId: userIds[1], AcknowledgedAt: 100},
+		{PostId: post.Id, UserId: userIds[0], AcknowledgedAt: 300},
+	}, acks)
+
+	require.NoError(t, s.DeletePostAcknowledgement(post.Id, userIds[1]))
+	// Deleting a missing acknowledgement is a no-op.
+	require.NoError(t, s.DeletePostAcknowledgement(post.Id, model.NewId()))
+	acks, err = s.PostAcknowledgements(post.Id)
+	require.NoError(t, err)
+	require.Len(t, acks, 1)
+	require.Equal(t, userIds[0], acks[0].UserId)
+
+	t.Run("deleted with the post", func(t *testing.T) {
+		require.NoError(t, s.DeletePost(post.Id))
+		acks, err := s.PostAcknowledgements(post.Id)
+		require.NoError(t, err)
+		require.Empty(t, acks)
+	})
+}
+
+func TestPostFileIds(t *testing.T) {
+	s, err := New(&Config{
+		MaxStoredPosts:          2,
+		MaxStoredUsers:          1,
+		MaxStoredChannelMembers: 1,
+		MaxStoredStatuses:       1,
+		MaxStoredThreads:        1,
+	})
+	require.NoError(t, err)
+
+	require.Error(t, s.SetPostFileIds("", []string{model.NewId()}))
+	_, err = s.GetPostFileIds("")
+	require.Error(t, err)
+
+	postIds := []string{model.NewId(), model.NewId(), model.NewId()}
+	fileIds := []string{model.NewId(), model.NewId()}
+	require.NoError(t, s.SetPostFileIds(postIds[0], fileIds))
+	ids, err := s.GetPostFileIds(postIds[0])
+	require.NoError(t, err)
+	require.Equal(t, fileIds, ids)
+	// The stored ids aren't shared with the callers.
+	want := append([]string{}, fileIds...)
+	fileIds[0] = model.NewId()
+	ids[1] = model.NewId()
+	ids, err = s.GetPostFileIds(postIds[0])
+	require.NoError(t, err)
+	require.Equal(t, want, ids)
+
+	// Posts without files don't fail.
+	ids, err = s.GetPostFileIds(postIds[1])
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	t.Run("eviction", func(t *testing.T) {
+		require.NoError(t, s.SetPostFileIds(postIds[1], []string{model.NewId()}))
+		// Replacing the ids of a known post doesn't evict any.
+		require.NoError(t, s.SetPostFileIds(postIds[1], []string{model.NewId()}))
+		ids, err := s.GetPostFileIds(postIds[0])
+		require.NoError(t, err)
+		require.Len(t, ids, 2)
+
+		require.NoError(t, s.SetPostFileIds(postIds[2], []string{model.NewId()}))
+		ids, err = s.GetPostFileIds(postIds[0])
+		require.NoError(t, err)
+		require.Empty(t, ids)
+		for _, postId := range postIds[1:] {
+			ids, err := s.GetPostFileIds(postId)
+			require.NoError(t, err)
+			require.Len(t, ids, 1)
+		}
+	})
+
+	t.Run("removal", func(t *testing.T) {
+		require.NoError(t, s.SetPostFileIds(postIds[1], nil))
+		ids, err := s.GetPostFileIds(postIds[1])
+		require.NoError(t, err)
+		require.Empty(t, ids)
+		// The removed post no longer counts towards the limit.
+		require.NoError(t, s.SetPostFileIds(postIds[0], []string{model.NewId()}))
+		ids, err = s.GetPostFileIds(postIds[2])
+		require.NoError(t, err)
+		require.Len(t, ids, 1)
+	})
+}
+
+func TestThreadState(t *testing.T) {
+	s := newStore(t)
+	root := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+	require.NoError(t, s.SetPost(root))
+	require.NoError(t, s.SetThread(&model.ThreadResponse{PostId: root.Id, Post: root}))
+
+	require.Error(t, s.SetThreadState("", store.ThreadState{}))
+
+	_, err := s.GetThreadState(root.Id)
+	require.Equal(t, ErrThreadNotFound, err)
+
+	t.Run("root not in store", func(t *testing.T) {
+		rootId := model.NewId()
+		require.NoError(t, s.SetThreadState(rootId, store.ThreadState{UnreadReplies: 1}))
+		_, err := s.GetThreadState(rootId)
+		require.Equal(t, ErrThreadNotFound, err)
+	})
+
+	state := store.ThreadState{LastViewedAt: 100, UnreadReplies: 2, UnreadMentions: 1}
+	require.NoError(t, s.SetThreadState(root.Id, state))
+	stored, err := s.GetThreadState(root.Id)
+	require.NoError(t, err)
+	require.Equal(t, state, stored)
+
+	// The related thread is kept in sync.
+	thread, err := s.Thread(root.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(100), thread.LastViewedAt)
+	require.Equal(t, int64(2), thread.UnreadReplies)
+	require.Equal(t, int64(1), thread.UnreadMentions)
+
+	other := &model.Post{Id: model.NewId(), ChannelId: root.ChannelId}
+	require.NoError(t, s.SetPost(other))
+	require.NoError(t, s.SetThreadState(other.Id, store.ThreadState{LastViewedAt: 200}))
+
+	unread, err := s.GetUnreadThreads()
+	require.NoError(t, err)
+	require.Equal(t, []string{root.Id}, unread)
+
+	t.Run("deleted with the post", func(t *testing.T) {
+		require.NoError(t, s.DeletePost(root.Id))
+		_, err := s.GetThreadState(root.Id)
+		require.Equal(t, ErrThreadNotFound, err)
+		unread, err := s.GetUnreadThreads()
+		require.NoError(t, err)
+		require.Empty(t, unread)
+	})
+}
+
+func TestEmojis(t *testing.T) {
+	s := newStore(t)
+
+	emojis, err := s.Emojis()
+	require.NoError(t, err)
+	require.Empty(t, emojis)
+
+	require.Error(t, s.SetEmoji(nil))
+	require.Error(t, s.SetEmoji(&model.Emoji{Id: model.NewId()}))
+
+	existing := []*model.Emoji{{Id: model.NewId(), Name: "existing"}}
+	require.NoError(t, s.SetEmojis(existing))
+
+	emoji := &model.Emoji{Id: model.NewId(), Name: "added", CreatorId: model.NewId()}
+	require.NoError(t, s.SetEmoji(emoji))
+	// Storing the same emoji again replaces it.
+	renamed := &model.Emoji{Id: emoji.Id, Name: "renamed", CreatorId: emoji.CreatorId}
+	require.NoError(t, s.SetEmoji(renamed))
+
+	emojis, err = s.Emojis()
+	require.NoError(t, err)
+	require.Equal(t, []model.Emoji{*existing[0], *renamed}, emojis)
+	// The slice given to SetEmojis is left untouched.
+	require.Len(t, existing, 1)
+}
+
+func TestUpdateUserRoles(t *testing.T) {
+	s := newStore(t)
+
+	_, err := s.IsSysAdmin()
+	require.Equal(t, ErrUserNotSet, err)
+
+	user := &model.User{Id: model.NewId(), Roles: model.SystemUserRoleId}
+	require.NoError(t, s.SetUser(user))
+	other := &model.User{Id: model.NewId(), Roles: model.SystemUserRoleId}
+	require.NoError(t, s.SetUsers([]*model.User{other}))
+
+	isAdmin, err := s.IsSysAdmin()
+	require.NoError(t, err)
+	require.False(t, isAdmin)
+
+	adminRoles := model.SystemUserRoleId + " " + model.SystemAdminRoleId
+	require.NoError(t, s.UpdateUserRoles(user.Id, adminRoles))
+	isAdmin, err = s.IsSysAdmin()
+	require.NoError(t, err)
+	require.True(t, isAdmin)
+	// The user previously returned is left untouched.
+	require.Equal(t, model.SystemUserRoleId, user.Roles)
+
+	require.NoError(t, s.UpdateUserRoles(other.Id, adminRoles))
+	u, err := s.GetUser(other.Id)
+	require.NoError(t, err)
+	require.Equal(t, adminRoles, u.Roles)
+
+	// Missing users are ignored.
+	require.NoError(t, s.UpdateUserRoles(model.NewId(), adminRoles))
+}
+
+func TestUpdateRole(t *testing.T) {
+	s := newStore(t)
+	role := &model.Role{Id: model.NewId(), Name: "role", Permissions: []string{"read"}}
+	require.NoError(t, s.SetRoles([]*model.Role{role}))
+
+	require.Error(t, s.UpdateRole(nil))
+	require.NoError(t, s.UpdateRole(&model.Role{Id: role.Id, Name: "role", Permissions: []string{"read", "write"}}))
+	// Roles not already stored are ignored.
+	require.NoError(t, s.UpdateRole(&model.Role{Id: model.NewId(), Name: "other"}))
+
+	roles, err := s.Roles()
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	require.Equal(t, []string{"read", "write"}, roles[0].Permissions)
+}
+
+func TestApplyPostWrites(t *testing.T) {
+	s := newStore(t)
+	posts := []*model.Post{
+		{Id: model.NewId(), Message: "first"},
+		{Id: model.NewId(), Message: "second"},
+	}
+
+	require.Error(t, s.ApplyPostWrites([]store.PostWrite{{}}))
+
+	err := s.ApplyPostWrites([]store.PostWrite{
+		{Post: posts[0]},
+		{Post: posts[1]},
+		// The delete is applied after the write it follows.
+		{Post: posts[0], Delete: true},
+		{Post: &model.Post{Id: posts[1].Id, Message: "edited"}},
+	})
+	require.NoError(t, err)
+
+	_, err = s.Post(posts[0].Id)
+	require.ErrorIs(t, err, ErrPostNotFound)
+	p, err := s.Post(posts[1].Id)
+	require.NoError(t, err)
+	require.Equal(t, "edited", p.Message)
+
+	// Storing a post again after deleting it brings it back.
+	err = s.ApplyPostWrites([]store.PostWrite{
+		{Post: posts[1], Delete: true},
+		{Post: posts[1]},
+	})
+	require.NoError(t, err)
+	p, err = s.Post(posts[1].Id)
+	require.NoError(t, err)
+	require.Equal(t, "second", p.Message)
+}
+
+// BenchmarkPostWrites compares storing posts one at a time with applying them
+// in batches, while other goroutines keep reading from the store as
+// controllers do.
+func BenchmarkPostWrites(b *testing.B) {
+	const numReaders = 4
+	for _, batchSize := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("batch size %d", batchSize), func(b *testing.B) {
+			s := newStore(b)
+			post := &model.Post{Id: model.NewId(), Message: "message"}
+			require.NoError(b, s.SetPost(post))
+
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			for i := 0; i < numReaders; i++ {
+				go func() {
+					defer func() { done <- struct{}{} }()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							_, _ = s.Post(post.Id)
+						}
+					}
+				}()
+			}
+
+			posts := make([]*model.Post, b.N)
+			for i := range posts {
+				posts[i] = &model.Post{Id: model.NewId(), Message: "message"}
+			}
+
+			b.ResetTimer()
+			if batchSize == 1 {
+				for _, p := range posts {
+					_ = s.SetPost(p)
+				}
+			} else {
+				writes := make([]store.PostWrite, 0, batchSize)
+				for _, p := range posts {
+					writes = append(writes, store.PostWrite{Post: p})
+					if len(writes) == batchSize {
+						_ = s.ApplyPostWrites(writes)
+						writes = writes[:0]
+					}
+				}
+				if len(writes) > 0 {
+					_ = s.ApplyPostWrites(writes)
+				}
+			}
+			b.StopTimer()
+
+			close(stop)
+			for i := 0; i < numReaders; i++ {
+				<-done
+			}
+		})
+	}
+}
+
+// recordChannelPost records a new post made in the given channel at the given
+// time.
+func recordChannelPost(t *testing.T, s *MemStore, channelId string, at time.Time) {
+	t.Helper()
+	_, err := s.RecordChannelPost(channelId, model.NewId(), at)
+	require.NoError(t, err)
+}
+
+func TestChannelPostRate(t *testing.T) {
+	s := newStore(t)
+	channelId := model.NewId()
+	now := time.Now()
+
+	_, err := s.RecordChannelPost("", model.NewId(), now)
+	require.Error(t, err)
+	_, err = s.RecordChannelPost(channelId, "", now)
+	require.Error(t, err)
+	_, err = s.GetChannelPostRate("")
+	require.Error(t, err)
+
+	rate, err := s.GetChannelPostRate(channelId)
+	require.NoError(t, err)
+	require.Zero(t, rate)
+
+	t.Run("burst", func(t *testing.T) {
+		for i := 0; i < 120; i++ {
+			recordChannelPost(t, s, channelId, now.Add(-10*time.Second))
+		}
+		// Posts older than the window aren't counted.
+		recordChannelPost(t, s, channelId, now.Add(-2*time.Minute))
+
+		rate, err := s.GetChannelPostRate(channelId)
+		require.NoError(t, err)
+		require.Equal(t, float64(120), rate)
+	})
+
+	t.Run("window slides", func(t *testing.T) {
+		otherId := model.NewId()
+		recordChannelPost(t, s, otherId, now.Add(-90*time.Second))
+		recordChannelPost(t, s, otherId, now.Add(-30*time.Second))
+		recordChannelPost(t, s, otherId, now.Add(-20*time.Second))
+
+		rate, err := s.GetChannelPostRate(otherId)
+		require.NoError(t, err)
+		require.Equal(t, float64(2), rate)
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		otherId := model.NewId()
+		postId := model.NewId()
+		counted, err := s.RecordChannelPost(otherId, postId, now.Add(-30*time.Second))
+		require.NoError(t, err)
+		require.True(t, counted)
+		// A post delivered again is only counted once within the window.
+		counted, err = s.RecordChannelPost(otherId, postId, now)
+		require.NoError(t, err)
+		require.False(t, counted)
+
+		rate, err := s.GetChannelPostRate(otherId)
+		require.NoError(t, err)
+		require.Equal(t, float64(1), rate)
+	})
+
+	t.Run("custom window", func(t *testing.T) {
+		s, err := New(&Config{
+			MaxStoredPosts:          1,
+			MaxStoredUsers:          1,
+			MaxStoredChannelMembers: 1,
+			MaxStoredStatuses:       1,
+			MaxStoredThreads:        1,
+			PostRateWindow:          10 * time.Second,
+		})
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			recordChannelPost(t, s, channelId, now.Add(-2*time.Second))
+		}
+		recordChannelPost(t, s, channelId, now.Add(-30*time.Second))
+
+		rate, err := s.GetChannelPostRate(channelId)
+		require.NoError(t, err)
+		require.Equal(t, float64(30), rate)
+	})
+
+	t.Run("inactive channels expire", func(t *testing.T) {
+		s := newStore(t)
+		inactiveId := model.NewId()
+		recordChannelPost(t, s, inactiveId, now.Add(-5*time.Minute))
+		recordChannelPost(t, s, channelId, now)
+		require.Len(t, s.postRates, 1)
+		require.Contains(t, s.postRates, channelId)
+
+		require.NoError(t, s.DeleteChannel(channelId))
+		require.Empty(t, s.postRates)
+	})
+}
+
+func TestKnownChannels(t *testing.T) {
+	s := newStore(t)
+	require.Empty(t, s.KnownChannels())
+
+	stored := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(stored))
+	postedId := model.NewId()
+	recordChannelPost(t, s, postedId, time.Now())
+	// Channels without a recent post aren't known.
+	recordChannelPost(t, s, model.NewId(), time.Now().Add(-2*time.Minute))
+	// Stored channels with posts are only returned once.
+	recordChannelPost(t, s, stored.Id, time.Now())
+
+	expected := []string{stored.Id, postedId}
+	sort.Strings(expected)
+	require.Equal(t, expected, s.KnownChannels())
+
+	t.Run("concurrent inserts", func(t *testing.T) {
+		s := newStore(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				s.SetChannel(&model.Channel{Id: model.NewId()})
+				s.RecordChannelPost(model.NewId(), model.NewId(), time.Now())
+			}
+		}()
+		// Each snapshot is sorted and never shrinks.
+		var last int
+		for {
+			ids := s.KnownChannels()
+			require.True(t, sort.StringsAreSorted(ids))
+			require.GreaterOrEqual(t, len(ids), last)
+			last = len(ids)
+			select {
+			case <-done:
+				require.Len(t, s.KnownChannels(), 200)
+				return
+			default:
+			}
+		}
+	})
+}
+
+func TestPluginStatuses(t *testing.T) {
+	s := newStore(t)
+
+	statuses, err := s.PluginStatuses()
+	require.NoError(t, err)
+	require.Empty(t, statuses)
+
+	require.Error(t, s.SetPluginStatuses(model.PluginStatuses{nil}))
+
+	first := model.PluginStatuses{
+		{PluginId: "com.mattermost.calls", State: model.PluginStateRunning},
+		{PluginId: "com.mattermost.playbooks", State: model.PluginStateRunning},
+	}
+	require.NoError(t, s.SetPluginStatuses(first))
+	// The stored statuses don't change with the given ones.
+	first[0].State = model.PluginStateFailedToStart
+
+	statuses, err = s.PluginStatuses()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	require.Equal(t, model.PluginStateRunning, statuses[0].State)
+
+	// The previous statuses are fully replaced.
+	second := model.PluginStatuses{{PluginId: "com.mattermost.calls", State: model.PluginStateNotRunning}}
+	require.NoError(t, s.SetPluginStatuses(second))
+	statuses, err = s.PluginStatuses()
+	require.NoError(t, err)
+	require.Equal(t, []model.PluginStatus{*second[0]}, statuses)
+}
+
+func TestSidebarCategories(t *testing.T) {
+	s := newStore(t)
+	teamId := model.NewId()
+	newCategory := func(id, name string, channels ...string) *model.SidebarCategoryWithChannels {
+		return &model.SidebarCategoryWithChannels{
+			SidebarCategory: model.SidebarCategory{Id: id, TeamId: teamId, DisplayName: name},
+			Channels:        channels,
+		}
+	}
+
+	require.NoError(t, s.SetCategories(teamId, &model.OrderedSidebarCategories{
+		Categories: model.SidebarCategoriesWithChannels{
+			newCategory("favorites", "Favorites"),
+			newCategory("channels", "Channels", "a", "b"),
+		},
+		Order: model.SidebarCategoryOrder{"favorites", "channels"},
+	}))
+
+	t.Run("missing", func(t *testing.T) {
+		category, err := s.SidebarCategory(teamId, "missing")
+		require.NoError(t, err)
+		require.Nil(t, category)
+		categories, err := s.SidebarCategories(model.NewId())
+		require.NoError(t, err)
+		require.Empty(t, categories.Categories)
+		require.Error(t, s.SetCategory(teamId, nil))
+	})
+
+	t.Run("create", func(t *testing.T) {
+		custom := newCategory("custom", "Custom", "c")
+		require.NoError(t, s.SetCategory(teamId, custom))
+		// The stored category doesn't change with the given one.
+		custom.Channels[0] = "d"
+
+		category, err := s.SidebarCategory(teamId, "custom")
+		require.NoError(t, err)
+		require.Equal(t, "Custom", category.DisplayName)
+		require.Equal(t, []string{"c"}, category.Channels)
+
+		categories, err := s.SidebarCategories(teamId)
+		require.NoError(t, err)
+		require.Equal(t, model.SidebarCategoryOrder{"favorites", "channels", "custom"}, categories.Order)
+	})
+
+	t.Run("rename and reorder", func(t *testing.T) {
+		require.NoError(t, s.SetCategory(teamId, newCategory("channels", "Renamed", "b", "a")))
+		require.NoError(t, s.SetCategoryOrder(teamId, []string{"custom", "channels", "favorites"}))
+
+		categories, err := s.SidebarCategories(teamId)
+		require.NoError(t, err)
+		require.Equal(t, model.SidebarCategoryOrder{"custom", "channels", "favorites"}, categories.Order)
+		require.Len(t, categories.Categories, 3)
+		require.Equal(t, "Renamed", categories.Categories[1].DisplayName)
+		require.Equal(t, []string{"b", "a"}, categories.Categories[1].Channels)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteCategory(teamId, "custom"))
+		category, err := s.SidebarCategory(teamId, "custom")
+		require.NoError(t, err)
+		require.Nil(t, category)
+
+		// The channels of the deleted category aren't moved to another one.
+		categories, err := s.SidebarCategories(teamId)
+		require.NoError(t, err)
+		require.Equal(t, model.SidebarCategoryOrder{"channels", "favorites"}, categories.Order)
+		for _, category := range categories.Categories {
+			require.NotContains(t, category.Channels, "c")
+		}
+	})
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package store
+
+import (
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// PostWrite is a single post write, as applied in batches by ApplyPostWrites.
+// The post is stored, or the post with its id is deleted if Delete is true.
+type PostWrite struct {
+	Post   *model.Post
+	Delete bool
+}
@@ -4,6 +4,8 @@
 package store
 
 import (
+	"time"
+
 	"github.com/mattermost/mattermost-server/v6/model"
 )
 
@@ -39,6 +41,11 @@ type UserStore interface {
 	Config() model.Config
 	// ClientConfig returns the partial server configuration settings for logged in user.
 	ClientConfig() map[string]string
+	// ClientLicense returns the client license of the server.
+	ClientLicense() map[string]string
+	// IsFeatureLicensed returns whether the stored client license enables the
+	// given feature, e.g. "MessageExport".
+	IsFeatureLicensed(feature string) bool
 	// Channel returns the channel for the given channelId.
 	Channel(channelId string) (*model.Channel, error)
 	// Channels returns the channels for a team.
@@ -53,14 +60,40 @@ type UserStore interface {
 	ChannelPostsSorted(channelId string, asc bool) ([]*model.Post, error)
 	// ChannelView returns the timestamp of the last view for the given channelId.
 	ChannelView(channelId string) (int64, error)
+	// RecentlyViewedChannels returns the ids of up to limit channels, sorted
+	// from the most to the least recently viewed.
+	RecentlyViewedChannels(limit int) ([]string, error)
+	// ChannelLastPostAt returns the timestamp (in milliseconds) of the most
+	// recently created or updated post stored for the given channelId.
+	ChannelLastPostAt(channelId string) (int64, error)
+	// GetChannelPostRate returns the number of posts per minute recently
+	// made in the given channelId.
+	GetChannelPostRate(channelId string) (float64, error)
+	// KnownChannels returns the sorted ids of the channels the user knows
+	// about, i.e. the stored ones and the ones posts were recently received
+	// for.
+	KnownChannels() []string
 	// ChannelStats returns statistics for the given channelId.
 	ChannelStats(channelId string) (*model.ChannelStats, error)
+	// ChannelBookmarks returns the bookmarks for the given channelId.
+	ChannelBookmarks(channelId string) ([]ChannelBookmark, error)
 
 	// GetUser returns the user for the given userId.
 	GetUser(userId string) (model.User, error)
 
 	// Status returns the status for the given userId.
 	Status(userId string) (model.Status, error)
+	// OnlineUsers returns the ids of the users whose stored status is online.
+	OnlineUsers() ([]string, error)
+	// GetTypingUsers returns the ids of the users currently typing in the
+	// given channelId.
+	GetTypingUsers(channelId string) ([]string, error)
+
+	// GroupChannels returns the ids of the channels synced with the given
+	// groupId.
+	GroupChannels(groupId string) ([]string, error)
+	// GroupMembers returns the ids of the stored members of the given groupId.
+	GroupMembers(groupId string) ([]string, error)
 
 	// teams
 	// Teams returns the teams a user belong to.
@@ -74,9 +107,18 @@ type UserStore interface {
 	Preferences() (model.Preferences, error)
 	// Roles returns the roles of the user.
 	Roles() ([]model.Role, error)
+	// IsSysAdmin returns whether the stored user is a system admin.
+	IsSysAdmin() (bool, error)
 
 	// Reactions returns the reactions for the specified post.
 	Reactions(postId string) ([]model.Reaction, error)
+	// PostAcknowledgements returns the acknowledgements for the specified
+	// post, sorted by time.
+	PostAcknowledgements(postId string) ([]PostAcknowledgement, error)
+	// Emojis returns the stored custom emojis.
+	Emojis() ([]model.Emoji, error)
+	// PluginStatuses returns the latest statuses of the server plugins.
+	PluginStatuses() ([]model.PluginStatus, error)
 
 	// random utils
 	// RandomChannel returns a random channel for the given teamId
@@ -120,6 +162,9 @@ type UserStore interface {
 	UserForPost(postId string) (string, error)
 	// FileInfoForPost returns the FileInfo for the specified post, if any.
 	FileInfoForPost(postId string) ([]*model.FileInfo, error)
+	// GetPostFileIds returns the ids of the files attached to the specified
+	// post, if any. The post itself doesn't need to be stored.
+	GetPostFileIds(postId string) ([]string, error)
 	// PostsIdsSince returns a list of post ids for posts created
 	// after a specified timestamp in milliseconds.
 	PostsIdsSince(ts int64) ([]string, error)
@@ -131,6 +176,20 @@ type UserStore interface {
 	Thread(threadId string) (*model.ThreadResponse, error)
 	// ThreadsSorted returns all threads, sorted by LastReplyAt
 	ThreadsSorted(unreadOnly, asc bool) ([]*model.ThreadResponse, error)
+	// GetThreadState returns the read state of the thread with the given
+	// root post id.
+	GetThreadState(rootId string) (ThreadState, error)
+	// GetUnreadThreads returns the sorted root post ids of the threads having
+	// unread replies.
+	GetUnreadThreads() ([]string, error)
+
+	// SidebarCategories
+	// SidebarCategory returns the sidebar category with the given id in the
+	// given team, or nil if it isn't stored.
+	SidebarCategory(teamID, categoryID string) (*model.SidebarCategoryWithChannels, error)
+	// SidebarCategories returns the sidebar categories of the given team, in
+	// the stored order.
+	SidebarCategories(teamID string) (*model.OrderedSidebarCategories, error)
 }
 
 // MutableUserStore is a super-set of UserStore which, apart from providing
@@ -142,6 +201,10 @@ type MutableUserStore interface {
 	// user object and state information (current team/channel) which are preserved.
 	Clear()
 
+	// Close releases the resources held by the store, such as open files.
+	// The store can still be used afterwards.
+	Close() error
+
 	// server
 	// SetConfig stores the given configuration settings.
 	SetConfig(*model.Config)
@@ -153,20 +216,39 @@ type MutableUserStore interface {
 	SetUser(user *model.User) error
 	// User returns the stored user.
 	User() (*model.User, error)
+	// UpdateUserRoles sets the roles of the user with the given id, if
+	// stored.
+	UpdateUserRoles(userId, roles string) error
 	// SetUsers stores the given users.
 	SetUsers(users []*model.User) error
 
 	// statuses
 	// SetStatus stores the status for the given userId.
 	SetStatus(userId string, status *model.Status) error
+	// SetTyping records that the given userId is typing in the given
+	// channelId until expiresAt.
+	SetTyping(channelId, userId string, expiresAt time.Time) error
+	// RecordChannelPost counts the post with the given postId, made in the
+	// given channelId at the given time, towards the channel's post rate,
+	// unless already counted. It returns whether the post was counted.
+	RecordChannelPost(channelId, postId string, at time.Time) (bool, error)
 
 	// posts
 	// SetPost stores the given post.
 	SetPost(post *model.Post) error
+	// SetPostFileIds stores the ids of the files attached to the specified
+	// post. An empty list removes them.
+	SetPostFileIds(postId string, fileIds []string) error
+	// UpdateThreadOnReply updates the root post, and related thread, of the
+	// given reply.
+	UpdateThreadOnReply(reply *model.Post) error
 	// DeletePost deletes the specified post.
 	DeletePost(postId string) error
 	// SetPosts stores the given posts.
 	SetPosts(posts []*model.Post) error
+	// ApplyPostWrites stores or deletes the given posts, in order, as a
+	// single update.
+	ApplyPostWrites(writes []PostWrite) error
 
 	// reactions
 	// SetReactions stores the given reactions for the specified post.
@@ -177,19 +259,45 @@ type MutableUserStore interface {
 	// It returns whether or not the reaction was deleted.
 	DeleteReaction(reaction *model.Reaction) (bool, error)
 
+	// acknowledgements
+	// SetPostAcknowledgement stores the given post acknowledgement,
+	// replacing any previous one by the same user.
+	SetPostAcknowledgement(ack *PostAcknowledgement) error
+	// DeletePostAcknowledgement deletes the acknowledgement of the given
+	// post by the given user.
+	DeletePostAcknowledgement(postId, userId string) error
+
 	// preferences
 	// Preferences stores the preferences for the stored user.
 	SetPreferences(preferences model.Preferences) error
+	// UpsertPreferences stores the given preferences for the stored user,
+	// replacing the ones with a matching category and name.
+	UpsertPreferences(preferences model.Preferences) error
 
 	// channels
 	SetChannel(channel *model.Channel) error
 	// SetChannels adds the given channels to the store.
 	SetChannels(channels []*model.Channel) error
+	// SetChannelIfMissing stores the given channel unless a channel with the
+	// same id is already stored. It returns whether the channel was stored.
+	SetChannelIfMissing(channel *model.Channel) (bool, error)
+	// DeleteChannel removes the given channel and any related data from the
+	// store.
+	DeleteChannel(channelId string) error
 	// SetCurrentChannel stores the channel the user is currently viewing.
 	SetCurrentChannel(channel *model.Channel) error
 	// SetChannelView marks the given channel as viewed and updates the store with the
 	// current timestamp.
 	SetChannelView(channelId string) error
+	// MarkChannelRead resets the unread message and mention counts for the
+	// given channel.
+	MarkChannelRead(channelId string) error
+	// SetChannelUnread updates the unread message and mention counts for the
+	// given channel.
+	SetChannelUnread(channelId string, unread *model.ChannelUnreadAt) error
+	// IncrementMentionCount increments the mention count for the given
+	// channel, and the root one as well if isRoot is true.
+	IncrementMentionCount(channelId string, isRoot bool) error
 	// SetChannelMembers stores the given channel members in the store.
 	SetChannelMembers(channelMembers model.ChannelMembers) error
 	// ChannelMembers returns a list of members for the specified channel.
@@ -198,8 +306,26 @@ type MutableUserStore interface {
 	SetChannelMember(channelId string, channelMember *model.ChannelMember) error
 	// RemoveChannelMember removes the channel member for the specified channel and user.
 	RemoveChannelMember(channelId string, userId string) error
+
+	// groups
+	// SetGroupChannels stores the given groupId along with the ids of the
+	// channels synced with it.
+	SetGroupChannels(groupId string, channelIds []string) error
+	// SetGroupMember stores the given userId as a member of the given
+	// groupId.
+	SetGroupMember(groupId, userId string) error
+	// RemoveGroupMember removes the given userId from the members of the
+	// given groupId.
+	RemoveGroupMember(groupId, userId string) error
+
 	// SetChannelStats stores statistics for the given channelId.
 	SetChannelStats(channelId string, stats *model.ChannelStats) error
+	// SetChannelBookmarks replaces the bookmarks for the given channelId.
+	SetChannelBookmarks(channelId string, bookmarks []*ChannelBookmark) error
+	// SetChannelBookmark stores the given channel bookmark.
+	SetChannelBookmark(bookmark *ChannelBookmark) error
+	// DeleteChannelBookmark deletes the specified bookmark from the given channelId.
+	DeleteChannelBookmark(channelId, bookmarkId string) error
 
 	// teams
 	SetTeam(team *model.Team) error
@@ -207,6 +333,9 @@ type MutableUserStore interface {
 	Team(teamId string) (*model.Team, error)
 	// SetTeams stores the given teams.
 	SetTeams(teams []*model.Team) error
+	// DeleteTeam removes the given team and the channels belonging to it
+	// from the store.
+	DeleteTeam(teamId string) error
 	// SetCurrentTeam sets the currently selected team for the user.
 	SetCurrentTeam(team *model.Team) error
 	// SetTeamMember stores the given team member.
@@ -219,10 +348,21 @@ type MutableUserStore interface {
 	// roles
 	// SetRoles stores the given roles.
 	SetRoles(roles []*model.Role) error
+	// UpdateRole replaces the stored role with the same id as the given one.
+	// It's a no-op if no such role is stored.
+	UpdateRole(role *model.Role) error
 
 	// emoji
 	// SetEmojis stores the given emojis.
 	SetEmojis(emoji []*model.Emoji) error
+	// SetEmoji stores the given emoji, replacing any previous one with the
+	// same id.
+	SetEmoji(emoji *model.Emoji) error
+
+	// plugins
+	// SetPluginStatuses replaces the stored plugin statuses with the given
+	// ones.
+	SetPluginStatuses(statuses model.PluginStatuses) error
 
 	// license
 	// SetLicense stores the given license in the store.
@@ -240,7 +380,19 @@ type MutableUserStore interface {
 	SetThreads(threads []*model.ThreadResponse) error
 	// MarkAllThreadsInTeamAsRead marks all threads in the given team as read
 	MarkAllThreadsInTeamAsRead(teamId string) error
+	// SetThreadState stores the read state of the thread with the given root
+	// post id. It's a no-op if the root post is missing from the store.
+	SetThreadState(rootId string, state ThreadState) error
 
 	// SidebarCategories
 	SetCategories(teamID string, sidebarCategories *model.OrderedSidebarCategories) error
+	// SetCategory stores the given sidebar category of the given team,
+	// replacing the one with the same id, if any.
+	SetCategory(teamID string, category *model.SidebarCategoryWithChannels) error
+	// SetCategoryOrder stores the order of the sidebar categories of the
+	// given team.
+	SetCategoryOrder(teamID string, order []string) error
+	// DeleteCategory removes the given sidebar category of the given team,
+	// leaving its channels uncategorized.
+	DeleteCategory(teamID, categoryID string) error
 }
@@ -0,0 +1,12 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package store
+
+// ThreadState holds the read state of a followed thread, as tracked through
+// thread related WebSocket events.
+type ThreadState struct {
+	LastViewedAt   int64
+	UnreadReplies  int64
+	UnreadMentions int64
+}
@@ -4,9 +4,13 @@
 package user
 
 import (
+	"context"
+	"io"
 	"regexp"
+	"time"
 
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/websocket"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 )
@@ -21,20 +25,44 @@ type User interface {
 	// store
 	// Store exposes the underlying UserStore.
 	Store() store.UserStore
-	// ClearUserData calls the Clear method on the underlying UserStore.
+	// ClearUserData calls the Clear method on the underlying UserStore, also
+	// releasing the resources it holds.
 	ClearUserData()
 
+	// Now returns the current time as seen by the user's client. It should
+	// be used to build any timestamp sent to the server.
+	Now() time.Time
+
 	// websocket
 	// Connect creates a WebSocket connection to the server and starts listening for messages.
 	Connect() (<-chan error, error)
+	// ConnectWithContext is like Connect, except that the given context can
+	// be cancelled to stop retrying to establish the connection.
+	ConnectWithContext(ctx context.Context) (<-chan error, error)
 	// Disconnect closes the WebSocket connection.
 	Disconnect() error
+	// DisconnectGraceful closes the WebSocket connection after delivering
+	// the events already received, for up to a configured deadline.
+	DisconnectGraceful() error
 	// Events returns the WebSocket event chan for the controller
 	// to listen and react to events.
 	Events() <-chan *model.WebSocketEvent
+	// Subscribe returns a channel receiving a copy of the WebSocket events,
+	// along with a function cancelling the subscription. The channel is
+	// closed once cancelled or on disconnect.
+	Subscribe() (<-chan *model.WebSocketEvent, func())
+	// StoreHealthy returns whether the user's store is believed to be in
+	// sync with the server. A store is unhealthy after repeated WebSocket
+	// sequence mismatches, until enough events are received in sequence.
+	StoreHealthy() bool
 	// SendTypingEvent will push a user_typing event out to all connected users
 	// who are in the specified channel.
 	SendTypingEvent(channelId, parentId string) error
+	// SendWebSocketAction queues the given action to be run with the live
+	// WebSocket connection by the goroutine listening on it. Actions are run
+	// on a best-effort basis: the ones still queued on disconnect or while
+	// reconnecting are dropped.
+	SendWebSocketAction(action func(client *websocket.Client) error) error
 
 	//server
 	// GetConfig fetches and stores the server's configuration.
@@ -54,6 +82,9 @@ type User interface {
 	Login() error
 	// Logout logs the user out. It terminates the current user's session.
 	Logout() error
+	// RefreshSession replaces the current user's session with a new one,
+	// revoking the previous session once the new token is in use.
+	RefreshSession() error
 	// GetMe loads user's information into the store and returns its id.
 	GetMe() (string, error)
 	// GetPreferences fetches and store the user's preferences.
@@ -66,6 +97,8 @@ type User interface {
 	UpdateUser(user *model.User) error
 	// UpdateUserRoles updates the given userId with the given role ids.
 	UpdateUserRoles(userId, roles string) error
+	// UpdateUserActive activates or deactivates the specified user's account.
+	UpdateUserActive(userId string, active bool) error
 	// PatchUser patches a given user with the given information.
 	PatchUser(userId string, patch *model.UserPatch) error
 	// GetUsersByIds fetches and stores the specified users.
@@ -123,8 +156,12 @@ type User interface {
 	// GetPostsSince fetches and stores posts in a given channelId that were made
 	// since the given time. It returns a list of posts ids.
 	GetPostsSince(channelId string, time int64, collapsedThreads bool) ([]string, error)
-	// GetPinnedPosts fetches and returns pinned posts in a given channelId.
+	// GetPinnedPosts fetches, stores and returns pinned posts in a given
+	// channelId.
 	GetPinnedPosts(channelId string) (*model.PostList, error)
+	// GetFlaggedPosts fetches, stores and returns the posts flagged by the
+	// user across all teams.
+	GetFlaggedPosts(page, perPage int) (*model.PostList, error)
 	// GetPostsAroundLastUnread fetches and stores the posts made around last
 	// unread in a given channelId. It returns a list of posts ids.
 	GetPostsAroundLastUnread(channelId string, limitBefore, limitAfter int, collapsedThreads bool) ([]string, error)
@@ -138,6 +175,16 @@ type User interface {
 	GetFileThumbnail(fileId string) error
 	// GetFilePreview fetches the preview for the specified file.
 	GetFilePreview(fileId string) error
+	// SearchFilesInChannel returns the specified page of the files shared in
+	// the given channel, as listed by the channel files view.
+	SearchFilesInChannel(teamId, channelName string, page, perPage int) (*model.FileInfoList, error)
+	// CreateUpload creates a new upload session.
+	CreateUpload(us *model.UploadSession) (*model.UploadSession, error)
+	// GetUpload returns the upload session for the specified uploadId.
+	GetUpload(uploadId string) (*model.UploadSession, error)
+	// UploadData uploads a chunk of data for the specified uploadId. It returns
+	// a FileInfo object once the upload has completed, nil otherwise.
+	UploadData(uploadId string, data io.Reader) (*model.FileInfo, error)
 
 	// channels
 	// CreateChannel creates and stores a new channel with the given information.
@@ -166,6 +213,8 @@ type User interface {
 	SearchGroupChannels(search *model.ChannelSearch) ([]*model.Channel, error)
 	// RemoveUserFromChannel removes the specified user from the specified channel.
 	RemoveUserFromChannel(channelId, userId string) error
+	// DeleteChannel archives the specified channel and removes it from the store.
+	DeleteChannel(channelId string) error
 	// ViewChannels performs a channel view for the user.
 	ViewChannel(view *model.ChannelView) (*model.ChannelViewResponse, error)
 	// GetChannelUnread fetches and returns information about the specified channel's unread
@@ -181,6 +230,13 @@ type User interface {
 	GetChannelMember(channelId string, userId string) error
 	// GetChannelStats fetches statistics for the specified channel.
 	GetChannelStats(channelId string) error
+	// GetChannelBookmarks fetches and stores the bookmarks for the specified
+	// channel.
+	GetChannelBookmarks(channelId string) error
+	// CreateChannelBookmark creates and stores the given channel bookmark.
+	CreateChannelBookmark(bookmark *store.ChannelBookmark) error
+	// DeleteChannelBookmark deletes the specified bookmark from the given channel.
+	DeleteChannelBookmark(channelId, bookmarkId string) error
 	// AddChannelMember adds the specified user to the specified channel.
 	AddChannelMember(channelId, userId string) error
 	// GetChannelsForTeamForUser fetches and stores chanels for the specified user in
@@ -257,6 +313,9 @@ type User interface {
 	SetCurrentTeam(team *model.Team) error
 	// SetCurrentChannel sets the given channel as the current channel for the user.
 	SetCurrentChannel(channel *model.Channel) error
+	// KnownChannels returns the sorted ids of the channels the user knows
+	// about, including the ones it only received posts for.
+	KnownChannels() []string
 
 	// System console functionalities
 	// GetLogs fetches the server logs.
@@ -306,4 +365,25 @@ type User interface {
 
 	// CreatePostReminder creates a post reminder at a given target time.
 	CreatePostReminder(userID, postID string, targetTime int64) error
+
+	// ListAutocompleteCommands returns the slash commands available to the
+	// user in the specified team.
+	ListAutocompleteCommands(teamId string) ([]*model.Command, error)
+
+	// ExecuteCommand executes the given slash command in the specified channel.
+	ExecuteCommand(channelId, command string) (*model.CommandResponse, error)
+
+	// GetProxiedImage fetches the given image through the server's image proxy.
+	GetProxiedImage(imageURL string) error
+
+	// SearchGifs searches for GIFs matching the given term through the
+	// Gfycat-compatible search API at searchURL, returning the URLs of the
+	// results.
+	SearchGifs(searchURL, term string) ([]string, error)
+
+	// Jobs
+	// CreateJob creates the given job on the server.
+	CreateJob(job *model.Job) (*model.Job, error)
+	// GetJob fetches the specified job.
+	GetJob(jobId string) (*model.Job, error)
 }
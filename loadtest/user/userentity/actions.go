@@ -4,9 +4,20 @@
 package userentity
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
 
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
 )
 
 // SignUp signs up the user with the given credentials.
@@ -33,11 +44,23 @@ func (ue *UserEntity) Login() error {
 		return err
 	}
 
-	loggedUser, _, err := ue.client.Login(user.Email, user.Password)
+	var loggedUser *model.User
+	if ue.config.DeviceId != "" {
+		loggedUser, _, err = ue.client.LoginWithDevice(user.Email, user.Password, ue.config.DeviceId)
+	} else {
+		loggedUser, _, err = ue.client.Login(user.Email, user.Password)
+	}
 	if err != nil {
 		return err
 	}
 
+	if ue.config.Locale != "" && loggedUser.Locale != ue.config.Locale {
+		loggedUser, _, err = ue.client.PatchUser(loggedUser.Id, &model.UserPatch{Locale: &ue.config.Locale})
+		if err != nil {
+			return fmt.Errorf("failed to set locale: %w", err)
+		}
+	}
+
 	// We need to set user again because the user ID does not get set
 	// if a user is already signed up.
 	if err := ue.store.SetUser(loggedUser); err != nil {
@@ -57,6 +80,29 @@ func (ue *UserEntity) Logout() error {
 	return nil
 }
 
+// RefreshSession replaces the current user's session with a new one,
+// revoking the previous session once the new token is in use. Any subsequent
+// request, including WebSocket reconnects, uses the new token.
+func (ue *UserEntity) RefreshSession() error {
+	oldToken := ue.client.AuthToken
+	if oldToken == "" {
+		return errors.New("user is not authenticated")
+	}
+
+	if err := ue.Login(); err != nil {
+		return err
+	}
+
+	oldClient := model.NewAPIv4Client(ue.config.ServerURL)
+	oldClient.HTTPClient = ue.client.HTTPClient
+	oldClient.SetToken(oldToken)
+	if _, err := oldClient.Logout(); err != nil {
+		return fmt.Errorf("failed to revoke previous session: %w", err)
+	}
+
+	return nil
+}
+
 // GetClientConfig fetches and stores the limited server's configuration for logged in user.
 func (ue *UserEntity) GetClientConfig() error {
 	config, _, err := ue.client.GetOldClientConfig("")
@@ -162,6 +208,16 @@ func (ue *UserEntity) UpdateUserRoles(userId, roles string) error {
 	return nil
 }
 
+// UpdateUserActive activates or deactivates the specified user's account.
+func (ue *UserEntity) UpdateUserActive(userId string, active bool) error {
+	_, err := ue.client.UpdateUserActive(userId, active)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // PatchUser patches a given user with the given information.
 func (ue *UserEntity) PatchUser(userId string, patch *model.UserPatch) error {
 	user, _, err := ue.client.PatchUser(userId, patch)
@@ -187,8 +243,22 @@ func (ue *UserEntity) CreatePost(post *model.Post) (string, error) {
 	post.PendingPostId = model.NewId()
 	post.UserId = user.Id
 
+	pendingId := post.PendingPostId
+	if ue.delivery != nil {
+		ue.delivery.TrackSent(pendingId, post.ChannelId)
+	}
+	if ue.pendingPosts != nil {
+		ue.pendingPosts.add(pendingId, time.Now())
+	}
+
 	post, _, err = ue.client.CreatePost(post)
 	if err != nil {
+		if ue.delivery != nil {
+			ue.delivery.Untrack(pendingId)
+		}
+		if ue.pendingPosts != nil {
+			ue.pendingPosts.remove(pendingId)
+		}
 		return "", err
 	}
 
@@ -287,13 +357,87 @@ func (ue *UserEntity) GetPostsSince(channelId string, time int64, collapsedThrea
 	return postList.Order, ue.store.SetPosts(postListToSlice(postList))
 }
 
-// GetPinnedPosts fetches and returns pinned posts in a given channelId.
+// GetPinnedPosts fetches, stores and returns pinned posts in a given
+// channelId.
 func (ue *UserEntity) GetPinnedPosts(channelId string) (*model.PostList, error) {
 	postList, _, err := ue.client.GetPinnedPosts(channelId, "")
 	if err != nil {
 		return nil, err
 	}
-	return postList, nil
+	if postList == nil || len(postList.Posts) == 0 {
+		return postList, nil
+	}
+	return postList, ue.store.SetPosts(postListToSlice(postList))
+}
+
+// GetFlaggedPosts fetches, stores and returns the posts flagged by the user
+// across all teams.
+func (ue *UserEntity) GetFlaggedPosts(page, perPage int) (*model.PostList, error) {
+	user, err := ue.getUserFromStore()
+	if err != nil {
+		return nil, err
+	}
+
+	postList, _, err := ue.client.GetFlaggedPostsForUser(user.Id, page, perPage)
+	if err != nil {
+		return nil, err
+	}
+	if postList == nil || len(postList.Posts) == 0 {
+		return postList, nil
+	}
+	return postList, ue.store.SetPosts(postListToSlice(postList))
+}
+
+// RefreshCurrentChannel fetches the posts made in the current channel since
+// the most recent one in the store, or its latest posts if none is stored,
+// and reconciles them with the store: deleted posts are removed and the others
+// are stored. It's meant to catch up after missing WebSocket events, e.g. from
+// the missed events handler. It's a no-op if no channel is being viewed.
+func (ue *UserEntity) RefreshCurrentChannel() error {
+	return ue.refreshCurrentChannel(ue.client)
+}
+
+// refreshCurrentChannel is like RefreshCurrentChannel, using the given client.
+func (ue *UserEntity) refreshCurrentChannel(client *model.Client4) error {
+	channel, err := ue.store.CurrentChannel()
+	if errors.Is(err, memstore.ErrChannelNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get current channel from store: %w", err)
+	}
+
+	since, err := ue.store.ChannelLastPostAt(channel.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get last post time from store: %w", err)
+	}
+
+	var postList *model.PostList
+	if since == 0 {
+		postList, _, err = client.GetPostsForChannel(channel.Id, 0, refreshPostsPerPage, "", false)
+	} else {
+		postList, _, err = client.GetPostsSince(channel.Id, since, false)
+	}
+	if err != nil {
+		return err
+	}
+	if postList == nil {
+		return nil
+	}
+
+	var posts []*model.Post
+	for _, post := range postsMapToSlice(postList.Posts) {
+		if post.DeleteAt != 0 {
+			if err := ue.store.DeletePost(post.Id); err != nil {
+				return err
+			}
+			continue
+		}
+		posts = append(posts, post)
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+	return ue.store.SetPosts(posts)
 }
 
 // GetPostsAroundLastUnread fetches and stores the posts made around last
@@ -383,6 +527,15 @@ func (ue *UserEntity) RemoveUserFromChannel(channelId, userId string) error {
 	return ue.store.RemoveChannelMember(channelId, userId)
 }
 
+// DeleteChannel archives the specified channel and removes it from the store.
+func (ue *UserEntity) DeleteChannel(channelId string) error {
+	_, err := ue.client.DeleteChannel(channelId)
+	if err != nil {
+		return err
+	}
+	return ue.store.DeleteChannel(channelId)
+}
+
 // AddChannelMember adds the specified user to the specified channel.
 func (ue *UserEntity) AddChannelMember(channelId, userId string) error {
 	member, _, err := ue.client.AddChannelMember(channelId, userId)
@@ -562,6 +715,55 @@ func (ue *UserEntity) GetChannelStats(channelId string) error {
 	return ue.store.SetChannelStats(channelId, stats)
 }
 
+// GetChannelBookmarks fetches and stores the bookmarks for the specified
+// channel.
+func (ue *UserEntity) GetChannelBookmarks(channelId string) error {
+	resp, err := ue.client.DoAPIGet(channelBookmarksRoute(channelId)+"?bookmarks_since=0", "")
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+
+	var bookmarks []*store.ChannelBookmark
+	if err := json.NewDecoder(resp.Body).Decode(&bookmarks); err != nil {
+		return err
+	}
+
+	return ue.store.SetChannelBookmarks(channelId, bookmarks)
+}
+
+// CreateChannelBookmark creates and stores the given channel bookmark.
+func (ue *UserEntity) CreateChannelBookmark(bookmark *store.ChannelBookmark) error {
+	data, err := json.Marshal(bookmark)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ue.client.DoAPIPostBytes(channelBookmarksRoute(bookmark.ChannelId), data)
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+
+	var created store.ChannelBookmark
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return err
+	}
+
+	return ue.store.SetChannelBookmark(&created)
+}
+
+// DeleteChannelBookmark deletes the specified bookmark from the given channel.
+func (ue *UserEntity) DeleteChannelBookmark(channelId, bookmarkId string) error {
+	resp, err := ue.client.DoAPIDelete(channelBookmarksRoute(channelId) + "/" + bookmarkId)
+	if err != nil {
+		return err
+	}
+	closeBody(resp)
+
+	return ue.store.DeleteChannelBookmark(channelId, bookmarkId)
+}
+
 // AutocompleteChannelsForTeam fetches and stores an ordered list of channels for a given
 // name in a specified team.
 func (ue *UserEntity) AutocompleteChannelsForTeam(teamId, name string) error {
@@ -834,6 +1036,37 @@ func (ue *UserEntity) UploadFile(data []byte, channelId, filename string) (*mode
 	return fresp, nil
 }
 
+// CreateUpload creates a new upload session.
+func (ue *UserEntity) CreateUpload(us *model.UploadSession) (*model.UploadSession, error) {
+	session, _, err := ue.client.CreateUpload(us)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// GetUpload returns the upload session for the specified uploadId.
+func (ue *UserEntity) GetUpload(uploadId string) (*model.UploadSession, error) {
+	session, _, err := ue.client.GetUpload(uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// UploadData uploads a chunk of data for the specified uploadId. It returns
+// a FileInfo object once the upload has completed, nil otherwise.
+func (ue *UserEntity) UploadData(uploadId string, data io.Reader) (*model.FileInfo, error) {
+	info, _, err := ue.client.UploadData(uploadId, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
 // GetFileInfosForPost returns file information for the specified post.
 func (ue *UserEntity) GetFileInfosForPost(postId string) ([]*model.FileInfo, error) {
 	infos, _, err := ue.client.GetFileInfosForPost(postId, "")
@@ -852,6 +1085,23 @@ func (ue *UserEntity) GetFileThumbnail(fileId string) error {
 	return nil
 }
 
+// SearchFilesInChannel returns the specified page of the files shared in
+// the given channel, as listed by the channel files view.
+func (ue *UserEntity) SearchFilesInChannel(teamId, channelName string, page, perPage int) (*model.FileInfoList, error) {
+	terms := "in:" + channelName
+	isOrSearch := false
+	list, _, err := ue.client.SearchFilesWithParams(teamId, &model.SearchParameter{
+		Terms:      &terms,
+		IsOrSearch: &isOrSearch,
+		Page:       &page,
+		PerPage:    &perPage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 // GetFilePreview fetches the preview for the specified file.
 func (ue *UserEntity) GetFilePreview(fileId string) error {
 	_, _, err := ue.client.GetFilePreview(fileId)
@@ -1077,14 +1327,52 @@ func (ue *UserEntity) SetCurrentTeam(team *model.Team) error {
 	return ue.store.SetCurrentTeam(team)
 }
 
+// KnownChannels returns the sorted ids of the channels the user knows about,
+// including the ones it only received posts for. It's safe to call while
+// events are being handled.
+func (ue *UserEntity) KnownChannels() []string {
+	return ue.store.KnownChannels()
+}
+
+// currentChannelEntry records when a channel became the current one.
+type currentChannelEntry struct {
+	channelId string
+	since     time.Time
+}
+
 // SetCurrentChannel sets the given channel as the current channel for the user.
 func (ue *UserEntity) SetCurrentChannel(channel *model.Channel) error {
-	return ue.store.SetCurrentChannel(channel)
+	if err := ue.store.SetCurrentChannel(channel); err != nil {
+		return err
+	}
+	// Setting the same channel again doesn't reset the time spent in it.
+	if entry, _ := ue.currentChannel.Load().(*currentChannelEntry); entry == nil || entry.channelId != channel.Id {
+		ue.currentChannel.Store(&currentChannelEntry{channelId: channel.Id, since: ue.clock.Now()})
+	}
+	return nil
 }
 
-// ClearUserData calls the Clear method on the underlying UserStore.
+// dwelledInChannel returns whether the given channel has been the current one
+// for at least the configured dwell time. Channels not set through
+// SetCurrentChannel are assumed to have been.
+func (ue *UserEntity) dwelledInChannel(channelId string) bool {
+	if ue.config.CurrentChannelDwell <= 0 {
+		return true
+	}
+	entry, _ := ue.currentChannel.Load().(*currentChannelEntry)
+	if entry == nil || entry.channelId != channelId {
+		return true
+	}
+	return ue.clock.Now().Sub(entry.since) >= ue.config.CurrentChannelDwell
+}
+
+// ClearUserData calls the Clear method on the underlying UserStore, also
+// releasing the resources it holds.
 func (ue *UserEntity) ClearUserData() {
 	ue.store.Clear()
+	if err := ue.store.Close(); err != nil {
+		mlog.Warn("userentity: failed to close store", mlog.Err(err))
+	}
 }
 
 // GetLogs fetches the server logs.
@@ -1373,3 +1661,91 @@ func (ue *UserEntity) CreatePostReminder(userID, postID string, targetTime int64
 	}
 	return nil
 }
+
+// ListAutocompleteCommands returns the slash commands available to the user in
+// the specified team, as listed when typing a command.
+func (ue *UserEntity) ListAutocompleteCommands(teamId string) ([]*model.Command, error) {
+	commands, _, err := ue.client.ListAutocompleteCommands(teamId)
+	if err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// ExecuteCommand executes the given slash command in the specified channel.
+func (ue *UserEntity) ExecuteCommand(channelId, command string) (*model.CommandResponse, error) {
+	resp, _, err := ue.client.ExecuteCommand(channelId, command)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GetProxiedImage fetches the given image through the server's image proxy.
+func (ue *UserEntity) GetProxiedImage(imageURL string) error {
+	resp, err := ue.client.DoAPIGet(imageProxyRoute(imageURL), "")
+	if err != nil {
+		return err
+	}
+	closeBody(resp)
+	return nil
+}
+
+// SearchGifs searches for GIFs matching the given term through the
+// Gfycat-compatible search API at searchURL, the way the GIF picker does,
+// returning the URLs of the results. The GIF picker doesn't search through
+// the server, so the request is made without credentials and through a client
+// that isn't accounted for in the HTTP metrics.
+func (ue *UserEntity) SearchGifs(searchURL, term string) ([]string, error) {
+	u, err := url.Parse(searchURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("search_text", term)
+	query.Set("count", strconv.Itoa(gifSearchCount))
+	u.RawQuery = query.Encode()
+
+	resp, err := ue.extClient.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to search GIFs: %w", err)
+	}
+	defer closeBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to search GIFs: unexpected status code %d", resp.StatusCode)
+	}
+	var results struct {
+		Gfycats []struct {
+			Max5mbGif string `json:"max5mbGif"`
+		} `json:"gfycats"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode GIF search results: %w", err)
+	}
+
+	urls := make([]string, 0, len(results.Gfycats))
+	for _, gif := range results.Gfycats {
+		if gif.Max5mbGif != "" {
+			urls = append(urls, gif.Max5mbGif)
+		}
+	}
+	return urls, nil
+}
+
+// CreateJob creates the given job on the server.
+func (ue *UserEntity) CreateJob(job *model.Job) (*model.Job, error) {
+	job, _, err := ue.client.CreateJob(job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJob fetches the specified job.
+func (ue *UserEntity) GetJob(jobId string) (*model.Job, error) {
+	job, _, err := ue.client.GetJob(jobId)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
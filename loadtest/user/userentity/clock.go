@@ -0,0 +1,29 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"time"
+)
+
+// Clock provides the current time and timers. It's used for the WebSocket
+// reconnect timing so that tests can control it.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the given duration to elapse and then sends the
+	// current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
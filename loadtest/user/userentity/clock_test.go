@@ -0,0 +1,157 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock whose time only moves forward when advanced. The
+// durations passed to After are sent on the waits channel.
+type fakeClock struct {
+	mut    sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+	waits  chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:   time.Now(),
+		waits: make(chan time.Duration, 100),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), c: ch})
+	c.waits <- d
+	return ch
+}
+
+// Advance moves the time forward, firing the timers that expire.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
+
+func (c *fakeClock) nextWait(t *testing.T) time.Duration {
+	select {
+	case d := <-c.waits:
+		return d
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the reconnect wait")
+	}
+	return 0
+}
+
+func TestReconnectTiming(t *testing.T) {
+	var conns int32
+	drop := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&conns, 1)
+
+		seq, _ := strconv.ParseInt(r.URL.Query().Get("sequence_number"), 10, 64)
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(seq)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+
+		// The connection is dropped when told to.
+		<-drop
+	}))
+	defer ts.Close()
+	defer close(drop)
+
+	clock := newFakeClock()
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s, Clock: clock}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		WebSocketMinReconnectDuration: time.Second,
+		WebSocketMaxReconnectDuration: 20 * time.Second,
+		WebSocketFailThreshold:        1,
+		WebSocketHealthyDuration:      time.Minute,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	go func() {
+		for range ue.Events() {
+		}
+	}()
+	defer ue.Disconnect()
+
+	dropConn := func(n int32) {
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&conns) == n
+		}, 5*time.Second, 10*time.Millisecond)
+		drop <- struct{}{}
+	}
+
+	// The wait grows with the failed connections past the threshold, up to
+	// the maximum.
+	for i, expected := range []time.Duration{time.Second, 4 * time.Second, 9 * time.Second, 16 * time.Second, 20 * time.Second} {
+		dropConn(int32(i + 1))
+		wait := clock.nextWait(t)
+		require.Equal(t, expected, wait)
+		clock.Advance(wait)
+	}
+
+	// Once a connection stayed up long enough, the backoff is reset.
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&conns) == 6
+	}, 5*time.Second, 10*time.Millisecond)
+	clock.Advance(time.Minute)
+	dropConn(6)
+	require.Equal(t, time.Second, clock.nextWait(t))
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/websocket"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// extraConn is an additional WebSocket connection of a user, modeling a
+// client connected from another device. It tracks its own connection id and
+// sequence number, and reconnects independently of the other connections.
+//
+// The events it receives aren't applied to the store nor forwarded to the
+// events channel, since the main connection already does so. Typing events
+// and WebSocket actions are only sent through the main connection as well.
+type extraConn struct {
+	// connID holds the id of the connection as a string. It's written by the
+	// listening goroutine and can be read concurrently.
+	connID    atomic.Value
+	serverSeq int64
+	rand      *rand.Rand
+}
+
+// newExtraConns returns the given number of additional connections. The
+// reconnect jitter of each is seeded from the username and the connection
+// index so that it's reproducible across runs.
+func newExtraConns(username string, n int) []*extraConn {
+	conns := make([]*extraConn, n)
+	for i := range conns {
+		h := fnv.New64a()
+		h.Write([]byte(fmt.Sprintf("%s:%d", username, i+1)))
+		conns[i] = &extraConn{rand: rand.New(rand.NewSource(int64(h.Sum64())))}
+	}
+	return conns
+}
+
+func (c *extraConn) connectionID() string {
+	connID, _ := c.connID.Load().(string)
+	return connID
+}
+
+// trackEvent updates the connection id and sequence number of the connection
+// given a received event. It returns false on a sequence mismatch, in which
+// case the connection should be reconnected.
+func (c *extraConn) trackEvent(ev *model.WebSocketEvent) bool {
+	if ev.EventType() == model.WebsocketEventHello {
+		if connID, ok := ev.GetData()["connection_id"].(string); ok {
+			// A different id means the server couldn't resume the previous
+			// connection, so the sequence starts over.
+			if curConnID := c.connectionID(); curConnID != "" && curConnID != connID {
+				c.serverSeq = 0
+			}
+			c.connID.Store(connID)
+		}
+	}
+
+	if ev.GetSequence() != c.serverSeq {
+		mlog.Debug("Missed websocket event on extra connection", mlog.Int64("got", ev.GetSequence()), mlog.Int64("expected", c.serverSeq))
+		return false
+	}
+	c.serverSeq = ev.GetSequence() + 1
+	return true
+}
+
+// listenExtra keeps the given additional connection open, reconnecting it
+// whenever it closes, until Disconnect is called or the main listener stops.
+// Like the main connection, it gives up after
+// Config.WebSocketMaxReconnectAttempts consecutive failed attempts.
+func (ue *UserEntity) listenExtra(c *extraConn) {
+	defer ue.wsExtraWG.Done()
+
+	readTimeout := ue.config.WebSocketReadTimeout
+	if readTimeout == 0 {
+		readTimeout = defaultWebsocketReadTimeout
+	}
+	connectionFailCount := 0
+	// reconnectAttempts counts the consecutive failed attempts since events
+	// were last received.
+	reconnectAttempts := 0
+	firstAttempt := true
+	var reconnectReason string
+	for {
+		if !firstAttempt {
+			ue.incWebSocketReconnects(reconnectReason)
+		}
+		firstAttempt = false
+
+		client, err := websocket.NewClient4(&websocket.ClientParams{
+			WsURL:             ue.config.WebSocketURL,
+			AuthToken:         ue.client.AuthToken,
+			ConnID:            c.connectionID(),
+			ServerSequence:    c.serverSeq,
+			Dialer:            ue.wsDialer,
+			ReadTimeout:       readTimeout,
+			EnableCompression: ue.config.WebSocketEnableCompression,
+			MaxMessageSize:    ue.config.WebSocketMaxMessageSize,
+		})
+		if err != nil {
+			ue.incWebSocketConnectFailures()
+			reconnectReason = reconnectReasonConnectError
+			mlog.Warn("userentity: failed to connect extra websocket", mlog.Err(err))
+		} else {
+			connectedAt := ue.clock.Now()
+			res, received := ue.readExtra(c, client)
+			if received {
+				reconnectAttempts = 0
+			}
+			switch res {
+			case extraConnClosing:
+				// Explicit disconnect, or the main listener stopped. Return.
+				return
+			case extraConnSeqMismatch:
+				// Reconnect right away, like the main connection.
+				reconnectReason = reconnectReasonSeqMismatch
+				continue
+			}
+			reconnectReason = reconnectReasonServerClose
+			connectionFailCount = ue.resetFailCountIfHealthy(connectionFailCount, ue.clock.Now().Sub(connectedAt))
+		}
+
+		connectionFailCount++
+		reconnectAttempts++
+		if ue.reconnectAttemptsExceeded(reconnectAttempts) {
+			mlog.Warn("userentity: gave up reconnecting extra websocket", mlog.Int("attempts", reconnectAttempts))
+			return
+		}
+		select {
+		case <-ue.wsClosing:
+			return
+		case <-ue.wsClosed:
+			return
+		case <-ue.clock.After(ue.jitteredWaitTime(connectionFailCount, c.rand)):
+		}
+	}
+}
+
+// extraConnResult is the reason for readExtra to return.
+type extraConnResult int
+
+const (
+	extraConnClosed extraConnResult = iota
+	extraConnSeqMismatch
+	extraConnClosing
+)
+
+// readExtra consumes the events received by the given client until it closes,
+// an event is missed or Disconnect is called, returning which one happened and
+// whether any event was received.
+func (ue *UserEntity) readExtra(c *extraConn, client *websocket.Client) (extraConnResult, bool) {
+	ue.incWebSocketConnections()
+	defer ue.decWebSocketConnections()
+	defer client.Close()
+
+	received := false
+	for {
+		select {
+		case ev, ok := <-client.EventChannel:
+			if !ok {
+				ue.incWebSocketCloseCodes(client.CloseCode())
+				if client.TimedOut() {
+					ue.incWebSocketReadTimeouts()
+				}
+				if client.ReadLimitExceeded() {
+					ue.incWebSocketReadLimitHits()
+				}
+				return extraConnClosed, received
+			}
+			atomic.AddUint64(&ue.eventsReceived, 1)
+			received = true
+			if !c.trackEvent(ev) {
+				ue.incWebSocketSeqMismatches()
+				return extraConnSeqMismatch, received
+			}
+		case <-ue.wsClosing:
+			return extraConnClosing, received
+		case <-ue.wsClosed:
+			return extraConnClosing, received
+		}
+	}
+}
+
+// ConnectionIDs returns the ids of all the WebSocket connections of the user,
+// starting with the main one. An id is empty until the first hello event is
+// received on its connection.
+func (ue *UserEntity) ConnectionIDs() []string {
+	ids := make([]string, 0, len(ue.wsExtraConns)+1)
+	ids = append(ids, ue.ConnectionID())
+	for _, c := range ue.wsExtraConns {
+		ids = append(ids, c.connectionID())
+	}
+	return ids
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// The kinds of outbound WebSocket messages recorded in dry-run mode.
+const (
+	DryRunUserTyping = "user_typing"
+	DryRunAction     = "action"
+)
+
+// recordDryRun records that an outbound WebSocket message of the given kind
+// would have been sent, had dry-run mode been disabled.
+func (ue *UserEntity) recordDryRun(kind string, fields ...mlog.Field) {
+	ue.dryRunMut.Lock()
+	if ue.dryRunSent == nil {
+		ue.dryRunSent = map[string]uint64{}
+	}
+	ue.dryRunSent[kind]++
+	ue.dryRunMut.Unlock()
+	mlog.Debug("userentity: dry run, not sending websocket message", append([]mlog.Field{mlog.String("kind", kind)}, fields...)...)
+}
+
+// DryRunSent returns the number of outbound WebSocket messages that weren't
+// sent because of Config.DryRun, by kind. It's safe for concurrent use.
+func (ue *UserEntity) DryRunSent() map[string]uint64 {
+	ue.dryRunMut.Lock()
+	defer ue.dryRunMut.Unlock()
+
+	sent := make(map[string]uint64, len(ue.dryRunSent))
+	for kind, count := range ue.dryRunSent {
+		sent[kind] = count
+	}
+	return sent
+}
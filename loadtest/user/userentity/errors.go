@@ -0,0 +1,97 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReconnectGaveUp is reported through the errors channel, and returned on
+// disconnect, once the user stops reconnecting after failing
+// Config.WebSocketMaxReconnectAttempts times in a row.
+var ErrReconnectGaveUp = errors.New("userentity: gave up reconnecting")
+
+// ErrNotConnected is returned when sending through the WebSocket connection
+// of a user that isn't connected, including once the listener stopped after
+// giving up reconnecting.
+var ErrNotConnected = errors.New("user is not connected")
+
+// ErrTypingQueueFull is returned by SendTypingEvent when the typing event
+// couldn't be queued within Config.TypingEventTimeout, e.g. because the
+// listener is busy reconnecting. Unlike ErrNotConnected, sending again later
+// may succeed.
+var ErrTypingQueueFull = errors.New("userentity: typing queue full")
+
+// listenerStoppedError is returned when sending through the WebSocket
+// connection after the listener stopped. It unwraps to the reason for
+// stopping while still matching ErrNotConnected.
+type listenerStoppedError struct {
+	err error
+}
+
+func (e *listenerStoppedError) Error() string {
+	return fmt.Sprintf("userentity: listener stopped: %s", e.err)
+}
+
+func (e *listenerStoppedError) Unwrap() error {
+	return e.err
+}
+
+func (e *listenerStoppedError) Is(target error) bool {
+	return target == ErrNotConnected
+}
+
+// WSErrorKind identifies the kind of failure a WSError stands for.
+type WSErrorKind int
+
+// The kinds of WebSocket failures.
+const (
+	// WSErrorConnect is a failure to establish the WebSocket connection.
+	WSErrorConnect WSErrorKind = iota + 1
+	// WSErrorHandler is a failure handling a received event.
+	WSErrorHandler
+	// WSErrorTyping is a failure sending a typing event.
+	WSErrorTyping
+	// WSErrorSeqMismatch is a gap in the sequence of the received events.
+	WSErrorSeqMismatch
+	// WSErrorAction is a failure running a queued WebSocket action.
+	WSErrorAction
+)
+
+// String returns a description of the kind.
+func (k WSErrorKind) String() string {
+	switch k {
+	case WSErrorConnect:
+		return "websocketClient creation error"
+	case WSErrorHandler:
+		return "error in wsEventHandler"
+	case WSErrorTyping:
+		return "error in client.UserTyping"
+	case WSErrorSeqMismatch:
+		return "event sequence error"
+	case WSErrorAction:
+		return "error in WebSocket action"
+	default:
+		return fmt.Sprintf("unknown error kind %d", int(k))
+	}
+}
+
+// WSError is a failure of the WebSocket connection of a UserEntity, as
+// reported through the errors channel. Callers can use errors.As to branch on
+// its Kind.
+type WSError struct {
+	Kind WSErrorKind
+	// Err is the underlying cause.
+	Err error
+}
+
+func (e *WSError) Error() string {
+	return fmt.Sprintf("userentity: %s: %s", e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying cause.
+func (e *WSError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWSServer is a scriptable WebSocket server standing in for Mattermost in
+// the tests exercising the listening loop end-to-end. Each connection it
+// accepts is handed over to the test through nextConn, which then decides
+// which events are sent and when the connection is closed.
+type fakeWSServer struct {
+	*httptest.Server
+	conns chan *fakeWSConn
+}
+
+// fakeWSConn is a connection accepted by a fakeWSServer. Its methods are not
+// safe for concurrent use, and are meant to be called by the test goroutine.
+type fakeWSConn struct {
+	conn *gorillaws.Conn
+	// connID and serverSeq are the resume parameters sent by the client.
+	connID    string
+	serverSeq int64
+	// seq is the sequence number of the next event sent by send. It starts
+	// from the one sent by the client, as when resuming a connection.
+	seq    int64
+	closed chan struct{}
+}
+
+func newFakeWSServer(t *testing.T) *fakeWSServer {
+	srv := &fakeWSServer{
+		conns: make(chan *fakeWSConn, 10),
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		c := &fakeWSConn{
+			conn:   conn,
+			connID: r.URL.Query().Get("connection_id"),
+			closed: make(chan struct{}),
+		}
+		c.serverSeq, _ = strconv.ParseInt(r.URL.Query().Get("sequence_number"), 10, 64)
+		c.seq = c.serverSeq
+		defer close(c.closed)
+		srv.conns <- c
+
+		// Reading is required to handle the control messages.
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// wsURL returns the URL to connect to the server with.
+func (srv *fakeWSServer) wsURL() string {
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+	return u.String()
+}
+
+// nextConn waits for the next connection to the server.
+func (srv *fakeWSServer) nextConn(t *testing.T) *fakeWSConn {
+	t.Helper()
+	select {
+	case c := <-srv.conns:
+		return c
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for a connection")
+		return nil
+	}
+}
+
+// noConn checks that the client doesn't connect to the server for d.
+func (srv *fakeWSServer) noConn(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case <-srv.conns:
+		require.FailNow(t, "unexpected connection")
+	case <-time.After(d):
+	}
+}
+
+// hello sends a hello event with the given connection id. Like the server
+// does, the sequence starts over unless the connection is resumed, i.e. the
+// id is the one sent by the client.
+func (c *fakeWSConn) hello(t *testing.T, connID string) {
+	t.Helper()
+	if connID != c.connID {
+		c.seq = 0
+	}
+	ev := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil)
+	ev.Add("connection_id", connID)
+	c.send(t, ev)
+}
+
+// send sends the given event with the next sequence number.
+func (c *fakeWSConn) send(t *testing.T, ev *model.WebSocketEvent) {
+	t.Helper()
+	c.sendSeq(t, ev, c.seq)
+}
+
+// sendSeq sends the given event with the given sequence number, which the
+// following events sent with send continue from.
+func (c *fakeWSConn) sendSeq(t *testing.T, ev *model.WebSocketEvent, seq int64) {
+	t.Helper()
+	data, err := ev.SetSequence(seq).ToJSON()
+	require.NoError(t, err)
+	require.NoError(t, c.conn.WriteMessage(gorillaws.TextMessage, data))
+	c.seq = seq + 1
+}
+
+// close closes the connection from the server side.
+func (c *fakeWSConn) close() {
+	c.conn.Close()
+}
+
+// waitClosed waits for the connection to be closed, be it by the server or
+// the client.
+func (c *fakeWSConn) waitClosed(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.closed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the connection to close")
+	}
+}
+
+// newFakeWSEntity returns a connected entity listening to the given server,
+// along with a buffered channel its events are forwarded to, so that the
+// listener isn't blocked by the events the test doesn't wait for. It
+// reconnects almost right away by default so that scenarios run quickly, and
+// gets disconnected once the test ends.
+func newFakeWSEntity(t *testing.T, srv *fakeWSServer, setup Setup, config Config) (*UserEntity, <-chan *model.WebSocketEvent) {
+	t.Helper()
+	if setup.Store == nil {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		setup.Store = s
+	}
+	config.WebSocketURL = srv.wsURL()
+	if config.WebSocketMinReconnectDuration == 0 {
+		config.WebSocketMinReconnectDuration = time.Millisecond
+		config.WebSocketMaxReconnectDuration = 10 * time.Millisecond
+	}
+	ue := New(setup, config)
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	events := make(chan *model.WebSocketEvent, 100)
+	go func(in <-chan *model.WebSocketEvent) {
+		defer close(events)
+		for ev := range in {
+			events <- ev
+		}
+	}(ue.Events())
+	t.Cleanup(func() {
+		if ue.connected {
+			ue.Disconnect()
+		}
+	})
+	return ue, events
+}
+
+// waitEvent waits for an event of the given type on events, skipping others.
+func waitEvent(t *testing.T, events <-chan *model.WebSocketEvent, eventType string) *model.WebSocketEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			require.True(t, ok, "events channel closed")
+			if ev.EventType() == eventType {
+				return ev
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for event", eventType)
+			return nil
+		}
+	}
+}
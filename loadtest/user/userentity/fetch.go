@@ -0,0 +1,93 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const (
+	// fetchQueueSize is the number of fetches the listener can queue before
+	// new ones get dropped.
+	fetchQueueSize = 64
+	// fetchTimeout bounds each of the requests made by a fetch.
+	fetchTimeout = 5 * time.Second
+)
+
+// fetchFunc makes the requests to the server needed to handle an event, using
+// the given client, and writes the result to the store. It returns whether
+// the store changed.
+type fetchFunc func(client *model.Client4) (bool, error)
+
+// fetchRequest is a fetch queued by the listener.
+type fetchRequest struct {
+	// desc describes the fetch in the error reported on failure.
+	desc  string
+	fetch fetchFunc
+}
+
+// fetch runs the given fetch in the background while connected, so that a
+// slow server doesn't hold back the listener from reading the following
+// events. The changes to the store then land after the event is handled, and
+// failures are reported through the errors channel. Otherwise the fetch runs
+// right away. It's only meant to be called by the listening goroutine.
+func (ue *UserEntity) fetch(desc string, fetch fetchFunc) error {
+	if ue.wsFetches == nil {
+		changed, err := fetch(ue.fetchClient())
+		if changed {
+			ue.storeChanged(nil)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s: %w", desc, err)
+		}
+		return nil
+	}
+
+	select {
+	case ue.wsFetches <- fetchRequest{desc: desc, fetch: fetch}:
+		return nil
+	default:
+		return fmt.Errorf("failed to %s: %w", desc, errFetchQueueFull)
+	}
+}
+
+var errFetchQueueFull = errors.New("fetch queue is full")
+
+// runFetches runs the fetches queued by the listener until Disconnect is
+// called.
+func (ue *UserEntity) runFetches(fetches <-chan fetchRequest, errChan chan error) {
+	defer ue.wsFetchWG.Done()
+
+	for {
+		select {
+		case req := <-fetches:
+			changed, err := req.fetch(ue.fetchClient())
+			if changed {
+				atomic.AddUint64(&ue.eventsApplied, 1)
+			}
+			if err != nil {
+				select {
+				case errChan <- &WSError{Kind: WSErrorHandler, Err: fmt.Errorf("failed to %s: %w", req.desc, err)}:
+				case <-ue.wsClosing:
+					return
+				}
+			}
+		case <-ue.wsClosing:
+			return
+		}
+	}
+}
+
+// fetchClient returns a copy of the client of the user whose requests time
+// out after fetchTimeout.
+func (ue *UserEntity) fetchClient() *model.Client4 {
+	client := *ue.client
+	client.HTTPClient = ue.fetchHTTPClient
+	return &client
+}
@@ -58,6 +58,46 @@ func (th *TestHelper) CreateUser() *UserEntity {
 		"testuser",
 		"testuser@example.com",
 		"testpassword",
+		"",
+		0,
+		0,
+		0,
+		0,
+		"",
+		"",
+		false,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		false,
+		0,
+		0,
+		0,
+		0,
+		0,
+		0,
+		false,
+		false,
+		0,
+		0,
+		0,
+		0,
+		false,
+		0,
+		false,
+		false,
+		0,
+		false,
+		0,
 	})
 	require.NotNil(th.tb, u)
 	return u
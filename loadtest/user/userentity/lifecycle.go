@@ -0,0 +1,47 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+// ConnectionEventType is the type of a WebSocket connection lifecycle event.
+type ConnectionEventType string
+
+// Available connection lifecycle event types.
+const (
+	ConnectionEventConnected    ConnectionEventType = "connected"
+	ConnectionEventDisconnected ConnectionEventType = "disconnected"
+	ConnectionEventReconnecting ConnectionEventType = "reconnecting"
+	ConnectionEventSeqMismatch  ConnectionEventType = "seq_mismatch"
+)
+
+// ConnectionEvent describes a change in the state of the WebSocket
+// connection of a UserEntity.
+type ConnectionEvent struct {
+	Type ConnectionEventType
+	// The id of the WebSocket connection at the time of the event.
+	ConnID string
+	// The next expected server sequence number at the time of the event.
+	Seq int64
+}
+
+// ConnectionEvents returns the channel the connection lifecycle events are
+// published to. It returns nil if Config.ConnectionEventsBufferSize is zero.
+// Events are dropped while the channel is full.
+func (ue *UserEntity) ConnectionEvents() <-chan ConnectionEvent {
+	return ue.connEvents
+}
+
+func (ue *UserEntity) publishConnectionEvent(evType ConnectionEventType) {
+	if ue.connEvents == nil {
+		return
+	}
+	// Publishing should never stall the listener.
+	select {
+	case ue.connEvents <- ConnectionEvent{
+		Type:   evType,
+		ConnID: ue.ConnectionID(),
+		Seq:    ue.wsServerSeq,
+	}:
+	default:
+	}
+}
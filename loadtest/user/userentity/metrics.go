@@ -5,6 +5,8 @@ package userentity
 
 import (
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -21,27 +23,210 @@ func (ue *UserEntity) decWebSocketConnections() {
 	}
 }
 
+func (ue *UserEntity) setWebSocketDegraded(degraded bool) {
+	if ue.wsDegraded == degraded {
+		return
+	}
+	ue.wsDegraded = degraded
+	if ue.metrics == nil {
+		return
+	}
+	if degraded {
+		ue.metrics.WebSocketDegraded.Inc()
+	} else {
+		ue.metrics.WebSocketDegraded.Dec()
+	}
+}
+
+func (ue *UserEntity) setWebSocketGaveUp(gaveUp bool) {
+	if ue.wsGaveUp == gaveUp {
+		return
+	}
+	ue.wsGaveUp = gaveUp
+	if ue.metrics == nil {
+		return
+	}
+	if gaveUp {
+		ue.metrics.WebSocketGaveUp.Inc()
+	} else {
+		ue.metrics.WebSocketGaveUp.Dec()
+	}
+}
+
+func (ue *UserEntity) setStoreUnhealthy(unhealthy bool) {
+	var val int32
+	if unhealthy {
+		val = 1
+	}
+	if atomic.SwapInt32(&ue.storeUnhealthy, val) == val {
+		return
+	}
+	if ue.metrics == nil {
+		return
+	}
+	if unhealthy {
+		ue.metrics.StoreUnhealthy.Inc()
+	} else {
+		ue.metrics.StoreUnhealthy.Dec()
+	}
+}
+
+func (ue *UserEntity) incWebSocketCloseCodes(code int) {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketCloseCodes.With(prometheus.Labels{
+			"code":    strconv.Itoa(code),
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
+// The reasons for reconnecting, as labeled in the reconnects metric.
+const (
+	// reconnectReasonConnectError is a failure to establish the connection.
+	reconnectReasonConnectError = "connect_error"
+	// reconnectReasonSeqMismatch is a gap in the sequence of the received
+	// events.
+	reconnectReasonSeqMismatch = "seq_mismatch"
+	// reconnectReasonServerClose is the connection being closed, be it by the
+	// server or because of a read failure.
+	reconnectReasonServerClose = "server_close"
+	// reconnectReasonChanClosed is the typing or actions channel being
+	// closed.
+	reconnectReasonChanClosed = "channel_closed"
+)
+
+func (ue *UserEntity) incWebSocketReconnects(reason string) {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketReconnects.With(prometheus.Labels{
+			"reason":  reason,
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) incWebSocketSeqMismatches() {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketSeqMismatches.With(prometheus.Labels{
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) incWebSocketConnectFailures() {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketConnectFailures.With(prometheus.Labels{
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) incWebSocketReadTimeouts() {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketReadTimeouts.With(prometheus.Labels{
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) incWebSocketReadLimitHits() {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketReadLimitHits.With(prometheus.Labels{
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
 func (ue *UserEntity) incHTTPErrors(path, method string, status int) {
 	if ue.metrics != nil {
 		ue.metrics.HTTPErrors.With(prometheus.Labels{
 			"path":        path,
 			"method":      method,
 			"status_code": strconv.Itoa(status),
+			"persona":     ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) incHTTPRateLimited(path, method string) {
+	if ue.metrics != nil {
+		ue.metrics.HTTPRateLimited.With(prometheus.Labels{
+			"path":    path,
+			"method":  method,
+			"persona": ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) observeHTTPRequestTimes(elapsed time.Duration, requestId string) {
+	if ue.metrics == nil {
+		return
+	}
+
+	obs := ue.metrics.HTTPRequestTimes.With(prometheus.Labels{
+		"persona": ue.config.Persona,
+	})
+
+	// Slow requests are tagged with the server's request id so that they can
+	// be easily correlated.
+	if requestId != "" && ue.config.ExemplarMinLatency > 0 && elapsed >= ue.config.ExemplarMinLatency {
+		if eo, ok := obs.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(elapsed.Seconds(), prometheus.Labels{"request_id": requestId})
+			return
+		}
+	}
+
+	obs.Observe(elapsed.Seconds())
+}
+
+func (ue *UserEntity) observeWebSocketEventTimes(elapsed time.Duration, eventType string) {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketEventTimes.With(prometheus.Labels{
+			"event_type": eventType,
+			"persona":    ue.config.Persona,
+		}).Observe(elapsed.Seconds())
+	}
+}
+
+func (ue *UserEntity) incWebSocketDroppedEvents(eventType string) {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketDroppedEvents.With(prometheus.Labels{
+			"event_type": eventType,
+			"persona":    ue.config.Persona,
 		}).Inc()
 	}
 }
 
-func (ue *UserEntity) observeHTTPRequestTimes(elapsed float64) {
+func (ue *UserEntity) incWebSocketSlowConsumerSends(eventType string) {
+	if ue.metrics != nil {
+		ue.metrics.WebSocketSlowConsumerSends.With(prometheus.Labels{
+			"event_type": eventType,
+			"persona":    ue.config.Persona,
+		}).Inc()
+	}
+}
+
+func (ue *UserEntity) observeTypingQueueTime(msg userTypingMsg) {
+	if ue.metrics != nil && !msg.enqueuedAt.IsZero() {
+		ue.metrics.WebSocketTypingQueueTimes.With(prometheus.Labels{
+			"persona": ue.config.Persona,
+		}).Observe(time.Since(msg.enqueuedAt).Seconds())
+	}
+}
+
+func (ue *UserEntity) observePostDeliveryTime(elapsed time.Duration) {
 	if ue.metrics != nil {
-		ue.metrics.HTTPRequestTimes.Observe(elapsed)
+		ue.metrics.PostDeliveryTimes.With(prometheus.Labels{
+			"persona": ue.config.Persona,
+		}).Observe(elapsed.Seconds())
 	}
 }
 
 func (ue *UserEntity) incHTTPTimeouts(path, method string) {
 	if ue.metrics != nil {
 		ue.metrics.HTTPTimeouts.With(prometheus.Labels{
-			"path":   path,
-			"method": method,
+			"path":    path,
+			"method":  method,
+			"persona": ue.config.Persona,
 		}).Inc()
 	}
 }
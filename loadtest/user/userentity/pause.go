@@ -0,0 +1,70 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// Pause stops handing the received WebSocket events over to the events
+// channel, simulating a frozen client, without closing the connection. The
+// events are still applied to the store as they are received. While paused,
+// they are held back until Resume is called, or dropped and counted if
+// Config.DropEventsWhenFull is set or Config.MaxHeldEvents are already held
+// back. Events still held back on disconnect are discarded. It's safe for
+// concurrent use.
+func (ue *UserEntity) Pause() {
+	atomic.StoreInt32(&ue.wsPaused, 1)
+}
+
+// Resume hands the events held back since Pause was called over to the events
+// channel, in the order they were received, and goes back to forwarding
+// events as they are received. It's safe for concurrent use.
+func (ue *UserEntity) Resume() {
+	atomic.StoreInt32(&ue.wsPaused, 0)
+	// The listener is woken up so that the held back events don't wait for
+	// the next one to be received.
+	select {
+	case ue.wsResumed <- struct{}{}:
+	default:
+	}
+}
+
+// Paused reports whether event processing is paused.
+func (ue *UserEntity) Paused() bool {
+	return atomic.LoadInt32(&ue.wsPaused) == 1
+}
+
+// holdEvent holds back the given event while paused, returning whether it
+// did so. It's only meant to be called by the listening goroutine.
+func (ue *UserEntity) holdEvent(ev *model.WebSocketEvent) bool {
+	if !ue.Paused() {
+		return false
+	}
+	maxHeld := ue.config.MaxHeldEvents
+	if maxHeld <= 0 {
+		maxHeld = defaultMaxHeldEvents
+	}
+	if ue.config.DropEventsWhenFull || len(ue.heldEvents) >= maxHeld {
+		ue.dropEvent(ev)
+	} else {
+		ue.heldEvents = append(ue.heldEvents, ev)
+	}
+	return true
+}
+
+// releaseHeldEvents hands the held back events over to the events channel,
+// unless paused. It's only meant to be called by the listening goroutine.
+func (ue *UserEntity) releaseHeldEvents() {
+	if ue.Paused() {
+		return
+	}
+	events := ue.heldEvents
+	ue.heldEvents = nil
+	for _, ev := range events {
+		ue.forwardEvent(ev)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxPendingPosts is the maximum number of posts waiting for their
+	// posted event at any given time. Posts created past it aren't measured.
+	maxPendingPosts = 100
+	// pendingPostMaxAge is the time after which a post whose posted event
+	// wasn't received is no longer waited for.
+	pendingPostMaxAge = time.Minute
+)
+
+// pendingPosts keeps the time at which the posts created by the user were
+// sent, until the related posted events are received. Posts are identified by
+// their pending post id, since the event can be received before the creation
+// request returns.
+//
+// pendingPosts is safe for concurrent use.
+type pendingPosts struct {
+	mut  sync.Mutex
+	sent map[string]time.Time
+}
+
+func newPendingPosts() *pendingPosts {
+	return &pendingPosts{
+		sent: map[string]time.Time{},
+	}
+}
+
+// add records that the post with the given pending post id was sent at the
+// given time. It returns false if too many posts are already pending.
+func (pp *pendingPosts) add(pendingId string, now time.Time) bool {
+	pp.mut.Lock()
+	defer pp.mut.Unlock()
+
+	if len(pp.sent) >= maxPendingPosts {
+		// The events for the oldest posts are likely lost, e.g. because
+		// they were created while disconnected.
+		for id, sentAt := range pp.sent {
+			if now.Sub(sentAt) > pendingPostMaxAge {
+				delete(pp.sent, id)
+			}
+		}
+		if len(pp.sent) >= maxPendingPosts {
+			return false
+		}
+	}
+	pp.sent[pendingId] = now
+	return true
+}
+
+// remove stops waiting for the post with the given pending post id, returning
+// the time at which it was sent, if it was pending.
+func (pp *pendingPosts) remove(pendingId string) (time.Time, bool) {
+	pp.mut.Lock()
+	defer pp.mut.Unlock()
+
+	sentAt, ok := pp.sent[pendingId]
+	if ok {
+		delete(pp.sent, pendingId)
+	}
+	return sentAt, ok
+}
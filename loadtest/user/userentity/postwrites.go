@@ -0,0 +1,164 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const defaultPostWriteFlushInterval = 100 * time.Millisecond
+
+// postWriteBatcher coalesces the post writes made while handling WebSocket
+// events so that they are applied to the store in batches, taking its lock
+// once per batch rather than once per post. Writes are applied in the order
+// they were made, so a delete is never applied before the write it follows.
+//
+// A nil postWriteBatcher disables batching. postWriteBatcher is not safe for
+// concurrent use. It's only meant to be used by the goroutine handling the
+// events.
+type postWriteBatcher struct {
+	maxSize  int
+	interval time.Duration
+	writes   []store.PostWrite
+	// pending maps the id of each post written since the last flush to its
+	// latest write.
+	pending map[string]store.PostWrite
+	timer   *time.Timer
+}
+
+// newPostWriteBatcher returns a batcher flushing after maxSize writes or
+// once the oldest write waited for interval, whichever comes first. It
+// returns nil if maxSize is zero.
+func newPostWriteBatcher(maxSize int, interval time.Duration) *postWriteBatcher {
+	if maxSize <= 0 {
+		return nil
+	}
+	if interval <= 0 {
+		interval = defaultPostWriteFlushInterval
+	}
+	return &postWriteBatcher{
+		maxSize:  maxSize,
+		interval: interval,
+		pending:  map[string]store.PostWrite{},
+	}
+}
+
+// add queues the given write, returning whether the batch is full and should
+// be flushed.
+func (b *postWriteBatcher) add(w store.PostWrite) bool {
+	if len(b.writes) == 0 {
+		b.timer = time.NewTimer(b.interval)
+	}
+	b.writes = append(b.writes, w)
+	b.pending[w.Post.Id] = w
+	return len(b.writes) >= b.maxSize
+}
+
+// queued reports whether there are queued writes.
+func (b *postWriteBatcher) queued() bool {
+	return b != nil && len(b.writes) > 0
+}
+
+// lookup returns the latest queued write of the given post, if any.
+func (b *postWriteBatcher) lookup(postId string) (store.PostWrite, bool) {
+	if b == nil {
+		return store.PostWrite{}, false
+	}
+	w, ok := b.pending[postId]
+	return w, ok
+}
+
+// take returns the queued writes, emptying the batch.
+func (b *postWriteBatcher) take() []store.PostWrite {
+	if b == nil || len(b.writes) == 0 {
+		return nil
+	}
+	writes := b.writes
+	b.writes = nil
+	b.pending = map[string]store.PostWrite{}
+	b.timer.Stop()
+	b.timer = nil
+	return writes
+}
+
+// C returns the channel signaling that the queued writes are due. It returns
+// nil if there are none.
+func (b *postWriteBatcher) C() <-chan time.Time {
+	if b == nil || b.timer == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// setPost stores the given post, batching the write if enabled.
+func (ue *UserEntity) setPost(post *model.Post) error {
+	if ue.postWrites == nil {
+		return ue.store.SetPost(post)
+	}
+	if ue.postWrites.add(store.PostWrite{Post: post}) {
+		return ue.flushPostWrites()
+	}
+	return nil
+}
+
+// deletePost deletes the given post, batching the write if enabled.
+func (ue *UserEntity) deletePost(post *model.Post) error {
+	if ue.postWrites == nil {
+		return ue.store.DeletePost(post.Id)
+	}
+	if ue.postWrites.add(store.PostWrite{Post: post, Delete: true}) {
+		return ue.flushPostWrites()
+	}
+	return nil
+}
+
+// storedPost returns the given post as it is once the batched writes are
+// applied.
+func (ue *UserEntity) storedPost(postId string) (*model.Post, error) {
+	if w, ok := ue.postWrites.lookup(postId); ok {
+		// Deleted posts aren't stored either.
+		if w.Delete || w.Post.DeleteAt > 0 {
+			return nil, memstore.ErrPostNotFound
+		}
+		return w.Post, nil
+	}
+	return ue.store.Post(postId)
+}
+
+// flushPostWrites applies the batched post writes to the store, then
+// delivers the events delayed until then.
+func (ue *UserEntity) flushPostWrites() error {
+	writes := ue.postWrites.take()
+	var err error
+	if len(writes) > 0 {
+		if err = ue.store.ApplyPostWrites(writes); err != nil {
+			err = fmt.Errorf("failed to apply post writes to store: %w", err)
+		}
+	}
+	events := ue.delayedEvents
+	ue.delayedEvents = nil
+	for _, ev := range events {
+		ue.deliverEvent(ev)
+	}
+	return err
+}
+
+// deliverEventOnceStored delivers the given event, unless post writes are
+// batched, in which case it's delayed until these are applied so that the
+// store is up to date when the event is received. Events are still delivered
+// in the order they were received. It's only meant to be called by the
+// listening goroutine.
+func (ue *UserEntity) deliverEventOnceStored(ev *model.WebSocketEvent) {
+	if ue.postWrites.queued() || len(ue.delayedEvents) > 0 {
+		ue.delayedEvents = append(ue.delayedEvents, ev)
+		return
+	}
+	ue.deliverEvent(ev)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRateLimitRetries is the maximum number of times a rate limited
+	// request is retried before its response is returned to the caller.
+	maxRateLimitRetries = 3
+	// defaultRateLimitBackoff is the time to back off for when a rate limited
+	// response doesn't say how long to wait.
+	defaultRateLimitBackoff = time.Second
+	// maxRateLimitBackoff caps the time to back off for, regardless of what
+	// the server asks for.
+	maxRateLimitBackoff = time.Minute
+)
+
+// rateLimitTransport backs off the entity when the server responds with
+// 429 (Too Many Requests), as real clients do. Once rate limited, any request
+// made by the entity waits until the backoff expires, after which the
+// rate limited request is retried.
+type rateLimitTransport struct {
+	transport    http.RoundTripper
+	mut          sync.Mutex
+	backoffUntil time.Time
+}
+
+// RoundTrip implements the RoundTripper interface for rateLimitTransport.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.transport.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		t.backoff(parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+
+		// Requests with a body can only be retried if the body can be
+		// obtained again.
+		if attempt >= maxRateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func (t *rateLimitTransport) backoff(d time.Duration) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if until := time.Now().Add(d); until.After(t.backoffUntil) {
+		t.backoffUntil = until
+	}
+}
+
+func (t *rateLimitTransport) wait(req *http.Request) error {
+	t.mut.Lock()
+	d := time.Until(t.backoffUntil)
+	t.mut.Unlock()
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// parseRetryAfter returns the time to wait for as specified by the given
+// Retry-After header value, which can either be a number of seconds or an
+// HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	d := defaultRateLimitBackoff
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		d = time.Duration(secs) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		d = date.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+	}
+
+	if d > maxRateLimitBackoff {
+		d = maxRateLimitBackoff
+	}
+
+	return d
+}
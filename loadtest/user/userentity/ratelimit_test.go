@@ -0,0 +1,74 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Now()
+	require.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("0", now))
+	require.Equal(t, defaultRateLimitBackoff, parseRetryAfter("", now))
+	require.Equal(t, defaultRateLimitBackoff, parseRetryAfter("invalid", now))
+	require.Equal(t, maxRateLimitBackoff, parseRetryAfter("3600", now))
+
+	date := now.Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	d := parseRetryAfter(date, now)
+	require.True(t, d > 8*time.Second && d <= 10*time.Second)
+	require.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Hour).UTC().Format(http.TimeFormat), now))
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	var calls int32
+	var limited int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		if atomic.LoadInt32(&limited) > 0 {
+			atomic.AddInt32(&limited, -1)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &rateLimitTransport{transport: http.DefaultTransport}}
+
+	t.Run("retries after backing off", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&limited, 1)
+		start := time.Now()
+		resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("payload"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "payload", string(body))
+		require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+		require.True(t, time.Since(start) >= time.Second)
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		atomic.StoreInt32(&limited, maxRateLimitRetries+1)
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, int32(maxRateLimitRetries+1), atomic.LoadInt32(&calls))
+	})
+}
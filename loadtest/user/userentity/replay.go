@@ -0,0 +1,88 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+)
+
+// EventRecorder writes WebSocket events as newline-delimited JSON, in the
+// format read by ReplayEvents.
+type EventRecorder struct {
+	mut sync.Mutex
+	w   io.Writer
+}
+
+// NewEventRecorder returns a new EventRecorder writing to the given writer.
+func NewEventRecorder(w io.Writer) *EventRecorder {
+	return &EventRecorder{w: w}
+}
+
+// Record writes the given event. It's safe to call concurrently.
+func (r *EventRecorder) Record(ev *model.WebSocketEvent) error {
+	if ev == nil {
+		return errors.New("userentity: event should not be nil")
+	}
+	data, err := ev.ToJSON()
+	if err != nil {
+		return fmt.Errorf("userentity: failed to marshal event: %w", err)
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if _, err := r.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("userentity: failed to write event: %w", err)
+	}
+	return nil
+}
+
+// ReplayEvents reads the newline-delimited JSON events from the given reader,
+// as written by an EventRecorder, and handles them in order as if they were
+// received through the WebSocket connection. It returns the first error
+// encountered. Gaps in the sequence numbers, as found in captures spanning
+// reconnects, are skipped over.
+func (ue *UserEntity) ReplayEvents(r io.Reader) (err error) {
+	// Batched post writes are applied before returning.
+	defer func() {
+		if flushErr := ue.flushPostWrites(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("userentity: failed to read event at line %d: %w", line, err)
+		}
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			ev, decodeErr := model.WebSocketEventFromJSON(bytes.NewReader(data))
+			if decodeErr != nil {
+				return fmt.Errorf("userentity: failed to decode event at line %d: %w", line, decodeErr)
+			}
+
+			if ev.GetSequence() != ue.wsServerSeq {
+				mlog.Debug("userentity: skipping sequence gap in replayed events", mlog.Int64("got", ev.GetSequence()), mlog.Int64("expected", ue.wsServerSeq))
+				atomic.StoreInt64(&ue.wsServerSeq, ev.GetSequence())
+			}
+			if _, handleErr := ue.wsEventHandler(ev); handleErr != nil {
+				return fmt.Errorf("userentity: failed to handle event at line %d: %w", line, handleErr)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayEvents(t *testing.T) {
+	channel := &model.Channel{Id: model.NewId()}
+	newEntity := func(t *testing.T) *UserEntity {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetCurrentChannel(channel))
+		return &UserEntity{store: s}
+	}
+
+	post := &model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: "hello"}
+	postData, err := json.Marshal(post)
+	require.NoError(t, err)
+	reaction := &model.Reaction{PostId: post.Id, UserId: model.NewId(), EmojiName: "smile"}
+	reactionData, err := json.Marshal(reaction)
+	require.NoError(t, err)
+	otherPost := &model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: "world"}
+	otherPostData, err := json.Marshal(otherPost)
+	require.NoError(t, err)
+
+	hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil)
+	hello.Add("connection_id", "conn")
+	posted := model.NewWebSocketEvent(model.WebsocketEventPosted, "", channel.Id, "", nil)
+	posted.Add("post", string(postData))
+	reacted := model.NewWebSocketEvent(model.WebsocketEventReactionAdded, "", channel.Id, "", nil)
+	reacted.Add("reaction", string(reactionData))
+	otherPosted := model.NewWebSocketEvent(model.WebsocketEventPosted, "", channel.Id, "", nil)
+	otherPosted.Add("post", string(otherPostData))
+
+	var buf bytes.Buffer
+	recorder := NewEventRecorder(&buf)
+	require.Error(t, recorder.Record(nil))
+	require.NoError(t, recorder.Record(hello.SetSequence(0)))
+	require.NoError(t, recorder.Record(posted.SetSequence(1)))
+	require.NoError(t, recorder.Record(reacted.SetSequence(2)))
+	// Events 3 to 9 are missing from the capture.
+	require.NoError(t, recorder.Record(otherPosted.SetSequence(10)))
+
+	ue := newEntity(t)
+	require.NoError(t, ue.ReplayEvents(&buf))
+	require.Equal(t, "conn", ue.ConnectionID())
+	require.Equal(t, int64(11), ue.wsServerSeq)
+
+	p, err := ue.store.Post(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, post.Message, p.Message)
+	reactions, err := ue.store.Reactions(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, []model.Reaction{*reaction}, reactions)
+	p, err = ue.store.Post(otherPost.Id)
+	require.NoError(t, err)
+	require.Equal(t, otherPost.Message, p.Message)
+
+	t.Run("empty", func(t *testing.T) {
+		require.NoError(t, newEntity(t).ReplayEvents(strings.NewReader("\n\n")))
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		err := newEntity(t).ReplayEvents(strings.NewReader("{\"event\": \"hello\", \"seq\": 0}\nnot json\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "line 2")
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", channel.Id, "", nil)
+		data, err := ev.ToJSON()
+		require.NoError(t, err)
+		err = newEntity(t).ReplayEvents(bytes.NewReader(data))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "post data is missing")
+	})
+}
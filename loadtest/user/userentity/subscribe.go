@@ -0,0 +1,216 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+// EventSubscription receives a copy of every WebSocket event received by the
+// entity, besides the events channel read by the controller. It's meant for
+// observers such as validators or recorders, which must treat the events as
+// read-only since they are shared with the controller.
+type EventSubscription struct {
+	ue      *UserEntity
+	events  chan *model.WebSocketEvent
+	dropped uint64
+	once    sync.Once
+	// queue is only set for the subscriptions made through Subscribe.
+	queue *eventQueue
+}
+
+// Subscribe registers a new subscriber getting the WebSocket events through
+// the returned channel. Unlike with SubscribeBuffered, no event is ever
+// dropped: the events the subscriber hasn't read yet are queued without
+// bound, so it should keep reading them until the channel is closed, or
+// cancel the subscription. The returned function cancels the subscription,
+// closing the channel and discarding the queued events, and can be called
+// more than once. On disconnect, the channel is closed once the queued events
+// are read. The events channel returned by Events keeps working alongside the
+// subscriptions. It's safe for concurrent use.
+func (ue *UserEntity) Subscribe() (<-chan *model.WebSocketEvent, func()) {
+	sub := &EventSubscription{
+		ue:     ue,
+		events: make(chan *model.WebSocketEvent),
+		queue:  newEventQueue(),
+	}
+	go sub.queue.forward(sub.events)
+	ue.subsMut.Lock()
+	ue.subs = append(ue.subs, sub)
+	ue.subsMut.Unlock()
+	return sub.events, sub.Unsubscribe
+}
+
+// SubscribeBuffered registers a new subscriber getting the WebSocket events
+// through a channel buffered with the given size. Events are received as soon
+// as they are handled, even while paused, and are dropped when the buffer is
+// full so that a slow subscriber never blocks the controller. Subscriptions
+// are kept across reconnects until Unsubscribe is called or the entity
+// disconnects. It's safe for concurrent use.
+func (ue *UserEntity) SubscribeBuffered(bufferSize int) *EventSubscription {
+	sub := &EventSubscription{
+		ue:     ue,
+		events: make(chan *model.WebSocketEvent, bufferSize),
+	}
+	ue.subsMut.Lock()
+	ue.subs = append(ue.subs, sub)
+	ue.subsMut.Unlock()
+	return sub
+}
+
+// Events returns the channel the events are received from. It's closed once
+// Unsubscribe is called or the entity disconnects.
+func (sub *EventSubscription) Events() <-chan *model.WebSocketEvent {
+	return sub.events
+}
+
+// Dropped returns the number of events dropped because the subscriber didn't
+// keep up. It's safe for concurrent use.
+func (sub *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// Unsubscribe stops sending events to the subscriber and closes its channel.
+// Calling it more than once is a no-op.
+func (sub *EventSubscription) Unsubscribe() {
+	sub.once.Do(func() {
+		ue := sub.ue
+		ue.subsMut.Lock()
+		defer ue.subsMut.Unlock()
+		for i, s := range ue.subs {
+			if s == sub {
+				ue.subs = append(ue.subs[:i], ue.subs[i+1:]...)
+				break
+			}
+		}
+		if sub.queue != nil {
+			sub.queue.stop()
+			return
+		}
+		close(sub.events)
+	})
+}
+
+// closeSubscriptions closes all the subscriptions. It's only meant to be
+// called once the listener stopped, so that no event is published to a
+// closed channel.
+func (ue *UserEntity) closeSubscriptions() {
+	ue.subsMut.Lock()
+	subs := ue.subs
+	ue.subs = nil
+	ue.subsMut.Unlock()
+
+	for _, sub := range subs {
+		if sub.queue != nil {
+			sub.queue.end()
+			continue
+		}
+		sub.once.Do(func() {
+			close(sub.events)
+		})
+	}
+}
+
+// publishEvent sends the given event to all the subscribers, without
+// blocking.
+func (ue *UserEntity) publishEvent(ev *model.WebSocketEvent) {
+	ue.subsMut.RLock()
+	defer ue.subsMut.RUnlock()
+	for _, sub := range ue.subs {
+		if sub.queue != nil {
+			sub.queue.push(ev)
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// eventQueue is an unbounded queue of events, forwarded to a subscription
+// channel by its own goroutine so that a slow subscriber neither blocks the
+// listener nor loses events.
+type eventQueue struct {
+	mut    sync.Mutex
+	events []*model.WebSocketEvent
+	// wake is signaled when events are pushed.
+	wake chan struct{}
+	// ended is closed once no more events are pushed, and stopped once the
+	// queued events should be discarded.
+	ended    chan struct{}
+	stopped  chan struct{}
+	endOnce  sync.Once
+	stopOnce sync.Once
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		wake:    make(chan struct{}, 1),
+		ended:   make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// push queues the given event without blocking.
+func (q *eventQueue) push(ev *model.WebSocketEvent) {
+	q.mut.Lock()
+	q.events = append(q.events, ev)
+	q.mut.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) take() []*model.WebSocketEvent {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// end makes forward return once the queued events are sent.
+func (q *eventQueue) end() {
+	q.endOnce.Do(func() { close(q.ended) })
+}
+
+// stop makes forward return right away.
+func (q *eventQueue) stop() {
+	q.stopOnce.Do(func() { close(q.stopped) })
+}
+
+// forward sends the queued events to out, in order, until the queue is
+// stopped, or ended and empty. It closes out before returning.
+func (q *eventQueue) forward(out chan<- *model.WebSocketEvent) {
+	defer close(out)
+	for {
+		events := q.take()
+		if len(events) == 0 {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.ended:
+				// Events pushed before ending are still sent.
+				if events = q.take(); len(events) == 0 {
+					return
+				}
+			case <-q.stopped:
+				return
+			}
+		}
+		for _, ev := range events {
+			select {
+			case out <- ev:
+			case <-q.stopped:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTypingRateLimited is returned by SendTypingEvent when the typing event
+// is dropped for exceeding the configured rate.
+var ErrTypingRateLimited = errors.New("userentity: typing event rate limited")
+
+// typingCoalescer limits the typing events sent for each channel to one per
+// window. Events received while the window is still open are held back and
+// only the latest one is sent once it closes.
+//
+// typingCoalescer is not safe for concurrent use. It's only meant to be used
+// by the listening goroutine.
+type typingCoalescer struct {
+	window   time.Duration
+	lastSent map[string]time.Time
+	pending  map[string]userTypingMsg
+	timer    *time.Timer
+}
+
+func newTypingCoalescer(window time.Duration) *typingCoalescer {
+	return &typingCoalescer{
+		window:   window,
+		lastSent: map[string]time.Time{},
+		pending:  map[string]userTypingMsg{},
+	}
+}
+
+// add records the given typing event, returning whether it should be sent
+// right away.
+func (tc *typingCoalescer) add(msg userTypingMsg, now time.Time) bool {
+	if tc.window <= 0 {
+		return true
+	}
+	if last, ok := tc.lastSent[msg.channelId]; !ok || now.Sub(last) >= tc.window {
+		tc.lastSent[msg.channelId] = now
+		delete(tc.pending, msg.channelId)
+		return true
+	}
+	tc.pending[msg.channelId] = msg
+	tc.arm(now)
+	return false
+}
+
+// due returns the held back events whose window has closed, which are
+// expected to be sent.
+func (tc *typingCoalescer) due(now time.Time) []userTypingMsg {
+	var msgs []userTypingMsg
+	for channelId, msg := range tc.pending {
+		if now.Sub(tc.lastSent[channelId]) >= tc.window {
+			msgs = append(msgs, msg)
+			tc.lastSent[channelId] = now
+			delete(tc.pending, channelId)
+		}
+	}
+	tc.arm(now)
+	return msgs
+}
+
+// flush returns all the held back events regardless of their window.
+func (tc *typingCoalescer) flush() []userTypingMsg {
+	msgs := make([]userTypingMsg, 0, len(tc.pending))
+	for _, msg := range tc.pending {
+		msgs = append(msgs, msg)
+	}
+	tc.reset()
+	return msgs
+}
+
+// reset drops all the held back events and stops the timer.
+func (tc *typingCoalescer) reset() {
+	tc.lastSent = map[string]time.Time{}
+	tc.pending = map[string]userTypingMsg{}
+	if tc.timer != nil {
+		tc.timer.Stop()
+		tc.timer = nil
+	}
+}
+
+// C returns the channel signaling that some held back events may be due. It
+// returns nil if there are none.
+func (tc *typingCoalescer) C() <-chan time.Time {
+	if tc.timer == nil {
+		return nil
+	}
+	return tc.timer.C
+}
+
+// arm sets the timer to fire when the earliest window of the held back
+// events closes.
+func (tc *typingCoalescer) arm(now time.Time) {
+	if tc.timer != nil {
+		tc.timer.Stop()
+		tc.timer = nil
+	}
+	if len(tc.pending) == 0 {
+		return
+	}
+
+	var next time.Duration
+	first := true
+	for channelId := range tc.pending {
+		d := tc.lastSent[channelId].Add(tc.window).Sub(now)
+		if first || d < next {
+			next = d
+			first = false
+		}
+	}
+	tc.timer = time.NewTimer(next)
+}
+
+// typingLimiter is a per channel token bucket limiting the rate of the typing
+// events sent by a user, similarly to how real clients throttle them.
+//
+// typingLimiter is safe for concurrent use.
+type typingLimiter struct {
+	// rate is the number of tokens added per second.
+	rate  float64
+	burst float64
+
+	mut     sync.Mutex
+	buckets map[string]*typingBucket
+}
+
+type typingBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newTypingLimiter returns a limiter allowing rate events per second for each
+// channel, with bursts of up to burst events. A zero rate disables limiting.
+func newTypingLimiter(rate float64, burst int) *typingLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &typingLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*typingBucket{},
+	}
+}
+
+// allow consumes a token from the given channel's bucket, returning whether
+// the event can be sent.
+func (tl *typingLimiter) allow(channelId string, now time.Time) bool {
+	if tl == nil || tl.rate <= 0 {
+		return true
+	}
+
+	tl.mut.Lock()
+	defer tl.mut.Unlock()
+
+	b, ok := tl.buckets[channelId]
+	if !ok {
+		b = &typingBucket{tokens: tl.burst, last: now}
+		tl.buckets[channelId] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * tl.rate
+		if b.tokens > tl.burst {
+			b.tokens = tl.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypingCoalescer(t *testing.T) {
+	now := time.Now()
+	msg1 := userTypingMsg{channelId: "ch1"}
+	msg2 := userTypingMsg{channelId: "ch2"}
+
+	t.Run("disabled", func(t *testing.T) {
+		tc := newTypingCoalescer(0)
+		require.True(t, tc.add(msg1, now))
+		require.True(t, tc.add(msg1, now))
+		require.Nil(t, tc.C())
+	})
+
+	t.Run("coalesced", func(t *testing.T) {
+		tc := newTypingCoalescer(time.Second)
+		defer tc.reset()
+
+		require.True(t, tc.add(msg1, now))
+		require.True(t, tc.add(msg2, now))
+		require.False(t, tc.add(msg1, now.Add(100*time.Millisecond)))
+		latest := userTypingMsg{channelId: "ch1", parentId: "parent"}
+		require.False(t, tc.add(latest, now.Add(200*time.Millisecond)))
+		require.NotNil(t, tc.C())
+
+		require.Empty(t, tc.due(now.Add(500*time.Millisecond)))
+		require.Equal(t, []userTypingMsg{latest}, tc.due(now.Add(time.Second)))
+		require.Nil(t, tc.C())
+
+		// The window restarts from the flushed event.
+		require.False(t, tc.add(msg1, now.Add(1500*time.Millisecond)))
+		require.True(t, tc.add(msg1, now.Add(2*time.Second)))
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		tc := newTypingCoalescer(time.Minute)
+		require.True(t, tc.add(msg1, now))
+		require.False(t, tc.add(msg1, now))
+		require.Equal(t, []userTypingMsg{msg1}, tc.flush())
+		require.Nil(t, tc.C())
+		require.True(t, tc.add(msg1, now))
+	})
+}
+
+func TestTypingCoalescing(t *testing.T) {
+	var typingMsgs int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Action string `json:"action"`
+			}
+			if json.Unmarshal(data, &msg) == nil && msg.Action == "user_typing" {
+				atomic.AddInt32(&typingMsgs, 1)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:         strings.Replace(ts.URL, "http://", "ws://", 1),
+		TypingCoalesceWindow: time.Minute,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, ue.SendTypingEvent("channelId", ""))
+	}
+	require.NoError(t, ue.SendTypingEvent("otherChannelId", ""))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&typingMsgs) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The held back event is sent on a graceful disconnect.
+	require.NoError(t, ue.DisconnectGraceful())
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&typingMsgs) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTypingLimiter(t *testing.T) {
+	now := time.Now()
+
+	t.Run("disabled", func(t *testing.T) {
+		tl := newTypingLimiter(0, 0)
+		for i := 0; i < 100; i++ {
+			require.True(t, tl.allow("ch1", now))
+		}
+		var nilLimiter *typingLimiter
+		require.True(t, nilLimiter.allow("ch1", now))
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		// One event every two seconds, with bursts of up to three.
+		tl := newTypingLimiter(0.5, 3)
+		for i := 0; i < 3; i++ {
+			require.True(t, tl.allow("ch1", now))
+		}
+		require.False(t, tl.allow("ch1", now))
+		require.False(t, tl.allow("ch1", now.Add(time.Second)))
+		require.True(t, tl.allow("ch1", now.Add(2*time.Second)))
+		require.False(t, tl.allow("ch1", now.Add(2*time.Second)))
+
+		// Tokens don't accumulate past the burst.
+		for i := 0; i < 3; i++ {
+			require.True(t, tl.allow("ch1", now.Add(time.Hour)))
+		}
+		require.False(t, tl.allow("ch1", now.Add(time.Hour)))
+
+		// Over a longer period, the rate is enforced.
+		var allowed int
+		start := now.Add(2 * time.Hour)
+		for i := 0; i < 1000; i++ {
+			if tl.allow("ch1", start.Add(time.Duration(i)*100*time.Millisecond)) {
+				allowed++
+			}
+		}
+		// 3 from the burst, plus one every two seconds over 100 seconds.
+		require.Equal(t, 3+49, allowed)
+	})
+
+	t.Run("per channel", func(t *testing.T) {
+		tl := newTypingLimiter(1, 0)
+		require.True(t, tl.allow("ch1", now))
+		require.False(t, tl.allow("ch1", now))
+		require.True(t, tl.allow("ch2", now))
+		require.False(t, tl.allow("ch2", now))
+	})
+}
+
+func TestSendTypingEventRateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:     strings.Replace(ts.URL, "http://", "ws://", 1),
+		TypingEventRate:  0.1,
+		TypingEventBurst: 2,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	defer ue.Disconnect()
+
+	require.NoError(t, ue.SendTypingEvent("channelId", ""))
+	require.NoError(t, ue.SendTypingEvent("channelId", ""))
+	require.ErrorIs(t, ue.SendTypingEvent("channelId", ""), ErrTypingRateLimited)
+	require.NoError(t, ue.SendTypingEvent("otherChannelId", ""))
+}
+
+func TestTypingEventRateValidation(t *testing.T) {
+	require.Error(t, (&Config{TypingEventRate: -1}).IsValid())
+	require.Error(t, (&Config{TypingEventBurst: -1}).IsValid())
+	require.NoError(t, (&Config{TypingEventRate: 1, TypingEventBurst: 5}).IsValid())
+}
@@ -4,33 +4,142 @@
 package userentity
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/delivery"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/errorlog"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/websocket"
 	"github.com/mattermost/mattermost-load-test-ng/performance"
 
 	"github.com/gocolly/colly/v2"
+	gorillaws "github.com/gorilla/websocket"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
 )
 
 // UserEntity is an implementation of the User interface
 // which provides methods to interact with the Mattermost server.
 type UserEntity struct {
-	store       store.MutableUserStore
-	client      *model.Client4
+	// eventsReceived counts the WebSocket events received across all
+	// connections. It's accessed atomically and kept first to guarantee its
+	// 64-bit alignment.
+	eventsReceived uint64
+	// eventsApplied counts the received WebSocket events which changed the
+	// store. It's accessed atomically and kept here for the same alignment
+	// reason.
+	eventsApplied uint64
+	// wsServerSeq is the next expected sequence number of the main
+	// connection. It's only written by the listening goroutine, atomically so
+	// that it can be read concurrently, and kept here for the same alignment
+	// reason.
+	wsServerSeq int64
+
+	store  store.MutableUserStore
+	client *model.Client4
+	// extClient is used for the requests not made to the server, which are
+	// left out of the HTTP metrics.
+	extClient   *http.Client
 	wsClosing   chan struct{}
 	wsClosed    chan struct{}
 	wsErrorChan chan error
 	wsEventChan chan *model.WebSocketEvent
 	wsTyping    chan userTypingMsg
+	wsActions   chan func(client *websocket.Client) error
 	connected   bool
 	config      Config
 	metrics     *performance.UserEntityMetrics
-	wsConnID    string
-	wsServerSeq int64
+	// wsConnID holds the id of the current WebSocket connection as a string.
+	// It's written by the listening goroutine and can be read concurrently.
+	wsConnID   atomic.Value
+	wsDegraded bool
+	// wsGaveUp is set once the listener gave up reconnecting, until the
+	// user disconnects.
+	wsGaveUp bool
+	// wsDrain is set on a graceful disconnect, before closing wsClosing.
+	wsDrain  bool
+	delivery *delivery.Tracker
+	errorLog *errorlog.Logger
+	// droppedEvents counts the WebSocket events dropped because the events
+	// buffer was full, by event type.
+	droppedEventsMut sync.Mutex
+	droppedEvents    map[string]uint64
+	// subs are the additional subscribers to the WebSocket events.
+	subsMut sync.RWMutex
+	subs    []*EventSubscription
+	// dryRunSent counts the outbound WebSocket messages not sent in dry-run
+	// mode, by kind.
+	dryRunMut  sync.Mutex
+	dryRunSent map[string]uint64
+	// wsPaused is set while event processing is paused. It's accessed
+	// atomically. wsResumed wakes up the listener on resume, which then hands
+	// over the heldEvents, only accessed by the listening goroutine.
+	wsPaused   int32
+	wsResumed  chan struct{}
+	heldEvents []*model.WebSocketEvent
+	// eventApplied is set while handling an event once the store got changed
+	// by it. It's only accessed by the goroutine handling the events.
+	eventApplied bool
+	// onMissedEvents is called whenever a gap in the WebSocket event sequence
+	// is detected.
+	onMissedEvents func(oldSeq, newSeq int64)
+	// onReconnect is called whenever the WebSocket connection is
+	// re-established.
+	onReconnect func()
+	// onRefreshBindings is called whenever the Apps framework asks the
+	// clients to refresh their bindings.
+	onRefreshBindings func()
+	wsDialer          *gorillaws.Dialer
+	// wsErr is the last error the listener couldn't deliver, or the one that
+	// stopped it. It's only safe to read once wsClosed is closed.
+	wsErr      error
+	connEvents chan ConnectionEvent
+	// seqMismatches holds the times of the recent WebSocket sequence
+	// mismatches, used to detect an out of sync store.
+	seqMismatches []time.Time
+	// inSeqEvents counts the consecutive in-sequence events received while
+	// the store is unhealthy.
+	inSeqEvents    int
+	storeUnhealthy int32
+	// wsRand is used to jitter the WebSocket reconnect wait time. It's only
+	// accessed by the listening goroutine.
+	wsRand *rand.Rand
+	// wsExtraConns are the connections opened in addition to the main one.
+	// wsExtraWG tracks their listening goroutines.
+	wsExtraConns []*extraConn
+	wsExtraWG    sync.WaitGroup
+	// wsFetches queues the fetches made on behalf of the listener while
+	// connected, run by the goroutine tracked by wsFetchWG.
+	wsFetches       chan fetchRequest
+	wsFetchWG       sync.WaitGroup
+	fetchHTTPClient *http.Client
+	typingLimiter   *typingLimiter
+	// postWrites batches the post writes made while handling events. It's
+	// nil if batching is disabled. delayedEvents holds the events handled
+	// while writes were batched, delivered once these are applied. It's only
+	// accessed by the listening goroutine.
+	postWrites    *postWriteBatcher
+	delayedEvents []*model.WebSocketEvent
+	// pendingPosts tracks the posts created by the user to measure the time
+	// taken for them to be delivered back. It's nil if metrics are disabled.
+	pendingPosts *pendingPosts
+	// currentChannel holds the *currentChannelEntry set by
+	// SetCurrentChannel. It can be read by the listening goroutine.
+	currentChannel atomic.Value
+	clock          Clock
+	channelFilter  func(channelId string) bool
+	loadWSState    func() (WebSocketState, bool)
+	saveWSState    func(state WebSocketState)
+	traceHook      func(raw []byte, ev *model.WebSocketEvent)
 }
 
 // Config holds necessary information required by a UserEntity.
@@ -45,6 +154,190 @@ type Config struct {
 	Email string
 	// The password to be used by the entity.
 	Password string
+	// The persona the entity belongs to. It's used to tag the emitted metrics.
+	Persona string
+	// The minimum duration of a request for its latency sample to carry an
+	// exemplar holding the server's request id. Zero disables exemplars.
+	ExemplarMinLatency time.Duration
+	// The number of consecutive WebSocket reconnect attempts after which the
+	// entity is reported as degraded. Zero disables the degraded state.
+	WebSocketDegradedThreshold int
+	// The number of consecutive WebSocket reconnect attempts after which the
+	// entity stops reconnecting and reports ErrReconnectGaveUp. Zero means it
+	// will retry indefinitely.
+	WebSocketMaxReconnectAttempts int
+	// The offset applied to the timestamps sent by the entity, used to
+	// simulate a client with a skewed clock.
+	ClockSkew time.Duration
+	// An optional mobile device id. If set, the entity logs in with it so that
+	// the server sends push notifications to its session.
+	DeviceId string
+	// An optional locale. If set, the entity sets it as its locale
+	// preference and sends it in the Accept-Language header.
+	Locale string
+	// If true, the entity backs off when rate limited by the server,
+	// honoring the Retry-After header, before retrying the request.
+	RateLimitBackoff bool
+	// The number of WebSocket sequence mismatches within StoreUnhealthyWindow
+	// after which the entity's store is considered out of sync. Zero disables
+	// the check.
+	StoreUnhealthyThreshold int
+	// The time window over which sequence mismatches are counted.
+	StoreUnhealthyWindow time.Duration
+	// The number of consecutive in-sequence WebSocket events after which an
+	// unhealthy store is considered in sync again.
+	StoreRecoveryEvents int
+	// The fraction by which the WebSocket reconnect wait time is randomly
+	// increased or decreased. Zero disables the jitter.
+	WebSocketReconnectJitter float64
+	// The maximum time to wait for a typing event to be queued for sending.
+	// Defaults to one second if zero.
+	TypingEventTimeout time.Duration
+	// The maximum time a graceful disconnect waits for the events already
+	// received to be consumed. Defaults to five seconds if zero.
+	DisconnectDrainTimeout time.Duration
+	// The number of WebSocket events that can be buffered while waiting to be
+	// consumed through the events channel. Zero means every event is handed
+	// over directly.
+	EventsBufferSize int
+	// The number of connection lifecycle events that can be buffered for
+	// subscribers. Zero disables publishing them.
+	ConnectionEventsBufferSize int
+	// The minimum time to wait before reconnecting the WebSocket. Defaults
+	// to three seconds if zero.
+	WebSocketMinReconnectDuration time.Duration
+	// The maximum time to wait before reconnecting the WebSocket. Defaults
+	// to five minutes if zero.
+	WebSocketMaxReconnectDuration time.Duration
+	// The number of consecutive failed connections after which the wait
+	// before reconnecting starts growing. Defaults to seven if zero.
+	WebSocketFailThreshold int
+	// The time window within which the typing events for a same channel are
+	// coalesced into a single one. Zero disables coalescing.
+	TypingCoalesceWindow time.Duration
+	// If true, reactions received for any post in the store are applied,
+	// not just the ones for posts in the current channel.
+	TrackAllLoadedChannels bool
+	// The time after which a WebSocket connection is considered healthy,
+	// resetting the reconnect backoff once it drops. Zero means the backoff
+	// is never reset.
+	WebSocketHealthyDuration time.Duration
+	// The maximum time to wait for any message from the server before the
+	// WebSocket connection is considered dead and reconnected. Defaults to
+	// one minute if zero.
+	WebSocketReadTimeout time.Duration
+	// The maximum number of typing events per second sent for a same
+	// channel. Events exceeding the rate are dropped. Zero disables the
+	// limit.
+	TypingEventRate float64
+	// The number of typing events that can be sent in a burst for a same
+	// channel before TypingEventRate applies. Defaults to one if zero.
+	TypingEventBurst int
+	// The number of WebSocket actions that can be queued while waiting to be
+	// run by the listening goroutine. Zero means every action is handed over
+	// directly.
+	WebSocketActionsBufferSize int
+	// The maximum number of missed WebSocket events that are recovered from
+	// by fetching the posts of the current channel instead of reconnecting.
+	// Zero means always reconnecting.
+	WebSocketSeqGapRecoveryThreshold int
+	// If true, WebSocket messages are compressed if the server supports it.
+	WebSocketEnableCompression bool
+	// If true, WebSocket events are dropped when the events buffer is full
+	// instead of blocking the listener until they are consumed.
+	DropEventsWhenFull bool
+	// The maximum number of WebSocket events held back while paused. Events
+	// received past it are dropped. Defaults to 1000 if zero.
+	MaxHeldEvents int
+	// The number of concurrent WebSocket connections opened by the user, to
+	// model clients connected from several devices. Zero means one.
+	WebSocketConnections int
+	// The maximum number of post writes, made while handling WebSocket
+	// events, that are applied to the store at once. Zero disables batching.
+	PostWriteBatchSize int
+	// The maximum time a batched post write waits before being applied to the
+	// store. Defaults to 100 milliseconds if zero.
+	PostWriteFlushInterval time.Duration
+	// If true, the users received through user_updated events are stored
+	// even if they weren't already, instead of only refreshing the stored
+	// ones.
+	CacheUpdatedUsers bool
+	// The maximum size in bytes of a WebSocket message read from the server.
+	// Larger messages make the connection close. Defaults to
+	// websocket.DefaultMaxMessageSize if zero. A negative value disables the
+	// limit.
+	WebSocketMaxMessageSize int64
+	// If true, the typing events and WebSocket actions are counted and
+	// logged instead of being sent, while the received events are still
+	// handled. Requests made through the API client are unaffected.
+	DryRun bool
+	// If true, Setup.EventTraceHook is called for every WebSocket event
+	// received. It's meant for debugging, to capture the exact payloads sent
+	// by the server.
+	TraceEvents bool
+	// The minimum time a channel must have been the current one for the
+	// reactions to its posts to be applied, so that rapid channel switches
+	// don't attribute them to channels the user merely went through. Zero
+	// applies them as soon as the channel becomes current.
+	CurrentChannelDwell time.Duration
+	// If true, the entity sets its status to online through the API every
+	// time the WebSocket connection is re-established, as the webapp does.
+	SetOnlineOnReconnect bool
+	// The time after which a WebSocket event still waiting to be handed over
+	// to the controller is reported, with a warning and a metric, to spot
+	// the controllers which can't keep up. Zero disables the reporting.
+	SlowConsumerThreshold time.Duration
+}
+
+// IsValid checks whether a Config is valid or not.
+func (c *Config) IsValid() error {
+	if c.WebSocketMinReconnectDuration < 0 || c.WebSocketMaxReconnectDuration < 0 {
+		return errors.New("WebSocket reconnect durations should not be negative")
+	}
+	if c.WebSocketReadTimeout < 0 {
+		return errors.New("WebSocketReadTimeout should not be negative")
+	}
+	if c.TypingEventRate < 0 || c.TypingEventBurst < 0 {
+		return errors.New("TypingEventRate and TypingEventBurst should not be negative")
+	}
+	if c.WebSocketSeqGapRecoveryThreshold < 0 {
+		return errors.New("WebSocketSeqGapRecoveryThreshold should not be negative")
+	}
+	if c.WebSocketConnections < 0 {
+		return errors.New("WebSocketConnections should not be negative")
+	}
+	if c.PostWriteBatchSize < 0 || c.PostWriteFlushInterval < 0 {
+		return errors.New("PostWriteBatchSize and PostWriteFlushInterval should not be negative")
+	}
+	if c.WebSocketActionsBufferSize < 0 {
+		return errors.New("WebSocketActionsBufferSize should not be negative")
+	}
+	if c.WebSocketFailThreshold < 0 {
+		return errors.New("WebSocketFailThreshold should not be negative")
+	}
+	if c.SlowConsumerThreshold < 0 {
+		return errors.New("SlowConsumerThreshold should not be negative")
+	}
+	if minWait, maxWait, _ := c.reconnectBounds(); minWait > maxWait {
+		return fmt.Errorf("WebSocketMinReconnectDuration (%s) should not be greater than WebSocketMaxReconnectDuration (%s)", minWait, maxWait)
+	}
+	return nil
+}
+
+// reconnectBounds returns the WebSocket reconnect settings, falling back to
+// the defaults for the ones not set.
+func (c *Config) reconnectBounds() (minWait, maxWait time.Duration, failThreshold int) {
+	minWait, maxWait, failThreshold = c.WebSocketMinReconnectDuration, c.WebSocketMaxReconnectDuration, c.WebSocketFailThreshold
+	if minWait == 0 {
+		minWait = defaultWebsocketMinReconnectDuration
+	}
+	if maxWait == 0 {
+		maxWait = defaultWebsocketMaxReconnectDuration
+	}
+	if failThreshold == 0 {
+		failThreshold = defaultWebsocketFailThreshold
+	}
+	return minWait, maxWait, failThreshold
 }
 
 // Setup contains data used to create a new instance of UserEntity.
@@ -55,11 +348,67 @@ type Setup struct {
 	Transport http.RoundTripper
 	// An optional object used to collect metrics.
 	Metrics *performance.UserEntityMetrics
+	// An optional tracker used to check that posted events get delivered.
+	DeliveryTracker *delivery.Tracker
+	// An optional logger the errors sent through the channel returned by
+	// Connect are also written to, grouped by Config.Persona.
+	ErrorLog *errorlog.Logger
+	// An optional callback called whenever the entity detects it missed
+	// WebSocket events, with the last expected sequence number and the one
+	// the entity continues from. It's run by the listening goroutine so it
+	// should not block.
+	MissedEventsHandler func(oldSeq, newSeq int64)
+	// An optional callback called every time the WebSocket connection is
+	// re-established, but not on the initial connection. It's run by the
+	// listening goroutine so it should not block.
+	ReconnectHook func()
+	// An optional callback called every time an Apps framework event asks
+	// the clients to re-fetch the apps bindings. The event is forwarded to
+	// the controller as any other. It's run by the listening goroutine so it
+	// should not block.
+	RefreshBindingsHook func()
+	// An optional dialer used to establish the WebSocket connection, e.g. to
+	// go through a proxy or use custom TLS settings.
+	WebSocketDialer *gorillaws.Dialer
+	// An optional clock used for the WebSocket reconnect timing and the time
+	// spent in the current channel. Defaults to the real clock.
+	Clock Clock
+	// An optional predicate deciding whether the WebSocket events for a
+	// channel are applied to the store. Events for the rejected channels are
+	// skipped before being decoded, though they are still delivered through
+	// the events channel. It's called by the listening goroutine so it should
+	// be fast. If nil, the events for all channels are applied.
+	ChannelFilter func(channelId string) bool
+	// An optional hook called on connect to seed the WebSocket state, e.g.
+	// persisted by a previous process, so that the server's event stream can
+	// be resumed. It should return false if there's no state to resume from.
+	// It's only called until the first connection is established.
+	LoadWebSocketState func() (WebSocketState, bool)
+	// An optional hook called whenever the WebSocket state changes, i.e. on
+	// every event received, so that it can be persisted. It's run by the
+	// listening goroutine so it should not block.
+	SaveWebSocketState func(state WebSocketState)
+	// An optional hook called with the exact bytes of every WebSocket event
+	// received on the main connection, along with the parsed event, before
+	// it's handled. It's only used if Config.TraceEvents is set. It's run by
+	// the goroutine reading from the connection so it should not block.
+	EventTraceHook func(raw []byte, ev *model.WebSocketEvent)
+}
+
+// WebSocketState holds what's needed to resume the server's WebSocket event
+// stream. If the server can't resume it, e.g. because the connection is too
+// old, the sequence is reset and the missed events handler is called.
+type WebSocketState struct {
+	ConnID    string
+	ServerSeq int64
 }
 
 type userTypingMsg struct {
 	channelId string
 	parentId  string
+	// enqueuedAt is the time at which SendTypingEvent was called, used to
+	// measure how long the event waited for the listener.
+	enqueuedAt time.Time
 }
 
 type ueTransport struct {
@@ -72,13 +421,20 @@ type ueTransport struct {
 func (t *ueTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	startTime := time.Now()
 	resp, err := t.transport.RoundTrip(req)
-	t.ue.observeHTTPRequestTimes(time.Since(startTime).Seconds())
+	var requestId string
+	if resp != nil {
+		requestId = resp.Header.Get(model.HeaderRequestId)
+	}
+	t.ue.observeHTTPRequestTimes(time.Since(startTime), requestId)
 	if os.IsTimeout(err) {
 		t.ue.incHTTPTimeouts(req.URL.Path, req.Method)
 	}
 	if resp != nil && resp.StatusCode >= 400 {
 		t.ue.incHTTPErrors(req.URL.Path, req.Method, resp.StatusCode)
 	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		t.ue.incHTTPRateLimited(req.URL.Path, req.Method)
+	}
 	return resp, err
 }
 
@@ -89,22 +445,66 @@ func (ue *UserEntity) Store() store.UserStore {
 
 // New returns a new instance of a UserEntity.
 func New(setup Setup, config Config) *UserEntity {
+	if err := config.IsValid(); err != nil {
+		mlog.Error("userentity: invalid config", mlog.Err(err))
+		return nil
+	}
+
 	var ue UserEntity
 	ue.config = config
+	ue.wsResumed = make(chan struct{}, 1)
 	ue.store = setup.Store
 	ue.metrics = setup.Metrics
+	ue.delivery = setup.DeliveryTracker
+	ue.errorLog = setup.ErrorLog
+	ue.onMissedEvents = setup.MissedEventsHandler
+	ue.onReconnect = setup.ReconnectHook
+	ue.onRefreshBindings = setup.RefreshBindingsHook
+	ue.wsDialer = setup.WebSocketDialer
+	ue.channelFilter = setup.ChannelFilter
+	ue.loadWSState = setup.LoadWebSocketState
+	ue.saveWSState = setup.SaveWebSocketState
+	if config.TraceEvents {
+		ue.traceHook = setup.EventTraceHook
+	}
+	ue.clock = setup.Clock
+	if ue.clock == nil {
+		ue.clock = realClock{}
+	}
+	ue.typingLimiter = newTypingLimiter(config.TypingEventRate, config.TypingEventBurst)
+	ue.postWrites = newPostWriteBatcher(config.PostWriteBatchSize, config.PostWriteFlushInterval)
+	if config.ConnectionEventsBufferSize > 0 {
+		ue.connEvents = make(chan ConnectionEvent, config.ConnectionEventsBufferSize)
+	}
 	ue.client = model.NewAPIv4Client(config.ServerURL)
 
+	// The jitter is seeded from the username so that the reconnect
+	// pattern of a given user is reproducible across runs.
+	h := fnv.New64a()
+	h.Write([]byte(config.Username))
+	ue.wsRand = rand.New(rand.NewSource(int64(h.Sum64())))
+
 	if setup.Transport == nil {
 		setup.Transport = http.DefaultTransport
 	}
+	ue.extClient = &http.Client{Transport: setup.Transport, Timeout: extRequestTimeout}
 	if setup.Metrics != nil {
+		ue.pendingPosts = newPendingPosts()
 		setup.Transport = &ueTransport{
 			transport: setup.Transport,
 			ue:        &ue,
 		}
 	}
+	if config.RateLimitBackoff {
+		setup.Transport = &rateLimitTransport{
+			transport: setup.Transport,
+		}
+	}
 	ue.client.HTTPClient = &http.Client{Transport: setup.Transport}
+	ue.fetchHTTPClient = &http.Client{Transport: setup.Transport, Timeout: fetchTimeout}
+	if config.Locale != "" {
+		ue.client.HTTPHeader = map[string]string{"Accept-Language": config.Locale}
+	}
 
 	err := ue.store.SetUser(&model.User{
 		Username: config.Username,
@@ -121,23 +521,63 @@ func New(setup Setup, config Config) *UserEntity {
 
 // Connect creates a WebSocket connection to the server and starts listening for messages.
 func (ue *UserEntity) Connect() (<-chan error, error) {
+	return ue.ConnectWithContext(context.Background())
+}
+
+// ConnectWithContext is like Connect, except that the given context can be
+// cancelled to stop retrying to establish the connection. When cancelled
+// while waiting to retry, no further attempts are made and the context's error
+// is reported, and also returned by Disconnect. The context has no effect once
+// connected.
+func (ue *UserEntity) ConnectWithContext(ctx context.Context) (<-chan error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if ue.connected {
 		return nil, errors.New("user is already connected")
 	}
-	ue.wsClosing = make(chan struct{})
-	ue.wsClosed = make(chan struct{})
-	ue.wsErrorChan = make(chan error, 1)
 	if ue.client.AuthToken == "" {
 		return nil, errors.New("user is not authenticated")
 	}
-	if ue.connected {
-		return nil, errors.New("user is already connected")
+
+	ue.wsClosing = make(chan struct{})
+	ue.wsClosed = make(chan struct{})
+	ue.wsErrorChan = make(chan error, 1)
+	ue.wsErr = nil
+
+	// A state already in memory is more recent than any persisted one.
+	if ue.loadWSState != nil && ue.ConnectionID() == "" {
+		if state, ok := ue.loadWSState(); ok {
+			ue.wsConnID.Store(state.ConnID)
+			atomic.StoreInt64(&ue.wsServerSeq, state.ServerSeq)
+		}
 	}
 
-	ue.wsEventChan = make(chan *model.WebSocketEvent)
+	ue.wsEventChan = make(chan *model.WebSocketEvent, ue.config.EventsBufferSize)
 	ue.wsTyping = make(chan userTypingMsg)
-	go ue.listen(ue.wsErrorChan)
+	ue.wsActions = make(chan func(client *websocket.Client) error, ue.config.WebSocketActionsBufferSize)
+	ue.wsFetches = make(chan fetchRequest, fetchQueueSize)
+	ue.wsFetchWG.Add(1)
+	go ue.runFetches(ue.wsFetches, ue.wsErrorChan)
+	go ue.listen(ctx, ue.wsErrorChan)
+	if n := ue.config.WebSocketConnections - 1; n > 0 {
+		// The extra connections are kept across reconnects so that they can
+		// be resumed.
+		if len(ue.wsExtraConns) != n {
+			ue.wsExtraConns = newExtraConns(ue.config.Username, n)
+		}
+		for _, c := range ue.wsExtraConns {
+			ue.wsExtraWG.Add(1)
+			go ue.listenExtra(c)
+		}
+	}
 	ue.connected = true
+	if ue.delivery != nil {
+		ue.delivery.SetConnected(ue.store.Id(), ue.expectsPosts)
+	}
+	if ue.errorLog != nil {
+		return ue.errorLog.Tee(ue.config.Persona, ue.wsErrorChan), nil
+	}
 	return ue.wsErrorChan, nil
 }
 
@@ -156,28 +596,77 @@ func (ue *UserEntity) FetchStaticAssets() error {
 	return c.Visit(ue.client.URL)
 }
 
-// Disconnect closes the WebSocket connection.
+// Disconnect closes the WebSocket connection. It returns once the listener
+// has stopped, with the last error it couldn't deliver through the errors
+// channel, or the one that made it stop, if any.
 func (ue *UserEntity) Disconnect() error {
+	return ue.disconnect(false)
+}
+
+// DisconnectGraceful closes the WebSocket connection. Unlike Disconnect, the
+// events already received are still handled and pushed to the events channel
+// until they are all consumed or the configured deadline passes.
+func (ue *UserEntity) DisconnectGraceful() error {
+	return ue.disconnect(true)
+}
+
+func (ue *UserEntity) disconnect(drain bool) error {
 	ue.client.HTTPClient.CloseIdleConnections()
 	if !ue.connected {
-		return errors.New("user is not connected")
+		return ErrNotConnected
 	}
 	// We exit the listener loop first, and then close the connection.
 	// Otherwise, it tries to reconnect first, and then
 	// exits, which causes unnecessary delay.
+	ue.wsDrain = drain
 	close(ue.wsClosing)
 
 	<-ue.wsClosed
+	ue.wsExtraWG.Wait()
+	// The fetches still queued are discarded.
+	ue.wsFetchWG.Wait()
+	ue.wsFetches = nil
+
+	if ue.delivery != nil {
+		ue.delivery.SetConnected(ue.store.Id(), nil)
+	}
+
+	ue.setWebSocketDegraded(false)
+	ue.setWebSocketGaveUp(false)
 
+	ue.closeSubscriptions()
 	close(ue.wsEventChan)
 	close(ue.wsTyping)
+	close(ue.wsActions)
 	close(ue.wsErrorChan)
 	ue.connected = false
-	return nil
+	return ue.wsErr
+}
+
+// isChannelMember reports whether the entity is known to be a member of the
+// specified channel.
+func (ue *UserEntity) isChannelMember(channelId string) bool {
+	member, err := ue.store.ChannelMember(channelId, ue.store.Id())
+	return err == nil && member.UserId != ""
+}
+
+// expectsPosts reports whether the entity is expected to receive the posts made
+// in the specified channel, which it's a member of and doesn't filter out.
+func (ue *UserEntity) expectsPosts(channelId string) bool {
+	if ue.channelFilter != nil && !ue.channelFilter(channelId) {
+		return false
+	}
+	return ue.isChannelMember(channelId)
+}
+
+// Now returns the current time as seen by the entity's client. This includes
+// any configured clock skew.
+func (ue *UserEntity) Now() time.Time {
+	return time.Now().Add(ue.config.ClockSkew)
 }
 
 // Events returns the WebSocket event chan for the controller
-// to listen and react to events.
+// to listen and react to events. Additional consumers can use Subscribe.
 func (ue *UserEntity) Events() <-chan *model.WebSocketEvent {
 	return ue.wsEventChan
 }
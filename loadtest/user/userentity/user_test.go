@@ -4,13 +4,18 @@
 package userentity
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost-load-test-ng/defaults"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+	"github.com/mattermost/mattermost-load-test-ng/performance"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -96,3 +101,162 @@ func TestIsSysAdmin(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, ok)
 }
+
+func TestRequestTimesExemplars(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(model.HeaderRequestId, "testrequestid")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	getMetrics := func(t *testing.T, m *performance.Metrics) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	newUser := func(t *testing.T, m *performance.Metrics, minLatency time.Duration) *UserEntity {
+		t.Helper()
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+			ServerURL:          ts.URL,
+			WebSocketURL:       ts.URL,
+			ExemplarMinLatency: minLatency,
+		})
+		require.NotNil(t, ue)
+		return ue
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		m := performance.NewMetrics()
+		ue := newUser(t, m, 0)
+
+		resp, err := ue.client.HTTPClient.Get(ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		out := getMetrics(t, m)
+		require.Contains(t, out, "loadtest_http_request_time_count")
+		require.NotContains(t, out, "testrequestid")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		m := performance.NewMetrics()
+		ue := newUser(t, m, time.Nanosecond)
+
+		resp, err := ue.client.HTTPClient.Get(ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		require.Contains(t, getMetrics(t, m), `request_id="testrequestid"`)
+	})
+}
+
+func TestRefreshCurrentChannel(t *testing.T) {
+	channel := &model.Channel{Id: model.NewId()}
+	seen := &model.Post{Id: model.NewId(), ChannelId: channel.Id, CreateAt: 1000}
+	deleted := &model.Post{Id: model.NewId(), ChannelId: channel.Id, CreateAt: 900}
+	created := &model.Post{Id: model.NewId(), ChannelId: channel.Id, CreateAt: 2000, Message: "created during the outage"}
+
+	queries := make(chan string, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/channels/"+channel.Id+"/posts", func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		list := &model.PostList{Posts: map[string]*model.Post{}}
+		if r.URL.Query().Get("since") != "" {
+			deletedCopy := deleted.Clone()
+			deletedCopy.DeleteAt = 1500
+			for _, post := range []*model.Post{created, deletedCopy} {
+				list.Order = append(list.Order, post.Id)
+				list.Posts[post.Id] = post
+			}
+		} else {
+			for _, post := range []*model.Post{created, seen} {
+				list.Order = append(list.Order, post.Id)
+				list.Posts[post.Id] = post
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	newEntity := func(t *testing.T) *UserEntity {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		ue := New(Setup{Store: s}, Config{ServerURL: ts.URL})
+		require.NotNil(t, ue)
+		return ue
+	}
+
+	t.Run("no current channel", func(t *testing.T) {
+		require.NoError(t, newEntity(t).RefreshCurrentChannel())
+		require.Empty(t, queries)
+	})
+
+	t.Run("after an outage", func(t *testing.T) {
+		ue := newEntity(t)
+		require.NoError(t, ue.store.SetChannel(channel))
+		require.NoError(t, ue.store.SetCurrentChannel(channel))
+		// The posts known before the outage.
+		require.NoError(t, ue.store.SetPosts([]*model.Post{seen, deleted}))
+
+		require.NoError(t, ue.RefreshCurrentChannel())
+		require.Len(t, queries, 1)
+		require.Contains(t, <-queries, "since=1000")
+
+		posts, err := ue.store.ChannelPostsSorted(channel.Id, true)
+		require.NoError(t, err)
+		require.Len(t, posts, 2)
+		require.Equal(t, seen.Id, posts[0].Id)
+		require.Equal(t, created.Id, posts[1].Id)
+	})
+
+	t.Run("empty store", func(t *testing.T) {
+		ue := newEntity(t)
+		require.NoError(t, ue.store.SetChannel(channel))
+		require.NoError(t, ue.store.SetCurrentChannel(channel))
+
+		require.NoError(t, ue.RefreshCurrentChannel())
+		require.Len(t, queries, 1)
+		require.NotContains(t, <-queries, "since")
+
+		posts, err := ue.store.ChannelPosts(channel.Id)
+		require.NoError(t, err)
+		require.Len(t, posts, 2)
+	})
+}
+
+func TestSearchGifs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/gfycats/search" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.Empty(t, r.Header.Get("Authorization"))
+		require.Equal(t, "thumbs up", r.URL.Query().Get("search_text"))
+		fmt.Fprint(w, `{"gfycats":[{"max5mbGif":"https://example.com/first.gif"},{"max5mbGif":""},{"max5mbGif":"https://example.com/second.gif"}]}`)
+	}))
+	defer ts.Close()
+
+	m := performance.NewMetrics()
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{ServerURL: ts.URL, WebSocketURL: ts.URL})
+	require.NotNil(t, ue)
+
+	urls, err := ue.SearchGifs(ts.URL+"/v1/gfycats/search", "thumbs up")
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://example.com/first.gif", "https://example.com/second.gif"}, urls)
+
+	_, err = ue.SearchGifs(ts.URL+"/unknown", "thumbs up")
+	require.Error(t, err)
+
+	// The requests are not made to the server, so they are not accounted for.
+	require.Zero(t, testutil.CollectAndCount(m.UserEntityMetrics().HTTPRequestTimes))
+	require.Zero(t, testutil.CollectAndCount(m.UserEntityMetrics().HTTPErrors))
+}
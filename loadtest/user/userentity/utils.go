@@ -3,7 +3,14 @@
 
 package userentity
 
-import "github.com/mattermost/mattermost-server/v6/model"
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
 
 func postsMapToSlice(postsMap map[string]*model.Post) []*model.Post {
 	posts := make([]*model.Post, len(postsMap))
@@ -22,3 +29,27 @@ func postListToSlice(list *model.PostList) []*model.Post {
 	}
 	return posts
 }
+
+// channelBookmarksRoute returns the API route for the bookmarks of the given
+// channel. The model.Client4 in use doesn't support channel bookmarks yet.
+func channelBookmarksRoute(channelId string) string {
+	return "/channels/" + channelId + "/bookmarks"
+}
+
+func imageProxyRoute(imageURL string) string {
+	return "/image?url=" + url.QueryEscape(imageURL)
+}
+
+// gifSearchCount is the number of results the GIF picker asks for.
+const gifSearchCount = 30
+
+// extRequestTimeout is the timeout of the requests not made to the server.
+const extRequestTimeout = 10 * time.Second
+
+// closeBody drains and closes the body of the given response.
+func closeBody(r *http.Response) {
+	if r.Body != nil {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_ = r.Body.Close()
+	}
+}
@@ -4,11 +4,18 @@
 package userentity
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/websocket"
 
@@ -18,80 +25,1180 @@ import (
 
 const (
 	// Same as the webapp settings.
-	minWebsocketReconnectDuration = 3 * time.Second
-	maxWebsocketReconnectDuration = 5 * time.Minute
-	maxWebsocketFails             = 7
+	defaultWebsocketMinReconnectDuration = 3 * time.Second
+	defaultWebsocketMaxReconnectDuration = 5 * time.Minute
+	defaultWebsocketFailThreshold        = 7
+
+	defaultTypingEventTimeout     = time.Second
+	defaultWebSocketActionTimeout = time.Second
+	defaultDisconnectDrainTimeout = 5 * time.Second
+	defaultMaxHeldEvents          = 1000
+	defaultWebsocketReadTimeout   = time.Minute
+	// Same as the server default for TimeBetweenUserTypingUpdatesMilliseconds.
+	defaultTypingTTL = 5 * time.Second
+	// Same as the number of posts the webapp loads at once.
+	refreshPostsPerPage = 60
+)
+
+// The post acknowledgement events, which are not available in the version of
+// the model package currently in use.
+const (
+	wsEventPostAcknowledgementAdded   = "post_acknowledgement_added"
+	wsEventPostAcknowledgementRemoved = "post_acknowledgement_removed"
 )
 
-var errSeqMismatch = errors.New("mismatch in server sequence number")
+// The event sent when several channels are viewed at once, which is not
+// available in the version of the model package currently in use.
+const wsEventMultipleChannelsViewed = "multiple_channels_viewed"
+
+// The event sent by the Apps framework when the apps bindings changed, which
+// is not available in the version of the model package currently in use.
+const wsEventAppsFrameworkRefreshBindings = "custom_com.mattermost.apps_refresh_bindings"
+
+var errSeqMismatch = errors.New("mismatch in server sequence number")
+
+func (ue *UserEntity) handleReactionEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["reaction"]; !ok {
+		return errors.New("reaction data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the reaction data should be a string, but it is %T", el)
+	}
+
+	var reaction *model.Reaction
+	if err := json.Unmarshal([]byte(data), &reaction); err != nil {
+		return err
+	}
+
+	var currentChannel *model.Channel
+	if !ue.config.TrackAllLoadedChannels {
+		var err error
+		currentChannel, err = ue.store.CurrentChannel()
+		if err != nil && !errors.Is(err, memstore.ErrChannelNotFound) {
+			return fmt.Errorf("failed to get current channel from store: %w", err)
+		} else if currentChannel == nil {
+			return nil
+		}
+	}
+
+	post, err := ue.store.Post(reaction.PostId)
+	if errors.Is(err, memstore.ErrPostNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get post from store: %w", err)
+	}
+	if currentChannel != nil && (post.ChannelId != currentChannel.Id || !ue.dwelledInChannel(currentChannel.Id)) {
+		return nil
+	}
+
+	switch ev.EventType() {
+	case model.WebsocketEventReactionAdded:
+		return ue.storeChanged(ue.store.SetReaction(reaction))
+	case model.WebsocketEventReactionRemoved:
+		if ok, err := ue.store.DeleteReaction(reaction); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("could not find reaction in the store")
+		}
+		ue.storeChanged(nil)
+	}
+
+	return nil
+}
+
+// handlePostAcknowledgementEvent records or removes an acknowledgement of a
+// post in the store.
+func (ue *UserEntity) handlePostAcknowledgementEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["acknowledgement"]; !ok {
+		return errors.New("acknowledgement data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the acknowledgement data should be a string, but it is %T", el)
+	}
+
+	var ack store.PostAcknowledgement
+	if err := json.Unmarshal([]byte(data), &ack); err != nil {
+		return err
+	}
+	if ack.PostId == "" || ack.UserId == "" {
+		return errors.New("post or user id data is missing")
+	}
+
+	if _, err := ue.store.Post(ack.PostId); errors.Is(err, memstore.ErrPostNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get post from store: %w", err)
+	}
+
+	if ev.EventType() == wsEventPostAcknowledgementAdded {
+		return ue.storeChanged(ue.store.SetPostAcknowledgement(&ack))
+	}
+	return ue.storeChanged(ue.store.DeletePostAcknowledgement(ack.PostId, ack.UserId))
+}
+
+func (ue *UserEntity) handlePostEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["post"]; !ok {
+		return errors.New("post data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the post data should be a string, but it is %T", el)
+	}
+
+	var post *model.Post
+	if err := json.Unmarshal([]byte(data), &post); err != nil {
+		return err
+	}
+
+	if ev.EventType() == model.WebsocketEventPosted && ue.delivery != nil {
+		ue.delivery.TrackReceived(ue.store.Id(), post.PendingPostId)
+	}
+	// Only the posts created by the user itself are measured.
+	if ev.EventType() == model.WebsocketEventPosted && ue.pendingPosts != nil && post.PendingPostId != "" {
+		if sentAt, ok := ue.pendingPosts.remove(post.PendingPostId); ok {
+			ue.observePostDeliveryTime(time.Since(sentAt))
+		}
+	}
+
+	// Posts are counted for every channel, including the ones not loaded, so
+	// the ones delivered again after reconnecting are told apart by id.
+	if ev.EventType() == model.WebsocketEventPosted && post.ChannelId != "" {
+		if counted, err := ue.store.RecordChannelPost(post.ChannelId, post.Id, time.Now()); err != nil {
+			return fmt.Errorf("failed to record channel post in store: %w", err)
+		} else if counted {
+			ue.storeChanged(nil)
+		}
+	}
+
+	// The root post needs to be updated before storing the reply, as replies
+	// already in the store are assumed to have been counted.
+	if ev.EventType() == model.WebsocketEventPosted && post.RootId != "" {
+		// Thread counts are updated on the stored posts directly.
+		_, replyPending := ue.postWrites.lookup(post.Id)
+		_, rootPending := ue.postWrites.lookup(post.RootId)
+		if replyPending || rootPending {
+			if err := ue.flushPostWrites(); err != nil {
+				return err
+			}
+		}
+		if err := ue.storeChanged(ue.store.UpdateThreadOnReply(post)); err != nil {
+			return fmt.Errorf("failed to update thread in store: %w", err)
+		}
+	}
+
+	// File ids are kept for every channel, so that the files recently shared
+	// can be found without storing the posts.
+	switch ev.EventType() {
+	case model.WebsocketEventPosted, model.WebsocketEventPostEdited:
+		if len(post.FileIds) > 0 {
+			if err := ue.storeChanged(ue.store.SetPostFileIds(post.Id, post.FileIds)); err != nil {
+				return fmt.Errorf("failed to set post file ids in store: %w", err)
+			}
+		}
+	case model.WebsocketEventPostDeleted:
+		if err := ue.storeChanged(ue.store.SetPostFileIds(post.Id, nil)); err != nil {
+			return fmt.Errorf("failed to remove post file ids from store: %w", err)
+		}
+	}
+
+	switch ev.EventType() {
+	case model.WebsocketEventPosted, model.WebsocketEventPostEdited:
+		stored, err := ue.storedPost(post.Id)
+		if err != nil && !errors.Is(err, memstore.ErrPostNotFound) {
+			return fmt.Errorf("failed to get post from store: %w", err)
+		}
+		if found := err == nil; found {
+			// Posts can be delivered again after reconnecting, in which case
+			// the stored post is kept as is.
+			if stored.EditAt == post.EditAt {
+				return nil
+			}
+			// Edits are applied to any post already in the store, to keep its
+			// content fresh, while new posts are only added for the current
+			// channel.
+			if ev.EventType() == model.WebsocketEventPostEdited {
+				return ue.storeChanged(ue.setPost(post))
+			}
+		}
+
+		currentChannel, err := ue.store.CurrentChannel()
+		if err == nil && currentChannel.Id == post.ChannelId {
+			return ue.storeChanged(ue.setPost(post))
+		} else if err != nil && !errors.Is(err, memstore.ErrChannelNotFound) {
+			return fmt.Errorf("failed to get current channel from store: %w", err)
+		}
+
+		// Mentions in the current channel are read right away so they are
+		// only counted for the other channels.
+		if ev.EventType() == model.WebsocketEventPosted {
+			return ue.handlePostMentions(ev, post)
+		}
+	case model.WebsocketEventPostDeleted:
+		return ue.storeChanged(ue.deletePost(post))
+	}
+
+	return nil
+}
+
+// handleEphemeralMessageEvent validates an ephemeral post, e.g. a bot's reply
+// to a slash command. Ephemeral posts only exist on the client so they aren't
+// stored: the controller gets them through the forwarded event.
+func (ue *UserEntity) handleEphemeralMessageEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["post"]; !ok {
+		return errors.New("post data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the post data should be a string, but it is %T", el)
+	}
+
+	var post *model.Post
+	if err := json.Unmarshal([]byte(data), &post); err != nil {
+		return err
+	}
+	if post == nil || post.ChannelId == "" {
+		return errors.New("channel id data is missing")
+	}
+
+	return nil
+}
+
+func (ue *UserEntity) handleUserUpdatedEvent(ev *model.WebSocketEvent) error {
+	data, ok := ev.GetData()["user"]
+	if !ok {
+		return errors.New("user data is missing")
+	}
+
+	// The user is sent as an object so it needs to be encoded back first.
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var user model.User
+	if err := json.Unmarshal(buf, &user); err != nil {
+		return err
+	}
+
+	// Our own user is kept updated through the API responses.
+	if user.Id == "" || user.Id == ue.store.Id() {
+		return nil
+	}
+
+	stored, err := ue.store.GetUser(user.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get user from store: %w", err)
+	} else if stored.Id == "" {
+		// Users we don't know about yet are only stored if configured to.
+		if !ue.config.CacheUpdatedUsers {
+			return nil
+		}
+		return ue.storeChanged(ue.store.SetUsers([]*model.User{&user}))
+	}
+
+	// The profile sent is sanitized so only the fields used to render
+	// mentions and direct channels are refreshed.
+	stored.Username = user.Username
+	stored.Nickname = user.Nickname
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.DeleteAt = user.DeleteAt
+	stored.UpdateAt = user.UpdateAt
+	return ue.storeChanged(ue.store.SetUsers([]*model.User{&stored}))
+}
+
+// handleChannelMembershipEvent keeps the members of the channels loaded in
+// the store, along with their member count, in sync when users are added to
+// or removed from them.
+func (ue *UserEntity) handleChannelMembershipEvent(ev *model.WebSocketEvent) error {
+	// Events sent to the user removed from a channel carry the channel id in
+	// their data rather than in the broadcast.
+	channelId := ev.GetBroadcast().ChannelId
+	if id, ok := ev.GetData()["channel_id"].(string); ok && id != "" {
+		channelId = id
+	}
+	userId, _ := ev.GetData()["user_id"].(string)
+	if userId == "" {
+		userId = ev.GetBroadcast().UserId
+	}
+	if channelId == "" || userId == "" {
+		return errors.New("channel or user id data is missing")
+	}
+
+	channel, err := ue.store.Channel(channelId)
+	if err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if channel == nil {
+		return nil
+	}
+
+	switch ev.EventType() {
+	case model.WebsocketEventUserAdded:
+		return ue.addChannelMember(channelId, userId)
+	case model.WebsocketEventUserRemoved:
+		// The entity itself no longer has access to the channel.
+		if userId == ue.store.Id() {
+			return ue.storeChanged(ue.store.DeleteChannel(channelId))
+		}
+		return ue.removeChannelMember(channelId, userId)
+	}
+
+	return nil
+}
+
+// addChannelMember stores the given user as a member of the given stored
+// channel, if not already.
+func (ue *UserEntity) addChannelMember(channelId, userId string) error {
+	member, err := ue.store.ChannelMember(channelId, userId)
+	if err != nil {
+		return fmt.Errorf("failed to get channel member from store: %w", err)
+	}
+	if member.UserId != "" {
+		return nil
+	}
+	if err := ue.storeChanged(ue.store.SetChannelMember(channelId, &model.ChannelMember{
+		ChannelId: channelId,
+		UserId:    userId,
+	})); err != nil {
+		return err
+	}
+	return ue.updateChannelMemberCount(channelId, 1)
+}
+
+// removeChannelMember removes the given user from the members of the given
+// stored channel.
+func (ue *UserEntity) removeChannelMember(channelId, userId string) error {
+	if err := ue.storeChanged(ue.store.RemoveChannelMember(channelId, userId)); err != nil {
+		return err
+	}
+	return ue.updateChannelMemberCount(channelId, -1)
+}
+
+// handleChannelMemberUpdatedEvent updates the roles and notify props of a
+// stored channel member.
+func (ue *UserEntity) handleChannelMemberUpdatedEvent(ev *model.WebSocketEvent) error {
+	data, ok := ev.GetData()["channelMember"].(string)
+	if !ok {
+		return errors.New("channelMember data is missing")
+	}
+	var updated model.ChannelMember
+	if err := json.Unmarshal([]byte(data), &updated); err != nil {
+		return fmt.Errorf("failed to unmarshal channel member: %w", err)
+	}
+	if updated.ChannelId == "" || updated.UserId == "" {
+		return errors.New("channel or user id data is missing")
+	}
+
+	if channel, err := ue.store.Channel(updated.ChannelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if channel == nil {
+		return nil
+	}
+	member, err := ue.store.ChannelMember(updated.ChannelId, updated.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to get channel member from store: %w", err)
+	}
+	if member.UserId == "" {
+		return nil
+	}
+
+	member.Roles = updated.Roles
+	member.SchemeGuest = updated.SchemeGuest
+	member.SchemeUser = updated.SchemeUser
+	member.SchemeAdmin = updated.SchemeAdmin
+	member.ExplicitRoles = updated.ExplicitRoles
+	member.NotifyProps = updated.NotifyProps
+	return ue.storeChanged(ue.store.SetChannelMember(member.ChannelId, &member))
+}
+
+// handleGroupMemberEvent keeps the stored group membership in sync, along
+// with the member sets of the loaded channels synced with the group.
+func (ue *UserEntity) handleGroupMemberEvent(ev *model.WebSocketEvent) error {
+	data, ok := ev.GetData()["group_member"].(string)
+	if !ok {
+		return errors.New("group_member data is missing")
+	}
+	var member model.GroupMember
+	if err := json.Unmarshal([]byte(data), &member); err != nil {
+		return fmt.Errorf("failed to unmarshal group member: %w", err)
+	}
+	if member.GroupId == "" || member.UserId == "" {
+		return errors.New("group or user id data is missing")
+	}
+
+	channelIds, err := ue.store.GroupChannels(member.GroupId)
+	if errors.Is(err, memstore.ErrGroupNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get group channels from store: %w", err)
+	}
+
+	switch ev.EventType() {
+	case model.WebsocketEventGroupMemberAdd:
+		if err := ue.storeChanged(ue.store.SetGroupMember(member.GroupId, member.UserId)); err != nil {
+			return err
+		}
+	case model.WebsocketEventGroupMemberDelete:
+		if err := ue.storeChanged(ue.store.RemoveGroupMember(member.GroupId, member.UserId)); err != nil {
+			return err
+		}
+	}
+
+	for _, channelId := range channelIds {
+		if channel, err := ue.store.Channel(channelId); err != nil {
+			return fmt.Errorf("failed to get channel from store: %w", err)
+		} else if channel == nil {
+			continue
+		}
+
+		var err error
+		if ev.EventType() == model.WebsocketEventGroupMemberAdd {
+			err = ue.addChannelMember(channelId, member.UserId)
+		} else {
+			err = ue.removeChannelMember(channelId, member.UserId)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateChannelMemberCount adds delta to the member count stored for the
+// given channel, if any.
+func (ue *UserEntity) updateChannelMemberCount(channelId string, delta int64) error {
+	stats, err := ue.store.ChannelStats(channelId)
+	if err != nil {
+		return fmt.Errorf("failed to get channel stats from store: %w", err)
+	} else if stats == nil {
+		return nil
+	}
+
+	updated := *stats
+	updated.MemberCount += delta
+	if updated.MemberCount < 0 {
+		updated.MemberCount = 0
+	}
+	return ue.storeChanged(ue.store.SetChannelStats(channelId, &updated))
+}
+
+// handleChannelCreatedEvent adds a channel created mid-run to the store. The
+// event only carries the channel and team ids, so unless the full channel is
+// included, a partial channel is stored for the controllers to act upon.
+func (ue *UserEntity) handleChannelCreatedEvent(ev *model.WebSocketEvent) error {
+	var channel *model.Channel
+	if el, ok := ev.GetData()["channel"]; ok {
+		data, ok := el.(string)
+		if !ok {
+			return fmt.Errorf("type of the channel data should be a string, but it is %T", el)
+		}
+		if err := json.Unmarshal([]byte(data), &channel); err != nil {
+			return err
+		}
+	} else {
+		channelId, ok := ev.GetData()["channel_id"].(string)
+		if !ok || channelId == "" {
+			return errors.New("channel_id data is missing")
+		}
+		teamId, _ := ev.GetData()["team_id"].(string)
+		channel = &model.Channel{Id: channelId, TeamId: teamId}
+	}
+
+	// A channel already in the store, possibly with more details, is kept.
+	added, err := ue.store.SetChannelIfMissing(channel)
+	if added {
+		ue.storeChanged(err)
+	}
+	return err
+}
+
+// handleDirectChannelAddedEvent fetches and stores a direct or group channel
+// the user was just added to, e.g. when receiving a new DM, along with the
+// user's membership. The channel is fetched in the background.
+func (ue *UserEntity) handleDirectChannelAddedEvent(ev *model.WebSocketEvent) error {
+	channelId := ev.GetBroadcast().ChannelId
+	if channelId == "" {
+		return errors.New("channel id is missing")
+	}
+
+	if channel, err := ue.store.Channel(channelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if channel != nil {
+		return nil
+	}
+
+	// The membership doesn't depend on the fetched channel.
+	if err := ue.addChannelMember(channelId, ue.store.Id()); err != nil {
+		return err
+	}
+	return ue.fetch("get channel", func(client *model.Client4) (bool, error) {
+		channel, _, err := client.GetChannel(channelId, "")
+		if err != nil {
+			return false, err
+		}
+		return ue.store.SetChannelIfMissing(channel)
+	})
+}
+
+// handleChannelConvertedEvent updates the type of a stored channel converted
+// between public and private. The event doesn't include the new type, so the
+// channel is fetched in the background.
+func (ue *UserEntity) handleChannelConvertedEvent(ev *model.WebSocketEvent) error {
+	channelId, ok := ev.GetData()["channel_id"].(string)
+	if !ok || channelId == "" {
+		return errors.New("channel_id data is missing")
+	}
+
+	if stored, err := ue.store.Channel(channelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if stored == nil {
+		return nil
+	}
+
+	return ue.fetch("get channel", func(client *model.Client4) (bool, error) {
+		channel, _, err := client.GetChannel(channelId, "")
+		if err != nil {
+			return false, err
+		}
+		return ue.updateStoredChannel(channelId, func(stored *model.Channel) {
+			stored.Type = channel.Type
+		})
+	})
+}
+
+// updateStoredChannel applies the given update to the stored channel with the
+// given id, if any, returning whether it did so. It's meant to be used by
+// fetches, so that the update applies to the latest stored copy.
+func (ue *UserEntity) updateStoredChannel(channelId string, update func(stored *model.Channel)) (bool, error) {
+	stored, err := ue.store.Channel(channelId)
+	if err != nil {
+		return false, fmt.Errorf("failed to get channel from store: %w", err)
+	} else if stored == nil {
+		return false, nil
+	}
+	update(stored)
+	if err := ue.store.SetChannel(stored); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handleChannelSchemeUpdatedEvent updates the scheme of a stored channel. The
+// event only carries the channel id in its broadcast, so the channel is
+// fetched in the background. The store doesn't cache any per-channel
+// permissions, so the scheme id is all there is to update.
+func (ue *UserEntity) handleChannelSchemeUpdatedEvent(ev *model.WebSocketEvent) error {
+	channelId := ev.GetBroadcast().ChannelId
+	if channelId == "" {
+		return errors.New("channel id is missing from the broadcast")
+	}
+
+	if stored, err := ue.store.Channel(channelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if stored == nil {
+		return nil
+	}
+
+	return ue.fetch("get channel", func(client *model.Client4) (bool, error) {
+		channel, _, err := client.GetChannel(channelId, "")
+		if err != nil {
+			return false, err
+		}
+		return ue.updateStoredChannel(channelId, func(stored *model.Channel) {
+			stored.SchemeId = channel.SchemeId
+		})
+	})
+}
+
+func (ue *UserEntity) handleChannelDeletedEvent(ev *model.WebSocketEvent) error {
+	channelId, ok := ev.GetData()["channel_id"].(string)
+	if !ok {
+		return errors.New("channel_id data is missing")
+	}
+
+	return ue.storeChanged(ue.store.DeleteChannel(channelId))
+}
+
+// teamFromEvent decodes the team sent in the data of the given event.
+func teamFromEvent(ev *model.WebSocketEvent) (*model.Team, error) {
+	var data string
+	if el, ok := ev.GetData()["team"]; !ok {
+		return nil, errors.New("team data is missing")
+	} else if data, ok = el.(string); !ok {
+		return nil, fmt.Errorf("type of the team data should be a string, but it is %T", el)
+	}
+
+	var team model.Team
+	if err := json.Unmarshal([]byte(data), &team); err != nil {
+		return nil, err
+	}
+	if team.Id == "" {
+		return nil, errors.New("team id is missing")
+	}
+	return &team, nil
+}
+
+// handleTeamUpdatedEvent updates a team the user belongs to. Teams not in the
+// store are ignored.
+func (ue *UserEntity) handleTeamUpdatedEvent(ev *model.WebSocketEvent) error {
+	team, err := teamFromEvent(ev)
+	if err != nil {
+		return err
+	}
+
+	stored, err := ue.store.Team(team.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get team from store: %w", err)
+	} else if stored == nil {
+		return nil
+	}
+	if err := ue.storeChanged(ue.store.SetTeam(team)); err != nil {
+		return err
+	}
+
+	current, err := ue.store.CurrentTeam()
+	if err != nil {
+		return fmt.Errorf("failed to get current team from store: %w", err)
+	}
+	if current != nil && current.Id == team.Id {
+		return ue.store.SetCurrentTeam(team)
+	}
+	return nil
+}
+
+// handleTeamDeletedEvent removes a deleted team, along with its channels, from
+// the store. Teams not in the store are ignored.
+func (ue *UserEntity) handleTeamDeletedEvent(ev *model.WebSocketEvent) error {
+	team, err := teamFromEvent(ev)
+	if err != nil {
+		return err
+	}
+
+	stored, err := ue.store.Team(team.Id)
+	if err != nil {
+		return fmt.Errorf("failed to get team from store: %w", err)
+	} else if stored == nil {
+		return nil
+	}
+	return ue.storeChanged(ue.store.DeleteTeam(team.Id))
+}
+
+// handleLeaveTeamEvent handles a user leaving or being removed from a team.
+// The team, along with its channels, is removed from the store when the user
+// is the entity itself, while only the team member goes otherwise. Teams not
+// in the store are ignored.
+func (ue *UserEntity) handleLeaveTeamEvent(ev *model.WebSocketEvent) error {
+	teamId, _ := ev.GetData()["team_id"].(string)
+	if teamId == "" {
+		teamId = ev.GetBroadcast().TeamId
+	}
+	userId, _ := ev.GetData()["user_id"].(string)
+	if teamId == "" || userId == "" {
+		return errors.New("team or user id data is missing")
+	}
+
+	stored, err := ue.store.Team(teamId)
+	if err != nil {
+		return fmt.Errorf("failed to get team from store: %w", err)
+	} else if stored == nil {
+		return nil
+	}
+	if userId == ue.store.Id() {
+		return ue.storeChanged(ue.store.DeleteTeam(teamId))
+	}
+	return ue.storeChanged(ue.store.RemoveTeamMember(teamId, userId))
+}
+
+// handleChannelViewedEvent resets the unread counts of a channel viewed from
+// another session of the same user.
+func (ue *UserEntity) handleChannelViewedEvent(ev *model.WebSocketEvent) error {
+	channelId, ok := ev.GetData()["channel_id"].(string)
+	if !ok || channelId == "" {
+		return nil
+	}
+
+	return ue.markChannelViewed(channelId)
+}
+
+// handleMultipleChannelsViewedEvent resets the unread counts of the channels
+// viewed at once from another session of the same user, e.g. when marking
+// them all as read.
+func (ue *UserEntity) handleMultipleChannelsViewedEvent(ev *model.WebSocketEvent) error {
+	// The channel ids are sent as the keys of the new view times.
+	var channelIds []string
+	switch times := ev.GetData()["channel_times"].(type) {
+	case map[string]interface{}:
+		for channelId := range times {
+			channelIds = append(channelIds, channelId)
+		}
+	case map[string]int64:
+		for channelId := range times {
+			channelIds = append(channelIds, channelId)
+		}
+	default:
+		return errors.New("channel_times data is missing")
+	}
+
+	for _, channelId := range channelIds {
+		if err := ue.markChannelViewed(channelId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markChannelViewed resets the unread counts of the given channel, if loaded.
+func (ue *UserEntity) markChannelViewed(channelId string) error {
+	if channel, err := ue.store.Channel(channelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if channel == nil {
+		return nil
+	}
+
+	return ue.storeChanged(ue.store.MarkChannelRead(channelId))
+}
+
+// handlePostUnreadEvent updates the unread counts of a channel marked as
+// unread from a post.
+func (ue *UserEntity) handlePostUnreadEvent(ev *model.WebSocketEvent) error {
+	channelId := ev.GetBroadcast().ChannelId
+	if id, ok := ev.GetData()["channel_id"].(string); ok && id != "" {
+		channelId = id
+	}
+	if channelId == "" {
+		return errors.New("channel_id data is missing")
+	}
+
+	var unread model.ChannelUnreadAt
+	var err error
+	if unread.MsgCount, err = eventDataInt(ev, "msg_count"); err != nil {
+		return err
+	}
+	if unread.MentionCount, err = eventDataInt(ev, "mention_count"); err != nil {
+		return err
+	}
+	// The root counts and the view time aren't always sent.
+	for key, dst := range map[string]*int64{
+		"msg_count_root":     &unread.MsgCountRoot,
+		"mention_count_root": &unread.MentionCountRoot,
+		"last_viewed_at":     &unread.LastViewedAt,
+	} {
+		if _, ok := ev.GetData()[key]; !ok {
+			continue
+		}
+		if *dst, err = eventDataInt(ev, key); err != nil {
+			return err
+		}
+	}
+
+	if channel, err := ue.store.Channel(channelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if channel == nil {
+		return nil
+	}
+
+	return ue.storeChanged(ue.store.SetChannelUnread(channelId, &unread))
+}
+
+// handleUserRoleUpdatedEvent updates the system roles of a user, e.g. when
+// promoted to system admin.
+func (ue *UserEntity) handleUserRoleUpdatedEvent(ev *model.WebSocketEvent) error {
+	userId, ok := ev.GetData()["user_id"].(string)
+	if !ok || userId == "" {
+		return errors.New("user_id data is missing")
+	}
+	var roles string
+	if el, ok := ev.GetData()["roles"]; !ok {
+		return errors.New("roles data is missing")
+	} else if roles, ok = el.(string); !ok {
+		return fmt.Errorf("type of the roles data should be a string, but it is %T", el)
+	}
+
+	return ue.storeChanged(ue.store.UpdateUserRoles(userId, roles))
+}
+
+// handleRoleUpdatedEvent updates the permissions of a stored role.
+func (ue *UserEntity) handleRoleUpdatedEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["role"]; !ok {
+		return errors.New("role data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the role data should be a string, but it is %T", el)
+	}
+
+	var role model.Role
+	if err := json.Unmarshal([]byte(data), &role); err != nil {
+		return fmt.Errorf("failed to decode role data: %w", err)
+	}
+	if role.Id == "" {
+		return errors.New("role id data is missing")
+	}
+
+	return ue.storeChanged(ue.store.UpdateRole(&role))
+}
+
+// handleEmojiAddedEvent stores a custom emoji created by any user, so that it
+// can be picked when reacting to posts.
+func (ue *UserEntity) handleEmojiAddedEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["emoji"]; !ok {
+		return errors.New("emoji data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the emoji data should be a string, but it is %T", el)
+	}
+
+	var emoji model.Emoji
+	if err := json.Unmarshal([]byte(data), &emoji); err != nil {
+		return fmt.Errorf("failed to decode emoji data: %w", err)
+	}
+	if emoji.Id == "" || emoji.Name == "" {
+		return errors.New("emoji id or name data is missing")
+	}
+
+	return ue.storeChanged(ue.store.SetEmoji(&emoji))
+}
+
+// handlePluginStatusesChangedEvent stores the statuses of the server plugins,
+// as sent to system admins whenever a plugin gets enabled or disabled.
+func (ue *UserEntity) handlePluginStatusesChangedEvent(ev *model.WebSocketEvent) error {
+	data, ok := ev.GetData()["plugin_statuses"]
+	if !ok {
+		return errors.New("plugin statuses data is missing")
+	}
+
+	// The statuses are sent as objects so they need to be encoded back first.
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var statuses model.PluginStatuses
+	if err := json.Unmarshal(buf, &statuses); err != nil {
+		return fmt.Errorf("failed to decode plugin statuses data: %w", err)
+	}
+	for _, status := range statuses {
+		if status == nil || status.PluginId == "" {
+			return errors.New("plugin id data is missing")
+		}
+	}
+
+	// The event carries the statuses of all the plugins.
+	return ue.storeChanged(ue.store.SetPluginStatuses(statuses))
+}
+
+// handleLicenseChangedEvent stores the client license of the server, as sent
+// whenever a license is added or removed.
+func (ue *UserEntity) handleLicenseChangedEvent(ev *model.WebSocketEvent) error {
+	data, ok := ev.GetData()["license"]
+	if !ok {
+		return errors.New("license data is missing")
+	}
+
+	// The license is decoded as a generic object when received from the
+	// server.
+	var license map[string]string
+	switch data := data.(type) {
+	case map[string]string:
+		license = make(map[string]string, len(data))
+		for k, v := range data {
+			license[k] = v
+		}
+	case map[string]interface{}:
+		license = make(map[string]string, len(data))
+		for k, v := range data {
+			value, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("invalid value for license field %q", k)
+			}
+			license[k] = value
+		}
+	default:
+		return errors.New("license data is invalid")
+	}
+
+	// The event carries the whole license, including the features which
+	// got disabled.
+	return ue.storeChanged(ue.store.SetLicense(license))
+}
+
+// handleThreadUpdatedEvent updates the read state of a followed thread, as
+// sent on new replies.
+func (ue *UserEntity) handleThreadUpdatedEvent(ev *model.WebSocketEvent) error {
+	var data string
+	if el, ok := ev.GetData()["thread"]; !ok {
+		return errors.New("thread data is missing")
+	} else if data, ok = el.(string); !ok {
+		return fmt.Errorf("type of the thread data should be a string, but it is %T", el)
+	}
+
+	var thread model.ThreadResponse
+	if err := json.Unmarshal([]byte(data), &thread); err != nil {
+		return err
+	}
+	if thread.PostId == "" {
+		return errors.New("thread id data is missing")
+	}
+
+	return ue.storeChanged(ue.store.SetThreadState(thread.PostId, store.ThreadState{
+		LastViewedAt:   thread.LastViewedAt,
+		UnreadReplies:  thread.UnreadReplies,
+		UnreadMentions: thread.UnreadMentions,
+	}))
+}
+
+// handleThreadReadChangedEvent updates the read state of a thread marked as
+// read or unread, or of all the threads of a team at once.
+func (ue *UserEntity) handleThreadReadChangedEvent(ev *model.WebSocketEvent) error {
+	// Marking all the threads of a team as read sends the event without a
+	// thread id. Only the threads whose channel is stored can be updated.
+	threadId, ok := ev.GetData()["thread_id"].(string)
+	if !ok || threadId == "" {
+		teamId := ev.GetBroadcast().TeamId
+		if teamId == "" {
+			return errors.New("team id is missing from the broadcast")
+		}
+		return ue.storeChanged(ue.store.MarkAllThreadsInTeamAsRead(teamId))
+	}
+
+	var state store.ThreadState
+	var err error
+	if state.LastViewedAt, err = eventDataInt(ev, "timestamp"); err != nil {
+		return err
+	}
+	if state.UnreadReplies, err = eventDataInt(ev, "unread_replies"); err != nil {
+		return err
+	}
+	if state.UnreadMentions, err = eventDataInt(ev, "unread_mentions"); err != nil {
+		return err
+	}
+
+	return ue.storeChanged(ue.store.SetThreadState(threadId, state))
+}
+
+// eventDataInt returns the integer value of the given key in the event data.
+// Numbers decoded from JSON are float64 while the ones set by the server
+// before encoding are int64, so both are accepted.
+func eventDataInt(ev *model.WebSocketEvent, key string) (int64, error) {
+	el, ok := ev.GetData()[key]
+	if !ok {
+		return 0, fmt.Errorf("%s data is missing", key)
+	}
+	switch val := el.(type) {
+	case float64:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case int:
+		return int64(val), nil
+	default:
+		return 0, fmt.Errorf("type of the %s data should be a number, but it is %T", key, el)
+	}
+}
+
+func (ue *UserEntity) handleStatusChangeEvent(ev *model.WebSocketEvent) error {
+	userId, ok := ev.GetData()["user_id"].(string)
+	if !ok || userId == "" {
+		return errors.New("user_id data is missing")
+	}
+	status, ok := ev.GetData()["status"].(string)
+	if !ok || status == "" {
+		return errors.New("status data is missing")
+	}
+
+	// Users not in the store are tracked anyway since their presence is
+	// still useful to the controllers.
+	return ue.storeChanged(ue.store.SetStatus(userId, &model.Status{
+		UserId: userId,
+		Status: status,
+	}))
+}
+
+// handlePreferenceEvent keeps the stored preferences in sync with the ones
+// changed from any session of the user. Both the single and bulk variants of
+// the event are handled.
+func (ue *UserEntity) handlePreferenceEvent(ev *model.WebSocketEvent) error {
+	var preferences model.Preferences
+	switch ev.EventType() {
+	case model.WebsocketEventPreferenceChanged:
+		data, ok := ev.GetData()["preference"].(string)
+		if !ok {
+			return errors.New("preference data is missing")
+		}
+		var preference model.Preference
+		if err := json.Unmarshal([]byte(data), &preference); err != nil {
+			return fmt.Errorf("failed to unmarshal preference: %w", err)
+		}
+		preferences = model.Preferences{preference}
+	case model.WebsocketEventPreferencesChanged:
+		data, ok := ev.GetData()["preferences"].(string)
+		if !ok {
+			return errors.New("preferences data is missing")
+		}
+		if err := json.Unmarshal([]byte(data), &preferences); err != nil {
+			return fmt.Errorf("failed to unmarshal preferences: %w", err)
+		}
+	}
+
+	return ue.storeChanged(ue.store.UpsertPreferences(preferences))
+}
+
+// handleSidebarCategoryCreatedEvent stores a sidebar category created by the
+// user. The event only includes the category id, so the category is fetched in
+// the background.
+func (ue *UserEntity) handleSidebarCategoryCreatedEvent(ev *model.WebSocketEvent) error {
+	teamId := ev.GetBroadcast().TeamId
+	if teamId == "" {
+		return errors.New("team id is missing from the broadcast")
+	}
+	categoryId, ok := ev.GetData()["category_id"].(string)
+	if !ok || categoryId == "" {
+		return errors.New("category_id data is missing")
+	}
+
+	userId := ue.store.Id()
+	return ue.fetch("get sidebar category", func(client *model.Client4) (bool, error) {
+		category, _, err := client.GetSidebarCategoryForTeamForUser(userId, teamId, categoryId, "")
+		if err != nil {
+			return false, err
+		}
+		if err := ue.store.SetCategory(teamId, category); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// handleSidebarCategoryUpdatedEvent stores the updated sidebar categories,
+// e.g. renamed ones or ones whose channels got reordered. The event sent on
+// preference changes doesn't say which categories changed and is ignored.
+func (ue *UserEntity) handleSidebarCategoryUpdatedEvent(ev *model.WebSocketEvent) error {
+	data, ok := ev.GetData()["updatedCategories"].(string)
+	if !ok {
+		return nil
+	}
+	teamId := ev.GetBroadcast().TeamId
+	if teamId == "" {
+		return errors.New("team id is missing from the broadcast")
+	}
 
-func (ue *UserEntity) handleReactionEvent(ev *model.WebSocketEvent) error {
-	var data string
-	if el, ok := ev.GetData()["reaction"]; !ok {
-		return errors.New("reaction data is missing")
-	} else if data, ok = el.(string); !ok {
-		return fmt.Errorf("type of the reaction data should be a string, but it is %T", el)
+	var categories []*model.SidebarCategoryWithChannels
+	if err := json.Unmarshal([]byte(data), &categories); err != nil {
+		return fmt.Errorf("failed to unmarshal sidebar categories: %w", err)
+	}
+	for _, category := range categories {
+		if category == nil {
+			continue
+		}
+		if err := ue.storeChanged(ue.store.SetCategory(teamId, category)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	var reaction *model.Reaction
-	if err := json.Unmarshal([]byte(data), &reaction); err != nil {
-		return err
+func (ue *UserEntity) handleSidebarCategoryOrderUpdatedEvent(ev *model.WebSocketEvent) error {
+	teamId := ev.GetBroadcast().TeamId
+	if teamId == "" {
+		return errors.New("team id is missing from the broadcast")
 	}
 
-	currentChannel, err := ue.store.CurrentChannel()
-	if err != nil && !errors.Is(err, memstore.ErrChannelNotFound) {
-		return fmt.Errorf("failed to get current channel from store: %w", err)
-	} else if currentChannel == nil {
-		return nil
+	var order []string
+	switch data := ev.GetData()["order"].(type) {
+	case []string:
+		order = data
+	case []interface{}:
+		// Decoded from JSON.
+		for _, id := range data {
+			categoryId, ok := id.(string)
+			if !ok {
+				return fmt.Errorf("type of the order data should be a string array, but it contains %T", id)
+			}
+			order = append(order, categoryId)
+		}
+	case nil:
+		return errors.New("order data is missing")
+	default:
+		return fmt.Errorf("type of the order data should be a string array, but it is %T", data)
 	}
+	return ue.storeChanged(ue.store.SetCategoryOrder(teamId, order))
+}
 
-	post, err := ue.store.Post(reaction.PostId)
-	if errors.Is(err, memstore.ErrPostNotFound) || post.ChannelId != currentChannel.Id {
+func (ue *UserEntity) handleSidebarCategoryDeletedEvent(ev *model.WebSocketEvent) error {
+	teamId := ev.GetBroadcast().TeamId
+	if teamId == "" {
+		return errors.New("team id is missing from the broadcast")
+	}
+	categoryId, ok := ev.GetData()["category_id"].(string)
+	if !ok || categoryId == "" {
+		return errors.New("category_id data is missing")
+	}
+	return ue.storeChanged(ue.store.DeleteCategory(teamId, categoryId))
+}
+
+// handlePostMentions increments the mention count for the channel of the
+// given post if the user is among the ones it mentions.
+func (ue *UserEntity) handlePostMentions(ev *model.WebSocketEvent, post *model.Post) error {
+	el, ok := ev.GetData()["mentions"]
+	if !ok {
 		return nil
 	}
-	if err != nil {
-		return fmt.Errorf("failed to get post from store: %w", err)
+	data, ok := el.(string)
+	if !ok {
+		return fmt.Errorf("type of the mentions data should be a string, but it is %T", el)
 	}
 
-	switch ev.EventType() {
-	case model.WebsocketEventReactionAdded:
-		return ue.store.SetReaction(reaction)
-	case model.WebsocketEventReactionRemoved:
-		if ok, err := ue.store.DeleteReaction(reaction); err != nil {
-			return err
-		} else if !ok {
-			return errors.New("could not find reaction in the store")
+	var mentions []string
+	if err := json.Unmarshal([]byte(data), &mentions); err != nil {
+		return fmt.Errorf("failed to unmarshal mentions: %w", err)
+	}
+
+	userId := ue.store.Id()
+	for _, id := range mentions {
+		if id == userId {
+			return ue.storeChanged(ue.store.IncrementMentionCount(post.ChannelId, post.RootId == ""))
 		}
 	}
 
 	return nil
 }
 
-func (ue *UserEntity) handlePostEvent(ev *model.WebSocketEvent) error {
-	var data string
-	if el, ok := ev.GetData()["post"]; !ok {
-		return errors.New("post data is missing")
-	} else if data, ok = el.(string); !ok {
-		return fmt.Errorf("type of the post data should be a string, but it is %T", el)
+// handleTypingEvent records the typing user in the store for as long as the
+// webapp would show them as typing.
+func (ue *UserEntity) handleTypingEvent(ev *model.WebSocketEvent) error {
+	channelId := ev.GetBroadcast().ChannelId
+	if channelId == "" {
+		return errors.New("channel_id data is missing")
+	}
+	userId, ok := ev.GetData()["user_id"].(string)
+	if !ok || userId == "" {
+		return errors.New("user_id data is missing")
 	}
 
-	var post *model.Post
-	if err := json.Unmarshal([]byte(data), &post); err != nil {
-		return err
+	if channel, err := ue.store.Channel(channelId); err != nil {
+		return fmt.Errorf("failed to get channel from store: %w", err)
+	} else if channel == nil {
+		return nil
 	}
 
-	switch ev.EventType() {
-	case model.WebsocketEventPosted, model.WebsocketEventPostEdited:
-		currentChannel, err := ue.store.CurrentChannel()
-		if err == nil && currentChannel.Id == post.ChannelId {
-			return ue.store.SetPost(post)
-		} else if err != nil && !errors.Is(err, memstore.ErrChannelNotFound) {
-			return fmt.Errorf("failed to get current channel from store: %w", err)
-		}
-	case model.WebsocketEventPostDeleted:
-		return ue.store.DeletePost(post.Id)
+	return ue.storeChanged(ue.store.SetTyping(channelId, userId, time.Now().Add(ue.typingTTL())))
+}
+
+// typingTTL returns the time a user is considered typing for after a typing
+// event, as set by the server, falling back to the default.
+func (ue *UserEntity) typingTTL() time.Duration {
+	ms, err := strconv.ParseInt(ue.store.ClientConfig()["TimeBetweenUserTypingUpdatesMilliseconds"], 10, 64)
+	if err != nil || ms <= 0 {
+		return defaultTypingTTL
 	}
+	return time.Duration(ms) * time.Millisecond
+}
 
+// handleAppsRefreshBindingsEvent calls the configured refresh bindings hook,
+// if any. Bindings aren't stored, so nothing is persisted: the controller can
+// re-fetch them after getting the forwarded event.
+func (ue *UserEntity) handleAppsRefreshBindingsEvent(_ *model.WebSocketEvent) error {
+	if ue.onRefreshBindings != nil {
+		ue.onRefreshBindings()
+	}
 	return nil
 }
 
@@ -100,148 +1207,872 @@ func (ue *UserEntity) handlePostEvent(ev *model.WebSocketEvent) error {
 // Handling the event at this layer is needed to keep the user state in
 // sync with the server. Any response to the event should be made by handling
 // the same event at the upper layer (controller).
-func (ue *UserEntity) wsEventHandler(ev *model.WebSocketEvent) error {
+//
+// It also reports whether the event was applied, that is whether it changed
+// the store. Events which are filtered out, ignored or only validated aren't
+// applied. The store may have been changed even when an error is returned.
+func (ue *UserEntity) wsEventHandler(ev *model.WebSocketEvent) (bool, error) {
+	ue.eventApplied = false
+
+	// Timing is skipped altogether when metrics are disabled.
+	if ue.metrics == nil {
+		err := ue.handleEvent(ev)
+		return ue.eventApplied, err
+	}
+
+	start := time.Now()
+	err := ue.handleEvent(ev)
+	ue.observeWebSocketEventTimes(time.Since(start), ev.EventType())
+	return ue.eventApplied, err
+}
+
+// storeChanged records that the event being handled changed the store,
+// unless the given error, returned by the store write, is not nil. The error
+// is returned unchanged.
+func (ue *UserEntity) storeChanged(err error) error {
+	if err == nil {
+		ue.eventApplied = true
+	}
+	return err
+}
+
+// acceptsEvent reports whether the given event should be applied to the store,
+// according to the configured channel filter. Only the broadcast data is
+// looked at so that the events filtered out don't need to be decoded.
+func (ue *UserEntity) acceptsEvent(ev *model.WebSocketEvent) bool {
+	if ue.channelFilter == nil {
+		return true
+	}
+	var channelId string
+	if broadcast := ev.GetBroadcast(); broadcast != nil {
+		channelId = broadcast.ChannelId
+	}
+	if channelId == "" {
+		channelId, _ = ev.GetData()["channel_id"].(string)
+	}
+	// Events not related to a channel are always applied.
+	if channelId == "" {
+		return true
+	}
+	return ue.channelFilter(channelId)
+}
+
+// helloExpectedSeqKey is the optional hello data field in which the server
+// indicates the sequence number it's resuming the connection from, e.g. after
+// pruning the events buffered for it.
+const helloExpectedSeqKey = "expected_seq"
+
+// reconcileServerSeq aligns the expected sequence number with the one the
+// server indicates in the given hello event, if any. The local counter is
+// kept unless the server explicitly sends a valid sequence number, so that
+// events missed without the server saying so are still caught.
+func (ue *UserEntity) reconcileServerSeq(hello *model.WebSocketEvent) {
+	var seq int64
+	switch v := hello.GetData()[helloExpectedSeqKey].(type) {
+	case float64:
+		// Numbers decoded from JSON. Fractional values aren't sequence numbers.
+		if v != math.Trunc(v) {
+			return
+		}
+		seq = int64(v)
+	case int64:
+		seq = v
+	default:
+		return
+	}
+	if seq < 0 || seq == ue.wsServerSeq {
+		return
+	}
+
+	mlog.Debug("Server indicated a different sequence number", mlog.Int64("expected", ue.wsServerSeq), mlog.Int64("server", seq))
+	ue.missedEvents(ue.wsServerSeq, seq)
+	atomic.StoreInt64(&ue.wsServerSeq, seq)
+}
+
+// handleEventSafely calls wsEventHandler, turning any panic into an error so
+// that a single bad event can't take down the listener.
+func (ue *UserEntity) handleEventSafely(ev *model.WebSocketEvent) (applied bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			applied = ue.eventApplied
+			mlog.Error("userentity: recovered from panic in wsEventHandler", mlog.String("stack", string(debug.Stack())))
+			err = fmt.Errorf("panic handling %q event with sequence %d: %v", ev.EventType(), ev.GetSequence(), r)
+		}
+	}()
+	return ue.wsEventHandler(ev)
+}
+
+func (ue *UserEntity) handleEvent(ev *model.WebSocketEvent) error {
 	if ev.EventType() == model.WebsocketEventHello {
 		if connID, ok := ev.GetData()["connection_id"].(string); ok {
 			// If we already have a connectionId present, and server sends a different one,
 			// that means it's either a long timeout, or server restart, or sequence number is not found.
 			// Then we reset sequence number to 0.
-			if ue.wsConnID != "" && ue.wsConnID != connID {
+			if curConnID := ue.ConnectionID(); curConnID != "" && curConnID != connID {
 				mlog.Debug("Long timeout, or server restart, or sequence number not found")
-				// In future, we can add the missed event callback here.
-				ue.wsServerSeq = 0
+				ue.missedEvents(ue.wsServerSeq, 0)
+				atomic.StoreInt64(&ue.wsServerSeq, 0)
 			}
-			ue.wsConnID = connID
+			ue.wsConnID.Store(connID)
 		}
+		ue.reconcileServerSeq(ev)
 	}
 
 	// Now we check for sequence number, and if it does not match,
-	// we just disconnect and reconnect.
+	// we just disconnect and reconnect, unless the gap is small enough to be
+	// recovered from.
 	if ev.GetSequence() != ue.wsServerSeq {
 		mlog.Warn("Missed websocket event", mlog.Int64("got", ev.GetSequence()), mlog.Int64("expected", ue.wsServerSeq))
-		return errSeqMismatch
+		ue.trackSeqMismatch(time.Now())
+		if !ue.recoverSeqGap(ev.GetSequence()) {
+			return &WSError{Kind: WSErrorSeqMismatch, Err: errSeqMismatch}
+		}
+	} else {
+		ue.trackInSeqEvent()
+	}
+
+	atomic.StoreInt64(&ue.wsServerSeq, ev.GetSequence()+1)
+	if ue.saveWSState != nil {
+		ue.saveWSState(WebSocketState{ConnID: ue.ConnectionID(), ServerSeq: ue.wsServerSeq})
+	}
+
+	if !ue.acceptsEvent(ev) {
+		return nil
 	}
 
-	ue.wsServerSeq = ev.GetSequence() + 1
+	// Batched post writes are applied before handling any other event so
+	// that it sees them.
+	switch ev.EventType() {
+	case model.WebsocketEventPosted, model.WebsocketEventPostEdited, model.WebsocketEventPostDeleted:
+	default:
+		if err := ue.flushPostWrites(); err != nil {
+			return err
+		}
+	}
 
 	switch ev.EventType() {
 	case model.WebsocketEventReactionAdded, model.WebsocketEventReactionRemoved:
 		return ue.handleReactionEvent(ev)
 	case model.WebsocketEventPosted, model.WebsocketEventPostEdited, model.WebsocketEventPostDeleted:
 		return ue.handlePostEvent(ev)
+	case model.WebsocketEventEphemeralMessage:
+		return ue.handleEphemeralMessageEvent(ev)
+	case model.WebsocketEventChannelCreated:
+		return ue.handleChannelCreatedEvent(ev)
+	case model.WebsocketEventChannelDeleted:
+		return ue.handleChannelDeletedEvent(ev)
+	case model.WebsocketEventUpdateTeam:
+		return ue.handleTeamUpdatedEvent(ev)
+	case model.WebsocketEventDeleteTeam:
+		return ue.handleTeamDeletedEvent(ev)
+	case model.WebsocketEventLeaveTeam:
+		return ue.handleLeaveTeamEvent(ev)
+	case model.WebsocketEventChannelConverted:
+		return ue.handleChannelConvertedEvent(ev)
+	case model.WebsocketEventChannelSchemeUpdated:
+		return ue.handleChannelSchemeUpdatedEvent(ev)
+	case model.WebsocketEventChannelViewed:
+		return ue.handleChannelViewedEvent(ev)
+	case wsEventMultipleChannelsViewed:
+		return ue.handleMultipleChannelsViewedEvent(ev)
+	case wsEventAppsFrameworkRefreshBindings:
+		return ue.handleAppsRefreshBindingsEvent(ev)
+	case model.WebsocketEventPostUnread:
+		return ue.handlePostUnreadEvent(ev)
+	case model.WebsocketEventUserAdded, model.WebsocketEventUserRemoved:
+		return ue.handleChannelMembershipEvent(ev)
+	case model.WebsocketEventUserUpdated:
+		return ue.handleUserUpdatedEvent(ev)
+	case model.WebsocketEventStatusChange:
+		return ue.handleStatusChangeEvent(ev)
+	case model.WebsocketEventTyping:
+		return ue.handleTypingEvent(ev)
+	case model.WebsocketEventPreferenceChanged, model.WebsocketEventPreferencesChanged:
+		return ue.handlePreferenceEvent(ev)
+	case model.WebsocketEventGroupMemberAdd, model.WebsocketEventGroupMemberDelete:
+		return ue.handleGroupMemberEvent(ev)
+	case model.WebsocketEventChannelMemberUpdated:
+		return ue.handleChannelMemberUpdatedEvent(ev)
+	case model.WebsocketEventDirectAdded, model.WebsocketEventGroupAdded:
+		return ue.handleDirectChannelAddedEvent(ev)
+	case wsEventPostAcknowledgementAdded, wsEventPostAcknowledgementRemoved:
+		return ue.handlePostAcknowledgementEvent(ev)
+	case model.WebsocketEventThreadUpdated:
+		return ue.handleThreadUpdatedEvent(ev)
+	case model.WebsocketEventThreadReadChanged:
+		return ue.handleThreadReadChangedEvent(ev)
+	case model.WebsocketEventEmojiAdded:
+		return ue.handleEmojiAddedEvent(ev)
+	case model.WebsocketEventUserRoleUpdated:
+		return ue.handleUserRoleUpdatedEvent(ev)
+	case model.WebsocketEventRoleUpdated:
+		return ue.handleRoleUpdatedEvent(ev)
+	case model.WebsocketEventPluginStatusesChanged:
+		return ue.handlePluginStatusesChangedEvent(ev)
+	case model.WebsocketEventLicenseChanged:
+		return ue.handleLicenseChangedEvent(ev)
+	case model.WebsocketEventSidebarCategoryCreated:
+		return ue.handleSidebarCategoryCreatedEvent(ev)
+	case model.WebsocketEventSidebarCategoryUpdated:
+		return ue.handleSidebarCategoryUpdatedEvent(ev)
+	case model.WebsocketEventSidebarCategoryOrderUpdated:
+		return ue.handleSidebarCategoryOrderUpdatedEvent(ev)
+	case model.WebsocketEventSidebarCategoryDeleted:
+		return ue.handleSidebarCategoryDeletedEvent(ev)
 	}
 
 	return nil
 }
 
+// ConnectionID returns the id of the current WebSocket connection, as sent by
+// the server. It returns an empty string until the first hello event is
+// received.
+func (ue *UserEntity) ConnectionID() string {
+	connID, _ := ue.wsConnID.Load().(string)
+	return connID
+}
+
+// ServerSequence returns the next sequence number expected from the server on
+// the main WebSocket connection. It's safe for concurrent use.
+func (ue *UserEntity) ServerSequence() int64 {
+	return atomic.LoadInt64(&ue.wsServerSeq)
+}
+
+// recoverSeqGap tries to recover from the events missed before the one with
+// the given sequence number by refreshing the posts of the current channel.
+// It returns whether the gap was recovered from, in which case the connection
+// can be kept. While connected, the posts are refreshed in the background, so
+// the gap counts as recovered from once the refresh is queued, and a failure
+// to refresh is only reported.
+func (ue *UserEntity) recoverSeqGap(seq int64) bool {
+	gap := seq - ue.wsServerSeq
+	if gap <= 0 || gap > int64(ue.config.WebSocketSeqGapRecoveryThreshold) {
+		return false
+	}
+
+	// The refreshed posts must not be overwritten by older batched writes.
+	if err := ue.flushPostWrites(); err != nil {
+		mlog.Warn("userentity: failed to apply post writes", mlog.Err(err))
+		return false
+	}
+	if err := ue.fetch("refresh current channel", func(client *model.Client4) (bool, error) {
+		return false, ue.refreshCurrentChannel(client)
+	}); err != nil {
+		mlog.Warn("userentity: failed to recover from missed websocket events", mlog.Int64("gap", gap), mlog.Err(err))
+		return false
+	}
+
+	ue.missedEvents(ue.wsServerSeq, seq)
+	return true
+}
+
+// missedEvents notifies the configured handler, if any, that the events
+// between oldSeq and newSeq were missed.
+func (ue *UserEntity) missedEvents(oldSeq, newSeq int64) {
+	if ue.onMissedEvents != nil {
+		ue.onMissedEvents(oldSeq, newSeq)
+	}
+}
+
+// reconnected runs the actions configured for when the WebSocket connection
+// is re-established.
+func (ue *UserEntity) reconnected() {
+	if ue.onReconnect != nil {
+		ue.onReconnect()
+	}
+	if ue.config.SetOnlineOnReconnect {
+		// The request is made in the background so that it doesn't delay
+		// handling the events.
+		go func() {
+			if err := ue.setOnline(); err != nil {
+				mlog.Warn("userentity: failed to set status online after reconnecting", mlog.Err(err))
+			}
+		}()
+	}
+}
+
+// setOnline sets the status of the user to online.
+func (ue *UserEntity) setOnline() error {
+	userId := ue.store.Id()
+	if userId == "" {
+		return errors.New("user id is missing from the store")
+	}
+	status, _, err := ue.client.UpdateUserStatus(userId, &model.Status{UserId: userId, Status: model.StatusOnline})
+	if err != nil {
+		return err
+	}
+	return ue.store.SetStatus(userId, status)
+}
+
 // listen starts to listen for messages on various channels.
 // It will keep reconnecting if the connection closes.
 // Only on calling Disconnect explicitly, it will return.
-func (ue *UserEntity) listen(errChan chan error) {
+func (ue *UserEntity) listen(ctx context.Context, errChan chan error) {
 	connectionFailCount := 0
+	// reconnectAttempts counts the consecutive failed attempts since the last
+	// healthy connection.
+	reconnectAttempts := 0
+	firstAttempt := true
+	// reconnectReason is why the previous connection attempt, or connection,
+	// ended.
+	var reconnectReason string
+	// connectedOnce is set once the first connection is established.
+	connectedOnce := false
+	typing := newTypingCoalescer(ue.config.TypingCoalesceWindow)
+	defer typing.reset()
+	readTimeout := ue.config.WebSocketReadTimeout
+	if readTimeout == 0 {
+		readTimeout = defaultWebsocketReadTimeout
+	}
+	// The context only applies until the first connection is established.
+	connectCtxDone := ctx.Done()
+	ue.heldEvents = nil
+	ue.delayedEvents = nil
 start:
 	for {
+		if !firstAttempt {
+			ue.incWebSocketReconnects(reconnectReason)
+			ue.publishConnectionEvent(ConnectionEventReconnecting)
+		}
+		firstAttempt = false
+
 		client, err := websocket.NewClient4(&websocket.ClientParams{
-			WsURL:          ue.config.WebSocketURL,
-			AuthToken:      ue.client.AuthToken,
-			ConnID:         ue.wsConnID,
-			ServerSequence: ue.wsServerSeq,
+			WsURL:             ue.config.WebSocketURL,
+			AuthToken:         ue.client.AuthToken,
+			ConnID:            ue.ConnectionID(),
+			ServerSequence:    ue.wsServerSeq,
+			Dialer:            ue.wsDialer,
+			ReadTimeout:       readTimeout,
+			EnableCompression: ue.config.WebSocketEnableCompression,
+			MaxMessageSize:    ue.config.WebSocketMaxMessageSize,
+			TraceHook:         ue.traceHook,
 		})
 		if err != nil {
-			errChan <- fmt.Errorf("userentity: websocketClient creation error: %w", err)
+			ue.incWebSocketConnectFailures()
+			ue.reportError(errChan, &WSError{Kind: WSErrorConnect, Err: err})
+			reconnectReason = reconnectReasonConnectError
 			connectionFailCount++
+			reconnectAttempts++
+			if ue.trackReconnectAttempt(reconnectAttempts) {
+				ue.giveUpReconnecting(errChan, reconnectAttempts)
+				return
+			}
 			select {
-			// Draining the channel to avoid blocking the sender.
+			// Draining the channels to avoid blocking the senders.
 			case <-ue.wsTyping:
+			case <-ue.wsActions:
 			case <-ue.wsClosing:
 				// Explicit disconnect. Return.
 				close(ue.wsClosed)
 				return
-			case <-time.After(getWaitTime(connectionFailCount)):
+			case <-connectCtxDone:
+				ue.stopReconnecting(errChan, ctx.Err())
+				return
+			case <-ue.clock.After(ue.getWaitTimeJittered(connectionFailCount)):
 			}
 			// Reconnect again.
 			continue
 		}
 
+		connectCtxDone = nil
 		ue.incWebSocketConnections()
+		ue.publishConnectionEvent(ConnectionEventConnected)
+		if connectedOnce {
+			ue.reconnected()
+		}
+		connectedOnce = true
+		connectedAt := ue.clock.Now()
+		// Resuming while reconnecting only takes effect now.
+		ue.releaseHeldEvents()
 
 		var chanClosed bool
 		for {
 			select {
 			case ev, ok := <-client.EventChannel:
 				if !ok {
+					ue.incWebSocketCloseCodes(client.CloseCode())
+					if client.TimedOut() {
+						ue.incWebSocketReadTimeouts()
+					}
+					if client.ReadLimitExceeded() {
+						ue.incWebSocketReadLimitHits()
+					}
+					reconnectReason = reconnectReasonServerClose
 					chanClosed = true
 					break
 				}
-				if err := ue.wsEventHandler(ev); err != nil {
-					if err == errSeqMismatch {
+				atomic.AddUint64(&ue.eventsReceived, 1)
+				// Receiving events means the connection is healthy.
+				reconnectAttempts = 0
+				ue.setWebSocketDegraded(false)
+				applied, err := ue.handleEventSafely(ev)
+				if applied {
+					atomic.AddUint64(&ue.eventsApplied, 1)
+				}
+				if err != nil {
+					if errors.Is(err, errSeqMismatch) {
+						ue.incWebSocketSeqMismatches()
+						ue.publishConnectionEvent(ConnectionEventSeqMismatch)
+						ue.missedEvents(ue.wsServerSeq, ev.GetSequence())
+						if err := ue.flushPostWrites(); err != nil {
+							ue.reportError(errChan, &WSError{Kind: WSErrorHandler, Err: err})
+						}
 						// Disconnect and reconnect.
+						reconnectReason = reconnectReasonSeqMismatch
 						client.Close()
+						typing.reset()
 						ue.decWebSocketConnections()
+						ue.publishConnectionEvent(ConnectionEventDisconnected)
 						continue start
 					}
-					errChan <- fmt.Errorf("userentity: error in wsEventHandler: %w", err)
+					ue.reportError(errChan, &WSError{Kind: WSErrorHandler, Err: err})
 				}
-				ue.wsEventChan <- ev
+				ue.deliverEventOnceStored(ev)
+			case <-ue.wsResumed:
+				ue.releaseHeldEvents()
 			case <-ue.wsClosing:
+				if ue.wsDrain {
+					for _, msg := range typing.flush() {
+						if err := client.UserTyping(msg.channelId, msg.parentId); err != nil {
+							mlog.Warn("userentity: error in client.UserTyping while draining", mlog.Err(err))
+						}
+					}
+				}
 				client.Close()
 				ue.decWebSocketConnections()
+				ue.publishConnectionEvent(ConnectionEventDisconnected)
+				if ue.wsDrain {
+					ue.drainEvents(client)
+				}
+				// Like the held back ones, the delayed events are discarded
+				// since they may not be read anymore at this point.
+				ue.delayedEvents = nil
+				if err := ue.flushPostWrites(); err != nil {
+					// The error channel may not be read anymore at this point.
+					mlog.Warn("userentity: failed to apply post writes on disconnect", mlog.Err(err))
+				}
 				// Explicit disconnect. Return.
 				close(ue.wsClosed)
 				return
 			case msg, ok := <-ue.wsTyping:
 				if !ok {
+					reconnectReason = reconnectReasonChanClosed
 					chanClosed = true
 					break
 				}
+				ue.observeTypingQueueTime(msg)
+				if !typing.add(msg, time.Now()) {
+					break
+				}
 				if err := client.UserTyping(msg.channelId, msg.parentId); err != nil {
-					errChan <- fmt.Errorf("userentity: error in client.UserTyping: %w", err)
+					ue.reportError(errChan, &WSError{Kind: WSErrorTyping, Err: err})
+				}
+			case action, ok := <-ue.wsActions:
+				if !ok {
+					reconnectReason = reconnectReasonChanClosed
+					chanClosed = true
+					break
+				}
+				if err := action(client); err != nil {
+					ue.reportError(errChan, &WSError{Kind: WSErrorAction, Err: err})
+				}
+			case <-ue.postWrites.C():
+				if err := ue.flushPostWrites(); err != nil {
+					ue.reportError(errChan, &WSError{Kind: WSErrorHandler, Err: err})
+				}
+			case <-typing.C():
+				for _, msg := range typing.due(time.Now()) {
+					if err := client.UserTyping(msg.channelId, msg.parentId); err != nil {
+						ue.reportError(errChan, &WSError{Kind: WSErrorTyping, Err: err})
+					}
 				}
 			}
 			if chanClosed {
 				client.Close()
+				typing.reset()
+				if err := ue.flushPostWrites(); err != nil {
+					ue.reportError(errChan, &WSError{Kind: WSErrorHandler, Err: err})
+				}
 				break
 			}
 		}
 
 		ue.decWebSocketConnections()
+		ue.publishConnectionEvent(ConnectionEventDisconnected)
 
+		connectionFailCount = ue.resetFailCountIfHealthy(connectionFailCount, ue.clock.Now().Sub(connectedAt))
 		connectionFailCount++
+		reconnectAttempts++
+		if ue.trackReconnectAttempt(reconnectAttempts) {
+			ue.giveUpReconnecting(errChan, reconnectAttempts)
+			return
+		}
 		select {
-		// Draining the channel to avoid blocking the sender.
+		// Draining the channels to avoid blocking the senders.
 		case <-ue.wsTyping:
+		case <-ue.wsActions:
 		case <-ue.wsClosing:
 			// Explicit disconnect. Return.
 			close(ue.wsClosed)
 			return
-		case <-time.After(getWaitTime(connectionFailCount)):
+		case <-connectCtxDone:
+			ue.stopReconnecting(errChan, ctx.Err())
+			return
+		case <-ue.clock.After(ue.getWaitTimeJittered(connectionFailCount)):
 		}
 		// Reconnect again.
 	}
 }
 
-// getWaitTime returns the wait time to sleep for.
+// drainEvents handles and pushes to the events channel the events still
+// buffered by the given closed client, until there are none left or the
+// drain deadline passes.
+func (ue *UserEntity) drainEvents(client *websocket.Client) {
+	timeout := ue.config.DisconnectDrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDisconnectDrainTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for ev := range client.EventChannel {
+		applied, err := ue.handleEventSafely(ev)
+		if applied {
+			atomic.AddUint64(&ue.eventsApplied, 1)
+		}
+		if errors.Is(err, errSeqMismatch) {
+			// Any later event can't be trusted.
+			return
+		} else if err != nil {
+			// The error channel may not be read anymore at this point.
+			mlog.Warn("userentity: error in wsEventHandler while draining", mlog.Err(err))
+		}
+		// As with deliverEvent, the event is only sent once handled.
+		ue.publishEvent(ev)
+		select {
+		case ue.wsEventChan <- ev:
+		case <-timer.C:
+			mlog.Warn("userentity: timed out draining events", mlog.String("timeout", timeout.String()))
+			return
+		}
+	}
+}
+
+// trackSeqMismatch records a sequence mismatch, marking the store as
+// unhealthy if too many of them happened within the configured window.
+func (ue *UserEntity) trackSeqMismatch(now time.Time) {
+	if ue.config.StoreUnhealthyThreshold <= 0 {
+		return
+	}
+
+	recent := ue.seqMismatches[:0]
+	for _, t := range ue.seqMismatches {
+		if now.Sub(t) < ue.config.StoreUnhealthyWindow {
+			recent = append(recent, t)
+		}
+	}
+	ue.seqMismatches = append(recent, now)
+	ue.inSeqEvents = 0
+
+	if len(ue.seqMismatches) >= ue.config.StoreUnhealthyThreshold {
+		ue.setStoreUnhealthy(true)
+	}
+}
+
+// trackInSeqEvent records an event received in sequence, marking the store
+// as healthy again once enough of them were received since the last mismatch.
+func (ue *UserEntity) trackInSeqEvent() {
+	if !ue.isStoreUnhealthy() {
+		return
+	}
+
+	ue.inSeqEvents++
+	if ue.inSeqEvents >= ue.config.StoreRecoveryEvents {
+		ue.inSeqEvents = 0
+		ue.seqMismatches = nil
+		ue.setStoreUnhealthy(false)
+	}
+}
+
+// StoreHealthy returns whether the user's store is believed to be in sync
+// with the server.
+func (ue *UserEntity) StoreHealthy() bool {
+	return !ue.isStoreUnhealthy()
+}
+
+func (ue *UserEntity) isStoreUnhealthy() bool {
+	return atomic.LoadInt32(&ue.storeUnhealthy) == 1
+}
+
+// resetFailCountIfHealthy returns the given count of failed connections,
+// reset to zero if the connection that just dropped had been up for long
+// enough to be considered healthy, so that the backoff starts small again.
+func (ue *UserEntity) resetFailCountIfHealthy(failCount int, uptime time.Duration) int {
+	if ue.config.WebSocketHealthyDuration > 0 && uptime >= ue.config.WebSocketHealthyDuration {
+		return 0
+	}
+	return failCount
+}
+
+// trackReconnectAttempt updates the degraded state of the user given the
+// number of consecutive reconnect attempts. It returns whether the user
+// should give up reconnecting.
+func (ue *UserEntity) trackReconnectAttempt(attempts int) bool {
+	if ue.config.WebSocketDegradedThreshold > 0 && attempts >= ue.config.WebSocketDegradedThreshold {
+		ue.setWebSocketDegraded(true)
+	}
+	return ue.reconnectAttemptsExceeded(attempts)
+}
+
+// reconnectAttemptsExceeded returns whether a connection should give up
+// reconnecting after the given number of consecutive reconnect attempts.
+func (ue *UserEntity) reconnectAttemptsExceeded(attempts int) bool {
+	return ue.config.WebSocketMaxReconnectAttempts > 0 && attempts >= ue.config.WebSocketMaxReconnectAttempts
+}
+
+// reportError sends the given error through errChan. If a disconnect is in
+// progress, the error is kept to be returned by it instead, so that the
+// listener never blocks on shutdown.
+func (ue *UserEntity) reportError(errChan chan error, err error) {
+	select {
+	case errChan <- err:
+	case <-ue.wsClosing:
+		ue.wsErr = err
+	}
+}
+
+// giveUpReconnecting reports the failure and stops the listener without
+// trying to reconnect again. The user keeps being counted as having given up
+// until it disconnects.
+func (ue *UserEntity) giveUpReconnecting(errChan chan error, attempts int) {
+	ue.setWebSocketGaveUp(true)
+	ue.stopReconnecting(errChan, fmt.Errorf("%w after %d attempts", ErrReconnectGaveUp, attempts))
+}
+
+// stopReconnecting reports the given error, which stopped the listener, and
+// closes wsClosed without trying to reconnect again. Disconnect is still
+// expected to be called to release the remaining resources.
+func (ue *UserEntity) stopReconnecting(errChan chan error, err error) {
+	ue.setWebSocketDegraded(false)
+	// This is the error that stopped the listener, so it's also returned on
+	// disconnect.
+	ue.wsErr = err
+	ue.reportError(errChan, ue.wsErr)
+	// The senders waiting on the listener are unblocked by this as well.
+	close(ue.wsClosed)
+}
+
+// getWaitTime returns the wait time to sleep for, between minWait and
+// maxWait. The wait time grows once failCount exceeds failThreshold.
 // This is the same as webapp reconnection logic.
-func getWaitTime(failCount int) time.Duration {
-	waitTime := minWebsocketReconnectDuration
-	if failCount > maxWebsocketFails {
+func getWaitTime(failCount int, minWait, maxWait time.Duration, failThreshold int) time.Duration {
+	waitTime := minWait
+	if failCount > failThreshold {
 		waitTime *= time.Duration(failCount) * time.Duration(failCount)
-		if waitTime > maxWebsocketReconnectDuration {
-			waitTime = maxWebsocketReconnectDuration
+		if waitTime > maxWait {
+			waitTime = maxWait
 		}
 	}
 	return waitTime
 }
 
+// getWaitTimeJittered returns the wait time to sleep for, randomly increased
+// or decreased by up to the configured jitter fraction so that users
+// disconnected at the same time don't all reconnect in lockstep. The wait
+// time never drops below the configured minimum.
+func (ue *UserEntity) getWaitTimeJittered(failCount int) time.Duration {
+	return ue.jitteredWaitTime(failCount, ue.wsRand)
+}
+
+// jitteredWaitTime is like getWaitTimeJittered, using the given source of
+// randomness, which must not be shared across goroutines.
+func (ue *UserEntity) jitteredWaitTime(failCount int, rnd *rand.Rand) time.Duration {
+	minWait, maxWait, failThreshold := ue.config.reconnectBounds()
+	waitTime := getWaitTime(failCount, minWait, maxWait, failThreshold)
+	jitter := ue.config.WebSocketReconnectJitter
+	if jitter <= 0 || rnd == nil {
+		return waitTime
+	}
+
+	waitTime += time.Duration(float64(waitTime) * jitter * (2*rnd.Float64() - 1))
+	if waitTime < minWait {
+		waitTime = minWait
+	}
+	return waitTime
+}
+
 // SendTypingEvent will push a user_typing event out to all connected users
-// who are in the specified channel.
+// who are in the specified channel. In dry-run mode, the event is only
+// counted.
 func (ue *UserEntity) SendTypingEvent(channelId, parentId string) error {
 	if !ue.connected {
-		return errors.New("user is not connected")
+		return ErrNotConnected
+	}
+
+	if !ue.typingLimiter.allow(channelId, time.Now()) {
+		return ErrTypingRateLimited
 	}
-	ue.wsTyping <- userTypingMsg{
-		channelId,
-		parentId,
+
+	if ue.config.DryRun {
+		ue.recordDryRun(DryRunUserTyping, mlog.String("channel_id", channelId), mlog.String("parent_id", parentId))
+		return nil
 	}
-	return nil
+
+	timeout := ue.config.TypingEventTimeout
+	if timeout <= 0 {
+		timeout = defaultTypingEventTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// The listener may be busy reconnecting, in which case dropping the
+	// event is preferable to blocking the caller.
+	select {
+	case ue.wsTyping <- userTypingMsg{
+		channelId:  channelId,
+		parentId:   parentId,
+		enqueuedAt: time.Now(),
+	}:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%w: timed out after %s sending typing event", ErrTypingQueueFull, timeout)
+	case <-ue.wsClosed:
+		return &listenerStoppedError{err: ue.wsErr}
+	}
+}
+
+// SendWebSocketAction queues the given action to be run with the live
+// WebSocket connection by the listening goroutine, so that all the writes to
+// the connection happen from a single goroutine. Any error returned by the
+// action is reported through the errors channel.
+//
+// Actions are run on a best-effort basis: the ones still queued when
+// disconnecting, or received while reconnecting, are dropped without being
+// run. In dry-run mode, actions are only counted.
+func (ue *UserEntity) SendWebSocketAction(action func(client *websocket.Client) error) error {
+	if action == nil {
+		return errors.New("action should not be nil")
+	}
+	if !ue.connected {
+		return ErrNotConnected
+	}
+
+	if ue.config.DryRun {
+		ue.recordDryRun(DryRunAction)
+		return nil
+	}
+
+	timer := time.NewTimer(defaultWebSocketActionTimeout)
+	defer timer.Stop()
+
+	select {
+	case ue.wsActions <- action:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("userentity: timed out after %s sending WebSocket action", defaultWebSocketActionTimeout)
+	case <-ue.wsClosed:
+		return &listenerStoppedError{err: ue.wsErr}
+	}
+}
+
+// deliverEvent hands the given event over to the subscribers and the events
+// channel, unless it's held back while paused. It's only meant to be called
+// by the listening goroutine.
+//
+// Events must only be delivered once handled, and once any error returned
+// by wsEventHandler was sent to the error channel: controllers reading the
+// store on receiving an event rely on it being already updated. Events
+// handled while post writes are batched are delivered by flushPostWrites.
+func (ue *UserEntity) deliverEvent(ev *model.WebSocketEvent) {
+	ue.publishEvent(ev)
+	if !ue.holdEvent(ev) {
+		// Events held back before a resume go first.
+		ue.releaseHeldEvents()
+		ue.forwardEvent(ev)
+	}
+}
+
+// forwardEvent hands the given event over to the events channel. If
+// DropEventsWhenFull is set, the event is dropped and counted instead of
+// blocking when the buffer is full.
+func (ue *UserEntity) forwardEvent(ev *model.WebSocketEvent) {
+	if !ue.config.DropEventsWhenFull {
+		if ue.config.SlowConsumerThreshold > 0 {
+			ue.forwardEventWatched(ev)
+			return
+		}
+		ue.wsEventChan <- ev
+		return
+	}
+
+	select {
+	case ue.wsEventChan <- ev:
+	default:
+		ue.dropEvent(ev)
+	}
+}
+
+// forwardEventWatched hands the given event over to the events channel,
+// reporting the controller as slow if it's not received within the slow
+// consumer threshold. The timer is only started if the event can't be
+// handed over right away.
+func (ue *UserEntity) forwardEventWatched(ev *model.WebSocketEvent) {
+	select {
+	case ue.wsEventChan <- ev:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(ue.config.SlowConsumerThreshold)
+	defer timer.Stop()
+	select {
+	case ue.wsEventChan <- ev:
+		return
+	case <-timer.C:
+	}
+
+	ue.incWebSocketSlowConsumerSends(ev.EventType())
+	mlog.Warn("userentity: controller is slow to receive WebSocket events",
+		mlog.String("user_id", ue.store.Id()),
+		mlog.String("event_type", ev.EventType()),
+		mlog.String("threshold", ue.config.SlowConsumerThreshold.String()))
+	ue.wsEventChan <- ev
+}
+
+// dropEvent counts the given event as dropped.
+func (ue *UserEntity) dropEvent(ev *model.WebSocketEvent) {
+	ue.droppedEventsMut.Lock()
+	if ue.droppedEvents == nil {
+		ue.droppedEvents = map[string]uint64{}
+	}
+	ue.droppedEvents[ev.EventType()]++
+	ue.droppedEventsMut.Unlock()
+	ue.incWebSocketDroppedEvents(ev.EventType())
+}
+
+// DroppedEvents returns the number of WebSocket events dropped because the
+// events buffer was full, or while paused, by event type. It's safe for
+// concurrent use.
+func (ue *UserEntity) DroppedEvents() map[string]uint64 {
+	ue.droppedEventsMut.Lock()
+	defer ue.droppedEventsMut.Unlock()
+
+	dropped := make(map[string]uint64, len(ue.droppedEvents))
+	for eventType, count := range ue.droppedEvents {
+		dropped[eventType] = count
+	}
+	return dropped
+}
+
+// EventsReceived returns the number of WebSocket events received by the user
+// since it was created, across reconnects. It's safe for concurrent use.
+func (ue *UserEntity) EventsReceived() uint64 {
+	return atomic.LoadUint64(&ue.eventsReceived)
+}
+
+// EventsApplied returns the number of received WebSocket events which changed
+// the user's store since it was created, across reconnects. It's at most
+// EventsReceived. It's safe for concurrent use.
+func (ue *UserEntity) EventsApplied() uint64 {
+	return atomic.LoadUint64(&ue.eventsApplied)
 }
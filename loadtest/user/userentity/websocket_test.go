@@ -0,0 +1,4810 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package userentity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/store/memstore"
+	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/websocket"
+	"github.com/mattermost/mattermost-load-test-ng/performance"
+
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/shared/mlog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+// eventErr returns the error returned by wsEventHandler, for the tests which
+// don't check whether the event was applied.
+func eventErr(_ bool, err error) error {
+	return err
+}
+
+func TestTrackReconnectAttempt(t *testing.T) {
+	m := performance.NewMetrics()
+	ue := &UserEntity{
+		metrics: m.UserEntityMetrics(),
+		config: Config{
+			WebSocketDegradedThreshold:    2,
+			WebSocketMaxReconnectAttempts: 4,
+		},
+	}
+
+	require.False(t, ue.trackReconnectAttempt(1))
+	require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().WebSocketDegraded))
+
+	require.False(t, ue.trackReconnectAttempt(2))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.UserEntityMetrics().WebSocketDegraded))
+
+	require.False(t, ue.trackReconnectAttempt(3))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.UserEntityMetrics().WebSocketDegraded))
+
+	require.True(t, ue.trackReconnectAttempt(4))
+
+	ue.setWebSocketDegraded(false)
+	require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().WebSocketDegraded))
+
+	t.Run("disabled", func(t *testing.T) {
+		ue := &UserEntity{metrics: m.UserEntityMetrics()}
+		require.False(t, ue.trackReconnectAttempt(100))
+		require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().WebSocketDegraded))
+	})
+}
+
+func TestStoreHealth(t *testing.T) {
+	m := performance.NewMetrics()
+	ue := &UserEntity{
+		metrics: m.UserEntityMetrics(),
+		config: Config{
+			StoreUnhealthyThreshold: 3,
+			StoreUnhealthyWindow:    time.Minute,
+			StoreRecoveryEvents:     2,
+		},
+	}
+
+	now := time.Now()
+	ue.trackSeqMismatch(now.Add(-2 * time.Minute))
+	ue.trackSeqMismatch(now.Add(-time.Second))
+	ue.trackSeqMismatch(now)
+	// The first mismatch falls out of the window.
+	require.True(t, ue.StoreHealthy())
+
+	ue.trackSeqMismatch(now)
+	require.False(t, ue.StoreHealthy())
+	require.Equal(t, float64(1), testutil.ToFloat64(m.UserEntityMetrics().StoreUnhealthy))
+
+	ue.trackInSeqEvent()
+	require.False(t, ue.StoreHealthy())
+	ue.trackSeqMismatch(now)
+	ue.trackInSeqEvent()
+	require.False(t, ue.StoreHealthy())
+	ue.trackInSeqEvent()
+	require.True(t, ue.StoreHealthy())
+	require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().StoreUnhealthy))
+
+	t.Run("disabled", func(t *testing.T) {
+		ue := &UserEntity{metrics: m.UserEntityMetrics()}
+		for i := 0; i < 100; i++ {
+			ue.trackSeqMismatch(now)
+		}
+		require.True(t, ue.StoreHealthy())
+	})
+}
+
+func TestHandleChannelMembershipEvent(t *testing.T) {
+	newEntity := func(t *testing.T) (*UserEntity, *model.Channel) {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+
+		channel := &model.Channel{Id: model.NewId(), TeamId: model.NewId()}
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetChannelStats(channel.Id, &model.ChannelStats{ChannelId: channel.Id, MemberCount: 2}))
+		return &UserEntity{store: s}, channel
+	}
+
+	memberCount := func(t *testing.T, ue *UserEntity, channelId string) int64 {
+		stats, err := ue.store.ChannelStats(channelId)
+		require.NoError(t, err)
+		require.NotNil(t, stats)
+		return stats.MemberCount
+	}
+
+	userAdded := func(channelId, userId string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserAdded, "", channelId, "", nil)
+		ev.Add("user_id", userId)
+		return ev
+	}
+
+	t.Run("other user added", func(t *testing.T) {
+		ue, channel := newEntity(t)
+		userId := model.NewId()
+		require.NoError(t, ue.handleChannelMembershipEvent(userAdded(channel.Id, userId)))
+
+		member, err := ue.store.ChannelMember(channel.Id, userId)
+		require.NoError(t, err)
+		require.Equal(t, userId, member.UserId)
+		require.Equal(t, int64(3), memberCount(t, ue, channel.Id))
+
+		// Adding an already known member doesn't change the count.
+		require.NoError(t, ue.handleChannelMembershipEvent(userAdded(channel.Id, userId)))
+		require.Equal(t, int64(3), memberCount(t, ue, channel.Id))
+	})
+
+	t.Run("entity added", func(t *testing.T) {
+		ue, channel := newEntity(t)
+		require.NoError(t, ue.handleChannelMembershipEvent(userAdded(channel.Id, ue.store.Id())))
+
+		member, err := ue.store.ChannelMember(channel.Id, ue.store.Id())
+		require.NoError(t, err)
+		require.Equal(t, ue.store.Id(), member.UserId)
+		require.Equal(t, int64(3), memberCount(t, ue, channel.Id))
+	})
+
+	t.Run("other user removed", func(t *testing.T) {
+		ue, channel := newEntity(t)
+		userId := model.NewId()
+		require.NoError(t, ue.handleChannelMembershipEvent(userAdded(channel.Id, userId)))
+
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserRemoved, "", channel.Id, "", nil)
+		ev.Add("user_id", userId)
+		require.NoError(t, ue.handleChannelMembershipEvent(ev))
+
+		member, err := ue.store.ChannelMember(channel.Id, userId)
+		require.NoError(t, err)
+		require.Empty(t, member.UserId)
+		require.Equal(t, int64(2), memberCount(t, ue, channel.Id))
+	})
+
+	t.Run("entity removed", func(t *testing.T) {
+		ue, channel := newEntity(t)
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserRemoved, "", "", ue.store.Id(), nil)
+		ev.Add("channel_id", channel.Id)
+		require.NoError(t, ue.handleChannelMembershipEvent(ev))
+
+		ch, err := ue.store.Channel(channel.Id)
+		require.NoError(t, err)
+		require.Nil(t, ch)
+	})
+
+	t.Run("channel not loaded", func(t *testing.T) {
+		ue, _ := newEntity(t)
+		channelId := model.NewId()
+		require.NoError(t, ue.handleChannelMembershipEvent(userAdded(channelId, model.NewId())))
+		stats, err := ue.store.ChannelStats(channelId)
+		require.NoError(t, err)
+		require.Nil(t, stats)
+	})
+
+	t.Run("missing data", func(t *testing.T) {
+		ue, _ := newEntity(t)
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserAdded, "", "", "", nil)
+		require.Error(t, ue.handleChannelMembershipEvent(ev))
+	})
+}
+
+func TestHandleChannelViewedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+
+	channel := &model.Channel{Id: model.NewId(), TotalMsgCount: 5}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+		ChannelId:    channel.Id,
+		UserId:       user.Id,
+		MsgCount:     1,
+		MentionCount: 3,
+	}))
+	ue := &UserEntity{store: s}
+
+	t.Run("missing channel_id", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelViewed, "", "", user.Id, nil)
+		require.NoError(t, ue.handleChannelViewedEvent(ev))
+	})
+
+	t.Run("channel not loaded", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelViewed, "", "", user.Id, nil)
+		ev.Add("channel_id", model.NewId())
+		require.NoError(t, ue.handleChannelViewedEvent(ev))
+	})
+
+	t.Run("unread counts reset", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelViewed, "", "", user.Id, nil)
+		ev.Add("channel_id", channel.Id)
+		require.NoError(t, ue.handleChannelViewedEvent(ev))
+
+		member, err := s.ChannelMember(channel.Id, user.Id)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), member.MsgCount)
+		require.Zero(t, member.MentionCount)
+	})
+}
+
+func TestHandleMultipleChannelsViewedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+
+	var channels []*model.Channel
+	for i := 0; i < 3; i++ {
+		channel := &model.Channel{Id: model.NewId(), TotalMsgCount: 5}
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+			ChannelId:    channel.Id,
+			UserId:       user.Id,
+			MsgCount:     1,
+			MentionCount: 3,
+		}))
+		channels = append(channels, channel)
+	}
+	ue := &UserEntity{store: s}
+
+	// The event is decoded from JSON, as when received from the server.
+	newEvent := func(t *testing.T, times interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(wsEventMultipleChannelsViewed, "", "", user.Id, nil)
+		ev.Add("channel_times", times)
+		data, err := ev.ToJSON()
+		require.NoError(t, err)
+		ev, err = model.WebSocketEventFromJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		return ev
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(wsEventMultipleChannelsViewed, "", "", user.Id, nil)
+		require.EqualError(t, ue.handleMultipleChannelsViewedEvent(ev), "channel_times data is missing")
+		require.EqualError(t, ue.handleMultipleChannelsViewedEvent(newEvent(t, []string{channels[0].Id})), "channel_times data is missing")
+	})
+
+	t.Run("loaded and unknown channels", func(t *testing.T) {
+		now := model.GetMillis()
+		times := map[string]int64{
+			channels[0].Id: now,
+			channels[1].Id: now,
+			model.NewId():  now,
+		}
+		require.NoError(t, ue.handleMultipleChannelsViewedEvent(newEvent(t, times)))
+
+		for i, channel := range channels {
+			member, err := s.ChannelMember(channel.Id, user.Id)
+			require.NoError(t, err)
+			if i < 2 {
+				require.Equal(t, int64(5), member.MsgCount)
+				require.Zero(t, member.MentionCount)
+			} else {
+				// The channel wasn't viewed.
+				require.Equal(t, int64(1), member.MsgCount)
+				require.Equal(t, int64(3), member.MentionCount)
+			}
+		}
+	})
+}
+
+func TestHandleTeamEvents(t *testing.T) {
+	type entity struct {
+		ue      *UserEntity
+		team    *model.Team
+		channel *model.Channel
+	}
+	newEntity := func(t *testing.T) entity {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+		team := &model.Team{Id: model.NewId(), DisplayName: "team"}
+		require.NoError(t, s.SetTeam(team))
+		require.NoError(t, s.SetCurrentTeam(team))
+		channel := &model.Channel{Id: model.NewId(), TeamId: team.Id}
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetCurrentChannel(channel))
+		return entity{ue: &UserEntity{store: s}, team: team, channel: channel}
+	}
+
+	teamEvent := func(t *testing.T, eventType string, team *model.Team) *model.WebSocketEvent {
+		data, err := json.Marshal(team)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(eventType, "", "", "", nil)
+		ev.Add("team", string(data))
+		return ev
+	}
+
+	leaveTeam := func(teamId, userId string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventLeaveTeam, teamId, "", "", nil)
+		ev.Add("team_id", teamId)
+		ev.Add("user_id", userId)
+		return ev
+	}
+
+	requireTeamRemoved := func(t *testing.T, e entity) {
+		t.Helper()
+		team, err := e.ue.store.Team(e.team.Id)
+		require.NoError(t, err)
+		require.Nil(t, team)
+		team, err = e.ue.store.CurrentTeam()
+		require.NoError(t, err)
+		require.Nil(t, team)
+		// The channels of the team don't dangle.
+		channel, err := e.ue.store.Channel(e.channel.Id)
+		require.NoError(t, err)
+		require.Nil(t, channel)
+		_, err = e.ue.store.CurrentChannel()
+		require.ErrorIs(t, err, memstore.ErrChannelNotFound)
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		e := newEntity(t)
+		require.EqualError(t, e.ue.handleTeamUpdatedEvent(model.NewWebSocketEvent(model.WebsocketEventUpdateTeam, "", "", "", nil)), "team data is missing")
+		require.EqualError(t, e.ue.handleTeamDeletedEvent(teamEvent(t, model.WebsocketEventDeleteTeam, &model.Team{})), "team id is missing")
+		require.EqualError(t, e.ue.handleLeaveTeamEvent(leaveTeam(e.team.Id, "")), "team or user id data is missing")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		e := newEntity(t)
+		updated := *e.team
+		updated.DisplayName = "updated"
+		require.NoError(t, e.ue.handleTeamUpdatedEvent(teamEvent(t, model.WebsocketEventUpdateTeam, &updated)))
+
+		team, err := e.ue.store.Team(e.team.Id)
+		require.NoError(t, err)
+		require.Equal(t, "updated", team.DisplayName)
+		team, err = e.ue.store.CurrentTeam()
+		require.NoError(t, err)
+		require.Equal(t, "updated", team.DisplayName)
+
+		// Teams not in the store are ignored.
+		other := &model.Team{Id: model.NewId()}
+		require.NoError(t, e.ue.handleTeamUpdatedEvent(teamEvent(t, model.WebsocketEventUpdateTeam, other)))
+		team, err = e.ue.store.Team(other.Id)
+		require.NoError(t, err)
+		require.Nil(t, team)
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		e := newEntity(t)
+		// Another member leaving only removes the member.
+		memberId := model.NewId()
+		require.NoError(t, e.ue.store.SetTeamMember(e.team.Id, &model.TeamMember{TeamId: e.team.Id, UserId: memberId}))
+		require.NoError(t, e.ue.handleLeaveTeamEvent(leaveTeam(e.team.Id, memberId)))
+		member, err := e.ue.store.TeamMember(e.team.Id, memberId)
+		require.NoError(t, err)
+		require.Empty(t, member.UserId)
+		team, err := e.ue.store.Team(e.team.Id)
+		require.NoError(t, err)
+		require.NotNil(t, team)
+
+		require.NoError(t, e.ue.handleLeaveTeamEvent(leaveTeam(e.team.Id, e.ue.store.Id())))
+		requireTeamRemoved(t, e)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		e := newEntity(t)
+		deleted := *e.team
+		deleted.DeleteAt = model.GetMillis()
+		require.NoError(t, e.ue.handleTeamDeletedEvent(teamEvent(t, model.WebsocketEventDeleteTeam, &deleted)))
+		requireTeamRemoved(t, e)
+
+		// Deleting a team not in the store is a no-op.
+		require.NoError(t, e.ue.handleTeamDeletedEvent(teamEvent(t, model.WebsocketEventDeleteTeam, &deleted)))
+	})
+}
+
+func TestGetWaitTime(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		ue := &UserEntity{}
+		require.Equal(t, 3*time.Second, ue.getWaitTimeJittered(1))
+		require.Equal(t, 3*time.Second, ue.getWaitTimeJittered(7))
+		require.Equal(t, 192*time.Second, ue.getWaitTimeJittered(8))
+		require.Equal(t, 5*time.Minute, ue.getWaitTimeJittered(20))
+	})
+
+	t.Run("short", func(t *testing.T) {
+		ue := &UserEntity{config: Config{
+			WebSocketMinReconnectDuration: 10 * time.Millisecond,
+			WebSocketMaxReconnectDuration: 100 * time.Millisecond,
+			WebSocketFailThreshold:        1,
+		}}
+		require.Equal(t, 10*time.Millisecond, ue.getWaitTimeJittered(1))
+		require.Equal(t, 40*time.Millisecond, ue.getWaitTimeJittered(2))
+		require.Equal(t, 90*time.Millisecond, ue.getWaitTimeJittered(3))
+		require.Equal(t, 100*time.Millisecond, ue.getWaitTimeJittered(4))
+	})
+
+	t.Run("long", func(t *testing.T) {
+		ue := &UserEntity{config: Config{
+			WebSocketMinReconnectDuration: 10 * time.Second,
+			WebSocketMaxReconnectDuration: 2 * time.Hour,
+			WebSocketFailThreshold:        20,
+		}}
+		require.Equal(t, 10*time.Second, ue.getWaitTimeJittered(20))
+		require.Equal(t, 4410*time.Second, ue.getWaitTimeJittered(21))
+		require.Equal(t, 2*time.Hour, ue.getWaitTimeJittered(30))
+	})
+}
+
+func TestConfigIsValid(t *testing.T) {
+	require.NoError(t, (&Config{}).IsValid())
+	require.NoError(t, (&Config{WebSocketMinReconnectDuration: time.Minute, WebSocketMaxReconnectDuration: time.Minute}).IsValid())
+	require.Error(t, (&Config{WebSocketMinReconnectDuration: time.Hour}).IsValid())
+	require.Error(t, (&Config{WebSocketMinReconnectDuration: time.Minute, WebSocketMaxReconnectDuration: time.Second}).IsValid())
+	require.Error(t, (&Config{WebSocketMaxReconnectDuration: -time.Second}).IsValid())
+	require.Error(t, (&Config{WebSocketFailThreshold: -1}).IsValid())
+	require.Error(t, (&Config{WebSocketReadTimeout: -time.Second}).IsValid())
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.Nil(t, New(Setup{Store: s}, Config{WebSocketFailThreshold: -1}))
+}
+
+func TestGetWaitTimeJittered(t *testing.T) {
+	newEntity := func(jitter float64) *UserEntity {
+		return &UserEntity{
+			config: Config{WebSocketReconnectJitter: jitter},
+			wsRand: rand.New(rand.NewSource(1)),
+		}
+	}
+
+	t.Run("no jitter", func(t *testing.T) {
+		ue := newEntity(0)
+		for failCount := 0; failCount < 20; failCount++ {
+			require.Equal(t, getWaitTime(failCount, defaultWebsocketMinReconnectDuration, defaultWebsocketMaxReconnectDuration, defaultWebsocketFailThreshold), ue.getWaitTimeJittered(failCount))
+		}
+	})
+
+	t.Run("within bounds", func(t *testing.T) {
+		ue := newEntity(0.1)
+		var jittered bool
+		for i := 0; i < 10000; i++ {
+			failCount := i % 20
+			base := getWaitTime(failCount, defaultWebsocketMinReconnectDuration, defaultWebsocketMaxReconnectDuration, defaultWebsocketFailThreshold)
+			waitTime := ue.getWaitTimeJittered(failCount)
+			require.GreaterOrEqual(t, waitTime, defaultWebsocketMinReconnectDuration)
+			require.GreaterOrEqual(t, waitTime, time.Duration(float64(base)*0.9))
+			require.LessOrEqual(t, waitTime, time.Duration(float64(base)*1.1))
+			if waitTime != base {
+				jittered = true
+			}
+		}
+		require.True(t, jittered)
+	})
+
+	t.Run("reproducible", func(t *testing.T) {
+		ue1 := newEntity(0.5)
+		ue2 := newEntity(0.5)
+		for failCount := 0; failCount < 20; failCount++ {
+			require.Equal(t, ue1.getWaitTimeJittered(failCount), ue2.getWaitTimeJittered(failCount))
+		}
+	})
+}
+
+func TestResetFailCountIfHealthy(t *testing.T) {
+	ue := &UserEntity{config: Config{WebSocketHealthyDuration: time.Minute}}
+
+	// A number of failed attempts have made the backoff grow to its max.
+	failCount := 20
+	require.Equal(t, defaultWebsocketMaxReconnectDuration, getWaitTime(failCount, defaultWebsocketMinReconnectDuration, defaultWebsocketMaxReconnectDuration, defaultWebsocketFailThreshold))
+
+	// A short-lived connection keeps the backoff as is.
+	require.Equal(t, failCount, ue.resetFailCountIfHealthy(failCount, time.Second))
+
+	// A long-lived connection dropping makes the backoff start small again.
+	failCount = ue.resetFailCountIfHealthy(failCount, time.Hour) + 1
+	require.Equal(t, 1, failCount)
+	require.Equal(t, defaultWebsocketMinReconnectDuration, getWaitTime(failCount, defaultWebsocketMinReconnectDuration, defaultWebsocketMaxReconnectDuration, defaultWebsocketFailThreshold))
+
+	// Resetting is disabled by default.
+	ue.config.WebSocketHealthyDuration = 0
+	require.Equal(t, 20, ue.resetFailCountIfHealthy(20, time.Hour))
+}
+
+func TestMissedEventsHandler(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	var calls [][2]int64
+	ue := New(Setup{
+		Store: s,
+		MissedEventsHandler: func(oldSeq, newSeq int64) {
+			calls = append(calls, [2]int64{oldSeq, newSeq})
+		},
+	}, Config{})
+	require.NotNil(t, ue)
+
+	hello := func(connID string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil)
+		ev.Add("connection_id", connID)
+		return ev.SetSequence(ue.wsServerSeq)
+	}
+
+	require.NoError(t, eventErr(ue.wsEventHandler(hello("conn1"))))
+	require.Empty(t, calls)
+	ue.wsServerSeq = 42
+
+	// Resuming the same connection doesn't reset the sequence.
+	require.NoError(t, eventErr(ue.wsEventHandler(hello("conn1"))))
+	require.Empty(t, calls)
+
+	ue.wsServerSeq = 42
+	ev := hello("conn2").SetSequence(0)
+	require.NoError(t, eventErr(ue.wsEventHandler(ev)))
+	require.Equal(t, [][2]int64{{42, 0}}, calls)
+	require.Equal(t, int64(1), ue.wsServerSeq)
+
+	// No handler set.
+	ue.onMissedEvents = nil
+	require.NotPanics(t, func() { ue.missedEvents(1, 2) })
+}
+
+func TestHelloExpectedSeq(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	var calls [][2]int64
+	ue := New(Setup{
+		Store: s,
+		MissedEventsHandler: func(oldSeq, newSeq int64) {
+			calls = append(calls, [2]int64{oldSeq, newSeq})
+		},
+	}, Config{})
+	require.NotNil(t, ue)
+
+	// The hello events are decoded from crafted payloads, as received.
+	hello := func(t *testing.T, seq int64, expectedSeq string) *model.WebSocketEvent {
+		data := `{"connection_id": "conn"`
+		if expectedSeq != "" {
+			data += `, "expected_seq": ` + expectedSeq
+		}
+		payload := fmt.Sprintf(`{"event": "hello", "seq": %d, "data": %s}}`, seq, data)
+		ev, err := model.WebSocketEventFromJSON(strings.NewReader(payload))
+		require.NoError(t, err)
+		return ev
+	}
+
+	require.NoError(t, eventErr(ue.wsEventHandler(hello(t, 0, ""))))
+	ue.wsServerSeq = 10
+
+	t.Run("no hint", func(t *testing.T) {
+		require.ErrorIs(t, eventErr(ue.wsEventHandler(hello(t, 15, ""))), errSeqMismatch)
+		require.Empty(t, calls)
+		require.Equal(t, int64(10), ue.wsServerSeq)
+	})
+
+	t.Run("invalid hints", func(t *testing.T) {
+		for _, expectedSeq := range []string{`"15"`, "-1", "15.5", "null"} {
+			require.ErrorIs(t, eventErr(ue.wsEventHandler(hello(t, 15, expectedSeq))), errSeqMismatch, expectedSeq)
+		}
+		require.Empty(t, calls)
+		require.Equal(t, int64(10), ue.wsServerSeq)
+	})
+
+	t.Run("matching hint", func(t *testing.T) {
+		ue.wsServerSeq = 10
+		require.NoError(t, eventErr(ue.wsEventHandler(hello(t, 10, "10"))))
+		require.Empty(t, calls)
+		require.Equal(t, int64(11), ue.wsServerSeq)
+	})
+
+	t.Run("pruned events", func(t *testing.T) {
+		ue.wsServerSeq = 10
+		require.NoError(t, eventErr(ue.wsEventHandler(hello(t, 15, "15"))))
+		require.Equal(t, [][2]int64{{10, 15}}, calls)
+		require.Equal(t, int64(16), ue.wsServerSeq)
+	})
+}
+
+// newSeqMismatchServer returns a WebSocket server skipping sequence numbers on
+// the first connection, along with the number of connections made to it.
+func newSeqMismatchServer() (*httptest.Server, *int32) {
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if atomic.AddInt32(&conns, 1) == 1 {
+			hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil)
+			hello.Add("connection_id", "conn")
+			data, _ := hello.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+
+			// Skipping sequence numbers forces a reconnect.
+			ev := model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil).SetSequence(5)
+			data, _ = ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	return ts, &conns
+}
+
+func TestListenMetrics(t *testing.T) {
+	ts, conns := newSeqMismatchServer()
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	m := performance.NewMetrics()
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+		Persona:      "test",
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range ue.Events() {
+		}
+	}()
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(conns) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, ue.Disconnect())
+
+	metrics := m.UserEntityMetrics()
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.WebSocketSeqMismatches.WithLabelValues("test")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.WebSocketReconnects.WithLabelValues(reconnectReasonSeqMismatch, "test")))
+	require.Zero(t, testutil.ToFloat64(metrics.WebSocketReconnects.WithLabelValues(reconnectReasonConnectError, "test")))
+	require.Zero(t, testutil.ToFloat64(metrics.WebSocketConnectFailures.WithLabelValues("test")))
+
+	t.Run("connect failure", func(t *testing.T) {
+		ts.Close()
+		errChan, err := ue.Connect()
+		require.NoError(t, err)
+		go func() {
+			for range errChan {
+			}
+		}()
+
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(metrics.WebSocketConnectFailures.WithLabelValues("test")) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, ue.Disconnect())
+	})
+}
+
+func TestListenReconnectReasons(t *testing.T) {
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The first connection is closed by the server right away.
+		if atomic.AddInt32(&conns, 1) == 1 {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	m := performance.NewMetrics()
+	metrics := m.UserEntityMetrics()
+	ue := New(Setup{Store: s, Metrics: metrics}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		Persona:                       "test",
+		WebSocketMinReconnectDuration: 10 * time.Millisecond,
+		WebSocketMaxReconnectDuration: 50 * time.Millisecond,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	reconnects := func(reason string) float64 {
+		return testutil.ToFloat64(metrics.WebSocketReconnects.WithLabelValues(reason, "test"))
+	}
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	t.Run("server close", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&conns) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, float64(1), reconnects(reconnectReasonServerClose))
+		require.Zero(t, reconnects(reconnectReasonChanClosed))
+		require.Zero(t, reconnects(reconnectReasonConnectError))
+	})
+
+	t.Run("channel closed", func(t *testing.T) {
+		// Nothing but Disconnect closes the actions channel, so the internals
+		// of Disconnect are replicated below to stop the listener.
+		close(ue.wsActions)
+		require.Eventually(t, func() bool {
+			return reconnects(reconnectReasonChanClosed) >= 1
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("connect error", func(t *testing.T) {
+		ts.Close()
+		require.Eventually(t, func() bool {
+			return reconnects(reconnectReasonConnectError) >= 1
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	close(ue.wsClosing)
+	<-ue.wsClosed
+	close(ue.wsEventChan)
+	close(ue.wsErrorChan)
+	require.Zero(t, reconnects(reconnectReasonSeqMismatch))
+}
+
+func TestListenFakeServer(t *testing.T) {
+	typing := func() *model.WebSocketEvent {
+		return model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil)
+	}
+
+	t.Run("reconnect", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		ue, events := newFakeWSEntity(t, srv, Setup{}, Config{})
+
+		conn := srv.nextConn(t)
+		require.Empty(t, conn.connID)
+		conn.hello(t, "conn1")
+		conn.send(t, typing())
+		conn.send(t, typing())
+		waitEvent(t, events, model.WebsocketEventHello)
+		waitEvent(t, events, model.WebsocketEventTyping)
+		waitEvent(t, events, model.WebsocketEventTyping)
+
+		// The client resumes the connection where it left off.
+		conn.close()
+		conn = srv.nextConn(t)
+		require.Equal(t, "conn1", conn.connID)
+		require.Equal(t, int64(3), conn.serverSeq)
+		conn.hello(t, "conn1")
+		conn.send(t, typing())
+		waitEvent(t, events, model.WebsocketEventTyping)
+		require.Equal(t, int64(5), atomic.LoadInt64(&ue.wsServerSeq))
+
+		// Disconnecting doesn't reconnect.
+		require.NoError(t, ue.Disconnect())
+		conn.waitClosed(t)
+		srv.noConn(t, 100*time.Millisecond)
+	})
+
+	t.Run("seq mismatch", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		_, events := newFakeWSEntity(t, srv, Setup{}, Config{})
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		waitEvent(t, events, model.WebsocketEventHello)
+
+		// Skipping sequence numbers makes the client reconnect, expecting the
+		// first missed event.
+		conn.sendSeq(t, typing(), 5)
+		conn.waitClosed(t)
+		conn = srv.nextConn(t)
+		require.Equal(t, "conn1", conn.connID)
+		require.Equal(t, int64(1), conn.serverSeq)
+
+		conn.hello(t, "conn1")
+		conn.send(t, typing())
+		ev := waitEvent(t, events, model.WebsocketEventTyping)
+		require.Equal(t, int64(2), ev.GetSequence())
+	})
+
+	t.Run("hello reset", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		missed := make(chan [2]int64, 10)
+		ue, events := newFakeWSEntity(t, srv, Setup{
+			MissedEventsHandler: func(oldSeq, newSeq int64) {
+				missed <- [2]int64{oldSeq, newSeq}
+			},
+		}, Config{})
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		conn.send(t, typing())
+		waitEvent(t, events, model.WebsocketEventTyping)
+		conn.close()
+
+		// The server can't resume the connection, so it starts over with a
+		// different id.
+		conn = srv.nextConn(t)
+		require.Equal(t, "conn1", conn.connID)
+		conn.hello(t, "conn2")
+		conn.send(t, typing())
+		ev := waitEvent(t, events, model.WebsocketEventTyping)
+		require.Equal(t, int64(1), ev.GetSequence())
+		require.Equal(t, [2]int64{2, 0}, <-missed)
+		require.Equal(t, "conn2", ue.ConnectionID())
+		require.Empty(t, missed)
+	})
+}
+
+func TestReconnectHook(t *testing.T) {
+	statuses := make(chan string, 10)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasSuffix(r.URL.Path, "/status") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var status model.Status
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		statuses <- status.Status
+		json.NewEncoder(w).Encode(&status)
+	}))
+	defer api.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+	var reconnects int32
+	srv := newFakeWSServer(t)
+	_, events := newFakeWSEntity(t, srv, Setup{
+		Store:         s,
+		ReconnectHook: func() { atomic.AddInt32(&reconnects, 1) },
+	}, Config{
+		ServerURL:            api.URL,
+		SetOnlineOnReconnect: true,
+	})
+
+	// The hook doesn't run on the initial connection.
+	conn := srv.nextConn(t)
+	conn.hello(t, "conn1")
+	waitEvent(t, events, model.WebsocketEventHello)
+	require.Zero(t, atomic.LoadInt32(&reconnects))
+
+	// It runs before the events of the new connection are handled.
+	conn.close()
+	conn = srv.nextConn(t)
+	conn.hello(t, "conn1")
+	waitEvent(t, events, model.WebsocketEventHello)
+	require.Equal(t, int32(1), atomic.LoadInt32(&reconnects))
+
+	select {
+	case status := <-statuses:
+		require.Equal(t, model.StatusOnline, status)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the status update")
+	}
+	// Only one status update is sent.
+	select {
+	case <-statuses:
+		require.FailNow(t, "unexpected status update")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHandleAppsRefreshBindingsEvent(t *testing.T) {
+	newEvent := func() *model.WebSocketEvent {
+		return model.NewWebSocketEvent(wsEventAppsFrameworkRefreshBindings, "", "", model.NewId(), nil)
+	}
+
+	t.Run("no hook", func(t *testing.T) {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		ue := New(Setup{Store: s}, Config{})
+		require.NotNil(t, ue)
+		applied, err := ue.wsEventHandler(newEvent())
+		require.NoError(t, err)
+		require.False(t, applied)
+	})
+
+	t.Run("hook", func(t *testing.T) {
+		var refreshes int32
+		srv := newFakeWSServer(t)
+		_, events := newFakeWSEntity(t, srv, Setup{
+			RefreshBindingsHook: func() { atomic.AddInt32(&refreshes, 1) },
+		}, Config{})
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		waitEvent(t, events, model.WebsocketEventHello)
+		require.Zero(t, atomic.LoadInt32(&refreshes))
+
+		// The event is still forwarded, once the hook ran.
+		conn.send(t, newEvent())
+		waitEvent(t, events, wsEventAppsFrameworkRefreshBindings)
+		require.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+	})
+}
+
+func TestEventDeliveryOrder(t *testing.T) {
+	newStore := func(t *testing.T) (*memstore.MemStore, *model.Channel) {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+		channel := &model.Channel{Id: model.NewId()}
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetCurrentChannel(channel))
+		return s, channel
+	}
+
+	posted := func(t *testing.T, post *model.Post) *model.WebSocketEvent {
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", post.ChannelId, "", nil)
+		ev.Add("post", string(data))
+		return ev
+	}
+
+	t.Run("store updated", func(t *testing.T) {
+		s, channel := newStore(t)
+		srv := newFakeWSServer(t)
+		_, events := newFakeWSEntity(t, srv, Setup{Store: s}, Config{})
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		const numPosts = 50
+		for i := 0; i < numPosts; i++ {
+			conn.send(t, posted(t, &model.Post{Id: model.NewId(), ChannelId: channel.Id, UserId: model.NewId()}))
+		}
+
+		// Each post is already stored when its event is received, without
+		// waiting.
+		for i := 0; i < numPosts; i++ {
+			ev := waitEvent(t, events, model.WebsocketEventPosted)
+			var post model.Post
+			require.NoError(t, json.Unmarshal([]byte(ev.GetData()["post"].(string)), &post))
+			stored, err := s.Post(post.Id)
+			require.NoError(t, err)
+			require.Equal(t, post.Id, stored.Id)
+		}
+	})
+
+	t.Run("store updated with batched writes", func(t *testing.T) {
+		s, channel := newStore(t)
+		srv := newFakeWSServer(t)
+		_, events := newFakeWSEntity(t, srv, Setup{Store: s}, Config{
+			PostWriteBatchSize:     10,
+			PostWriteFlushInterval: time.Hour,
+		})
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		const numPosts = 25
+		postIds := make([]string, numPosts)
+		for i := 0; i < numPosts; i++ {
+			postIds[i] = model.NewId()
+			conn.send(t, posted(t, &model.Post{Id: postIds[i], ChannelId: channel.Id, UserId: model.NewId()}))
+		}
+
+		receivePosts := func(t *testing.T, postIds []string) {
+			t.Helper()
+			for _, postId := range postIds {
+				ev := waitEvent(t, events, model.WebsocketEventPosted)
+				var post model.Post
+				require.NoError(t, json.Unmarshal([]byte(ev.GetData()["post"].(string)), &post))
+				require.Equal(t, postId, post.Id)
+				stored, err := s.Post(post.Id)
+				require.NoError(t, err)
+				require.Equal(t, post.Id, stored.Id)
+			}
+		}
+
+		// Only the posts of the full batches are delivered.
+		receivePosts(t, postIds[:20])
+		select {
+		case ev := <-events:
+			require.FailNow(t, "event delivered before being stored", ev.EventType())
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// The last writes are applied when handling the next event, after
+		// which their events are delivered first.
+		conn.send(t, model.NewWebSocketEvent("custom_test_event", "", channel.Id, "", nil))
+		receivePosts(t, postIds[20:])
+		select {
+		case ev := <-events:
+			require.Equal(t, "custom_test_event", ev.EventType())
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for event")
+		}
+	})
+
+	t.Run("error reported", func(t *testing.T) {
+		s, channel := newStore(t)
+		srv := newFakeWSServer(t)
+		ue := New(Setup{Store: s}, Config{WebSocketURL: srv.wsURL()})
+		require.NotNil(t, ue)
+		ue.client.AuthToken = "token"
+		errChan, err := ue.Connect()
+		require.NoError(t, err)
+		defer ue.Disconnect()
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		conn.send(t, model.NewWebSocketEvent(model.WebsocketEventPosted, "", channel.Id, "", nil))
+
+		events := ue.Events()
+		waitEvent(t, events, model.WebsocketEventPosted)
+		// The error is already in the error channel when the event is
+		// received.
+		select {
+		case err := <-errChan:
+			require.EqualError(t, err, "userentity: error in wsEventHandler: post data is missing")
+		default:
+			require.FailNow(t, "the error was not reported before delivering the event")
+		}
+		go func() {
+			for range errChan {
+			}
+		}()
+		go func() {
+			for range events {
+			}
+		}()
+	})
+}
+
+func TestEventSubscriptions(t *testing.T) {
+	srv := newFakeWSServer(t)
+	ue, events := newFakeWSEntity(t, srv, Setup{}, Config{})
+	observer := ue.SubscribeBuffered(100)
+	// A subscriber which never reads its events.
+	lossy := ue.SubscribeBuffered(1)
+
+	conn := srv.nextConn(t)
+	conn.hello(t, "conn1")
+	const numEvents = 10
+	for i := 0; i < numEvents; i++ {
+		conn.send(t, model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil))
+	}
+
+	// The primary consumer gets all the events despite the lossy subscriber.
+	waitEvent(t, events, model.WebsocketEventHello)
+	for i := 0; i < numEvents; i++ {
+		waitEvent(t, events, model.WebsocketEventTyping)
+	}
+
+	// Events are sent to subscribers before the primary consumer.
+	require.Len(t, observer.Events(), numEvents+1)
+	require.Zero(t, observer.Dropped())
+	require.Len(t, lossy.Events(), 1)
+	require.Equal(t, uint64(numEvents), lossy.Dropped())
+	require.Equal(t, model.WebsocketEventHello, (<-lossy.Events()).EventType())
+
+	// Unsubscribing closes the channel and stops sending events.
+	observer.Unsubscribe()
+	observer.Unsubscribe()
+	for range observer.Events() {
+	}
+	conn.send(t, model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil))
+	waitEvent(t, events, model.WebsocketEventTyping)
+	require.Len(t, lossy.Events(), 1)
+	lossy.Unsubscribe()
+	require.Empty(t, ue.subs)
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Run("receive and cancel", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		ue, events := newFakeWSEntity(t, srv, Setup{}, Config{})
+		sub, cancel := ue.Subscribe()
+		other, cancelOther := ue.Subscribe()
+		defer cancelOther()
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		conn.send(t, model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil))
+
+		// Every subscriber gets the events, as does the events channel.
+		for _, ch := range []<-chan *model.WebSocketEvent{sub, other, events} {
+			waitEvent(t, ch, model.WebsocketEventHello)
+			waitEvent(t, ch, model.WebsocketEventTyping)
+		}
+
+		cancel()
+		cancel()
+		_, ok := <-sub
+		require.False(t, ok)
+
+		// The other subscribers are unaffected.
+		conn.send(t, model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil))
+		waitEvent(t, other, model.WebsocketEventTyping)
+		waitEvent(t, events, model.WebsocketEventTyping)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		ue, events := newFakeWSEntity(t, srv, Setup{}, Config{})
+		sub, cancel := ue.Subscribe()
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		waitEvent(t, events, model.WebsocketEventHello)
+
+		// The subscriptions are cleaned up on disconnect, after the events
+		// already received.
+		require.NoError(t, ue.Disconnect())
+		waitEvent(t, sub, model.WebsocketEventHello)
+		_, ok := <-sub
+		require.False(t, ok)
+		require.Empty(t, ue.subs)
+		// Cancelling once disconnected is a no-op.
+		cancel()
+	})
+
+	t.Run("slow subscriber", func(t *testing.T) {
+		srv := newFakeWSServer(t)
+		ue, events := newFakeWSEntity(t, srv, Setup{}, Config{})
+		sub, cancel := ue.Subscribe()
+		defer cancel()
+
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn1")
+		waitEvent(t, events, model.WebsocketEventHello)
+
+		// The primary consumer isn't held back by the subscriber, which
+		// doesn't read anything until well past the buffer size of
+		// SubscribeBuffered.
+		const rounds, perRound = 6, 50
+		for r := 0; r < rounds; r++ {
+			for i := 0; i < perRound; i++ {
+				conn.send(t, model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil))
+			}
+			for i := 0; i < perRound; i++ {
+				waitEvent(t, events, model.WebsocketEventTyping)
+			}
+		}
+		const numEvents = rounds * perRound
+
+		// The subscriber still gets every event, in order.
+		require.NoError(t, ue.Disconnect())
+		var seqs []int64
+		for ev := range sub {
+			seqs = append(seqs, ev.GetSequence())
+		}
+		require.Len(t, seqs, numEvents+1)
+		for i, seq := range seqs {
+			require.Equal(t, int64(i), seq)
+		}
+	})
+}
+
+func TestListenReadTimeout(t *testing.T) {
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&conns, 1)
+
+		// The server goes silent, leaving the connection half-open.
+		conn.SetPingHandler(func(string) error { return nil })
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	m := performance.NewMetrics()
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		Persona:                       "test",
+		WebSocketReadTimeout:          100 * time.Millisecond,
+		WebSocketMinReconnectDuration: 10 * time.Millisecond,
+		WebSocketMaxReconnectDuration: 10 * time.Millisecond,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range ue.Events() {
+		}
+	}()
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	// The timeout fires and the entity reconnects.
+	metrics := m.UserEntityMetrics()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&conns) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, ue.Disconnect())
+	require.GreaterOrEqual(t, testutil.ToFloat64(metrics.WebSocketReadTimeouts.WithLabelValues("test")), float64(1))
+}
+
+func TestListenReadLimit(t *testing.T) {
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&conns, 1)
+
+		// The message exceeds the configured limit.
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", "", "", nil)
+		ev.Add("post", strings.Repeat("a", 1024))
+		data, _ := ev.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	m := performance.NewMetrics()
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		Persona:                       "test",
+		WebSocketMaxMessageSize:       512,
+		WebSocketMinReconnectDuration: 10 * time.Millisecond,
+		WebSocketMaxReconnectDuration: 10 * time.Millisecond,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	// The connection is closed and the entity reconnects.
+	metrics := m.UserEntityMetrics()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&conns) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, ue.Disconnect())
+	require.GreaterOrEqual(t, testutil.ToFloat64(metrics.WebSocketReadLimitHits.WithLabelValues("test")), float64(1))
+	require.Zero(t, testutil.ToFloat64(metrics.WebSocketReadTimeouts.WithLabelValues("test")))
+}
+
+func TestSendTypingEvent(t *testing.T) {
+	ue := &UserEntity{
+		config: Config{TypingEventTimeout: 10 * time.Millisecond},
+	}
+	err := ue.SendTypingEvent("channelId", "")
+	require.ErrorIs(t, err, ErrNotConnected)
+	require.EqualError(t, err, "user is not connected")
+
+	ue.connected = true
+	ue.wsTyping = make(chan userTypingMsg)
+	ue.wsClosed = make(chan struct{})
+
+	t.Run("timeout", func(t *testing.T) {
+		// Nobody is receiving from wsTyping so the send can't complete.
+		err := ue.SendTypingEvent("channelId", "")
+		require.ErrorIs(t, err, ErrTypingQueueFull)
+		require.NotErrorIs(t, err, ErrNotConnected)
+		require.Contains(t, err.Error(), "timed out")
+	})
+
+	t.Run("sent", func(t *testing.T) {
+		done := make(chan userTypingMsg, 1)
+		go func() {
+			done <- <-ue.wsTyping
+		}()
+		require.NoError(t, ue.SendTypingEvent("channelId", "parentId"))
+		msg := <-done
+		require.Equal(t, "channelId", msg.channelId)
+		require.Equal(t, "parentId", msg.parentId)
+		require.False(t, msg.enqueuedAt.IsZero())
+	})
+
+	t.Run("listener stopped", func(t *testing.T) {
+		ue.wsErr = ErrReconnectGaveUp
+		close(ue.wsClosed)
+		err := ue.SendTypingEvent("channelId", "")
+		require.ErrorIs(t, err, ErrNotConnected)
+		require.ErrorIs(t, err, ErrReconnectGaveUp)
+		require.NotErrorIs(t, err, ErrTypingQueueFull)
+	})
+}
+
+func TestHandlePostUnreadEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+
+	channel := &model.Channel{Id: model.NewId(), TotalMsgCount: 10}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+		ChannelId: channel.Id,
+		UserId:    user.Id,
+		MsgCount:  10,
+	}))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(channelId string) *model.WebSocketEvent {
+		return model.NewWebSocketEvent(model.WebsocketEventPostUnread, "", channelId, user.Id, nil)
+	}
+
+	t.Run("missing data", func(t *testing.T) {
+		require.Error(t, ue.handlePostUnreadEvent(newEvent("")))
+
+		ev := newEvent(channel.Id)
+		ev.Add("msg_count", int64(1))
+		require.EqualError(t, ue.handlePostUnreadEvent(ev), "mention_count data is missing")
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		ev := newEvent(channel.Id)
+		ev.Add("msg_count", "1")
+		ev.Add("mention_count", int64(1))
+		require.Error(t, ue.handlePostUnreadEvent(ev))
+	})
+
+	t.Run("channel not loaded", func(t *testing.T) {
+		ev := newEvent(model.NewId())
+		ev.Add("msg_count", int64(1))
+		ev.Add("mention_count", int64(1))
+		require.NoError(t, ue.handlePostUnreadEvent(ev))
+	})
+
+	t.Run("unread counts updated", func(t *testing.T) {
+		ev := newEvent(channel.Id)
+		ev.Add("msg_count", int64(7))
+		ev.Add("mention_count", int64(2))
+		ev.Add("mention_count_root", int64(1))
+		ev.Add("last_viewed_at", int64(1234))
+
+		// The event data is decoded from JSON when received by the client.
+		data, err := ev.ToJSON()
+		require.NoError(t, err)
+		ev, err = model.WebSocketEventFromJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.NoError(t, ue.handlePostUnreadEvent(ev))
+
+		member, err := s.ChannelMember(channel.Id, user.Id)
+		require.NoError(t, err)
+		require.Equal(t, int64(7), member.MsgCount)
+		require.Equal(t, int64(2), member.MentionCount)
+		require.Equal(t, int64(1), member.MentionCountRoot)
+		require.Equal(t, int64(1234), member.LastViewedAt)
+	})
+}
+
+func TestDisconnectGraceful(t *testing.T) {
+	const numEvents = 4
+	written := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 0; i < numEvents; i++ {
+			ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(int64(i))
+			data, _ := ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+		close(written)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	// The events are left buffered by the client until the consumer starts
+	// reading after the disconnect was requested.
+	<-written
+	time.Sleep(50 * time.Millisecond)
+	disconnected := make(chan error)
+	go func() {
+		disconnected <- ue.DisconnectGraceful()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	var received int
+	for range ue.Events() {
+		received++
+	}
+	require.NoError(t, <-disconnected)
+	require.Equal(t, numEvents, received)
+}
+
+// BenchmarkEventsBufferSize measures how often a slow consumer makes the
+// entity reconnect while receiving a burst of events, for different buffer
+// sizes.
+func BenchmarkEventsBufferSize(b *testing.B) {
+	const numEvents = 500
+
+	burst := func(b *testing.B, bufSize int) bool {
+		var conns int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgrader := &gorillaws.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			// The burst is only sent on the first connection.
+			if atomic.AddInt32(&conns, 1) == 1 {
+				for i := 0; i < numEvents; i++ {
+					ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(int64(i))
+					data, _ := ev.ToJSON()
+					conn.WriteMessage(gorillaws.TextMessage, data)
+					if i%10 == 0 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+		defer ts.Close()
+
+		s, err := memstore.New(nil)
+		require.NoError(b, err)
+		ue := New(Setup{Store: s}, Config{
+			WebSocketURL:     strings.Replace(ts.URL, "http://", "ws://", 1),
+			EventsBufferSize: bufSize,
+		})
+		require.NotNil(b, ue)
+		ue.client.AuthToken = "token"
+
+		errChan, err := ue.Connect()
+		require.NoError(b, err)
+		go func() {
+			for range errChan {
+			}
+		}()
+
+		var received int32
+		go func() {
+			for range ue.Events() {
+				// A slow consumer.
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&received, 1)
+			}
+		}()
+
+		require.Eventually(b, func() bool {
+			return atomic.LoadInt32(&received) == numEvents || atomic.LoadInt32(&conns) > 1
+		}, 10*time.Second, time.Millisecond)
+		require.NoError(b, ue.Disconnect())
+
+		return atomic.LoadInt32(&conns) > 1
+	}
+
+	for _, bufSize := range []int{0, numEvents} {
+		b.Run(fmt.Sprintf("buffer %d", bufSize), func(b *testing.B) {
+			var reconnects int
+			for i := 0; i < b.N; i++ {
+				if burst(b, bufSize) {
+					reconnects++
+				}
+			}
+			b.ReportMetric(float64(reconnects)/float64(b.N), "reconnects/op")
+		})
+	}
+}
+
+func TestHandleStatusChangeEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	newEvent := func(data map[string]interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventStatusChange, "", "", "", nil)
+		return ev.SetData(data)
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		require.EqualError(t, ue.handleStatusChangeEvent(newEvent(map[string]interface{}{
+			"status": model.StatusOnline,
+		})), "user_id data is missing")
+		require.EqualError(t, ue.handleStatusChangeEvent(newEvent(map[string]interface{}{
+			"user_id": model.NewId(),
+			"status":  1,
+		})), "status data is missing")
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		userId := model.NewId()
+		require.NoError(t, ue.handleStatusChangeEvent(newEvent(map[string]interface{}{
+			"user_id": userId,
+			"status":  model.StatusOnline,
+		})))
+
+		status, err := s.Status(userId)
+		require.NoError(t, err)
+		require.Equal(t, model.StatusOnline, status.Status)
+
+		userIds, err := s.OnlineUsers()
+		require.NoError(t, err)
+		require.Equal(t, []string{userId}, userIds)
+	})
+}
+
+func TestHandleTypingEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	channel := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(channel))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(channelId, userId string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventTyping, "", channelId, "", nil)
+		ev.Add("parent_id", "")
+		ev.Add("user_id", userId)
+		return ev
+	}
+
+	require.EqualError(t, ue.handleTypingEvent(newEvent("", model.NewId())), "channel_id data is missing")
+	require.EqualError(t, ue.handleTypingEvent(newEvent(channel.Id, "")), "user_id data is missing")
+
+	// Channels not in the store are ignored.
+	otherChannelId := model.NewId()
+	require.NoError(t, ue.handleTypingEvent(newEvent(otherChannelId, model.NewId())))
+	userIds, err := s.GetTypingUsers(otherChannelId)
+	require.NoError(t, err)
+	require.Empty(t, userIds)
+
+	require.Equal(t, defaultTypingTTL, ue.typingTTL())
+	s.SetClientConfig(map[string]string{"TimeBetweenUserTypingUpdatesMilliseconds": "50"})
+	require.Equal(t, 50*time.Millisecond, ue.typingTTL())
+
+	userId := model.NewId()
+	require.NoError(t, ue.handleTypingEvent(newEvent(channel.Id, userId)))
+	userIds, err = s.GetTypingUsers(channel.Id)
+	require.NoError(t, err)
+	require.Equal(t, []string{userId}, userIds)
+
+	require.Eventually(t, func() bool {
+		userIds, err := s.GetTypingUsers(channel.Id)
+		require.NoError(t, err)
+		return len(userIds) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConnectionEvents(t *testing.T) {
+	ts, _ := newSeqMismatchServer()
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:               strings.Replace(ts.URL, "http://", "ws://", 1),
+		ConnectionEventsBufferSize: 10,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range ue.Events() {
+		}
+	}()
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	next := func() ConnectionEvent {
+		select {
+		case ev := <-ue.ConnectionEvents():
+			return ev
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for connection event")
+		}
+		return ConnectionEvent{}
+	}
+
+	require.Equal(t, ConnectionEvent{Type: ConnectionEventConnected}, next())
+	require.Equal(t, ConnectionEvent{Type: ConnectionEventSeqMismatch, ConnID: "conn", Seq: 1}, next())
+	require.Equal(t, ConnectionEvent{Type: ConnectionEventDisconnected, ConnID: "conn", Seq: 1}, next())
+	require.Equal(t, ConnectionEvent{Type: ConnectionEventReconnecting, ConnID: "conn", Seq: 1}, next())
+	require.Equal(t, ConnectionEvent{Type: ConnectionEventConnected, ConnID: "conn", Seq: 1}, next())
+
+	require.NoError(t, ue.Disconnect())
+	require.Equal(t, ConnectionEvent{Type: ConnectionEventDisconnected, ConnID: "conn", Seq: 1}, next())
+
+	t.Run("disabled", func(t *testing.T) {
+		ue := &UserEntity{}
+		require.Nil(t, ue.ConnectionEvents())
+		require.NotPanics(t, func() { ue.publishConnectionEvent(ConnectionEventConnected) })
+	})
+}
+
+func TestHandleChannelCreatedAndDeletedEvents(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	channelId := model.NewId()
+	teamId := model.NewId()
+	created := model.NewWebSocketEvent(model.WebsocketEventChannelCreated, "", "", "", nil)
+	created.Add("channel_id", channelId)
+	created.Add("team_id", teamId)
+	require.NoError(t, ue.handleChannelCreatedEvent(created))
+
+	channel, err := s.Channel(channelId)
+	require.NoError(t, err)
+	require.Equal(t, &model.Channel{Id: channelId, TeamId: teamId}, channel)
+
+	// A duplicate event doesn't replace the stored channel.
+	require.NoError(t, s.SetChannel(&model.Channel{Id: channelId, TeamId: teamId, Name: "name"}))
+	require.NoError(t, ue.handleChannelCreatedEvent(created))
+	channel, err = s.Channel(channelId)
+	require.NoError(t, err)
+	require.Equal(t, "name", channel.Name)
+
+	post := &model.Post{Id: model.NewId(), ChannelId: channelId}
+	require.NoError(t, s.SetPost(post))
+
+	deleted := model.NewWebSocketEvent(model.WebsocketEventChannelDeleted, "", "", "", nil)
+	deleted.Add("channel_id", channelId)
+	require.NoError(t, ue.handleChannelDeletedEvent(deleted))
+
+	channel, err = s.Channel(channelId)
+	require.NoError(t, err)
+	require.Nil(t, channel)
+	_, err = s.Post(post.Id)
+	require.ErrorIs(t, err, memstore.ErrPostNotFound)
+
+	t.Run("missing data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelCreated, "", "", "", nil)
+		require.Error(t, ue.handleChannelCreatedEvent(ev))
+	})
+}
+
+func TestConnectionID(t *testing.T) {
+	ts, _ := newSeqMismatchServer()
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+	require.Empty(t, ue.ConnectionID())
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range ue.Events() {
+		}
+	}()
+	go func() {
+		for range errChan {
+		}
+	}()
+
+	// Read concurrently with the listening goroutine writing it.
+	require.Eventually(t, func() bool {
+		return ue.ConnectionID() == "conn"
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, ue.Disconnect())
+}
+
+func TestHandleReactionEvent(t *testing.T) {
+	current := &model.Channel{Id: model.NewId()}
+	background := &model.Channel{Id: model.NewId()}
+
+	newEntity := func(t *testing.T, trackAll bool) (*UserEntity, *model.Post, *model.Post) {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetChannel(current))
+		require.NoError(t, s.SetChannel(background))
+		require.NoError(t, s.SetCurrentChannel(current))
+
+		currentPost := &model.Post{Id: model.NewId(), ChannelId: current.Id}
+		backgroundPost := &model.Post{Id: model.NewId(), ChannelId: background.Id}
+		require.NoError(t, s.SetPost(currentPost))
+		require.NoError(t, s.SetPost(backgroundPost))
+
+		return &UserEntity{store: s, config: Config{TrackAllLoadedChannels: trackAll}}, currentPost, backgroundPost
+	}
+
+	reactionAdded := func(t *testing.T, postId string) *model.WebSocketEvent {
+		data, err := json.Marshal(&model.Reaction{PostId: postId, UserId: model.NewId(), EmojiName: "smile"})
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventReactionAdded, "", "", "", nil)
+		ev.Add("reaction", string(data))
+		return ev
+	}
+
+	numReactions := func(t *testing.T, ue *UserEntity, postId string) int {
+		reactions, err := ue.store.Reactions(postId)
+		require.NoError(t, err)
+		return len(reactions)
+	}
+
+	t.Run("current channel only", func(t *testing.T) {
+		ue, currentPost, backgroundPost := newEntity(t, false)
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, currentPost.Id)))
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, backgroundPost.Id)))
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, model.NewId())))
+		require.Equal(t, 1, numReactions(t, ue, currentPost.Id))
+		require.Zero(t, numReactions(t, ue, backgroundPost.Id))
+	})
+
+	t.Run("all loaded channels", func(t *testing.T) {
+		ue, currentPost, backgroundPost := newEntity(t, true)
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, currentPost.Id)))
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, backgroundPost.Id)))
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, model.NewId())))
+		require.Equal(t, 1, numReactions(t, ue, currentPost.Id))
+		require.Equal(t, 1, numReactions(t, ue, backgroundPost.Id))
+	})
+
+	t.Run("dwell time", func(t *testing.T) {
+		ue, currentPost, backgroundPost := newEntity(t, false)
+		clock := newFakeClock()
+		ue.clock = clock
+		ue.config.CurrentChannelDwell = time.Second
+
+		// The user switches channels rapidly, not staying long enough in
+		// any of them.
+		for i := 0; i < 3; i++ {
+			require.NoError(t, ue.SetCurrentChannel(background))
+			require.NoError(t, ue.handleReactionEvent(reactionAdded(t, backgroundPost.Id)))
+			clock.Advance(500 * time.Millisecond)
+			require.NoError(t, ue.SetCurrentChannel(current))
+			require.NoError(t, ue.handleReactionEvent(reactionAdded(t, currentPost.Id)))
+			clock.Advance(500 * time.Millisecond)
+		}
+		require.Zero(t, numReactions(t, ue, currentPost.Id))
+		require.Zero(t, numReactions(t, ue, backgroundPost.Id))
+
+		// Setting the same channel again keeps the time already spent in it.
+		require.NoError(t, ue.SetCurrentChannel(current))
+		clock.Advance(500 * time.Millisecond)
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, currentPost.Id)))
+		require.Equal(t, 1, numReactions(t, ue, currentPost.Id))
+		require.NoError(t, ue.handleReactionEvent(reactionAdded(t, backgroundPost.Id)))
+		require.Zero(t, numReactions(t, ue, backgroundPost.Id))
+	})
+}
+
+func TestHandleEphemeralMessageEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	channel := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetCurrentChannel(channel))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(data interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventEphemeralMessage, "", channel.Id, "", nil)
+		ev.Add("post", data)
+		return ev
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventEphemeralMessage, "", channel.Id, "", nil)
+		require.EqualError(t, eventErr(ue.wsEventHandler(ev)), "post data is missing")
+		require.EqualError(t, ue.handleEphemeralMessageEvent(newEvent(42)), "type of the post data should be a string, but it is int")
+		require.Error(t, ue.handleEphemeralMessageEvent(newEvent("invalid")))
+		require.EqualError(t, ue.handleEphemeralMessageEvent(newEvent("{}")), "channel id data is missing")
+	})
+
+	t.Run("not stored", func(t *testing.T) {
+		post := &model.Post{Id: model.NewId(), ChannelId: channel.Id, Type: model.PostTypeEphemeral, Message: "bot reply"}
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		require.NoError(t, ue.handleEphemeralMessageEvent(newEvent(string(data))))
+
+		_, err = s.Post(post.Id)
+		require.ErrorIs(t, err, memstore.ErrPostNotFound)
+		posts, err := s.ChannelPosts(channel.Id)
+		require.NoError(t, err)
+		require.Empty(t, posts)
+	})
+}
+
+func TestKnownChannels(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	stored := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(stored))
+	ue := &UserEntity{store: s}
+	require.Equal(t, []string{stored.Id}, ue.KnownChannels())
+
+	// Receiving a post makes its channel known even if not loaded.
+	post := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+	data, err := json.Marshal(post)
+	require.NoError(t, err)
+	ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", post.ChannelId, "", nil)
+	ev.Add("post", string(data))
+	require.NoError(t, ue.handlePostEvent(ev))
+
+	require.ElementsMatch(t, []string{stored.Id, post.ChannelId}, ue.KnownChannels())
+}
+
+func TestHandlePostEditedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	newEvent := func(t *testing.T, eventType string, post *model.Post) *model.WebSocketEvent {
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(eventType, "", post.ChannelId, "", nil)
+		ev.Add("post", string(data))
+		return ev
+	}
+
+	// The user has no current channel.
+	_, err = s.CurrentChannel()
+	require.ErrorIs(t, err, memstore.ErrChannelNotFound)
+
+	post := &model.Post{Id: model.NewId(), ChannelId: model.NewId(), Message: "original"}
+	require.NoError(t, s.SetPost(post))
+
+	t.Run("edit to a stored post", func(t *testing.T) {
+		edited := post.Clone()
+		edited.Message = "edited"
+		edited.EditAt = model.GetMillis()
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPostEdited, edited)))
+
+		stored, err := s.Post(post.Id)
+		require.NoError(t, err)
+		require.Equal(t, "edited", stored.Message)
+	})
+
+	t.Run("edit to a post not in the store", func(t *testing.T) {
+		other := &model.Post{Id: model.NewId(), ChannelId: post.ChannelId, Message: "edited", EditAt: model.GetMillis()}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPostEdited, other)))
+
+		_, err := s.Post(other.Id)
+		require.ErrorIs(t, err, memstore.ErrPostNotFound)
+	})
+
+	t.Run("new post outside the current channel", func(t *testing.T) {
+		newPost := &model.Post{Id: model.NewId(), ChannelId: post.ChannelId, Message: "new"}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPosted, newPost)))
+
+		_, err := s.Post(newPost.Id)
+		require.ErrorIs(t, err, memstore.ErrPostNotFound)
+	})
+}
+
+func TestDisconnectError(t *testing.T) {
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+
+		// Malformed events make the listener report errors on the first
+		// connection.
+		if atomic.AddInt32(&conns, 1) == 1 {
+			for seq := int64(1); seq <= 2; seq++ {
+				ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", "", "", nil).SetSequence(seq)
+				data, _ := ev.ToJSON()
+				conn.WriteMessage(gorillaws.TextMessage, data)
+			}
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	// Errors are not consumed, so the listener blocks reporting the second
+	// one, right after the first malformed event is consumed.
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	var consumed int32
+	go func() {
+		for ev := range ue.Events() {
+			if ev.EventType() == model.WebsocketEventPosted {
+				atomic.AddInt32(&consumed, 1)
+			}
+		}
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&consumed) == 1 && len(errChan) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	disconnected := make(chan error)
+	go func() {
+		disconnected <- ue.Disconnect()
+	}()
+
+	select {
+	case err := <-disconnected:
+		require.EqualError(t, err, "userentity: error in wsEventHandler: post data is missing")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for disconnect")
+	}
+
+	// The error delivered before disconnecting can still be read.
+	require.EqualError(t, <-errChan, "userentity: error in wsEventHandler: post data is missing")
+	_, ok := <-errChan
+	require.False(t, ok)
+
+	t.Run("no error", func(t *testing.T) {
+		errChan, err := ue.Connect()
+		require.NoError(t, err)
+		go func() {
+			for range ue.Events() {
+			}
+		}()
+		go func() {
+			for range errChan {
+			}
+		}()
+		require.NoError(t, ue.Disconnect())
+	})
+}
+
+func TestWebSocketEventTimes(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	m := performance.NewMetrics()
+	ue := &UserEntity{store: s, metrics: m.UserEntityMetrics(), config: Config{Persona: "test"}}
+
+	status := model.NewWebSocketEvent(model.WebsocketEventStatusChange, "", "", "", nil)
+	status.Add("user_id", model.NewId())
+	status.Add("status", model.StatusOnline)
+	require.NoError(t, eventErr(ue.wsEventHandler(status.SetSequence(0))))
+	require.NoError(t, eventErr(ue.wsEventHandler(status.SetSequence(1))))
+
+	// Failures are timed as well.
+	require.Error(t, eventErr(ue.wsEventHandler(model.NewWebSocketEvent(model.WebsocketEventPosted, "", "", "", nil).SetSequence(2))))
+
+	metrics := m.UserEntityMetrics()
+	require.Equal(t, 2, testutil.CollectAndCount(metrics.WebSocketEventTimes))
+	for eventType, count := range map[string]uint64{
+		model.WebsocketEventStatusChange: 2,
+		model.WebsocketEventPosted:       1,
+	} {
+		var metric dto.Metric
+		obs := metrics.WebSocketEventTimes.WithLabelValues(eventType, "test")
+		require.NoError(t, obs.(prometheus.Histogram).Write(&metric))
+		require.Equal(t, count, metric.GetHistogram().GetSampleCount())
+	}
+}
+
+func BenchmarkWebSocketEventTimes(b *testing.B) {
+	channel := &model.Channel{Id: model.NewId()}
+	data, err := json.Marshal(&model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: "message"})
+	require.NoError(b, err)
+	ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", channel.Id, "", nil)
+	ev.Add("post", string(data))
+
+	for _, tc := range []struct {
+		name    string
+		metrics *performance.UserEntityMetrics
+	}{
+		{"metrics disabled", nil},
+		{"metrics enabled", performance.NewMetrics().UserEntityMetrics()},
+	} {
+		metrics := tc.metrics
+		b.Run(tc.name, func(b *testing.B) {
+			s, err := memstore.New(nil)
+			require.NoError(b, err)
+			require.NoError(b, s.SetChannel(channel))
+			require.NoError(b, s.SetCurrentChannel(channel))
+			ue := &UserEntity{store: s, metrics: metrics}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// The same event is handled over and over.
+				ue.wsServerSeq = 0
+				if _, err := ue.wsEventHandler(ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePreferenceEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+	userId := model.NewId()
+
+	preference := func(t *testing.T, category, name string) model.Preference {
+		prefs, err := s.Preferences()
+		require.NoError(t, err)
+		for _, p := range prefs {
+			if p.Category == category && p.Name == name {
+				return p
+			}
+		}
+		return model.Preference{}
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventPreferenceChanged, "", "", userId, nil)
+		require.EqualError(t, ue.handlePreferenceEvent(ev), "preference data is missing")
+		ev.Add("preference", "invalid")
+		require.Error(t, ue.handlePreferenceEvent(ev))
+
+		ev = model.NewWebSocketEvent(model.WebsocketEventPreferencesChanged, "", "", userId, nil)
+		require.EqualError(t, ue.handlePreferenceEvent(ev), "preferences data is missing")
+	})
+
+	t.Run("favorite channel", func(t *testing.T) {
+		channelId := model.NewId()
+		data, err := json.Marshal(model.Preferences{
+			{UserId: userId, Category: model.PreferenceCategoryFavoriteChannel, Name: channelId, Value: "true"},
+		})
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventPreferencesChanged, "", "", userId, nil)
+		ev.Add("preferences", string(data))
+		require.NoError(t, eventErr(ue.wsEventHandler(ev)))
+
+		require.Equal(t, "true", preference(t, model.PreferenceCategoryFavoriteChannel, channelId).Value)
+	})
+
+	t.Run("toggle CRT", func(t *testing.T) {
+		for _, value := range []string{"on", "off"} {
+			data, err := json.Marshal(model.Preference{
+				UserId:   userId,
+				Category: model.PreferenceCategoryDisplaySettings,
+				Name:     model.PreferenceNameCollapsedThreadsEnabled,
+				Value:    value,
+			})
+			require.NoError(t, err)
+			ev := model.NewWebSocketEvent(model.WebsocketEventPreferenceChanged, "", "", userId, nil)
+			ev.Add("preference", string(data))
+			require.NoError(t, ue.handlePreferenceEvent(ev))
+
+			require.Equal(t, value, preference(t, model.PreferenceCategoryDisplaySettings, model.PreferenceNameCollapsedThreadsEnabled).Value)
+		}
+
+		// Toggling replaces the preference rather than adding a new one.
+		prefs, err := s.Preferences()
+		require.NoError(t, err)
+		require.Len(t, prefs, 2)
+	})
+}
+
+func TestConnectWithContext(t *testing.T) {
+	// The server is closed right away so that every connection attempt fails.
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		WebSocketMinReconnectDuration: time.Hour,
+		WebSocketMaxReconnectDuration: time.Hour,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	t.Run("already cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ue.ConnectWithContext(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Error(t, ue.Disconnect())
+	})
+
+	t.Run("cancelled while retrying", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		errChan, err := ue.ConnectWithContext(ctx)
+		require.NoError(t, err)
+
+		// The first attempt fails, after which the user waits to retry.
+		select {
+		case err := <-errChan:
+			require.Contains(t, err.Error(), "websocketClient creation error")
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the connection to fail")
+		}
+
+		cancel()
+		select {
+		case err := <-errChan:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the cancellation to be reported")
+		}
+
+		require.ErrorIs(t, ue.Disconnect(), context.Canceled)
+	})
+}
+
+func TestReconnectGaveUp(t *testing.T) {
+	var attempts int32
+	dialer := &gorillaws.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&attempts, 1)
+			return nil, errors.New("connection refused")
+		},
+	}
+
+	m := performance.NewMetrics()
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s, WebSocketDialer: dialer, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL:                  "ws://localhost",
+		WebSocketDegradedThreshold:    2,
+		WebSocketMaxReconnectAttempts: 3,
+		WebSocketMinReconnectDuration: time.Millisecond,
+		WebSocketMaxReconnectDuration: time.Millisecond,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+
+	var gaveUpErr error
+	timeout := time.After(5 * time.Second)
+	for gaveUpErr == nil {
+		select {
+		case err := <-errChan:
+			if errors.Is(err, ErrReconnectGaveUp) {
+				gaveUpErr = err
+			}
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for the user to give up")
+		}
+	}
+	require.EqualError(t, gaveUpErr, "userentity: gave up reconnecting after 3 attempts")
+
+	// The listener returns instead of retrying.
+	select {
+	case <-ue.wsClosed:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the listener to return")
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	require.ErrorIs(t, ue.SendTypingEvent(model.NewId(), ""), ErrReconnectGaveUp)
+
+	// The user is still counted until it disconnects.
+	require.Equal(t, float64(1), testutil.ToFloat64(m.UserEntityMetrics().WebSocketGaveUp))
+
+	require.ErrorIs(t, ue.Disconnect(), ErrReconnectGaveUp)
+	require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().WebSocketGaveUp))
+	require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().WebSocketDegraded))
+}
+
+func TestHandlePostEventDuplicate(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	channel := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetCurrentChannel(channel))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(t *testing.T, eventType string, post *model.Post) *model.WebSocketEvent {
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(eventType, "", post.ChannelId, "", nil)
+		ev.Add("post", string(data))
+		return ev
+	}
+
+	root := &model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: "root"}
+	require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPosted, root)))
+	reply := &model.Post{Id: model.NewId(), ChannelId: channel.Id, RootId: root.Id, UserId: model.NewId(), CreateAt: model.GetMillis()}
+
+	// Delivering the same reply twice, as it can happen after reconnecting,
+	// leaves the store as it was after the first delivery.
+	var states []*model.Post
+	for i := 0; i < 2; i++ {
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPosted, reply)))
+		stored, err := s.Post(root.Id)
+		require.NoError(t, err)
+		states = append(states, stored)
+	}
+	require.Equal(t, int64(1), states[0].ReplyCount)
+	require.Equal(t, states[0], states[1])
+
+	t.Run("local fields are kept", func(t *testing.T) {
+		stored, err := s.Post(reply.Id)
+		require.NoError(t, err)
+		stored.IsFollowing = model.NewBool(true)
+		require.NoError(t, s.SetPost(stored))
+
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPosted, reply)))
+		stored, err = s.Post(reply.Id)
+		require.NoError(t, err)
+		require.NotNil(t, stored.IsFollowing)
+		require.True(t, *stored.IsFollowing)
+	})
+
+	t.Run("newer edit", func(t *testing.T) {
+		edited := reply.Clone()
+		edited.Message = "edited"
+		edited.EditAt = model.GetMillis()
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPostEdited, edited)))
+		stored, err := s.Post(reply.Id)
+		require.NoError(t, err)
+		require.Equal(t, "edited", stored.Message)
+	})
+}
+
+func TestHandleGroupMemberEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	loaded := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(loaded))
+	require.NoError(t, s.SetChannelStats(loaded.Id, &model.ChannelStats{ChannelId: loaded.Id, MemberCount: 1}))
+	notLoadedId := model.NewId()
+	groupId := model.NewId()
+	require.NoError(t, s.SetGroupChannels(groupId, []string{loaded.Id, notLoadedId}))
+
+	newEvent := func(t *testing.T, eventType string, member *model.GroupMember) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(eventType, "", "", member.UserId, nil)
+		data, err := json.Marshal(member)
+		require.NoError(t, err)
+		ev.Add("group_member", string(data))
+		return ev
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventGroupMemberAdd, "", "", "", nil)
+		require.EqualError(t, ue.handleGroupMemberEvent(ev), "group_member data is missing")
+		ev.Add("group_member", "invalid")
+		require.Error(t, ue.handleGroupMemberEvent(ev))
+		require.EqualError(t, ue.handleGroupMemberEvent(newEvent(t, model.WebsocketEventGroupMemberAdd, &model.GroupMember{GroupId: groupId})), "group or user id data is missing")
+	})
+
+	t.Run("unknown group", func(t *testing.T) {
+		member := &model.GroupMember{GroupId: model.NewId(), UserId: model.NewId()}
+		require.NoError(t, ue.handleGroupMemberEvent(newEvent(t, model.WebsocketEventGroupMemberAdd, member)))
+		_, err := s.GroupMembers(member.GroupId)
+		require.ErrorIs(t, err, memstore.ErrGroupNotFound)
+	})
+
+	member := &model.GroupMember{GroupId: groupId, UserId: model.NewId()}
+
+	t.Run("member added", func(t *testing.T) {
+		require.NoError(t, eventErr(ue.wsEventHandler(newEvent(t, model.WebsocketEventGroupMemberAdd, member))))
+
+		members, err := s.GroupMembers(groupId)
+		require.NoError(t, err)
+		require.Equal(t, []string{member.UserId}, members)
+
+		channelMember, err := s.ChannelMember(loaded.Id, member.UserId)
+		require.NoError(t, err)
+		require.Equal(t, member.UserId, channelMember.UserId)
+		stats, err := s.ChannelStats(loaded.Id)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), stats.MemberCount)
+
+		// Channels not loaded are left alone.
+		channel, err := s.Channel(notLoadedId)
+		require.NoError(t, err)
+		require.Nil(t, channel)
+	})
+
+	t.Run("member deleted", func(t *testing.T) {
+		require.NoError(t, eventErr(ue.wsEventHandler(newEvent(t, model.WebsocketEventGroupMemberDelete, member).SetSequence(1))))
+
+		members, err := s.GroupMembers(groupId)
+		require.NoError(t, err)
+		require.Empty(t, members)
+
+		channelMember, err := s.ChannelMember(loaded.Id, member.UserId)
+		require.NoError(t, err)
+		require.Empty(t, channelMember.UserId)
+		stats, err := s.ChannelStats(loaded.Id)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), stats.MemberCount)
+	})
+}
+
+func TestHandlePostEventMentions(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+
+	current := &model.Channel{Id: model.NewId()}
+	other := &model.Channel{Id: model.NewId()}
+	for _, channel := range []*model.Channel{current, other} {
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+			ChannelId: channel.Id,
+			UserId:    user.Id,
+		}))
+	}
+	require.NoError(t, s.SetCurrentChannel(current))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(t *testing.T, post *model.Post, mentions interface{}) *model.WebSocketEvent {
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", post.ChannelId, "", nil)
+		ev.Add("post", string(data))
+		if mentions != nil {
+			ev.Add("mentions", mentions)
+		}
+		return ev
+	}
+
+	mentionCounts := func(t *testing.T, channelId string) (int64, int64) {
+		member, err := s.ChannelMember(channelId, user.Id)
+		require.NoError(t, err)
+		return member.MentionCount, member.MentionCountRoot
+	}
+
+	mentions := model.ArrayToJSON([]string{model.NewId(), user.Id})
+
+	t.Run("mentions missing", func(t *testing.T) {
+		post := &model.Post{Id: model.NewId(), ChannelId: other.Id}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, post, nil)))
+		count, _ := mentionCounts(t, other.Id)
+		require.Zero(t, count)
+	})
+
+	t.Run("invalid mentions", func(t *testing.T) {
+		post := &model.Post{Id: model.NewId(), ChannelId: other.Id}
+		require.Error(t, ue.handlePostEvent(newEvent(t, post, []string{user.Id})))
+		post = &model.Post{Id: model.NewId(), ChannelId: other.Id}
+		require.Error(t, ue.handlePostEvent(newEvent(t, post, "invalid")))
+	})
+
+	t.Run("user not mentioned", func(t *testing.T) {
+		post := &model.Post{Id: model.NewId(), ChannelId: other.Id}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, post, model.ArrayToJSON([]string{model.NewId()}))))
+		count, _ := mentionCounts(t, other.Id)
+		require.Zero(t, count)
+	})
+
+	t.Run("current channel", func(t *testing.T) {
+		post := &model.Post{Id: model.NewId(), ChannelId: current.Id}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, post, mentions)))
+		count, _ := mentionCounts(t, current.Id)
+		require.Zero(t, count)
+	})
+
+	t.Run("other channel", func(t *testing.T) {
+		root := &model.Post{Id: model.NewId(), ChannelId: other.Id}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, root, mentions)))
+		reply := &model.Post{Id: model.NewId(), ChannelId: other.Id, RootId: model.NewId()}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, reply, mentions)))
+
+		count, countRoot := mentionCounts(t, other.Id)
+		require.Equal(t, int64(2), count)
+		require.Equal(t, int64(1), countRoot)
+	})
+}
+
+func TestSendWebSocketAction(t *testing.T) {
+	actions := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Action string `json:"action"`
+			}
+			if json.Unmarshal(data, &msg) == nil {
+				actions <- msg.Action
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:               strings.Replace(ts.URL, "http://", "ws://", 1),
+		WebSocketActionsBufferSize: 1,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	action := func(client *websocket.Client) error {
+		return client.SendMessage("custom_action", map[string]interface{}{"key": "value"})
+	}
+	require.Error(t, ue.SendWebSocketAction(action))
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	defer ue.Disconnect()
+
+	require.Error(t, ue.SendWebSocketAction(nil))
+
+	t.Run("custom action", func(t *testing.T) {
+		require.NoError(t, ue.SendWebSocketAction(action))
+		select {
+		case got := <-actions:
+			require.Equal(t, "custom_action", got)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for the action")
+		}
+	})
+
+	t.Run("action error", func(t *testing.T) {
+		actionErr := errors.New("action error")
+		require.NoError(t, ue.SendWebSocketAction(func(client *websocket.Client) error {
+			return actionErr
+		}))
+		select {
+		case err := <-errChan:
+			require.ErrorIs(t, err, actionErr)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for the error")
+		}
+	})
+}
+
+func TestSeqGapRecovery(t *testing.T) {
+	channel := &model.Channel{Id: model.NewId()}
+	missed := &model.Post{Id: model.NewId(), ChannelId: channel.Id, CreateAt: 2000, Message: "missed"}
+
+	var requests int32
+	var fail int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// Posts are expected to be fetched since the last stored one.
+		if atomic.LoadInt32(&fail) == 1 || r.URL.Path != "/api/v4/channels/"+channel.Id+"/posts" || r.URL.Query().Get("since") != "1000" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(&model.PostList{
+			Order: []string{missed.Id},
+			Posts: map[string]*model.Post{missed.Id: missed},
+		})
+	}))
+	defer ts.Close()
+
+	newEntity := func(t *testing.T, threshold int) *UserEntity {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetCurrentChannel(channel))
+		require.NoError(t, s.SetPost(&model.Post{Id: model.NewId(), ChannelId: channel.Id, CreateAt: 1000}))
+		ue := New(Setup{Store: s}, Config{
+			ServerURL:                        ts.URL,
+			WebSocketSeqGapRecoveryThreshold: threshold,
+		})
+		require.NotNil(t, ue)
+		ue.wsServerSeq = 1
+		return ue
+	}
+
+	newEvent := func(seq int64) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil)
+		return ev.SetSequence(seq)
+	}
+
+	t.Run("recovered", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		ue := newEntity(t, 2)
+		var oldSeq, newSeq int64
+		ue.onMissedEvents = func(o, n int64) {
+			oldSeq, newSeq = o, n
+		}
+
+		require.NoError(t, eventErr(ue.wsEventHandler(newEvent(3))))
+		require.Equal(t, int64(4), ue.wsServerSeq)
+		require.Equal(t, int64(1), oldSeq)
+		require.Equal(t, int64(3), newSeq)
+		require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+		post, err := ue.store.Post(missed.Id)
+		require.NoError(t, err)
+		require.Equal(t, missed.Message, post.Message)
+	})
+
+	t.Run("gap too large", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		ue := newEntity(t, 2)
+		require.ErrorIs(t, eventErr(ue.wsEventHandler(newEvent(4))), errSeqMismatch)
+		require.Equal(t, int64(1), ue.wsServerSeq)
+		require.Zero(t, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		ue := newEntity(t, 0)
+		require.ErrorIs(t, eventErr(ue.wsEventHandler(newEvent(2))), errSeqMismatch)
+		require.Zero(t, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("sequence going back", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		ue := newEntity(t, 2)
+		require.ErrorIs(t, eventErr(ue.wsEventHandler(newEvent(0))), errSeqMismatch)
+		require.Zero(t, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("fetch failure", func(t *testing.T) {
+		atomic.StoreInt32(&fail, 1)
+		defer atomic.StoreInt32(&fail, 0)
+		ue := newEntity(t, 2)
+		require.ErrorIs(t, eventErr(ue.wsEventHandler(newEvent(2))), errSeqMismatch)
+		require.Equal(t, int64(1), ue.wsServerSeq)
+	})
+
+	t.Run("refreshed in the background", func(t *testing.T) {
+		release := make(chan struct{})
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			ts.Config.Handler.ServeHTTP(w, r)
+		}))
+		defer api.Close()
+		defer close(release)
+
+		srv := newFakeWSServer(t)
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetChannel(channel))
+		require.NoError(t, s.SetCurrentChannel(channel))
+		require.NoError(t, s.SetPost(&model.Post{Id: model.NewId(), ChannelId: channel.Id, CreateAt: 1000}))
+		_, events := newFakeWSEntity(t, srv, Setup{Store: s}, Config{
+			ServerURL:                        api.URL,
+			WebSocketSeqGapRecoveryThreshold: 2,
+		})
+		conn := srv.nextConn(t)
+		conn.hello(t, "conn")
+		waitEvent(t, events, model.WebsocketEventHello)
+
+		// The events following the gap keep being handled while the posts
+		// are fetched, without reconnecting.
+		conn.sendSeq(t, newEvent(0), 2)
+		conn.send(t, model.NewWebSocketEvent(model.WebsocketEventStatusChange, "", "", "", nil))
+		waitEvent(t, events, model.WebsocketEventStatusChange)
+		srv.noConn(t, 50*time.Millisecond)
+		_, err = s.Post(missed.Id)
+		require.ErrorIs(t, err, memstore.ErrPostNotFound)
+
+		release <- struct{}{}
+		require.Eventually(t, func() bool {
+			post, err := s.Post(missed.Id)
+			return err == nil && post != nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestHandleChannelMemberUpdatedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+	channel := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{
+		ChannelId:    channel.Id,
+		UserId:       user.Id,
+		Roles:        model.ChannelUserRoleId,
+		SchemeUser:   true,
+		MentionCount: 2,
+		NotifyProps:  model.GetDefaultChannelNotifyProps(),
+	}))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(t *testing.T, member *model.ChannelMember) *model.WebSocketEvent {
+		data, err := json.Marshal(member)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelMemberUpdated, "", "", member.UserId, nil)
+		ev.Add("channelMember", string(data))
+		return ev
+	}
+
+	t.Run("missing data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelMemberUpdated, "", "", user.Id, nil)
+		require.EqualError(t, ue.handleChannelMemberUpdatedEvent(ev), "channelMember data is missing")
+		require.Error(t, ue.handleChannelMemberUpdatedEvent(newEvent(t, &model.ChannelMember{UserId: user.Id})))
+	})
+
+	t.Run("not in store", func(t *testing.T) {
+		require.NoError(t, ue.handleChannelMemberUpdatedEvent(newEvent(t, &model.ChannelMember{
+			ChannelId: model.NewId(),
+			UserId:    user.Id,
+		})))
+		otherUserId := model.NewId()
+		require.NoError(t, ue.handleChannelMemberUpdatedEvent(newEvent(t, &model.ChannelMember{
+			ChannelId: channel.Id,
+			UserId:    otherUserId,
+		})))
+		member, err := s.ChannelMember(channel.Id, otherUserId)
+		require.NoError(t, err)
+		require.Empty(t, member.UserId)
+	})
+
+	t.Run("promoted to admin", func(t *testing.T) {
+		notifyProps := model.GetDefaultChannelNotifyProps()
+		notifyProps[model.MarkUnreadNotifyProp] = model.ChannelMarkUnreadMention
+		require.NoError(t, ue.handleChannelMemberUpdatedEvent(newEvent(t, &model.ChannelMember{
+			ChannelId:   channel.Id,
+			UserId:      user.Id,
+			Roles:       model.ChannelUserRoleId + " " + model.ChannelAdminRoleId,
+			SchemeUser:  true,
+			SchemeAdmin: true,
+			NotifyProps: notifyProps,
+		})))
+
+		member, err := s.ChannelMember(channel.Id, user.Id)
+		require.NoError(t, err)
+		require.True(t, member.SchemeAdmin)
+		require.Contains(t, member.Roles, model.ChannelAdminRoleId)
+		require.Equal(t, model.ChannelMarkUnreadMention, member.NotifyProps[model.MarkUnreadNotifyProp])
+		// Only the roles and notify props are updated.
+		require.Equal(t, int64(2), member.MentionCount)
+	})
+}
+
+func TestEventsReceived(t *testing.T) {
+	const numEvents = 5
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Events resume from the sequence number the client expects.
+		seq, _ := strconv.ParseInt(r.URL.Query().Get("sequence_number"), 10, 64)
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(seq)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for i := int64(1); i < numEvents; i++ {
+			ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(seq + i)
+			data, _ := ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+
+		// The first connection is dropped to make the client reconnect.
+		if atomic.AddInt32(&conns, 1) == 1 {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		WebSocketMinReconnectDuration: 10 * time.Millisecond,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+	require.Zero(t, ue.EventsReceived())
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	go func() {
+		for range ue.Events() {
+		}
+	}()
+	defer ue.Disconnect()
+
+	require.Eventually(t, func() bool {
+		return ue.EventsReceived() == 2*numEvents
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(2), atomic.LoadInt32(&conns))
+	// None of the events changed the store.
+	require.Zero(t, ue.EventsApplied())
+}
+
+func TestEventApplied(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+	current := &model.Channel{Id: model.NewId()}
+	background := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(current))
+	require.NoError(t, s.SetChannel(background))
+	require.NoError(t, s.SetCurrentChannel(current))
+	backgroundPost := &model.Post{Id: model.NewId(), ChannelId: background.Id}
+	require.NoError(t, s.SetPost(backgroundPost))
+	ue := New(Setup{Store: s}, Config{})
+	require.NotNil(t, ue)
+
+	var seq int64
+	newEvent := func(eventType, channelId, key string, value interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(eventType, "", channelId, "", nil).SetSequence(seq)
+		seq++
+		data, err := json.Marshal(value)
+		require.NoError(t, err)
+		ev.Add(key, string(data))
+		return ev
+	}
+
+	t.Run("stored post", func(t *testing.T) {
+		post := &model.Post{Id: model.NewId(), ChannelId: current.Id, UserId: model.NewId(), Message: "hello"}
+		applied, err := ue.wsEventHandler(newEvent(model.WebsocketEventPosted, current.Id, "post", post))
+		require.NoError(t, err)
+		require.True(t, applied)
+		stored, err := s.Post(post.Id)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+	})
+
+	t.Run("filtered reaction", func(t *testing.T) {
+		// Reactions to posts outside of the current channel aren't tracked.
+		reaction := &model.Reaction{PostId: backgroundPost.Id, UserId: model.NewId(), EmojiName: "smile"}
+		applied, err := ue.wsEventHandler(newEvent(model.WebsocketEventReactionAdded, background.Id, "reaction", reaction))
+		require.NoError(t, err)
+		require.False(t, applied)
+		reactions, err := s.Reactions(backgroundPost.Id)
+		require.NoError(t, err)
+		require.Empty(t, reactions)
+	})
+
+	t.Run("failed event", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", current.Id, "", nil).SetSequence(seq)
+		seq++
+		applied, err := ue.wsEventHandler(ev)
+		require.EqualError(t, err, "post data is missing")
+		require.False(t, applied)
+	})
+}
+
+func TestSlowConsumerWatchdog(t *testing.T) {
+	logger, err := mlog.NewLogger()
+	require.NoError(t, err)
+	defer logger.Shutdown()
+	var logs mlog.Buffer
+	require.NoError(t, mlog.AddWriterTarget(logger, &logs, true, mlog.LvlWarn))
+	mlog.InitGlobalLogger(logger)
+	defer mlog.InitGlobalLogger(nil)
+
+	m := performance.NewMetrics()
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	userId := model.NewId()
+	require.NoError(t, s.SetUser(&model.User{Id: userId}))
+	srv := newFakeWSServer(t)
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL:          srv.wsURL(),
+		SlowConsumerThreshold: 50 * time.Millisecond,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	defer ue.Disconnect()
+
+	slowSends := func() float64 {
+		return testutil.ToFloat64(m.UserEntityMetrics().WebSocketSlowConsumerSends.With(prometheus.Labels{
+			"event_type": model.WebsocketEventHello,
+			"persona":    "",
+		}))
+	}
+
+	// The consumer is stalled, so the hello event can't be handed over.
+	conn := srv.nextConn(t)
+	conn.hello(t, "conn1")
+	require.Eventually(t, func() bool {
+		return slowSends() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, logger.Flush())
+	require.Contains(t, logs.String(), "controller is slow to receive WebSocket events")
+	require.Contains(t, logs.String(), userId)
+	require.Contains(t, logs.String(), model.WebsocketEventHello)
+
+	// The event is still delivered once the consumer catches up, and events
+	// received right away aren't reported.
+	events := ue.Events()
+	waitEvent(t, events, model.WebsocketEventHello)
+	conn.send(t, model.NewWebSocketEvent(model.WebsocketEventTyping, "", "", "", nil))
+	waitEvent(t, events, model.WebsocketEventTyping)
+	require.Equal(t, float64(1), slowSends())
+	require.Zero(t, testutil.ToFloat64(m.UserEntityMetrics().WebSocketSlowConsumerSends.With(prometheus.Labels{
+		"event_type": model.WebsocketEventTyping,
+		"persona":    "",
+	})))
+}
+
+func TestDropEventsWhenFull(t *testing.T) {
+	const numEvents = 10
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(0)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for i := int64(1); i <= numEvents; i++ {
+			ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(i)
+			data, _ := ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	m := performance.NewMetrics()
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL:       strings.Replace(ts.URL, "http://", "ws://", 1),
+		EventsBufferSize:   1,
+		DropEventsWhenFull: true,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	defer ue.Disconnect()
+
+	// Nothing consumes the events so the buffer fills up with the hello
+	// event and every later one is dropped, without blocking the listener.
+	require.Eventually(t, func() bool {
+		return ue.EventsReceived() == numEvents+1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]uint64{model.WebsocketEventConfigChanged: numEvents}, ue.DroppedEvents())
+	require.Equal(t, float64(numEvents), testutil.ToFloat64(m.UserEntityMetrics().WebSocketDroppedEvents.With(prometheus.Labels{
+		"event_type": model.WebsocketEventConfigChanged,
+		"persona":    "",
+	})))
+
+	ev := <-ue.Events()
+	require.Equal(t, model.WebsocketEventHello, ev.EventType())
+}
+
+func TestMultipleConnections(t *testing.T) {
+	const numEvents = 5
+	type request struct {
+		connID string
+		seq    int64
+	}
+	requests := make(chan request, 10)
+	var conns int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		n := atomic.AddInt32(&conns, 1)
+		connID := r.URL.Query().Get("connection_id")
+		if connID == "" {
+			connID = fmt.Sprintf("conn%d", n)
+		}
+		seq, _ := strconv.ParseInt(r.URL.Query().Get("sequence_number"), 10, 64)
+		requests <- request{connID: r.URL.Query().Get("connection_id"), seq: seq}
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(seq)
+		hello.Add("connection_id", connID)
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for i := int64(1); i < numEvents; i++ {
+			ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(seq + i)
+			data, _ := ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+
+		// Only the first connection is dropped, the other one stays up.
+		if n == 1 {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL:                  strings.Replace(ts.URL, "http://", "ws://", 1),
+		WebSocketMinReconnectDuration: 10 * time.Millisecond,
+		WebSocketConnections:          2,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+	defer ue.Disconnect()
+
+	// Both connections receive all the events, including the ones sent after
+	// the dropped one reconnected.
+	require.Eventually(t, func() bool {
+		return ue.EventsReceived() == 3*numEvents
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var reqs []request
+	for len(reqs) < 3 {
+		select {
+		case req := <-requests:
+			reqs = append(reqs, req)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for connections")
+		}
+	}
+	// The two connections are opened without an id, then only the dropped
+	// one reconnects, resuming from its own sequence number.
+	require.Equal(t, request{}, reqs[0])
+	require.Equal(t, request{}, reqs[1])
+	require.Equal(t, request{connID: "conn1", seq: numEvents}, reqs[2])
+
+	ids := ue.ConnectionIDs()
+	require.Len(t, ids, 2)
+	require.ElementsMatch(t, []string{"conn1", "conn2"}, ids)
+
+	require.NoError(t, ue.Disconnect())
+	// The connections are kept when reconnecting the user.
+	require.Equal(t, ids, ue.ConnectionIDs())
+	errChan2, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan2 {
+		}
+	}()
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+	for i := 0; i < 2; i++ {
+		select {
+		case req := <-requests:
+			require.Contains(t, ids, req.connID)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for connections")
+		}
+	}
+}
+
+func TestExtraConnectionsGiveUp(t *testing.T) {
+	newEntity := func(t *testing.T, dials *int32, config Config) *UserEntity {
+		dialer := &gorillaws.Dialer{
+			NetDial: func(network, addr string) (net.Conn, error) {
+				atomic.AddInt32(dials, 1)
+				return nil, errors.New("connection refused")
+			},
+		}
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		config.WebSocketURL = "ws://localhost"
+		config.WebSocketMinReconnectDuration = time.Millisecond
+		config.WebSocketMaxReconnectDuration = time.Millisecond
+		ue := New(Setup{Store: s, WebSocketDialer: dialer}, config)
+		require.NotNil(t, ue)
+		ue.client.AuthToken = "token"
+		return ue
+	}
+
+	t.Run("max attempts", func(t *testing.T) {
+		var dials int32
+		ue := newEntity(t, &dials, Config{
+			WebSocketConnections:          3,
+			WebSocketMaxReconnectAttempts: 3,
+		})
+
+		errChan, err := ue.Connect()
+		require.NoError(t, err)
+		timeout := time.After(5 * time.Second)
+		for gaveUp := false; !gaveUp; {
+			select {
+			case err := <-errChan:
+				gaveUp = errors.Is(err, ErrReconnectGaveUp)
+			case <-timeout:
+				require.FailNow(t, "timed out waiting for the user to give up")
+			}
+		}
+		require.ErrorIs(t, ue.Disconnect(), ErrReconnectGaveUp)
+
+		// No connection kept retrying past the limit.
+		require.LessOrEqual(t, atomic.LoadInt32(&dials), int32(9))
+		n := atomic.LoadInt32(&dials)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, n, atomic.LoadInt32(&dials))
+	})
+
+	t.Run("main listener stopped", func(t *testing.T) {
+		var dials int32
+		ue := newEntity(t, &dials, Config{})
+		ue.wsClosing = make(chan struct{})
+		ue.wsClosed = make(chan struct{})
+
+		// The extra connection would retry indefinitely on its own.
+		ue.wsExtraWG.Add(1)
+		go ue.listenExtra(newExtraConns(ue.config.Username, 1)[0])
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&dials) > 1
+		}, 5*time.Second, time.Millisecond)
+
+		// The main listener giving up closes wsClosed.
+		close(ue.wsClosed)
+		done := make(chan struct{})
+		go func() {
+			ue.wsExtraWG.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the extra connection to stop")
+		}
+		n := atomic.LoadInt32(&dials)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, n, atomic.LoadInt32(&dials))
+	})
+}
+
+func TestHandleDirectChannelAddedEvent(t *testing.T) {
+	dm := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeDirect, Name: "dm"}
+	gm := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeGroup, Name: "gm"}
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		for _, channel := range []*model.Channel{dm, gm} {
+			if r.URL.Path == "/api/v4/channels/"+channel.Id {
+				json.NewEncoder(w).Encode(channel)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId()}
+	require.NoError(t, s.SetUser(user))
+	ue := New(Setup{Store: s}, Config{ServerURL: ts.URL})
+	require.NotNil(t, ue)
+
+	for _, tc := range []struct {
+		eventType string
+		channel   *model.Channel
+	}{
+		{model.WebsocketEventDirectAdded, dm},
+		{model.WebsocketEventGroupAdded, gm},
+	} {
+		t.Run(tc.eventType, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			ev := model.NewWebSocketEvent(tc.eventType, "", tc.channel.Id, "", nil)
+			require.NoError(t, ue.handleDirectChannelAddedEvent(ev))
+			require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+			channel, err := s.Channel(tc.channel.Id)
+			require.NoError(t, err)
+			require.NotNil(t, channel)
+			require.Equal(t, tc.channel.Type, channel.Type)
+			require.True(t, ue.isChannelMember(tc.channel.Id))
+
+			// A channel already in the store isn't fetched again.
+			require.NoError(t, ue.handleDirectChannelAddedEvent(ev))
+			require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+		})
+	}
+
+	t.Run("missing channel id", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventDirectAdded, "", "", "", nil)
+		require.Error(t, ue.handleDirectChannelAddedEvent(ev))
+	})
+
+	t.Run("fetch failure", func(t *testing.T) {
+		channelId := model.NewId()
+		ev := model.NewWebSocketEvent(model.WebsocketEventGroupAdded, "", channelId, "", nil)
+		require.Error(t, ue.handleDirectChannelAddedEvent(ev))
+		channel, err := s.Channel(channelId)
+		require.NoError(t, err)
+		require.Nil(t, channel)
+	})
+}
+
+func TestBackgroundFetch(t *testing.T) {
+	dm := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeDirect, Name: "dm"}
+	release := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if r.URL.Path == "/api/v4/channels/"+dm.Id {
+			json.NewEncoder(w).Encode(dm)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer api.Close()
+	defer close(release)
+
+	srv := newFakeWSServer(t)
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+	ue, events := newFakeWSEntity(t, srv, Setup{Store: s}, Config{ServerURL: api.URL})
+	conn := srv.nextConn(t)
+	conn.hello(t, "conn")
+	waitEvent(t, events, model.WebsocketEventHello)
+
+	// The listener keeps handling events while the channel is fetched.
+	conn.send(t, model.NewWebSocketEvent(model.WebsocketEventDirectAdded, "", dm.Id, "", nil))
+	conn.send(t, model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil))
+	waitEvent(t, events, model.WebsocketEventDirectAdded)
+	waitEvent(t, events, model.WebsocketEventConfigChanged)
+	channel, err := s.Channel(dm.Id)
+	require.NoError(t, err)
+	require.Nil(t, channel)
+
+	release <- struct{}{}
+	require.Eventually(t, func() bool {
+		channel, err := s.Channel(dm.Id)
+		return err == nil && channel != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, ue.isChannelMember(dm.Id))
+}
+
+func TestHandleChannelConvertedEvent(t *testing.T) {
+	channel := &model.Channel{Id: model.NewId(), Type: model.ChannelTypePrivate, Name: "channel"}
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/api/v4/channels/"+channel.Id {
+			json.NewEncoder(w).Encode(channel)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetChannel(&model.Channel{Id: channel.Id, Type: model.ChannelTypeOpen, Name: "channel", DisplayName: "Channel"}))
+	ue := New(Setup{Store: s}, Config{ServerURL: ts.URL})
+	require.NotNil(t, ue)
+
+	newEvent := func(channelId string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelConverted, model.NewId(), "", "", nil)
+		ev.Add("channel_id", channelId)
+		return ev
+	}
+
+	t.Run("missing channel id", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventChannelConverted, model.NewId(), "", "", nil)
+		require.EqualError(t, ue.handleChannelConvertedEvent(ev), "channel_id data is missing")
+	})
+
+	t.Run("channel not in store", func(t *testing.T) {
+		require.NoError(t, ue.handleChannelConvertedEvent(newEvent(model.NewId())))
+		require.Zero(t, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("converted", func(t *testing.T) {
+		require.NoError(t, ue.handleChannelConvertedEvent(newEvent(channel.Id)))
+		stored, err := s.Channel(channel.Id)
+		require.NoError(t, err)
+		require.Equal(t, model.ChannelTypePrivate, stored.Type)
+		// The rest of the stored channel is left untouched.
+		require.Equal(t, "Channel", stored.DisplayName)
+	})
+
+	t.Run("fetch failure", func(t *testing.T) {
+		other := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeOpen}
+		require.NoError(t, s.SetChannel(other))
+		require.Error(t, ue.handleChannelConvertedEvent(newEvent(other.Id)))
+		stored, err := s.Channel(other.Id)
+		require.NoError(t, err)
+		require.Equal(t, model.ChannelTypeOpen, stored.Type)
+	})
+}
+
+func TestHandleChannelSchemeUpdatedEvent(t *testing.T) {
+	schemeId := model.NewId()
+	channel := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeOpen, Name: "channel", SchemeId: &schemeId}
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/api/v4/channels/"+channel.Id {
+			json.NewEncoder(w).Encode(channel)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetChannel(&model.Channel{Id: channel.Id, Type: model.ChannelTypeOpen, Name: "channel", DisplayName: "Channel"}))
+	ue := New(Setup{Store: s}, Config{ServerURL: ts.URL})
+	require.NotNil(t, ue)
+
+	newEvent := func(channelId string) *model.WebSocketEvent {
+		return model.NewWebSocketEvent(model.WebsocketEventChannelSchemeUpdated, "", channelId, "", nil)
+	}
+
+	t.Run("missing channel id", func(t *testing.T) {
+		require.EqualError(t, ue.handleChannelSchemeUpdatedEvent(newEvent("")), "channel id is missing from the broadcast")
+	})
+
+	t.Run("channel not in store", func(t *testing.T) {
+		require.NoError(t, ue.handleChannelSchemeUpdatedEvent(newEvent(model.NewId())))
+		require.Zero(t, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("updated", func(t *testing.T) {
+		require.NoError(t, ue.handleChannelSchemeUpdatedEvent(newEvent(channel.Id)))
+		stored, err := s.Channel(channel.Id)
+		require.NoError(t, err)
+		require.NotNil(t, stored.SchemeId)
+		require.Equal(t, schemeId, *stored.SchemeId)
+		// The rest of the stored channel is left untouched.
+		require.Equal(t, "Channel", stored.DisplayName)
+	})
+
+	t.Run("fetch failure", func(t *testing.T) {
+		other := &model.Channel{Id: model.NewId(), Type: model.ChannelTypeOpen}
+		require.NoError(t, s.SetChannel(other))
+		require.Error(t, ue.handleChannelSchemeUpdatedEvent(newEvent(other.Id)))
+		stored, err := s.Channel(other.Id)
+		require.NoError(t, err)
+		require.Nil(t, stored.SchemeId)
+	})
+}
+
+func TestHandlePostAcknowledgementEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	post := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+	require.NoError(t, s.SetPost(post))
+	ue := &UserEntity{store: s}
+
+	newEvent := func(t *testing.T, eventType string, ack *store.PostAcknowledgement) *model.WebSocketEvent {
+		data, err := json.Marshal(ack)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(eventType, "", post.ChannelId, "", nil)
+		ev.Add("acknowledgement", string(data))
+		return ev
+	}
+
+	t.Run("missing data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(wsEventPostAcknowledgementAdded, "", post.ChannelId, "", nil)
+		require.EqualError(t, ue.handlePostAcknowledgementEvent(ev), "acknowledgement data is missing")
+		require.Error(t, ue.handlePostAcknowledgementEvent(newEvent(t, wsEventPostAcknowledgementAdded, &store.PostAcknowledgement{PostId: post.Id})))
+	})
+
+	t.Run("post not in store", func(t *testing.T) {
+		ack := &store.PostAcknowledgement{PostId: model.NewId(), UserId: model.NewId()}
+		require.NoError(t, ue.handlePostAcknowledgementEvent(newEvent(t, wsEventPostAcknowledgementAdded, ack)))
+		acks, err := s.PostAcknowledgements(ack.PostId)
+		require.NoError(t, err)
+		require.Empty(t, acks)
+	})
+
+	ack := &store.PostAcknowledgement{PostId: post.Id, UserId: model.NewId(), AcknowledgedAt: model.GetMillis()}
+
+	t.Run("added", func(t *testing.T) {
+		require.NoError(t, ue.handlePostAcknowledgementEvent(newEvent(t, wsEventPostAcknowledgementAdded, ack)))
+		acks, err := s.PostAcknowledgements(post.Id)
+		require.NoError(t, err)
+		require.Equal(t, []store.PostAcknowledgement{*ack}, acks)
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		removed := &store.PostAcknowledgement{PostId: post.Id, UserId: ack.UserId}
+		require.NoError(t, ue.handlePostAcknowledgementEvent(newEvent(t, wsEventPostAcknowledgementRemoved, removed)))
+		acks, err := s.PostAcknowledgements(post.Id)
+		require.NoError(t, err)
+		require.Empty(t, acks)
+	})
+}
+
+func TestListenHandlerPanic(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		send := func(ev *model.WebSocketEvent) {
+			data, _ := ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil)
+		hello.Add("connection_id", "conn")
+		send(hello)
+		// A new connection id makes the entity call the missed events
+		// handler, which panics.
+		crafted := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(1)
+		crafted.Add("connection_id", "other")
+		send(crafted)
+		send(model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(1))
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{
+		Store: s,
+		MissedEventsHandler: func(oldSeq, newSeq int64) {
+			panic("bad event")
+		},
+	}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	defer ue.Disconnect()
+
+	var received []string
+	for len(received) < 3 {
+		select {
+		case ev := <-ue.Events():
+			received = append(received, ev.EventType())
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for events")
+		}
+	}
+	// The listener kept going after the panic.
+	require.Equal(t, []string{model.WebsocketEventHello, model.WebsocketEventHello, model.WebsocketEventConfigChanged}, received)
+
+	select {
+	case err := <-errChan:
+		require.EqualError(t, err, `userentity: error in wsEventHandler: panic handling "hello" event with sequence 1: bad event`)
+		var wsErr *WSError
+		require.ErrorAs(t, err, &wsErr)
+		require.Equal(t, WSErrorHandler, wsErr.Kind)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the error")
+	}
+}
+
+func TestWSError(t *testing.T) {
+	cause := errors.New("connection refused")
+	var err error = &WSError{Kind: WSErrorConnect, Err: cause}
+	require.EqualError(t, err, "userentity: websocketClient creation error: connection refused")
+	require.ErrorIs(t, err, cause)
+
+	var wsErr *WSError
+	require.ErrorAs(t, fmt.Errorf("wrapped: %w", err), &wsErr)
+	require.Equal(t, WSErrorConnect, wsErr.Kind)
+
+	err = &WSError{Kind: WSErrorSeqMismatch, Err: errSeqMismatch}
+	require.ErrorIs(t, err, errSeqMismatch)
+	require.False(t, errors.Is(err, cause))
+}
+
+func TestChannelFilter(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+	accepted := &model.Channel{Id: model.NewId()}
+	rejected := &model.Channel{Id: model.NewId()}
+	for _, channel := range []*model.Channel{accepted, rejected} {
+		require.NoError(t, s.SetChannel(channel))
+	}
+	ue := New(Setup{
+		Store: s,
+		ChannelFilter: func(channelId string) bool {
+			return channelId != rejected.Id
+		},
+	}, Config{})
+	require.NotNil(t, ue)
+
+	var seq int64
+	newEvent := func(eventType, channelId string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(eventType, "", channelId, "", nil).SetSequence(seq)
+		seq++
+		return ev
+	}
+
+	// Events for rejected channels are skipped before being decoded, so
+	// malformed ones don't fail.
+	require.NoError(t, eventErr(ue.wsEventHandler(newEvent(model.WebsocketEventPosted, rejected.Id))))
+	require.EqualError(t, eventErr(ue.wsEventHandler(newEvent(model.WebsocketEventPosted, accepted.Id))), "post data is missing")
+
+	// The channel id can also come from the event data.
+	ev := newEvent(model.WebsocketEventTyping, "")
+	ev.Add("channel_id", rejected.Id)
+	require.NoError(t, eventErr(ue.wsEventHandler(ev)))
+	typing, err := s.GetTypingUsers(rejected.Id)
+	require.NoError(t, err)
+	require.Empty(t, typing)
+
+	// The sequence keeps being tracked for skipped events.
+	require.Equal(t, seq, ue.wsServerSeq)
+
+	t.Run("delivery", func(t *testing.T) {
+		userId := ue.store.Id()
+		for _, channel := range []*model.Channel{accepted, rejected} {
+			require.NoError(t, s.SetChannelMember(channel.Id, &model.ChannelMember{ChannelId: channel.Id, UserId: userId}))
+		}
+		require.True(t, ue.expectsPosts(accepted.Id))
+		require.False(t, ue.expectsPosts(rejected.Id))
+	})
+}
+
+func BenchmarkChannelFilter(b *testing.B) {
+	channel := &model.Channel{Id: model.NewId()}
+	data, err := json.Marshal(&model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: strings.Repeat("message ", 100)})
+	require.NoError(b, err)
+	ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", channel.Id, "", nil)
+	ev.Add("post", string(data))
+
+	for _, tc := range []struct {
+		name   string
+		filter func(channelId string) bool
+	}{
+		{"no filter", nil},
+		{"channel filtered out", func(channelId string) bool { return false }},
+	} {
+		filter := tc.filter
+		b.Run(tc.name, func(b *testing.B) {
+			s, err := memstore.New(nil)
+			require.NoError(b, err)
+			require.NoError(b, s.SetChannel(channel))
+			ue := &UserEntity{store: s, channelFilter: filter}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// The same event is handled over and over.
+				ue.wsServerSeq = 0
+				if _, err := ue.wsEventHandler(ev); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestResumeWebSocketState(t *testing.T) {
+	newServer := func(t *testing.T, connID string, query chan<- string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query <- r.URL.RawQuery
+			upgrader := &gorillaws.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			// The stream is resumed if the connection id is known, and
+			// started over otherwise.
+			var seq int64
+			if r.URL.Query().Get("connection_id") == connID {
+				seq, _ = strconv.ParseInt(r.URL.Query().Get("sequence_number"), 10, 64)
+			}
+			hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(seq)
+			hello.Add("connection_id", connID)
+			data, _ := hello.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+			ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(seq + 1)
+			data, _ = ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}))
+	}
+
+	run := func(t *testing.T, ts *httptest.Server, seeded WebSocketState) (WebSocketState, [][2]int64) {
+		saved := make(chan WebSocketState, 10)
+		var missed [][2]int64
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		ue := New(Setup{
+			Store: s,
+			LoadWebSocketState: func() (WebSocketState, bool) {
+				return seeded, true
+			},
+			SaveWebSocketState: func(state WebSocketState) {
+				saved <- state
+			},
+			MissedEventsHandler: func(oldSeq, newSeq int64) {
+				missed = append(missed, [2]int64{oldSeq, newSeq})
+			},
+		}, Config{
+			WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+		})
+		require.NotNil(t, ue)
+		ue.client.AuthToken = "token"
+
+		errChan, err := ue.Connect()
+		require.NoError(t, err)
+		go func() {
+			for range errChan {
+			}
+		}()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-ue.Events():
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for events")
+			}
+		}
+		require.NoError(t, ue.Disconnect())
+		close(saved)
+
+		var last WebSocketState
+		for state := range saved {
+			last = state
+		}
+		return last, missed
+	}
+
+	t.Run("resumed", func(t *testing.T) {
+		query := make(chan string, 1)
+		ts := newServer(t, "conn", query)
+		defer ts.Close()
+
+		state, missed := run(t, ts, WebSocketState{ConnID: "conn", ServerSeq: 5})
+		require.Contains(t, <-query, "connection_id=conn&sequence_number=5")
+		require.Equal(t, WebSocketState{ConnID: "conn", ServerSeq: 7}, state)
+		require.Empty(t, missed)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		query := make(chan string, 1)
+		ts := newServer(t, "new", query)
+		defer ts.Close()
+
+		state, missed := run(t, ts, WebSocketState{ConnID: "stale", ServerSeq: 5})
+		require.Contains(t, <-query, "connection_id=stale&sequence_number=5")
+		require.Equal(t, WebSocketState{ConnID: "new", ServerSeq: 2}, state)
+		require.Equal(t, [][2]int64{{5, 0}}, missed)
+	})
+}
+
+func TestHandleThreadEvents(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	root := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+	require.NoError(t, s.SetPost(root))
+	ue := &UserEntity{store: s}
+
+	newUpdatedEvent := func(t *testing.T, thread *model.ThreadResponse) *model.WebSocketEvent {
+		data, err := json.Marshal(thread)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventThreadUpdated, "", "", model.NewId(), nil)
+		ev.Add("thread", string(data))
+		return ev
+	}
+
+	newReadChangedEvent := func(threadId string, timestamp, unreadReplies, unreadMentions int64) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventThreadReadChanged, "", "", model.NewId(), nil)
+		ev.Add("thread_id", threadId)
+		ev.Add("timestamp", timestamp)
+		ev.Add("unread_replies", unreadReplies)
+		ev.Add("unread_mentions", unreadMentions)
+		return ev
+	}
+
+	t.Run("missing data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventThreadUpdated, "", "", model.NewId(), nil)
+		require.EqualError(t, ue.handleThreadUpdatedEvent(ev), "thread data is missing")
+		require.EqualError(t, ue.handleThreadUpdatedEvent(newUpdatedEvent(t, &model.ThreadResponse{})), "thread id data is missing")
+
+		ev = model.NewWebSocketEvent(model.WebsocketEventThreadReadChanged, "", "", model.NewId(), nil)
+		ev.Add("thread_id", root.Id)
+		require.EqualError(t, ue.handleThreadReadChangedEvent(ev), "timestamp data is missing")
+	})
+
+	t.Run("root not in store", func(t *testing.T) {
+		rootId := model.NewId()
+		require.NoError(t, ue.handleThreadUpdatedEvent(newUpdatedEvent(t, &model.ThreadResponse{PostId: rootId, UnreadReplies: 1})))
+		_, err := s.GetThreadState(rootId)
+		require.Equal(t, memstore.ErrThreadNotFound, err)
+	})
+
+	t.Run("reply then read", func(t *testing.T) {
+		thread := &model.ThreadResponse{PostId: root.Id, LastViewedAt: 100, UnreadReplies: 1, UnreadMentions: 1}
+		require.NoError(t, ue.handleThreadUpdatedEvent(newUpdatedEvent(t, thread)))
+		state, err := s.GetThreadState(root.Id)
+		require.NoError(t, err)
+		require.Equal(t, store.ThreadState{LastViewedAt: 100, UnreadReplies: 1, UnreadMentions: 1}, state)
+		unread, err := s.GetUnreadThreads()
+		require.NoError(t, err)
+		require.Equal(t, []string{root.Id}, unread)
+
+		require.NoError(t, ue.handleThreadReadChangedEvent(newReadChangedEvent(root.Id, 200, 0, 0)))
+		state, err = s.GetThreadState(root.Id)
+		require.NoError(t, err)
+		require.Equal(t, store.ThreadState{LastViewedAt: 200}, state)
+		unread, err = s.GetUnreadThreads()
+		require.NoError(t, err)
+		require.Empty(t, unread)
+	})
+
+	t.Run("team wide read", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventThreadReadChanged, "", "", model.NewId(), nil)
+		ev.Add("timestamp", int64(300))
+		require.EqualError(t, ue.handleThreadReadChangedEvent(ev), "team id is missing from the broadcast")
+
+		teamId := model.NewId()
+		channel := &model.Channel{Id: model.NewId(), TeamId: teamId}
+		require.NoError(t, s.SetChannel(channel))
+		// A followed thread, a thread only tracked through its read state
+		// and a thread from a channel which isn't loaded.
+		followed := &model.Post{Id: model.NewId(), ChannelId: channel.Id}
+		tracked := &model.Post{Id: model.NewId(), ChannelId: channel.Id}
+		unloaded := &model.Post{Id: model.NewId(), ChannelId: model.NewId()}
+		require.NoError(t, s.SetPosts([]*model.Post{followed, tracked, unloaded}))
+		require.NoError(t, s.SetThreads([]*model.ThreadResponse{{PostId: followed.Id, Post: followed, UnreadReplies: 3, UnreadMentions: 1}}))
+		for _, post := range []*model.Post{followed, tracked, unloaded} {
+			require.NoError(t, s.SetThreadState(post.Id, store.ThreadState{LastViewedAt: 100, UnreadReplies: 3, UnreadMentions: 1}))
+		}
+
+		ev = model.NewWebSocketEvent(model.WebsocketEventThreadReadChanged, teamId, "", model.NewId(), nil)
+		ev.Add("timestamp", int64(300))
+		require.NoError(t, ue.handleThreadReadChangedEvent(ev))
+
+		for _, post := range []*model.Post{followed, tracked} {
+			state, err := s.GetThreadState(post.Id)
+			require.NoError(t, err)
+			require.Zero(t, state.UnreadReplies)
+			require.Zero(t, state.UnreadMentions)
+		}
+		thread, err := s.Thread(followed.Id)
+		require.NoError(t, err)
+		require.Zero(t, thread.UnreadReplies)
+		unread, err := s.GetUnreadThreads()
+		require.NoError(t, err)
+		require.Equal(t, []string{unloaded.Id}, unread)
+	})
+}
+
+func TestHandleEmojiAddedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	newEvent := func(data string) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventEmojiAdded, "", "", "", nil)
+		ev.Add("emoji", data)
+		return ev
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventEmojiAdded, "", "", "", nil)
+		require.EqualError(t, ue.handleEmojiAddedEvent(ev), "emoji data is missing")
+		ev.Add("emoji", 42)
+		require.EqualError(t, ue.handleEmojiAddedEvent(ev), "type of the emoji data should be a string, but it is int")
+		require.Error(t, ue.handleEmojiAddedEvent(newEvent("invalid")))
+		require.EqualError(t, ue.handleEmojiAddedEvent(newEvent(`{"name":"emoji"}`)), "emoji id or name data is missing")
+
+		emojis, err := s.Emojis()
+		require.NoError(t, err)
+		require.Empty(t, emojis)
+	})
+
+	t.Run("added", func(t *testing.T) {
+		emoji := &model.Emoji{Id: model.NewId(), CreatorId: model.NewId(), Name: "custom"}
+		data, err := json.Marshal(emoji)
+		require.NoError(t, err)
+		require.NoError(t, ue.handleEmojiAddedEvent(newEvent(string(data))))
+
+		emojis, err := s.Emojis()
+		require.NoError(t, err)
+		require.Equal(t, []model.Emoji{*emoji}, emojis)
+		random, err := s.RandomEmoji()
+		require.NoError(t, err)
+		require.Equal(t, *emoji, random)
+	})
+}
+
+func TestTypingQueueTime(t *testing.T) {
+	actions := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(0)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req model.WebSocketRequest
+			if json.Unmarshal(data, &req) == nil {
+				actions <- req.Action
+			}
+		}
+	}))
+	defer ts.Close()
+
+	m := performance.NewMetrics()
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+		Persona:      "test",
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+	defer ue.Disconnect()
+
+	require.NoError(t, ue.SendTypingEvent(model.NewId(), ""))
+	select {
+	case action := <-actions:
+		require.Equal(t, "user_typing", action)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for the typing event")
+	}
+
+	var metric dto.Metric
+	obs := m.UserEntityMetrics().WebSocketTypingQueueTimes.WithLabelValues("test")
+	require.NoError(t, obs.(prometheus.Histogram).Write(&metric))
+	require.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+	require.Greater(t, metric.GetHistogram().GetSampleSum(), float64(0))
+}
+
+func TestPostDeliveryTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v4/posts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var post model.Post
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		post.Id = model.NewId()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&post)
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+	m := performance.NewMetrics()
+	ue := New(Setup{Store: s, Metrics: m.UserEntityMetrics()}, Config{
+		ServerURL:    ts.URL,
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+		Persona:      "test",
+	})
+	require.NotNil(t, ue)
+
+	samples := func(t *testing.T) uint64 {
+		var metric dto.Metric
+		obs := m.UserEntityMetrics().PostDeliveryTimes.WithLabelValues("test")
+		require.NoError(t, obs.(prometheus.Histogram).Write(&metric))
+		return metric.GetHistogram().GetSampleCount()
+	}
+	newEvent := func(post *model.Post) *model.WebSocketEvent {
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", post.ChannelId, "", nil)
+		ev.Add("post", string(data))
+		return ev
+	}
+
+	channelId := model.NewId()
+	postId, err := ue.CreatePost(&model.Post{ChannelId: channelId, Message: "message"})
+	require.NoError(t, err)
+	post, err := s.Post(postId)
+	require.NoError(t, err)
+
+	// The post is delivered back to its author.
+	require.NoError(t, ue.handlePostEvent(newEvent(post)))
+	require.Equal(t, uint64(1), samples(t))
+
+	// A duplicate delivery isn't measured again.
+	require.NoError(t, ue.handlePostEvent(newEvent(post)))
+	require.Equal(t, uint64(1), samples(t))
+
+	// Posts created by other users are ignored.
+	other := &model.Post{Id: model.NewId(), ChannelId: channelId, PendingPostId: model.NewId(), UserId: model.NewId()}
+	require.NoError(t, ue.handlePostEvent(newEvent(other)))
+	require.Equal(t, uint64(1), samples(t))
+}
+
+func TestPendingPosts(t *testing.T) {
+	pp := newPendingPosts()
+	now := time.Now()
+	for i := 0; i < maxPendingPosts; i++ {
+		require.True(t, pp.add(strconv.Itoa(i), now))
+	}
+	// No more posts are tracked once full.
+	require.False(t, pp.add("full", now))
+
+	// Stale posts make room for new ones.
+	require.True(t, pp.add("new", now.Add(2*pendingPostMaxAge)))
+	sentAt, ok := pp.remove("new")
+	require.True(t, ok)
+	require.Equal(t, now.Add(2*pendingPostMaxAge), sentAt)
+	_, ok = pp.remove("0")
+	require.False(t, ok)
+}
+
+func TestPause(t *testing.T) {
+	newServer := func(t *testing.T) (*httptest.Server, chan int, *int32) {
+		send := make(chan int)
+		var conns int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgrader := &gorillaws.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			atomic.AddInt32(&conns, 1)
+
+			hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(0)
+			hello.Add("connection_id", "conn")
+			data, _ := hello.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+			seq := int64(1)
+			for n := range send {
+				for i := 0; i < n; i++ {
+					ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(seq)
+					data, _ := ev.ToJSON()
+					conn.WriteMessage(gorillaws.TextMessage, data)
+					seq++
+				}
+			}
+		}))
+		return ts, send, &conns
+	}
+
+	connect := func(t *testing.T, ts *httptest.Server, config Config) *UserEntity {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		config.WebSocketURL = strings.Replace(ts.URL, "http://", "ws://", 1)
+		ue := New(Setup{Store: s}, config)
+		require.NotNil(t, ue)
+		ue.client.AuthToken = "token"
+
+		errChan, err := ue.Connect()
+		require.NoError(t, err)
+		go func() {
+			for range errChan {
+			}
+		}()
+		select {
+		case ev := <-ue.Events():
+			require.Equal(t, model.WebsocketEventHello, ev.EventType())
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the hello event")
+		}
+		return ue
+	}
+
+	receive := func(t *testing.T, ue *UserEntity) *model.WebSocketEvent {
+		select {
+		case ev := <-ue.Events():
+			return ev
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for an event")
+		}
+		return nil
+	}
+
+	t.Run("held back", func(t *testing.T) {
+		ts, send, conns := newServer(t)
+		defer ts.Close()
+		defer close(send)
+		ue := connect(t, ts, Config{EventsBufferSize: 10})
+		defer ue.Disconnect()
+
+		ue.Pause()
+		require.True(t, ue.Paused())
+		send <- 3
+		require.Eventually(t, func() bool {
+			return ue.EventsReceived() == 4
+		}, 5*time.Second, 10*time.Millisecond)
+		select {
+		case ev := <-ue.Events():
+			require.FailNow(t, "unexpected event while paused", ev.EventType())
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		ue.Resume()
+		require.False(t, ue.Paused())
+		for seq := int64(1); seq <= 3; seq++ {
+			require.Equal(t, seq, receive(t, ue).GetSequence())
+		}
+
+		// The sequence kept being tracked while paused.
+		send <- 1
+		require.Equal(t, int64(4), receive(t, ue).GetSequence())
+		require.Equal(t, int32(1), atomic.LoadInt32(conns))
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		ts, send, conns := newServer(t)
+		defer ts.Close()
+		defer close(send)
+		ue := connect(t, ts, Config{EventsBufferSize: 10, DropEventsWhenFull: true})
+		defer ue.Disconnect()
+
+		ue.Pause()
+		send <- 3
+		require.Eventually(t, func() bool {
+			return ue.EventsReceived() == 4
+		}, 5*time.Second, 10*time.Millisecond)
+		ue.Resume()
+		require.Equal(t, map[string]uint64{model.WebsocketEventConfigChanged: 3}, ue.DroppedEvents())
+
+		send <- 1
+		require.Equal(t, int64(4), receive(t, ue).GetSequence())
+		require.Equal(t, int32(1), atomic.LoadInt32(conns))
+	})
+
+	t.Run("held back past the limit", func(t *testing.T) {
+		ts, send, conns := newServer(t)
+		defer ts.Close()
+		defer close(send)
+		ue := connect(t, ts, Config{EventsBufferSize: 10, MaxHeldEvents: 2})
+		defer ue.Disconnect()
+
+		ue.Pause()
+		send <- 3
+		require.Eventually(t, func() bool {
+			return ue.EventsReceived() == 4
+		}, 5*time.Second, 10*time.Millisecond)
+		ue.Resume()
+		for seq := int64(1); seq <= 2; seq++ {
+			require.Equal(t, seq, receive(t, ue).GetSequence())
+		}
+		require.Equal(t, map[string]uint64{model.WebsocketEventConfigChanged: 1}, ue.DroppedEvents())
+
+		send <- 1
+		require.Equal(t, int64(4), receive(t, ue).GetSequence())
+		require.Equal(t, int32(1), atomic.LoadInt32(conns))
+	})
+}
+
+func TestHandleRoleUpdatedEvents(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	user := &model.User{Id: model.NewId(), Roles: model.SystemUserRoleId}
+	require.NoError(t, s.SetUser(user))
+	ue := &UserEntity{store: s}
+
+	t.Run("promoted to system admin", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserRoleUpdated, "", "", user.Id, nil)
+		ev.Add("user_id", user.Id)
+		ev.Add("roles", model.SystemUserRoleId+" "+model.SystemAdminRoleId)
+		require.NoError(t, ue.handleUserRoleUpdatedEvent(ev))
+
+		isAdmin, err := s.IsSysAdmin()
+		require.NoError(t, err)
+		require.True(t, isAdmin)
+		isAdmin, err = ue.IsSysAdmin()
+		require.NoError(t, err)
+		require.True(t, isAdmin)
+	})
+
+	t.Run("malformed user role data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserRoleUpdated, "", "", user.Id, nil)
+		require.EqualError(t, ue.handleUserRoleUpdatedEvent(ev), "user_id data is missing")
+		ev.Add("user_id", user.Id)
+		require.EqualError(t, ue.handleUserRoleUpdatedEvent(ev), "roles data is missing")
+		ev.Add("roles", 42)
+		require.EqualError(t, ue.handleUserRoleUpdatedEvent(ev), "type of the roles data should be a string, but it is int")
+	})
+
+	t.Run("role permissions", func(t *testing.T) {
+		role := &model.Role{Id: model.NewId(), Name: model.SystemUserRoleId}
+		require.NoError(t, s.SetRoles([]*model.Role{role}))
+
+		updated := &model.Role{Id: role.Id, Name: role.Name, Permissions: []string{model.PermissionCreateTeam.Id}}
+		data, err := json.Marshal(updated)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventRoleUpdated, "", "", "", nil)
+		ev.Add("role", string(data))
+		require.NoError(t, ue.handleRoleUpdatedEvent(ev))
+
+		roles, err := s.Roles()
+		require.NoError(t, err)
+		require.Len(t, roles, 1)
+		require.Equal(t, updated.Permissions, roles[0].Permissions)
+	})
+
+	t.Run("malformed role data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventRoleUpdated, "", "", "", nil)
+		require.EqualError(t, ue.handleRoleUpdatedEvent(ev), "role data is missing")
+		ev.Add("role", "invalid")
+		require.Error(t, ue.handleRoleUpdatedEvent(ev))
+		ev.Add("role", "{}")
+		require.EqualError(t, ue.handleRoleUpdatedEvent(ev), "role id data is missing")
+	})
+}
+
+func TestServerSequence(t *testing.T) {
+	const numEvents = 10
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(0)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for i := int64(1); i < numEvents; i++ {
+			ev := model.NewWebSocketEvent(model.WebsocketEventConfigChanged, "", "", "", nil).SetSequence(i)
+			data, _ := ev.ToJSON()
+			conn.WriteMessage(gorillaws.TextMessage, data)
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+	require.Zero(t, ue.ServerSequence())
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+	defer ue.Disconnect()
+
+	// The sequence is read while the listener keeps updating it.
+	var last int64
+	require.Eventually(t, func() bool {
+		seq := ue.ServerSequence()
+		require.GreaterOrEqual(t, seq, last)
+		last = seq
+		return seq == numEvents
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestPostWriteBatching(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	channel := &model.Channel{Id: model.NewId()}
+	require.NoError(t, s.SetChannel(channel))
+	require.NoError(t, s.SetCurrentChannel(channel))
+	ue := &UserEntity{store: s, postWrites: newPostWriteBatcher(3, time.Hour)}
+
+	var seq int64
+	handle := func(t *testing.T, eventType string, post *model.Post) {
+		t.Helper()
+		ev := model.NewWebSocketEvent(eventType, "", channel.Id, "", nil).SetSequence(seq)
+		seq++
+		if post != nil {
+			data, err := json.Marshal(post)
+			require.NoError(t, err)
+			ev.Add("post", string(data))
+		}
+		require.NoError(t, ue.handleEvent(ev))
+	}
+	stored := func(postId string) bool {
+		_, err := s.Post(postId)
+		if errors.Is(err, memstore.ErrPostNotFound) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+
+	posts := make([]*model.Post, 3)
+	for i := range posts {
+		posts[i] = &model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: "message"}
+	}
+
+	t.Run("flushed when full", func(t *testing.T) {
+		handle(t, model.WebsocketEventPosted, posts[0])
+		handle(t, model.WebsocketEventPosted, posts[1])
+		// A duplicate delivery is recognized before being flushed.
+		handle(t, model.WebsocketEventPosted, posts[0])
+		require.False(t, stored(posts[0].Id))
+		require.False(t, stored(posts[1].Id))
+
+		handle(t, model.WebsocketEventPostDeleted, posts[0])
+		require.False(t, stored(posts[0].Id))
+		require.True(t, stored(posts[1].Id))
+	})
+
+	t.Run("flushed before other events", func(t *testing.T) {
+		handle(t, model.WebsocketEventPosted, posts[2])
+		require.False(t, stored(posts[2].Id))
+		handle(t, model.WebsocketEventConfigChanged, nil)
+		require.True(t, stored(posts[2].Id))
+	})
+
+	t.Run("replies", func(t *testing.T) {
+		root := &model.Post{Id: model.NewId(), ChannelId: channel.Id}
+		reply := &model.Post{Id: model.NewId(), ChannelId: channel.Id, RootId: root.Id, UserId: model.NewId()}
+		handle(t, model.WebsocketEventPosted, root)
+		handle(t, model.WebsocketEventPosted, reply)
+		handle(t, model.WebsocketEventPosted, reply)
+		require.NoError(t, ue.flushPostWrites())
+
+		p, err := s.Post(root.Id)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), p.ReplyCount)
+	})
+
+	t.Run("flush interval", func(t *testing.T) {
+		b := newPostWriteBatcher(3, 10*time.Millisecond)
+		require.Nil(t, b.C())
+		b.add(store.PostWrite{Post: posts[0]})
+		select {
+		case <-b.C():
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for the flush")
+		}
+		require.Len(t, b.take(), 1)
+		require.Nil(t, b.C())
+	})
+}
+
+func TestHandleUserUpdatedEvent(t *testing.T) {
+	newEvent := func(user *model.User) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserUpdated, "", "", "", nil)
+		ev.Add("user", user)
+		return ev
+	}
+
+	setup := func(t *testing.T, cacheUpdatedUsers bool) (*UserEntity, *memstore.MemStore, model.User) {
+		s, err := memstore.New(nil)
+		require.NoError(t, err)
+		require.NoError(t, s.SetUser(&model.User{Id: model.NewId()}))
+		user := model.User{Id: model.NewId(), Username: "username", Nickname: "nickname", Email: "user@example.com"}
+		require.NoError(t, s.SetUsers([]*model.User{&user}))
+		return &UserEntity{store: s, config: Config{CacheUpdatedUsers: cacheUpdatedUsers}}, s, user
+	}
+
+	t.Run("missing data", func(t *testing.T) {
+		ue, _, _ := setup(t, false)
+		ev := model.NewWebSocketEvent(model.WebsocketEventUserUpdated, "", "", "", nil)
+		require.EqualError(t, ue.handleUserUpdatedEvent(ev), "user data is missing")
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		ue, s, user := setup(t, false)
+		// The server sanitizes the profiles sent to other users.
+		updated := &model.User{Id: user.Id, Username: "renamed", Nickname: "renamed nickname"}
+		require.NoError(t, ue.handleUserUpdatedEvent(newEvent(updated)))
+
+		stored, err := s.GetUser(user.Id)
+		require.NoError(t, err)
+		require.Equal(t, "renamed", stored.Username)
+		require.Equal(t, "renamed nickname", stored.Nickname)
+		require.Equal(t, user.Email, stored.Email)
+	})
+
+	t.Run("deactivation", func(t *testing.T) {
+		ue, s, user := setup(t, false)
+		deleteAt := model.GetMillis()
+		updated := &model.User{Id: user.Id, Username: user.Username, Nickname: user.Nickname, DeleteAt: deleteAt}
+		require.NoError(t, ue.handleUserUpdatedEvent(newEvent(updated)))
+
+		stored, err := s.GetUser(user.Id)
+		require.NoError(t, err)
+		require.Equal(t, deleteAt, stored.DeleteAt)
+		require.Equal(t, user.Username, stored.Username)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		ue, s, _ := setup(t, false)
+		unknown := &model.User{Id: model.NewId(), Username: "unknown"}
+		require.NoError(t, ue.handleUserUpdatedEvent(newEvent(unknown)))
+		stored, err := s.GetUser(unknown.Id)
+		require.NoError(t, err)
+		require.Empty(t, stored.Id)
+
+		ue, s, _ = setup(t, true)
+		require.NoError(t, ue.handleUserUpdatedEvent(newEvent(unknown)))
+		stored, err = s.GetUser(unknown.Id)
+		require.NoError(t, err)
+		require.Equal(t, "unknown", stored.Username)
+	})
+
+	t.Run("own user", func(t *testing.T) {
+		ue, s, _ := setup(t, true)
+		self := &model.User{Id: s.Id(), Username: "self"}
+		require.NoError(t, ue.handleUserUpdatedEvent(newEvent(self)))
+		stored, err := s.GetUser(self.Id)
+		require.NoError(t, err)
+		require.Empty(t, stored.Id)
+	})
+}
+
+func TestHandlePostEventPostRate(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	// Posts are counted for channels not loaded in the store as well.
+	channelId := model.NewId()
+	var events []*model.WebSocketEvent
+	for i := 0; i < 3; i++ {
+		data, err := json.Marshal(&model.Post{Id: model.NewId(), ChannelId: channelId})
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", channelId, "", nil)
+		ev.Add("post", string(data))
+		require.NoError(t, ue.handlePostEvent(ev))
+		events = append(events, ev)
+	}
+
+	// The posts delivered again after reconnecting aren't counted twice.
+	for _, ev := range events {
+		ue.eventApplied = false
+		require.NoError(t, ue.handlePostEvent(ev))
+		require.False(t, ue.eventApplied)
+	}
+
+	rate, err := s.GetChannelPostRate(channelId)
+	require.NoError(t, err)
+	require.Equal(t, float64(3), rate)
+}
+
+func TestHandlePostEventFileIds(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	newEvent := func(t *testing.T, eventType string, post *model.Post) *model.WebSocketEvent {
+		data, err := json.Marshal(post)
+		require.NoError(t, err)
+		ev := model.NewWebSocketEvent(eventType, "", post.ChannelId, "", nil)
+		ev.Add("post", string(data))
+		return ev
+	}
+
+	// File ids are kept for posts in channels not loaded in the store.
+	post := &model.Post{Id: model.NewId(), ChannelId: model.NewId(), FileIds: model.StringArray{model.NewId(), model.NewId()}}
+	require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPosted, post)))
+	fileIds, err := s.GetPostFileIds(post.Id)
+	require.NoError(t, err)
+	require.Equal(t, []string(post.FileIds), fileIds)
+
+	t.Run("no files", func(t *testing.T) {
+		other := &model.Post{Id: model.NewId(), ChannelId: post.ChannelId}
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPosted, other)))
+		fileIds, err := s.GetPostFileIds(other.Id)
+		require.NoError(t, err)
+		require.Empty(t, fileIds)
+	})
+
+	t.Run("deleted post", func(t *testing.T) {
+		require.NotEmpty(t, post.FileIds)
+		post.DeleteAt = model.GetMillis()
+		ue.eventApplied = false
+		require.NoError(t, ue.handlePostEvent(newEvent(t, model.WebsocketEventPostDeleted, post)))
+		// Removing the file ids counts as applying the event.
+		require.True(t, ue.eventApplied)
+		fileIds, err := s.GetPostFileIds(post.Id)
+		require.NoError(t, err)
+		require.Empty(t, fileIds)
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	actions := make(chan string, 10)
+	serverDone := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(serverDone)
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(0)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Action string `json:"action"`
+			}
+			if json.Unmarshal(data, &msg) == nil {
+				actions <- msg.Action
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := New(Setup{Store: s}, Config{
+		WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+		DryRun:       true,
+	})
+	require.NotNil(t, ue)
+	ue.client.AuthToken = "token"
+
+	errChan, err := ue.Connect()
+	require.NoError(t, err)
+	go func() {
+		for range errChan {
+		}
+	}()
+	go func(events <-chan *model.WebSocketEvent) {
+		for range events {
+		}
+	}(ue.Events())
+
+	// Received events are still handled.
+	require.Eventually(t, func() bool {
+		return ue.ConnectionID() == "conn"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, ue.SendTypingEvent(model.NewId(), ""))
+	}
+	require.NoError(t, ue.SendWebSocketAction(func(client *websocket.Client) error {
+		return client.SendMessage("custom_action", nil)
+	}))
+	require.NoError(t, ue.Disconnect())
+
+	select {
+	case <-serverDone:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for the connection to close")
+	}
+	require.Empty(t, actions)
+	require.Equal(t, map[string]uint64{
+		DryRunUserTyping: 3,
+		DryRunAction:     1,
+	}, ue.DryRunSent())
+}
+
+func TestHandlePluginStatusesChangedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	// The event is decoded from JSON, as when received from the server.
+	newEvent := func(t *testing.T, statuses interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventPluginStatusesChanged, "", "", "", nil)
+		ev.Add("plugin_statuses", statuses)
+		data, err := ev.ToJSON()
+		require.NoError(t, err)
+		ev, err = model.WebSocketEventFromJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		return ev
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventPluginStatusesChanged, "", "", "", nil)
+		require.EqualError(t, ue.handlePluginStatusesChangedEvent(ev), "plugin statuses data is missing")
+		require.Error(t, ue.handlePluginStatusesChangedEvent(newEvent(t, "invalid")))
+		require.EqualError(t, ue.handlePluginStatusesChangedEvent(newEvent(t, []map[string]interface{}{{"state": 2}})), "plugin id data is missing")
+
+		statuses, err := s.PluginStatuses()
+		require.NoError(t, err)
+		require.Empty(t, statuses)
+	})
+
+	t.Run("status change", func(t *testing.T) {
+		statuses := model.PluginStatuses{
+			{PluginId: "com.mattermost.calls", State: model.PluginStateRunning},
+			{PluginId: "com.mattermost.playbooks", State: model.PluginStateRunning},
+		}
+		require.NoError(t, ue.handlePluginStatusesChangedEvent(newEvent(t, statuses)))
+		stored, err := s.PluginStatuses()
+		require.NoError(t, err)
+		require.Len(t, stored, 2)
+
+		// A plugin gets disabled and the other one removed.
+		changed := model.PluginStatuses{{PluginId: "com.mattermost.calls", State: model.PluginStateNotRunning}}
+		require.NoError(t, ue.handlePluginStatusesChangedEvent(newEvent(t, changed)))
+		stored, err = s.PluginStatuses()
+		require.NoError(t, err)
+		require.Equal(t, []model.PluginStatus{*changed[0]}, stored)
+	})
+}
+
+func TestHandleLicenseChangedEvent(t *testing.T) {
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	ue := &UserEntity{store: s}
+
+	// The event is decoded from JSON, as when received from the server.
+	newEvent := func(t *testing.T, license interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(model.WebsocketEventLicenseChanged, "", "", "", nil)
+		ev.Add("license", license)
+		data, err := ev.ToJSON()
+		require.NoError(t, err)
+		ev, err = model.WebSocketEventFromJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		return ev
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventLicenseChanged, "", "", "", nil)
+		require.EqualError(t, ue.handleLicenseChangedEvent(ev), "license data is missing")
+		require.EqualError(t, ue.handleLicenseChangedEvent(newEvent(t, "invalid")), "license data is invalid")
+		require.Error(t, ue.handleLicenseChangedEvent(newEvent(t, map[string]interface{}{"IsLicensed": true})))
+		require.Empty(t, s.ClientLicense())
+	})
+
+	t.Run("feature toggle", func(t *testing.T) {
+		require.False(t, s.IsFeatureLicensed("MessageExport"))
+
+		license := map[string]string{"IsLicensed": "true", "MessageExport": "true", "LDAP": "true"}
+		require.NoError(t, ue.handleLicenseChangedEvent(newEvent(t, license)))
+		require.True(t, s.IsFeatureLicensed("MessageExport"))
+		require.True(t, s.IsFeatureLicensed("LDAP"))
+
+		// The license is replaced as a whole, dropping the missing features.
+		license = map[string]string{"IsLicensed": "true", "MessageExport": "false"}
+		require.NoError(t, ue.handleLicenseChangedEvent(newEvent(t, license)))
+		require.False(t, s.IsFeatureLicensed("MessageExport"))
+		require.False(t, s.IsFeatureLicensed("LDAP"))
+		require.Equal(t, license, s.ClientLicense())
+
+		require.NoError(t, ue.handleLicenseChangedEvent(newEvent(t, map[string]string{"IsLicensed": "true", "MessageExport": "true"})))
+		require.True(t, s.IsFeatureLicensed("MessageExport"))
+
+		// Removing the license disables all the features.
+		require.NoError(t, ue.handleLicenseChangedEvent(newEvent(t, map[string]string{"IsLicensed": "false", "MessageExport": "true"})))
+		require.False(t, s.IsFeatureLicensed("MessageExport"))
+	})
+}
+
+func TestEventTraceHook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := &gorillaws.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hello := model.NewWebSocketEvent(model.WebsocketEventHello, "", "", "", nil).SetSequence(0)
+		hello.Add("connection_id", "conn")
+		data, _ := hello.ToJSON()
+		conn.WriteMessage(gorillaws.TextMessage, data)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	for name, traceEvents := range map[string]bool{"enabled": true, "disabled": false} {
+		t.Run(name, func(t *testing.T) {
+			traced := make(chan []byte, 10)
+			s, err := memstore.New(nil)
+			require.NoError(t, err)
+			ue := New(Setup{
+				Store: s,
+				EventTraceHook: func(raw []byte, ev *model.WebSocketEvent) {
+					traced <- raw
+				},
+			}, Config{
+				WebSocketURL: strings.Replace(ts.URL, "http://", "ws://", 1),
+				TraceEvents:  traceEvents,
+			})
+			require.NotNil(t, ue)
+			ue.client.AuthToken = "token"
+
+			errChan, err := ue.Connect()
+			require.NoError(t, err)
+			go func() {
+				for range errChan {
+				}
+			}()
+			events := ue.Events()
+			select {
+			case ev := <-events:
+				require.Equal(t, model.WebsocketEventHello, ev.EventType())
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for the event")
+			}
+			go func() {
+				for range events {
+				}
+			}()
+			require.NoError(t, ue.Disconnect())
+
+			if traceEvents {
+				require.Len(t, traced, 1)
+				require.Contains(t, string(<-traced), `"connection_id":"conn"`)
+			} else {
+				require.Empty(t, traced)
+			}
+		})
+	}
+}
+
+func TestHandleSidebarCategoryEvents(t *testing.T) {
+	teamId := model.NewId()
+	userId := model.NewId()
+	custom := &model.SidebarCategoryWithChannels{
+		SidebarCategory: model.SidebarCategory{Id: model.NewId(), UserId: userId, TeamId: teamId, DisplayName: "Custom"},
+		Channels:        []string{"a", "b"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v4/users/"+userId+"/teams/"+teamId+"/channels/categories/"+custom.Id {
+			json.NewEncoder(w).Encode(custom)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	s, err := memstore.New(nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetUser(&model.User{Id: userId}))
+	require.NoError(t, s.SetCategories(teamId, &model.OrderedSidebarCategories{
+		Categories: model.SidebarCategoriesWithChannels{
+			{SidebarCategory: model.SidebarCategory{Id: "channels", TeamId: teamId, DisplayName: "Channels"}},
+		},
+		Order: model.SidebarCategoryOrder{"channels"},
+	}))
+	ue := New(Setup{Store: s}, Config{ServerURL: ts.URL})
+	require.NotNil(t, ue)
+
+	// The events are decoded from JSON, as when received from the server.
+	newEvent := func(t *testing.T, eventType string, data map[string]interface{}) *model.WebSocketEvent {
+		ev := model.NewWebSocketEvent(eventType, teamId, "", userId, nil)
+		for k, v := range data {
+			ev.Add(k, v)
+		}
+		buf, err := ev.ToJSON()
+		require.NoError(t, err)
+		ev, err = model.WebSocketEventFromJSON(bytes.NewReader(buf))
+		require.NoError(t, err)
+		return ev
+	}
+	stored := func(t *testing.T) *model.OrderedSidebarCategories {
+		categories, err := s.SidebarCategories(teamId)
+		require.NoError(t, err)
+		return categories
+	}
+
+	t.Run("malformed data", func(t *testing.T) {
+		ev := model.NewWebSocketEvent(model.WebsocketEventSidebarCategoryCreated, "", "", userId, nil)
+		require.EqualError(t, ue.handleSidebarCategoryCreatedEvent(ev), "team id is missing from the broadcast")
+		require.EqualError(t, ue.handleSidebarCategoryDeletedEvent(newEvent(t, model.WebsocketEventSidebarCategoryDeleted, nil)), "category_id data is missing")
+		require.EqualError(t, ue.handleSidebarCategoryOrderUpdatedEvent(newEvent(t, model.WebsocketEventSidebarCategoryOrderUpdated, nil)), "order data is missing")
+		require.Error(t, ue.handleSidebarCategoryOrderUpdatedEvent(newEvent(t, model.WebsocketEventSidebarCategoryOrderUpdated, map[string]interface{}{"order": []int{1}})))
+		require.Error(t, ue.handleSidebarCategoryUpdatedEvent(newEvent(t, model.WebsocketEventSidebarCategoryUpdated, map[string]interface{}{"updatedCategories": "invalid"})))
+		// Updates not saying which categories changed are ignored.
+		require.NoError(t, ue.handleSidebarCategoryUpdatedEvent(newEvent(t, model.WebsocketEventSidebarCategoryUpdated, nil)))
+		require.Len(t, stored(t).Categories, 1)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		ev := newEvent(t, model.WebsocketEventSidebarCategoryCreated, map[string]interface{}{"category_id": custom.Id})
+		require.NoError(t, ue.handleSidebarCategoryCreatedEvent(ev))
+		category, err := s.SidebarCategory(teamId, custom.Id)
+		require.NoError(t, err)
+		require.Equal(t, custom, category)
+
+		ev = newEvent(t, model.WebsocketEventSidebarCategoryCreated, map[string]interface{}{"category_id": model.NewId()})
+		require.Error(t, ue.handleSidebarCategoryCreatedEvent(ev))
+		require.Len(t, stored(t).Categories, 2)
+	})
+
+	updated := func(t *testing.T, category *model.SidebarCategoryWithChannels) *model.WebSocketEvent {
+		data, err := json.Marshal([]*model.SidebarCategoryWithChannels{category})
+		require.NoError(t, err)
+		return newEvent(t, model.WebsocketEventSidebarCategoryUpdated, map[string]interface{}{"updatedCategories": string(data)})
+	}
+
+	t.Run("rename", func(t *testing.T) {
+		renamed := *custom
+		renamed.DisplayName = "Renamed"
+		require.NoError(t, ue.handleSidebarCategoryUpdatedEvent(updated(t, &renamed)))
+		category, err := s.SidebarCategory(teamId, custom.Id)
+		require.NoError(t, err)
+		require.Equal(t, "Renamed", category.DisplayName)
+	})
+
+	t.Run("reorder", func(t *testing.T) {
+		reordered := *custom
+		reordered.DisplayName = "Renamed"
+		reordered.Channels = []string{"b", "a"}
+		require.NoError(t, ue.handleSidebarCategoryUpdatedEvent(updated(t, &reordered)))
+		category, err := s.SidebarCategory(teamId, custom.Id)
+		require.NoError(t, err)
+		require.Equal(t, []string{"b", "a"}, category.Channels)
+
+		ev := newEvent(t, model.WebsocketEventSidebarCategoryOrderUpdated, map[string]interface{}{"order": []string{custom.Id, "channels"}})
+		require.NoError(t, ue.handleSidebarCategoryOrderUpdatedEvent(ev))
+		require.Equal(t, model.SidebarCategoryOrder{custom.Id, "channels"}, stored(t).Order)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		ev := newEvent(t, model.WebsocketEventSidebarCategoryDeleted, map[string]interface{}{"category_id": custom.Id})
+		require.NoError(t, ue.handleSidebarCategoryDeletedEvent(ev))
+		category, err := s.SidebarCategory(teamId, custom.Id)
+		require.NoError(t, err)
+		require.Nil(t, category)
+
+		categories := stored(t)
+		require.Equal(t, model.SidebarCategoryOrder{"channels"}, categories.Order)
+		// The channels of the deleted category are left uncategorized.
+		require.Empty(t, categories.Categories[0].Channels)
+	})
+}
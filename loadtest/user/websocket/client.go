@@ -7,9 +7,12 @@ package websocket
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/shared/mlog"
@@ -18,17 +21,34 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 )
 
-const avgReadMsgSizeBytes = 1024
+const (
+	avgReadMsgSizeBytes = 1024
+	// writeControlTimeout is the time allowed to write a ping or pong.
+	writeControlTimeout = 10 * time.Second
+	// DefaultMaxMessageSize is the maximum size in bytes of a message read
+	// from the server when ClientParams.MaxMessageSize is zero. It fits the
+	// events sent by a server with the default post size limit, whose message
+	// alone can take up to model.PostMessageMaxBytesV2 bytes before being
+	// encoded twice, with room for the post metadata.
+	DefaultMaxMessageSize = 8 * model.PostMessageMaxBytesV2
+)
 
 // Client is the websocket client to perform all actions.
 type Client struct {
 	EventChannel chan *model.WebSocketEvent
 
-	conn      *websocket.Conn
-	authToken string
-	sequence  int64
-	readWg    sync.WaitGroup
-	writeMut  sync.RWMutex
+	conn        *websocket.Conn
+	authToken   string
+	sequence    int64
+	readTimeout time.Duration
+	readWg      sync.WaitGroup
+	writeMut    sync.RWMutex
+	// readerDone is closed once the reader has quit.
+	readerDone chan struct{}
+	// readErr is the error that made the reader quit. It's safe to read
+	// once EventChannel is closed.
+	readErr   error
+	traceHook func(raw []byte, ev *model.WebSocketEvent)
 }
 
 type ClientParams struct {
@@ -36,6 +56,27 @@ type ClientParams struct {
 	AuthToken      string
 	ConnID         string
 	ServerSequence int64
+	// An optional dialer used to establish the connection, e.g. to go
+	// through a proxy or use custom TLS settings. If nil,
+	// websocket.DefaultDialer is used.
+	Dialer *websocket.Dialer
+	// The maximum time to wait for any message, including pongs, from the
+	// server before considering the connection dead and closing it. Pings are
+	// sent to the server at half this interval so that an idle but healthy
+	// connection is kept open. Zero disables the timeout.
+	ReadTimeout time.Duration
+	// If true, the permessage-deflate extension is requested so that
+	// messages get compressed if the server supports it.
+	EnableCompression bool
+	// The maximum size in bytes of a message read from the server. Larger
+	// messages make the connection close. Defaults to DefaultMaxMessageSize
+	// if zero. A negative value disables the limit.
+	MaxMessageSize int64
+	// An optional hook called with the exact bytes of every event received,
+	// along with the parsed event, before it's sent through EventChannel.
+	// It's run by the reading goroutine so it should not block. The bytes are
+	// only copied if it's set.
+	TraceHook func(raw []byte, ev *model.WebSocketEvent)
 }
 
 // NewClient4 constructs a new WebSocket client.
@@ -45,17 +86,57 @@ func NewClient4(param *ClientParams) (*Client, error) {
 	}
 
 	url := param.WsURL + model.APIURLSuffix + "/websocket" + fmt.Sprintf("?connection_id=%s&sequence_number=%d", param.ConnID, param.ServerSequence)
-	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	dialer := websocket.DefaultDialer
+	if param.Dialer != nil {
+		dialer = param.Dialer
+	}
+	if param.EnableCompression {
+		// The dialer is copied to leave the given one untouched.
+		d := *dialer
+		d.EnableCompression = true
+		dialer = &d
+	}
+	conn, _, err := dialer.Dial(url, header)
 	if err != nil {
 		return nil, err
 	}
+	switch {
+	case param.MaxMessageSize == 0:
+		conn.SetReadLimit(DefaultMaxMessageSize)
+	case param.MaxMessageSize > 0:
+		conn.SetReadLimit(param.MaxMessageSize)
+	}
 
 	client := &Client{
 		EventChannel: make(chan *model.WebSocketEvent, 100),
 
-		conn:      conn,
-		authToken: param.AuthToken,
-		sequence:  1,
+		conn:        conn,
+		authToken:   param.AuthToken,
+		sequence:    1,
+		readTimeout: param.ReadTimeout,
+		readerDone:  make(chan struct{}),
+		traceHook:   param.TraceHook,
+	}
+
+	if client.readTimeout > 0 {
+		client.extendReadDeadline()
+		conn.SetPongHandler(func(string) error {
+			client.extendReadDeadline()
+			return nil
+		})
+		conn.SetPingHandler(func(data string) error {
+			client.extendReadDeadline()
+			err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeControlTimeout))
+			// Same as the default ping handler.
+			if err == websocket.ErrCloseSent {
+				return nil
+			} else if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				return nil
+			}
+			return err
+		})
+		client.readWg.Add(1)
+		go client.pinger()
 	}
 
 	client.readWg.Add(1)
@@ -77,6 +158,7 @@ func (c *Client) Close() {
 func (c *Client) reader() {
 	defer func() {
 		close(c.EventChannel)
+		close(c.readerDone)
 		// Mark wg as Done.
 		c.readWg.Done()
 	}()
@@ -89,15 +171,18 @@ func (c *Client) reader() {
 		buf.Reset()
 		_, r, err := c.conn.NextReader()
 		if err != nil {
+			c.readErr = err
 			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
 				// log error
 				mlog.Debug("error from conn.NextReader", mlog.Err(err))
 			}
 			return
 		}
+		c.extendReadDeadline()
 		// Use pre-allocated buffer.
 		_, err = buf.ReadFrom(r)
 		if err != nil {
+			c.readErr = err
 			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
 				// log error
 				mlog.Warn("error from buf.ReadFrom", mlog.Err(err))
@@ -105,11 +190,20 @@ func (c *Client) reader() {
 			return
 		}
 
+		// The buffer is consumed while decoding.
+		var raw []byte
+		if c.traceHook != nil {
+			raw = append([]byte(nil), buf.Bytes()...)
+		}
+
 		event, err := model.WebSocketEventFromJSON(&buf)
 		if event == nil || err != nil {
 			continue
 		}
 		if event.IsValid() {
+			if c.traceHook != nil {
+				c.traceHook(raw, event)
+			}
 			// non-blocking send in case event channel is full.
 			select {
 			case c.EventChannel <- event:
@@ -119,6 +213,63 @@ func (c *Client) reader() {
 	}
 }
 
+// extendReadDeadline pushes the read deadline forward by the read timeout,
+// if any.
+func (c *Client) extendReadDeadline() {
+	if c.readTimeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+}
+
+// pinger periodically pings the server until the reader quits.
+func (c *Client) pinger() {
+	defer c.readWg.Done()
+
+	ticker := time.NewTicker(c.readTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// WriteControl is safe to call concurrently with the other
+			// write methods. A failure is caught by the reader, so it's
+			// only logged here.
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeControlTimeout)); err != nil {
+				mlog.Debug("error sending ping", mlog.Err(err))
+			}
+		case <-c.readerDone:
+			return
+		}
+	}
+}
+
+// TimedOut returns whether the connection was closed because no message was
+// received from the server within the read timeout. It should only be called
+// once EventChannel is closed.
+func (c *Client) TimedOut() bool {
+	var netErr net.Error
+	return errors.As(c.readErr, &netErr) && netErr.Timeout()
+}
+
+// ReadLimitExceeded returns whether the connection was closed because a
+// message larger than the maximum message size was received. It should only
+// be called once EventChannel is closed.
+func (c *Client) ReadLimitExceeded() bool {
+	return errors.Is(c.readErr, websocket.ErrReadLimit)
+}
+
+// CloseCode returns the close code the connection was closed with.
+// Connections dropped without receiving a close frame are reported as
+// websocket.CloseAbnormalClosure. It should only be called once EventChannel
+// is closed.
+func (c *Client) CloseCode() int {
+	var closeErr *websocket.CloseError
+	if errors.As(c.readErr, &closeErr) {
+		return closeErr.Code
+	}
+	return websocket.CloseAbnormalClosure
+}
+
 // SendMessage is the method to write to the websocket.
 func (c *Client) SendMessage(action string, data map[string]interface{}) error {
 	// It uses a mutex to synchronize writes.
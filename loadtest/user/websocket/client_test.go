@@ -4,10 +4,13 @@
 package websocket
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -303,3 +306,309 @@ func TestSendBinaryMessage(t *testing.T) {
 	require.NoError(t, err)
 	c.Close()
 }
+
+func TestCloseCode(t *testing.T) {
+	closingHandler := func(closeFrame bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			upgrader := &websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(w, req, nil)
+			require.NoError(t, err)
+			if closeFrame {
+				msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down")
+				err = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				require.NoError(t, err)
+			}
+			conn.Close()
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		closeFrame bool
+		code       int
+	}{
+		"going away": {closeFrame: true, code: websocket.CloseGoingAway},
+		"abnormal":   {closeFrame: false, code: websocket.CloseAbnormalClosure},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := httptest.NewServer(closingHandler(tc.closeFrame))
+			defer s.Close()
+
+			c, err := NewClient4(&ClientParams{
+				WsURL:     strings.Replace(s.URL, "http://", "ws://", 1),
+				AuthToken: "authToken",
+			})
+			require.NoError(t, err)
+
+			select {
+			case _, ok := <-c.EventChannel:
+				require.False(t, ok)
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for the event channel to close")
+			}
+
+			require.Equal(t, tc.code, c.CloseCode())
+			c.Close()
+		})
+	}
+}
+
+func TestDialer(t *testing.T) {
+	var wg sync.WaitGroup
+	s := httptest.NewServer(dummyWebsocketHandler(t, &wg))
+	defer func() {
+		wg.Wait()
+		s.Close()
+	}()
+
+	var dialed int32
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dialed, 1)
+			return net.Dial(network, addr)
+		},
+	}
+
+	wg.Add(1)
+	c, err := NewClient4(&ClientParams{
+		WsURL:     strings.Replace(s.URL, "http://", "ws://", 1),
+		AuthToken: "authToken",
+		Dialer:    dialer,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&dialed))
+	c.Close()
+
+	t.Run("failing dialer", func(t *testing.T) {
+		dialErr := errors.New("dial failed")
+		_, err := NewClient4(&ClientParams{
+			WsURL:     strings.Replace(s.URL, "http://", "ws://", 1),
+			AuthToken: "authToken",
+			Dialer: &websocket.Dialer{
+				NetDial: func(network, addr string) (net.Conn, error) {
+					return nil, dialErr
+				},
+			},
+		})
+		require.ErrorIs(t, err, dialErr)
+	})
+}
+
+func TestEnableCompression(t *testing.T) {
+	extensions := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		extensions <- req.Header.Get("Sec-WebSocket-Extensions")
+		upgrader := &websocket.Upgrader{EnableCompression: true}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	for _, enabled := range []bool{false, true} {
+		dialer := &websocket.Dialer{}
+		c, err := NewClient4(&ClientParams{
+			WsURL:             strings.Replace(s.URL, "http://", "ws://", 1),
+			AuthToken:         "authToken",
+			Dialer:            dialer,
+			EnableCompression: enabled,
+		})
+		require.NoError(t, err)
+		if enabled {
+			require.Contains(t, <-extensions, "permessage-deflate")
+		} else {
+			require.NotContains(t, <-extensions, "permessage-deflate")
+		}
+		// The given dialer is left untouched.
+		require.False(t, dialer.EnableCompression)
+		require.NoError(t, c.SendMessage("test", nil))
+		c.Close()
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	handler := func(silent bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			upgrader := &websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(w, req, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			if silent {
+				// Pings are ignored rather than answered, as a half-open
+				// connection would do.
+				conn.SetPingHandler(func(string) error { return nil })
+			}
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	t.Run("silent server", func(t *testing.T) {
+		s := httptest.NewServer(handler(true))
+		defer s.Close()
+
+		c, err := NewClient4(&ClientParams{
+			WsURL:       strings.Replace(s.URL, "http://", "ws://", 1),
+			AuthToken:   "authToken",
+			ReadTimeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		select {
+		case _, ok := <-c.EventChannel:
+			require.False(t, ok)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the read timeout to fire")
+		}
+
+		require.True(t, c.TimedOut())
+		require.Equal(t, websocket.CloseAbnormalClosure, c.CloseCode())
+		c.Close()
+	})
+
+	t.Run("responsive server", func(t *testing.T) {
+		s := httptest.NewServer(handler(false))
+		defer s.Close()
+
+		c, err := NewClient4(&ClientParams{
+			WsURL:       strings.Replace(s.URL, "http://", "ws://", 1),
+			AuthToken:   "authToken",
+			ReadTimeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		// Pongs keep the idle connection open well past the timeout.
+		select {
+		case <-c.EventChannel:
+			require.FailNow(t, "connection should have been kept open")
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		c.Close()
+		require.False(t, c.TimedOut())
+	})
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	// The event is larger than the default limit.
+	const eventSize = 2 * DefaultMaxMessageSize
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upgrader := &websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ev := model.NewWebSocketEvent(model.WebsocketEventPosted, "", "", "", nil)
+		ev.Add("post", strings.Repeat("a", eventSize))
+		data, err := ev.ToJSON()
+		require.NoError(t, err)
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	t.Run("default limit", func(t *testing.T) {
+		c, err := NewClient4(&ClientParams{
+			WsURL:     strings.Replace(s.URL, "http://", "ws://", 1),
+			AuthToken: "authToken",
+		})
+		require.NoError(t, err)
+
+		select {
+		case _, ok := <-c.EventChannel:
+			require.False(t, ok)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the event channel to close")
+		}
+
+		require.True(t, c.ReadLimitExceeded())
+		require.False(t, c.TimedOut())
+		c.Close()
+	})
+
+	t.Run("raised limit", func(t *testing.T) {
+		c, err := NewClient4(&ClientParams{
+			WsURL:          strings.Replace(s.URL, "http://", "ws://", 1),
+			AuthToken:      "authToken",
+			MaxMessageSize: 2 * eventSize,
+		})
+		require.NoError(t, err)
+
+		select {
+		case ev, ok := <-c.EventChannel:
+			require.True(t, ok)
+			require.Equal(t, model.WebsocketEventPosted, ev.EventType())
+			require.Len(t, ev.GetData()["post"], eventSize)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the event")
+		}
+
+		c.Close()
+		require.False(t, c.ReadLimitExceeded())
+	})
+}
+
+func TestTraceHook(t *testing.T) {
+	// The payload isn't formatted the way it would be encoded back.
+	payload := []byte(`{"event": "hello", "data": {"connection_id": "conn"}, "broadcast": {}, "seq": 0}`)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upgrader := &websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, req, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		for i := 0; i < 2; i++ {
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	type traced struct {
+		raw []byte
+		ev  *model.WebSocketEvent
+	}
+	traces := make(chan traced, 2)
+	c, err := NewClient4(&ClientParams{
+		WsURL:     strings.Replace(s.URL, "http://", "ws://", 1),
+		AuthToken: "authToken",
+		TraceHook: func(raw []byte, ev *model.WebSocketEvent) {
+			traces <- traced{raw: raw, ev: ev}
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case ev := <-c.EventChannel:
+			tr := <-traces
+			require.Equal(t, payload, tr.raw)
+			require.Same(t, ev, tr.ev)
+			require.Equal(t, model.WebsocketEventHello, ev.EventType())
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for the event")
+		}
+	}
+}
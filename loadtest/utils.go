@@ -6,6 +6,8 @@ package loadtest
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"time"
 
 	"github.com/mattermost/mattermost-load-test-ng/loadtest/user/userentity"
 
@@ -33,6 +35,74 @@ func pickRate(config UserControllerConfiguration) (float64, error) {
 	return dist[idx].Rate, nil
 }
 
+// PickPersona randomly selects a persona from the configured distribution.
+// If no distribution is configured it returns DefaultPersona.
+func PickPersona(config UsersConfiguration) (string, error) {
+	dist := config.PersonasDistribution
+	if len(dist) == 0 {
+		return DefaultPersona, nil
+	}
+
+	weights := make([]int, len(dist))
+	for i := range dist {
+		weights[i] = int(dist[i].Percentage * 100)
+	}
+
+	idx, err := control.SelectWeighted(weights)
+	if err != nil {
+		return "", fmt.Errorf("loadtest: failed to select weight: %w", err)
+	}
+
+	return dist[idx].Persona, nil
+}
+
+// PickLocale randomly selects a locale from the configured distribution.
+// If no distribution is configured it returns an empty string.
+func PickLocale(config UsersConfiguration) (string, error) {
+	dist := config.LocalesDistribution
+	if len(dist) == 0 {
+		return "", nil
+	}
+
+	weights := make([]int, len(dist))
+	for i := range dist {
+		weights[i] = int(dist[i].Percentage * 100)
+	}
+
+	idx, err := control.SelectWeighted(weights)
+	if err != nil {
+		return "", fmt.Errorf("loadtest: failed to select weight: %w", err)
+	}
+
+	return dist[idx].Locale, nil
+}
+
+// PickClockSkew randomly selects a clock offset within the configured maximum
+// skew.
+func PickClockSkew(config UsersConfiguration) time.Duration {
+	if config.ClockSkewMaxMs <= 0 {
+		return 0
+	}
+	skewMs := rand.Intn(2*config.ClockSkewMaxMs+1) - config.ClockSkewMaxMs
+	return time.Duration(skewMs) * time.Millisecond
+}
+
+// PickDeviceId randomly decides whether a user should have a simulated mobile
+// session, given the configured percentage. It returns the device id to be used
+// or an empty string if the user should not have a mobile session.
+func PickDeviceId(config UsersConfiguration) string {
+	if rand.Float64() >= config.PercentMobileSessions {
+		return ""
+	}
+	return model.PushNotifyAndroidReactNative + ":" + model.NewId()
+}
+
+// PickReceiver randomly decides whether a user should be a receiver, given the
+// configured percentage.
+func PickReceiver(config UsersConfiguration) bool {
+	return rand.Float64() < config.PercentReceivers
+}
+
 // PromoteToAdmin promotes user to a sysadmin role
 func PromoteToAdmin(admin, userForPromotion *userentity.UserEntity) error {
 	isAdmin, err := admin.IsSysAdmin()
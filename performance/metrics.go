@@ -14,30 +14,56 @@ const (
 	metricsNamespace     = "loadtest"
 	metricsSubSystemHTTP = "http"
 	metricsSubSystemWS   = "websocket"
+
+	metricsSubSystemCoordinator = "coordinator"
 )
 
 type UserEntityMetrics struct {
-	HTTPRequestTimes     prometheus.Histogram
-	HTTPErrors           *prometheus.CounterVec
-	HTTPTimeouts         *prometheus.CounterVec
-	WebSocketConnections prometheus.Gauge
+	HTTPRequestTimes           *prometheus.HistogramVec
+	HTTPErrors                 *prometheus.CounterVec
+	HTTPTimeouts               *prometheus.CounterVec
+	HTTPRateLimited            *prometheus.CounterVec
+	WebSocketConnections       prometheus.Gauge
+	WebSocketDegraded          prometheus.Gauge
+	WebSocketGaveUp            prometheus.Gauge
+	WebSocketCloseCodes        *prometheus.CounterVec
+	WebSocketReconnects        *prometheus.CounterVec
+	WebSocketSeqMismatches     *prometheus.CounterVec
+	WebSocketConnectFailures   *prometheus.CounterVec
+	WebSocketReadTimeouts      *prometheus.CounterVec
+	WebSocketReadLimitHits     *prometheus.CounterVec
+	WebSocketEventTimes        *prometheus.HistogramVec
+	WebSocketDroppedEvents     *prometheus.CounterVec
+	WebSocketSlowConsumerSends *prometheus.CounterVec
+	WebSocketTypingQueueTimes  *prometheus.HistogramVec
+	PostDeliveryTimes          *prometheus.HistogramVec
+	StoreUnhealthy             prometheus.Gauge
+}
+
+type CoordinatorMetrics struct {
+	RampStage            prometheus.Gauge
+	RampStageTargetUsers prometheus.Gauge
+	DailyPhase           prometheus.Gauge
+	DailyTargetUsers     prometheus.Gauge
 }
 
 type Metrics struct {
 	registry  *prometheus.Registry
 	ueMetrics UserEntityMetrics
+	cMetrics  CoordinatorMetrics
 }
 
 func NewMetrics() *Metrics {
 	var m Metrics
 	m.registry = prometheus.NewRegistry()
 
-	m.ueMetrics.HTTPRequestTimes = prometheus.NewHistogram(prometheus.HistogramOpts{
+	m.ueMetrics.HTTPRequestTimes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: metricsNamespace,
 		Subsystem: metricsSubSystemHTTP,
 		Name:      "request_time",
 		Help:      "The time taken to execute client requests.",
-	})
+	},
+		[]string{"persona"})
 	m.registry.MustRegister(m.ueMetrics.HTTPRequestTimes)
 
 	m.ueMetrics.HTTPErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -46,7 +72,7 @@ func NewMetrics() *Metrics {
 		Name:      "errors_total",
 		Help:      "The total number of HTTP client errors.",
 	},
-		[]string{"path", "method", "status_code"})
+		[]string{"path", "method", "status_code", "persona"})
 	m.registry.MustRegister(m.ueMetrics.HTTPErrors)
 
 	m.ueMetrics.HTTPTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -55,9 +81,18 @@ func NewMetrics() *Metrics {
 		Name:      "timeouts_total",
 		Help:      "The total number of HTTP client timeouts.",
 	},
-		[]string{"path", "method"})
+		[]string{"path", "method", "persona"})
 	m.registry.MustRegister(m.ueMetrics.HTTPTimeouts)
 
+	m.ueMetrics.HTTPRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemHTTP,
+		Name:      "rate_limited_total",
+		Help:      "The total number of HTTP requests rate limited by the server.",
+	},
+		[]string{"path", "method", "persona"})
+	m.registry.MustRegister(m.ueMetrics.HTTPRateLimited)
+
 	m.ueMetrics.WebSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: metricsNamespace,
 		Subsystem: metricsSubSystemWS,
@@ -66,13 +101,182 @@ func NewMetrics() *Metrics {
 	})
 	m.registry.MustRegister(m.ueMetrics.WebSocketConnections)
 
+	m.ueMetrics.WebSocketDegraded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "degraded_total",
+		Help:      "The total number of users struggling to keep a WebSocket connection.",
+	})
+	m.registry.MustRegister(m.ueMetrics.WebSocketDegraded)
+
+	m.ueMetrics.WebSocketGaveUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "gave_up_total",
+		Help:      "The total number of users that gave up reconnecting their WebSocket connection.",
+	})
+	m.registry.MustRegister(m.ueMetrics.WebSocketGaveUp)
+
+	m.ueMetrics.WebSocketCloseCodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "close_codes_total",
+		Help:      "The total number of WebSocket connections closed by the server, by close code.",
+	},
+		[]string{"code", "persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketCloseCodes)
+
+	m.ueMetrics.WebSocketReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "reconnects_total",
+		Help:      "The total number of WebSocket reconnect attempts, by reason.",
+	},
+		[]string{"reason", "persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketReconnects)
+
+	m.ueMetrics.WebSocketSeqMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "seq_mismatches_total",
+		Help:      "The total number of WebSocket events received out of sequence.",
+	},
+		[]string{"persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketSeqMismatches)
+
+	m.ueMetrics.WebSocketConnectFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "connect_failures_total",
+		Help:      "The total number of failed WebSocket connection attempts.",
+	},
+		[]string{"persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketConnectFailures)
+
+	m.ueMetrics.WebSocketReadTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "read_timeouts_total",
+		Help:      "The total number of WebSocket connections closed after not receiving anything from the server in time.",
+	},
+		[]string{"persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketReadTimeouts)
+
+	m.ueMetrics.WebSocketReadLimitHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "read_limit_exceeded_total",
+		Help:      "The total number of WebSocket connections closed after receiving a message larger than the maximum message size.",
+	},
+		[]string{"persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketReadLimitHits)
+
+	m.ueMetrics.WebSocketEventTimes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "event_handling_time",
+		Help:      "The time taken to handle received WebSocket events, by event type.",
+		// Handling an event is expected to take from a few microseconds up
+		// to a few milliseconds.
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	},
+		[]string{"event_type", "persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketEventTimes)
+
+	m.ueMetrics.WebSocketDroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "dropped_events_total",
+		Help:      "The total number of WebSocket events dropped because the controller couldn't keep up, by event type.",
+	},
+		[]string{"event_type", "persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketDroppedEvents)
+
+	m.ueMetrics.WebSocketSlowConsumerSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "slow_consumer_sends_total",
+		Help:      "The total number of WebSocket events which waited longer than the slow consumer threshold to be received by the controller, by event type.",
+	},
+		[]string{"event_type", "persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketSlowConsumerSends)
+
+	m.ueMetrics.WebSocketTypingQueueTimes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "typing_queue_time",
+		Help:      "The time typing events wait to be picked up by the WebSocket listener.",
+		// The listener is expected to pick up events within microseconds,
+		// unless it's busy or reconnecting.
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	},
+		[]string{"persona"})
+	m.registry.MustRegister(m.ueMetrics.WebSocketTypingQueueTimes)
+
+	m.ueMetrics.PostDeliveryTimes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "post_delivery_time",
+		Help:      "The time between a user creating a post and receiving the related posted event.",
+		// Posts are expected to come back within milliseconds, up to a few
+		// seconds under heavy load.
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	},
+		[]string{"persona"})
+	m.registry.MustRegister(m.ueMetrics.PostDeliveryTimes)
+
+	m.ueMetrics.StoreUnhealthy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemWS,
+		Name:      "store_unhealthy_total",
+		Help:      "The total number of users whose actions are paused because their store is out of sync.",
+	})
+	m.registry.MustRegister(m.ueMetrics.StoreUnhealthy)
+
+	m.cMetrics.RampStage = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemCoordinator,
+		Name:      "ramp_stage",
+		Help:      "The current stage of the coordinator's ramp profile.",
+	})
+	m.registry.MustRegister(m.cMetrics.RampStage)
+
+	m.cMetrics.RampStageTargetUsers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemCoordinator,
+		Name:      "ramp_stage_target_users",
+		Help:      "The target number of active users for the current stage of the coordinator's ramp profile.",
+	})
+	m.registry.MustRegister(m.cMetrics.RampStageTargetUsers)
+
+	m.cMetrics.DailyPhase = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemCoordinator,
+		Name:      "daily_phase",
+		Help:      "The elapsed fraction of the current simulated day of the coordinator's daily profile.",
+	})
+	m.registry.MustRegister(m.cMetrics.DailyPhase)
+
+	m.cMetrics.DailyTargetUsers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubSystemCoordinator,
+		Name:      "daily_target_users",
+		Help:      "The target number of active users at the current point of the coordinator's daily profile.",
+	})
+	m.registry.MustRegister(m.cMetrics.DailyTargetUsers)
+
 	return &m
 }
 
 func (m *Metrics) Handler() http.Handler {
-	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	// OpenMetrics is needed for exemplars to be exposed.
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
 }
 
 func (m *Metrics) UserEntityMetrics() *UserEntityMetrics {
 	return &m.ueMetrics
 }
+
+func (m *Metrics) CoordinatorMetrics() *CoordinatorMetrics {
+	return &m.cMetrics
+}